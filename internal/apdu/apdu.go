@@ -3,6 +3,8 @@ package apdu
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"sync/atomic"
 
 	"github.com/shigmas/modore/pkg/bacnet"
 )
@@ -43,13 +45,12 @@ type PDUType uint8
 const (
 	PDUTypeConfirmedServiceRequest   PDUType = 0
 	PDUTypeUnconfirmedServiceRequest         = 0x10
+	PDUTypeSimpleAck                         = 0x20
 	PDUTypeComplexAck                        = 0x30
 	PDUTypeSegmentAck                        = 0x40
 	PDUTypeError                             = 0x50
 	PDUTypeReject                            = 0x60
 	PDUTypeAbort                             = 0x70
-
-	// 6 more
 )
 
 // ServiceConfirmed is the type of service for confirmed requests
@@ -57,8 +58,35 @@ type ServiceConfirmed uint8
 
 // The values for ServiceConfirmed. We are explicit because these are transmitted.
 const (
-	ServiceConfirmedAcknowledgeAlarm ServiceConfirmed = 0
-	ServiceConfirmedCovNotofication                   = 1
+	ServiceConfirmedAcknowledgeAlarm           ServiceConfirmed = 0
+	ServiceConfirmedCovNotofication                             = 1
+	ServiceConfirmedEventNotification                           = 2
+	ServiceConfirmedGetAlarmSummary                             = 3
+	ServiceConfirmedGetEnrollmentSummary                        = 4
+	ServiceConfirmedSubscribeCOV                                = 5
+	ServiceConfirmedAtomicReadFile                              = 6
+	ServiceConfirmedAtomicWriteFile                             = 7
+	ServiceConfirmedAddListElement                              = 8
+	ServiceConfirmedRemoveListElement                           = 9
+	ServiceConfirmedCreateObject                                = 10
+	ServiceConfirmedDeleteObject                                = 11
+	ServiceConfirmedReadProperty                                = 12
+	ServiceConfirmedReadPropertyMultiple                        = 14
+	ServiceConfirmedWriteProperty                               = 15
+	ServiceConfirmedWritePropertyMultiple                       = 16
+	ServiceConfirmedDeviceCommunicationControl                  = 17
+	ServiceConfirmedPrivateTransfer                             = 18
+	ServiceConfirmedTextMessage                                 = 19
+	ServiceConfirmedReinitializeDevice                          = 20
+	ServiceConfirmedVTOpen                                      = 21
+	ServiceConfirmedVTClose                                     = 22
+	ServiceConfirmedVTData                                      = 23
+	ServiceConfirmedReadRange                                   = 26
+	ServiceConfirmedLifeSafetyOperation                         = 27
+	ServiceConfirmedSubscribeCOVProperty                        = 28
+	ServiceConfirmedGetEventInformation                         = 29
+	// ServiceConfirmedMax is one past the last defined service. It is not a service.
+	ServiceConfirmedMax = 30
 )
 
 // ServiceUnconfirmed do not need confirmations. Should just be service, and we can figure out
@@ -78,12 +106,221 @@ const (
 	ServiceUnconfirmedWhoIs                                = 8
 	ServiceUnconfirmedUTCTimeSync                          = 9
 	ServiceUnconfirmedWriteGroup                           = 10
+	// ServiceUnconfirmedMax is one past the last defined service. It is not a service.
+	ServiceUnconfirmedMax = 11
+)
+
+var serviceConfirmedNames = map[ServiceConfirmed]string{
+	ServiceConfirmedAcknowledgeAlarm:           "AcknowledgeAlarm",
+	ServiceConfirmedCovNotofication:            "ConfirmedCOVNotification",
+	ServiceConfirmedEventNotification:          "ConfirmedEventNotification",
+	ServiceConfirmedGetAlarmSummary:            "GetAlarmSummary",
+	ServiceConfirmedGetEnrollmentSummary:       "GetEnrollmentSummary",
+	ServiceConfirmedSubscribeCOV:               "SubscribeCOV",
+	ServiceConfirmedAtomicReadFile:             "AtomicReadFile",
+	ServiceConfirmedAtomicWriteFile:            "AtomicWriteFile",
+	ServiceConfirmedAddListElement:             "AddListElement",
+	ServiceConfirmedRemoveListElement:          "RemoveListElement",
+	ServiceConfirmedCreateObject:               "CreateObject",
+	ServiceConfirmedDeleteObject:               "DeleteObject",
+	ServiceConfirmedReadProperty:               "ReadProperty",
+	ServiceConfirmedReadPropertyMultiple:       "ReadPropertyMultiple",
+	ServiceConfirmedWriteProperty:              "WriteProperty",
+	ServiceConfirmedWritePropertyMultiple:      "WritePropertyMultiple",
+	ServiceConfirmedDeviceCommunicationControl: "DeviceCommunicationControl",
+	ServiceConfirmedPrivateTransfer:            "ConfirmedPrivateTransfer",
+	ServiceConfirmedTextMessage:                "ConfirmedTextMessage",
+	ServiceConfirmedReinitializeDevice:         "ReinitializeDevice",
+	ServiceConfirmedVTOpen:                     "VTOpen",
+	ServiceConfirmedVTClose:                    "VTClose",
+	ServiceConfirmedVTData:                     "VTData",
+	ServiceConfirmedReadRange:                  "ReadRange",
+	ServiceConfirmedLifeSafetyOperation:        "LifeSafetyOperation",
+	ServiceConfirmedSubscribeCOVProperty:       "SubscribeCOVProperty",
+	ServiceConfirmedGetEventInformation:        "GetEventInformation",
+}
+
+// String returns the name of the service in the spec, or unknown with the number for the ones we don't have.
+func (s ServiceConfirmed) String() string {
+	if name, ok := serviceConfirmedNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+var serviceUnconfirmedNames = map[ServiceUnconfirmed]string{
+	ServiceUnconfirmedIAm:               "IAm",
+	ServiceUnconfirmedIHave:             "IHave",
+	ServiceUnconfirmedCOVNotification:   "UnconfirmedCOVNotification",
+	ServiceUnconfirmedEventNotification: "UnconfirmedEventNotification",
+	ServiceUnconfirmedPrivateTransfer:   "UnconfirmedPrivateTransfer",
+	ServiceUnconfirmedTextMessage:       "UnconfirmedTextMessage",
+	ServiceUnconfirmedTimeSync:          "TimeSynchronization",
+	ServiceUnconfirmedWhoHas:            "WhoHas",
+	ServiceUnconfirmedWhoIs:             "WhoIs",
+	ServiceUnconfirmedUTCTimeSync:       "UTCTimeSynchronization",
+	ServiceUnconfirmedWriteGroup:        "WriteGroup",
+}
+
+// String returns the name of the service in the spec, or unknown with the number for the ones we don't have.
+func (s ServiceUnconfirmed) String() string {
+	if name, ok := serviceUnconfirmedNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// MaxAPDULength is the 4 bit code for the maximum length of APDU that we accept in confirmed requests
+// (20.1.2.5 in the spec).
+type MaxAPDULength uint8
+
+// The values for MaxAPDULength. We are explicit because these are transmitted.
+const (
+	MaxAPDULength50   MaxAPDULength = 0
+	MaxAPDULength128                = 1
+	MaxAPDULength206                = 2
+	MaxAPDULength480                = 3
+	MaxAPDULength1024               = 4
+	MaxAPDULength1476               = 5
 )
 
+// defaultMaxAPDULength is advertised by the builders. 1476 is the largest that fits in an ethernet frame
+// with BACnet/IP, so it's what most devices use. It's a uint32 so we can use atomic.
+var defaultMaxAPDULength uint32 = MaxAPDULength1476
+
+// SetDefaultMaxAPDULength sets the max APDU length advertised in confirmed requests and IAm messages built
+// after this is called. Devices with small buffers may reject requests from us if this is larger than
+// they can handle.
+func SetDefaultMaxAPDULength(maxLength MaxAPDULength) {
+	atomic.StoreUint32(&defaultMaxAPDULength, uint32(maxLength))
+}
+
+// DefaultMaxAPDULength gets the max APDU length used by the builders.
+func DefaultMaxAPDULength() MaxAPDULength {
+	return MaxAPDULength(atomic.LoadUint32(&defaultMaxAPDULength))
+}
+
+// Bytes returns the number of bytes for the length code. Reserved codes return 0.
+func (m MaxAPDULength) Bytes() uint {
+	switch m {
+	case MaxAPDULength50:
+		return 50
+	case MaxAPDULength128:
+		return 128
+	case MaxAPDULength206:
+		return 206
+	case MaxAPDULength480:
+		return 480
+	case MaxAPDULength1024:
+		return 1024
+	case MaxAPDULength1476:
+		return 1476
+	default:
+		return 0
+	}
+}
+
+// MaxAPDULengthFromBytes returns the largest length code that fits in n bytes, like the max APDU length
+// accepted from an IAm. Anything smaller than 50 bytes is 50, since every device has to accept that.
+func MaxAPDULengthFromBytes(n uint) MaxAPDULength {
+	for m := MaxAPDULength(MaxAPDULength1476); m > MaxAPDULength50; m-- {
+		if m.Bytes() <= n {
+			return m
+		}
+	}
+	return MaxAPDULength50
+}
+
+// NegotiatedAPDULength returns the number of bytes we can send to a device that accepts remote, when we
+// accept local. It's the smaller of the two, so it's the limit for confirmed requests and segments.
+func NegotiatedAPDULength(remote, local MaxAPDULength) uint {
+	if remote.Validate() != nil {
+		remote = local
+	}
+	if remote < local {
+		return remote.Bytes()
+	}
+	return local.Bytes()
+}
+
+// MaxSegments is the 3 bit code for the number of segments a device will accept in a segmented response.
+type MaxSegments uint8
+
+// The values for MaxSegments. We are explicit because these are transmitted.
+const (
+	MaxSegmentsUnspecified MaxSegments = 0
+	MaxSegments2                       = 1
+	MaxSegments4                       = 2
+	MaxSegments8                       = 3
+	MaxSegments16                      = 4
+	MaxSegments32                      = 5
+	MaxSegments64                      = 6
+	MaxSegmentsMoreThan64              = 7
+)
+
+// Segments returns the number of segments for the code. Unspecified and more than 64 have no count, so
+// they return 0.
+func (m MaxSegments) Segments() uint {
+	if m == MaxSegmentsUnspecified || m >= MaxSegmentsMoreThan64 {
+		return 0
+	}
+	return 1 << m
+}
+
+// Validate checks that the code fits in the 3 bits of the control byte.
+func (m MaxSegments) Validate() error {
+	if m > MaxSegmentsMoreThan64 {
+		return bacnet.ErrValueTooLarge
+	}
+	return nil
+}
+
+// Segmentation is whether a device can send and receive segmented messages. It is the segmentation supported
+// parameter of IAm. (BACnetSegmentation in 21 of the spec)
+type Segmentation uint8
+
+// The values for Segmentation. We are explicit because these are transmitted.
+const (
+	SegmentationBoth     Segmentation = 0
+	SegmentationTransmit              = 1
+	SegmentationReceive               = 2
+	SegmentationNone                  = 3
+)
+
+// CanTransmit is true if the device can send segmented messages.
+func (s Segmentation) CanTransmit() bool {
+	return s == SegmentationBoth || s == SegmentationTransmit
+}
+
+// CanReceive is true if the device can receive segmented messages.
+func (s Segmentation) CanReceive() bool {
+	return s == SegmentationBoth || s == SegmentationReceive
+}
+
+// Validate checks that the value is one of the defined values.
+func (s Segmentation) Validate() error {
+	if s > SegmentationNone {
+		return bacnet.ErrInvalidData
+	}
+	return nil
+}
+
+// Validate checks that the code is one of the defined lengths. The rest of the 4 bits are reserved.
+func (m MaxAPDULength) Validate() error {
+	if m > MaxAPDULength1476 {
+		return bacnet.ErrValueTooLarge
+	}
+	return nil
+}
+
 type (
 	// Message is the basic interface for apdu messages.
 	Message interface {
 		Encode() ([]byte, error)
+		// Clone makes a deep copy, so a decoded message can be shared across goroutines and changed
+		Clone() Message
+		// PDUType is the type of the message, so it can be dispatched without a type switch
+		PDUType() PDUType
 	}
 
 	// MessageBase is the base type for the various types of APDU messages.
@@ -120,13 +357,15 @@ type (
 		IsSegmented               bool
 		DoSegmentsFollow          bool
 		IsSegmentResponseAccepted bool
-		MaxSegmentsAccepted       uint8 // Only up to 7
-		MaxLengthAccepted         uint8 // Only up to 8
+		MaxSegmentsAccepted       uint8 // A MaxSegments code, so only up to 7
+		MaxLengthAccepted         uint8 // A MaxAPDULength code, so only up to 5
 		InvokeID                  uint8
 		SequenceNumber            *uint8 // if IsSegmented is true
 		ProposedWindowSize        *uint8 // if IsSegmented is true
 		ServiceID                 ServiceConfirmed
 		ServiceData               []byte
+		// parameters is ServiceData decoded by Parameters
+		parameters []TagType
 	}
 
 	// UnconfirmedMessage is a little simpler and has the following encoding:
@@ -149,24 +388,64 @@ type (
 		//ServiceData []TagType
 		ServiceData []TagType
 	}
+
+	// IAm is the parameters of an IAm message, which announces a device (16.1 in the spec). Partial is set
+	// if the device left out parameters, or sent ones that we couldn't use, so they are zero values.
+	IAm struct {
+		DeviceID              bacnet.ObjectID
+		MaxAPDULengthAccepted uint
+		Segmentation          Segmentation
+		VendorID              uint16
+		Partial               bool
+	}
+
+	// WhoIs is the device instance range of a WhoIs message (16.10 in the spec). A WhoIs without the range is
+	// for all devices, which is the same as the full range.
+	WhoIs struct {
+		Low  uint
+		High uint
+	}
 )
 
+// PDUType returns the type of the message
+func (m MessageBase) PDUType() PDUType {
+	return m.ServiceType
+}
+
 var (
 	_ (Message) = (*ConfirmedMessage)(nil)
 	_ (Message) = (*UnconfirmedMessage)(nil)
 )
 
-// NewMessageFromBytes creates an APDU message from bytes by interpreting the first byte.
-func NewMessageFromBytes(data []byte) (Message, error) {
+// NewMessageFromBytes creates an APDU message from bytes by interpreting the first byte. When a tag can't be
+// decoded, the error is a DecodeError with the offset in data.
+func NewMessageFromBytes(data []byte, opts ...DecodeOption) (_ Message, err error) {
+	defer locateDecodeError(&err, len(data))
 	if len(data) < 1 {
 		return nil, errors.New("bytes do not contain an NPDU message")
 	}
+	o, err := newDecodeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	pduType := PDUType(data[0] & 0xF0)
 	switch pduType {
 	case PDUTypeConfirmedServiceRequest:
 		return newConfirmedMessageFromBytes(pduType, data)
 	case PDUTypeUnconfirmedServiceRequest:
-		return newUnconfirmedMessageFromBytes(pduType, data)
+		return newUnconfirmedMessageFromBytes(pduType, data, o)
+	case PDUTypeSimpleAck:
+		return newSimpleAckMessageFromBytes(pduType, data)
+	case PDUTypeComplexAck:
+		return newComplexAckMessageFromBytes(pduType, data)
+	case PDUTypeSegmentAck:
+		return newSegmentAckMessageFromBytes(pduType, data)
+	case PDUTypeError:
+		return newErrorMessageFromBytes(pduType, data)
+	case PDUTypeReject:
+		return newRejectMessageFromBytes(pduType, data)
+	case PDUTypeAbort:
+		return newAbortMessageFromBytes(pduType, data)
 	default:
 		return nil, errors.New("Unimplemented PDUType")
 	}
@@ -178,13 +457,13 @@ func newConfirmedMessageFromBytes(pdu PDUType, data []byte) (*ConfirmedMessage,
 		return nil, errors.New("insufficient length for message type")
 	}
 	control := data[0]
-	maxSegs := data[1] & 0b0111000 >> 4
+	maxSegs := (data[1] & 0x70) >> 4
 	maxLen := data[1] & 0x0F
 
 	msg := ConfirmedMessage{
 		MessageBase:               MessageBase{pdu},
-		IsSegmented:               (control & 0x04) != 0,
-		DoSegmentsFollow:          (control & 0x03) != 0,
+		IsSegmented:               (control & 0x08) != 0,
+		DoSegmentsFollow:          (control & 0x04) != 0,
 		IsSegmentResponseAccepted: (control & 0x02) != 0,
 		MaxSegmentsAccepted:       maxSegs,
 		MaxLengthAccepted:         maxLen,
@@ -192,7 +471,7 @@ func newConfirmedMessageFromBytes(pdu PDUType, data []byte) (*ConfirmedMessage,
 	}
 	currByteIndex := 3
 	if msg.IsSegmented {
-		if len(data) < 5 {
+		if len(data) < 6 {
 			return nil, errors.New("insufficient length for message type")
 		}
 		seqNumber := data[currByteIndex]
@@ -203,6 +482,12 @@ func newConfirmedMessageFromBytes(pdu PDUType, data []byte) (*ConfirmedMessage,
 		msg.ProposedWindowSize = &winSize
 	}
 
+	if len(data) <= currByteIndex {
+		return nil, errors.New("insufficient length for message type")
+	}
+	if data[currByteIndex] >= ServiceConfirmedMax {
+		return nil, bacnet.ErrInvalidData
+	}
 	msg.ServiceID = ServiceConfirmed(data[currByteIndex])
 	currByteIndex++
 
@@ -213,9 +498,15 @@ func newConfirmedMessageFromBytes(pdu PDUType, data []byte) (*ConfirmedMessage,
 
 }
 
+// unconfirmedWithoutParameters are the unconfirmed services whose parameters are all optional, so they can
+// be sent without any.
+var unconfirmedWithoutParameters = map[ServiceUnconfirmed]bool{
+	ServiceUnconfirmedWhoIs: true,
+}
+
 // The first byte is the control byte, which has already been parsed, so unconfirmed messages
 // read from the second byte onward
-func newUnconfirmedMessageFromBytes(pdu PDUType, data []byte) (*UnconfirmedMessage, error) {
+func newUnconfirmedMessageFromBytes(pdu PDUType, data []byte, o *decodeOptions) (*UnconfirmedMessage, error) {
 	if len(data) < 2 {
 		return nil, errors.New("insufficient length for message type")
 	}
@@ -225,12 +516,35 @@ func newUnconfirmedMessageFromBytes(pdu PDUType, data []byte) (*UnconfirmedMessa
 		ServiceID:   ServiceUnconfirmed(data[1]),
 	}
 
+	// Some services, like a global WhoIs, have no parameters, so the message is only the PDU type and the
+	// service choice.
+	if len(data) == 2 && unconfirmedWithoutParameters[msg.ServiceID] {
+		return &msg, nil
+	}
+
 	// The parameters depend on the service type. So, we just have a big switch and parse the data
 	buf := bytes.NewBuffer(data[2:])
 	switch msg.ServiceID {
 	case ServiceUnconfirmedIAm:
-
+		// Only the device ID is required. Some devices leave off parameters, or add more, so we keep
+		// whatever we can decode of the rest.
+		devID, err := decodeIAmParameter(buf, 0, o)
+		if err != nil {
+			return nil, err
+		}
+		params := []TagType{devID}
+		for len(params) < 4 && buf.Len() > 0 {
+			param, err := decodeIAmParameter(buf, len(params), o)
+			if err != nil {
+				break
+			}
+			params = append(params, param)
+		}
+		msg.ServiceData = params
+		return &msg, nil
 	case ServiceUnconfirmedWhoIs:
+		// A global WhoIs has no range, so it had no parameters. Otherwise, it must have both limits, and
+		// nothing else.
 		lowTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
 		if err != nil {
 			return nil, err
@@ -239,16 +553,79 @@ func newUnconfirmedMessageFromBytes(pdu PDUType, data []byte) (*UnconfirmedMessa
 		if err != nil {
 			return nil, err
 		}
-		return &UnconfirmedMessage{
-			MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
-			ServiceID:   ServiceUnconfirmedWhoIs,
-			ServiceData: []TagType{lowTag, highTag},
-		}, nil
+		low := lowTag.(*ContextSpecificUnsignedIntType)
+		high := highTag.(*ContextSpecificUnsignedIntType)
+		if low.TagNumber != 0 || high.TagNumber != 1 || buf.Len() > 0 {
+			return nil, bacnet.ErrInvalidData
+		}
+		msg.ServiceData = []TagType{lowTag, highTag}
+		return &msg, nil
+	case ServiceUnconfirmedWhoHas:
+		params, err := decodeWhoHas(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg.ServiceData = params
+		return &msg, nil
+	case ServiceUnconfirmedIHave:
+		params, err := decodeIHave(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg.ServiceData = params
+		return &msg, nil
+	case ServiceUnconfirmedCOVNotification:
+		params, err := decodeCOVNotification(data[2:])
+		if err != nil {
+			return nil, err
+		}
+		msg.ServiceData = params
+		return &msg, nil
+	case ServiceUnconfirmedTimeSync, ServiceUnconfirmedUTCTimeSync:
+		params, err := decodeTimeSync(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg.ServiceData = params
+		return &msg, nil
 	default:
 		return nil, bacnet.ErrNotImplemented
 	}
+}
 
-	return &msg, nil
+// MaxInstanceNumber is the largest object instance that fits in the 22 bits of an object ID. For devices, it
+// is also used to indicate an unconfigured device.
+const MaxInstanceNumber = 0x3FFFFF
+
+// NewWhoIsFromMessage gets the range from a decoded WhoIs message. Without the range, it's 0 to
+// MaxInstanceNumber.
+func NewWhoIsFromMessage(msg *UnconfirmedMessage) (*WhoIs, error) {
+	if msg.ServiceID != ServiceUnconfirmedWhoIs {
+		return nil, bacnet.ErrInvalidData
+	}
+	switch len(msg.ServiceData) {
+	case 0:
+		return &WhoIs{Low: 0, High: MaxInstanceNumber}, nil
+	case 2:
+		low, lowOK := msg.ServiceData[0].(*ContextSpecificUnsignedIntType)
+		high, highOK := msg.ServiceData[1].(*ContextSpecificUnsignedIntType)
+		if !lowOK || !highOK {
+			return nil, bacnet.ErrInvalidData
+		}
+		return &WhoIs{Low: low.Value(), High: high.Value()}, nil
+	default:
+		return nil, bacnet.ErrInvalidData
+	}
+}
+
+// Matches checks if the device instance is in the range. A high limit of MaxInstanceNumber (or more) is all
+// devices from the low limit. An unconfigured device, whose instance is MaxInstanceNumber, never matches,
+// even the full range, since it isn't a real instance.
+func (w *WhoIs) Matches(instance uint32) bool {
+	if instance >= MaxInstanceNumber {
+		return false
+	}
+	return uint(instance) >= w.Low && (w.High >= MaxInstanceNumber || uint(instance) <= w.High)
 }
 
 // NewWhoisMessage is just here temporarily. This should be in bacnet, but it requires that we export more types.
@@ -269,50 +646,254 @@ func NewWhoisMessage(low, high uint) (*UnconfirmedMessage, error) {
 
 }
 
-func NewIAmMessage(objectID, objectInstance uint32, maxAPDULengthAccepted uint, segmentationSupported bool,
+func NewIAmMessage(objectID, objectInstance uint32, maxAPDULengthAccepted uint, segmentation Segmentation,
 	vendorID uint16) (*UnconfirmedMessage, error) {
+	if err := segmentation.Validate(); err != nil {
+		return nil, err
+	}
 
-	devID, err := NewContextSpecificObjectID(0, objectID, objectInstance)
+	// IAm parameters are application tags (16.1.1.2 in the spec), unlike WhoIs.
+	devID, err := NewApplicationObjectID(objectID, objectInstance)
 	if err != nil {
 		return nil, err
 	}
-	maxAccepted, err := NewContextSpecificUnsignedInt(1, maxAPDULengthAccepted)
+	maxAccepted, err := NewApplicationUnsignedInt(maxAPDULengthAccepted)
 	if err != nil {
 		return nil, err
 	}
-	segSupported, err := NewContextSpecificBool(2, segmentationSupported)
+	segSupported, err := NewApplicationEnumerated(uint(segmentation))
 	if err != nil {
 		return nil, err
 	}
-	vID, err := NewContextSpecificUnsignedInt(3, uint(vendorID))
+	vID, err := NewApplicationUnsignedInt(uint(vendorID))
 	if err != nil {
 		return nil, err
 	}
 
 	return &UnconfirmedMessage{
 		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
-		ServiceID:   ServiceUnconfirmedWhoIs,
+		ServiceID:   ServiceUnconfirmedIAm,
 		ServiceData: []TagType{devID, maxAccepted, segSupported, vID},
 	}, nil
 
 }
 
-// Encode is unimplemented
+// NewDefaultIAmMessage creates an IAm for a device, advertising the default max APDU length.
+func NewDefaultIAmMessage(deviceInstance uint32, segmentation Segmentation,
+	vendorID uint16) (*UnconfirmedMessage, error) {
+	return NewIAmMessage(uint32(bacnet.ObjectTypeDevice), deviceInstance, DefaultMaxAPDULength().Bytes(),
+		segmentation, vendorID)
+}
+
+// decodeIAmParameter decodes the IAm parameter at the index. They should be application tags, but some
+// devices send context specific tags, so those are converted to the application tag for the parameter.
+func decodeIAmParameter(buf *bytes.Buffer, index int, o *decodeOptions) (TagType, error) {
+	return decodeWithRawCapture(buf, o, func(buf *bytes.Buffer) (TagType, error) {
+		return decodeIAmTag(buf, index)
+	})
+}
+
+// decodeIAmTag decodes the IAm parameter at the index, converting a context specific tag.
+func decodeIAmTag(buf *bytes.Buffer, index int) (TagType, error) {
+	_, class, _, err := peekTag(buf)
+	if err != nil {
+		return nil, err
+	}
+	if class == TagApplicationClass {
+		if index == 0 {
+			return NewApplicationObjectIDFromBytes(buf)
+		}
+		return newApplicationTagFromBytes(buf)
+	}
+
+	tag, err := NewContextSpecificRawFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	warnf("IAm parameter %d has a context specific tag instead of an application tag", index)
+	raw := tag.(*ContextSpecificRawType)
+	switch index {
+	case 0:
+		objectID, err := raw.ObjectID()
+		if err != nil {
+			return nil, err
+		}
+		return NewApplicationObjectID(uint32(objectID.Type), objectID.Instance)
+	case 2:
+		return NewApplicationEnumerated(raw.Unsigned())
+	default:
+		return NewApplicationUnsignedInt(raw.Unsigned())
+	}
+}
+
+// NewIAmFromMessage gets the parameters from a decoded IAm message. The device ID is required, but the rest
+// are best effort: if any are missing or invalid, they are left as the zero value, and Partial is set. The
+// exception is the segmentation, which is SegmentationNone, since the zero value claims full support.
+func NewIAmFromMessage(msg *UnconfirmedMessage) (*IAm, error) {
+	if msg.ServiceID != ServiceUnconfirmedIAm || len(msg.ServiceData) == 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	devID, ok := msg.ServiceData[0].(*ApplicationObjectIDType)
+	if !ok {
+		return nil, bacnet.ErrInvalidData
+	}
+	iAm := IAm{
+		DeviceID:     devID.ObjectID(),
+		Segmentation: SegmentationNone,
+		Partial:      len(msg.ServiceData) < 4,
+	}
+
+	if len(msg.ServiceData) > 1 {
+		if maxAccepted, ok := msg.ServiceData[1].(*ApplicationUnsignedIntType); ok {
+			iAm.MaxAPDULengthAccepted = maxAccepted.Value()
+		} else {
+			iAm.Partial = true
+		}
+	}
+	if len(msg.ServiceData) > 2 {
+		segSupported, ok := msg.ServiceData[2].(*ApplicationEnumeratedType)
+		if ok && segSupported.Value() <= uint(SegmentationNone) {
+			iAm.Segmentation = Segmentation(segSupported.Value())
+		} else {
+			iAm.Partial = true
+		}
+	}
+	if len(msg.ServiceData) > 3 {
+		vID, ok := msg.ServiceData[3].(*ApplicationUnsignedIntType)
+		if ok && vID.Value() <= 0xFFFF {
+			iAm.VendorID = uint16(vID.Value())
+		} else {
+			iAm.Partial = true
+		}
+	}
+	return &iAm, nil
+}
+
+// newConfirmedMessage creates an unsegmented confirmed request with the default max APDU length. All of the
+// confirmed builders should use this.
+func newConfirmedMessage(invokeID uint8, service ServiceConfirmed, data []byte) *ConfirmedMessage {
+	return &ConfirmedMessage{
+		MessageBase:       MessageBase{PDUTypeConfirmedServiceRequest},
+		MaxLengthAccepted: uint8(DefaultMaxAPDULength()),
+		InvokeID:          invokeID,
+		ServiceID:         service,
+		ServiceData:       data,
+	}
+}
+
+// Encode encodes the confirmed message. The service data is already encoded by the builders.
 func (cm *ConfirmedMessage) Encode() ([]byte, error) {
-	return nil, bacnet.ErrNotImplemented
+	if err := MaxSegments(cm.MaxSegmentsAccepted).Validate(); err != nil {
+		return nil, err
+	}
+	if err := MaxAPDULength(cm.MaxLengthAccepted).Validate(); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 4+len(cm.ServiceData)))
+
+	control := byte(cm.ServiceType)
+	if cm.IsSegmented {
+		control |= 0x08
+	}
+	if cm.DoSegmentsFollow {
+		control |= 0x04
+	}
+	if cm.IsSegmentResponseAccepted {
+		control |= 0x02
+	}
+	buf.WriteByte(control)
+	buf.WriteByte(cm.MaxSegmentsAccepted<<4 | cm.MaxLengthAccepted)
+	buf.WriteByte(cm.InvokeID)
+	if cm.IsSegmented {
+		if cm.SequenceNumber == nil || cm.ProposedWindowSize == nil {
+			return nil, errors.New("segmented message requires a sequence number and window size")
+		}
+		buf.WriteByte(*cm.SequenceNumber)
+		buf.WriteByte(*cm.ProposedWindowSize)
+	}
+	buf.WriteByte(byte(cm.ServiceID))
+	buf.Write(cm.ServiceData)
+
+	return buf.Bytes(), nil
+}
+
+// Clone copies the message, including the service data and the segmentation fields. The decoded parameters
+// are not copied, and will be decoded again if they are needed.
+func (cm *ConfirmedMessage) Clone() Message {
+	clone := *cm
+	clone.SequenceNumber = cloneUint8(cm.SequenceNumber)
+	clone.ProposedWindowSize = cloneUint8(cm.ProposedWindowSize)
+	clone.ServiceData = cloneBytes(cm.ServiceData)
+	clone.parameters = nil
+	return &clone
+}
+
+// Parameters decodes the service data into tags. Primitive context specific parameters are decoded as
+// ContextSpecificRawType, since the type depends on the service. The result is cached, so ServiceData
+// should not be changed after this is called. Like the rest of the message, it isn't safe to call from
+// multiple goroutines, so Clone the message for each.
+func (cm *ConfirmedMessage) Parameters() ([]TagType, error) {
+	if cm.parameters != nil {
+		return cm.parameters, nil
+	}
+	params, err := DecodeTags(bytes.NewBuffer(cm.ServiceData))
+	if err != nil {
+		return nil, err
+	}
+	cm.parameters = params
+	return params, nil
+}
+
+// Clone copies the message, including each of the tags in the service data and what they hold
+func (um *UnconfirmedMessage) Clone() Message {
+	clone := *um
+	if um.ServiceData != nil {
+		clone.ServiceData = make([]TagType, len(um.ServiceData))
+		for i, tag := range um.ServiceData {
+			clone.ServiceData[i] = cloneTag(tag)
+		}
+	}
+	return &clone
+}
+
+// cloneUint8 copies the optional value, so the copy doesn't point to the original.
+func cloneUint8(val *uint8) *uint8 {
+	if val == nil {
+		return nil
+	}
+	clone := *val
+	return &clone
+}
+
+// cloneBytes copies the slice, keeping nil as nil.
+func cloneBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append([]byte{}, data...)
 }
 
-// Encode is This is generic enough to encode all Unconfirmed messages.
+// Encode is This is generic enough to encode all Unconfirmed messages. The service data of the services that
+// have an encoder from RegisterUnconfirmedEncoder is encoded with it instead.
 func (um *UnconfirmedMessage) Encode() ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 2))
 
 	buf.WriteByte(byte(um.ServiceType)) // I thought << 5
 	buf.WriteByte(byte(um.ServiceID))
 
+	if encoder := unconfirmedEncoder(um.ServiceID); encoder != nil {
+		data, err := encoder(um.ServiceData)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		return buf.Bytes(), nil
+	}
+
 	for _, param := range um.ServiceData {
-		// This is probably more complicated than it needs to be. But, until I study the encodings for
-		// each type and class, it's better to keep them separate for now.
-		bs, err := param.EncodeAsTagData(TagContextSpecificClass)
+		// Each parameter knows its class, since some services (IAm) use application tags, and others
+		// (WhoIs) use context specific tags.
+		bs, err := param.EncodeAsTagData(param.Class())
 		if err != nil {
 			return nil, err
 		}
@@ -321,3 +902,38 @@ func (um *UnconfirmedMessage) Encode() ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// ReEncode encodes the message like Encode, except that the parameters that were decoded WithRawCapture
+// are written as the bytes that they were decoded from. So, a message that was decoded with raw capture is
+// encoded as it was received, even if we would have encoded the parameters differently.
+func (um *UnconfirmedMessage) ReEncode() ([]byte, error) {
+	if unconfirmedEncoder(um.ServiceID) != nil {
+		return um.Encode()
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 2))
+	buf.WriteByte(byte(um.ServiceType))
+	buf.WriteByte(byte(um.ServiceID))
+	for _, param := range um.ServiceData {
+		bs := param.Raw()
+		if bs == nil {
+			var err error
+			bs, err = param.EncodeAsTagData(param.Class())
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(bs)
+	}
+	return buf.Bytes(), nil
+}
+
+// ReEncode encodes the decoded message as it was received, if it was decoded WithRawCapture. Only the
+// unconfirmed messages need the raw bytes, since the others keep their service data as bytes, and the rest of
+// them has only one encoding. The exception is the class and code of an Error, which may not have been
+// encoded in the fewest bytes.
+func ReEncode(msg Message) ([]byte, error) {
+	if um, ok := msg.(*UnconfirmedMessage); ok {
+		return um.ReEncode()
+	}
+	return msg.Encode()
+}
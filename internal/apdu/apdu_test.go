@@ -1,9 +1,14 @@
 package apdu
 
 import (
+	"bytes"
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 func TestUInt(t *testing.T) {
@@ -35,3 +40,551 @@ func TestUInt(t *testing.T) {
 		})
 	}
 }
+
+func TestInt(t *testing.T) {
+	testCases := []struct {
+		name     string
+		val      int64
+		wantSize uint
+		encoded  []byte
+	}{
+		{"zero", 0, 1, []byte{0x00}},
+		{"minus one", -1, 1, []byte{0xFF}},
+		{"largest 1 byte", 127, 1, []byte{0x7F}},
+		{"smallest positive 2 bytes", 128, 2, []byte{0x00, 0x80}},
+		{"smallest 1 byte", -128, 1, []byte{0x80}},
+		{"negative 2 bytes", -129, 2, []byte{0xFF, 0x7F}},
+		{"smallest 2 bytes", math.MinInt16, 2, []byte{0x80, 0x00}},
+		{"3 bytes", math.MaxInt16 + 1, 3, []byte{0x00, 0x80, 0x00}},
+		{"smallest 4 bytes", math.MinInt32, 4, []byte{0x80, 0x00, 0x00, 0x00}},
+		{"5 bytes", math.MaxInt32 + 1, 5, []byte{0x00, 0x80, 0x00, 0x00, 0x00}},
+		{"largest", math.MaxInt64, 8, []byte{0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"smallest", math.MinInt64, 8, []byte{0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tcase := range testCases {
+		t.Run(tcase.name, func(t *testing.T) {
+			assert.Equal(t, tcase.wantSize, GetSignedIntByteSize(tcase.val), "Unexpected size")
+			b := EncodeInt(tcase.val, tcase.wantSize)
+			assert.Equal(t, tcase.encoded, b, "Unexpected encoding")
+			assert.Equal(t, tcase.val, DecodeInt(b), "Unexpected decoded value")
+		})
+	}
+
+	// A larger size than needed is sign extended
+	assert.Equal(t, []byte{0xFF, 0xFF, 0xFF}, EncodeInt(-1, 3), "Negative not sign extended")
+	assert.Equal(t, int64(-1), DecodeInt([]byte{0xFF, 0xFF, 0xFF}), "Negative not sign extended")
+}
+
+func TestConfirmedServiceRange(t *testing.T) {
+	testCases := []struct {
+		name        string
+		service     byte
+		expectedErr error
+	}{
+		{"last service", 29, nil},
+		{"max", 30, bacnet.ErrInvalidData},
+		{"past max", 31, bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			_, err := NewMessageFromBytes([]byte{0x00, 0x05, 1, tCase.service})
+			assert.Equal(t, tCase.expectedErr, err, "Unexpected error decoding service")
+		})
+	}
+}
+
+func TestConfirmedControlLimits(t *testing.T) {
+	testCases := []struct {
+		name         string
+		maxSegments  uint8
+		maxLength    uint8
+		expectedByte byte
+		expectedErr  error
+	}{
+		{"unspecified", uint8(MaxSegmentsUnspecified), MaxAPDULength1476, 0x05, nil},
+		{"more than 64", uint8(MaxSegmentsMoreThan64), MaxAPDULength480, 0x73, nil},
+		{"segments out of range", 8, MaxAPDULength1476, 0, bacnet.ErrValueTooLarge},
+		{"length reserved", uint8(MaxSegments4), 6, 0, bacnet.ErrValueTooLarge},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg := newConfirmedMessage(1, ServiceConfirmedReadProperty, []byte{0x19, 85})
+			msg.MaxSegmentsAccepted = tCase.maxSegments
+			msg.MaxLengthAccepted = tCase.maxLength
+			encoded, err := msg.Encode()
+			assert.Equal(t, tCase.expectedErr, err, "Unexpected error encoding")
+			if tCase.expectedErr != nil {
+				return
+			}
+			assert.Equal(t, tCase.expectedByte, encoded[1], "Unexpected control byte")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+		})
+	}
+
+	assert.Equal(t, uint(0), MaxSegments(MaxSegmentsMoreThan64).Segments(), "More than 64 has no count")
+	assert.Equal(t, uint(64), MaxSegments(MaxSegments64).Segments(), "Unexpected segment count")
+}
+
+func TestConfirmedSegmentationFields(t *testing.T) {
+	seq := uint8(3)
+	window := uint8(4)
+	testCases := []struct {
+		name           string
+		data           []byte
+		segmented      bool
+		sequenceNumber *uint8
+		windowSize     *uint8
+	}{
+		{"segmented", []byte{0x0E, 0x45, 0x01, 0x03, 0x04, 0x0C, 0x19, 0x55}, true, &seq, &window},
+		{"not segmented", []byte{0x02, 0x45, 0x01, 0x0C, 0x19, 0x55}, false, nil, nil},
+		// Only the SEG bit has the fields, not the more follows bit
+		{"more follows without segmented", []byte{0x06, 0x45, 0x01, 0x0C, 0x19, 0x55}, false, nil, nil},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			decoded, err := NewMessageFromBytes(tCase.data)
+			assert.NoError(t, err, "Unexpected error decoding")
+			msg, ok := decoded.(*ConfirmedMessage)
+			if !assert.True(t, ok, "Expected a confirmed message") {
+				return
+			}
+			assert.Equal(t, tCase.segmented, msg.IsSegmented, "Unexpected segmented flag")
+			assert.Equal(t, tCase.sequenceNumber, msg.SequenceNumber, "Unexpected sequence number")
+			assert.Equal(t, tCase.windowSize, msg.ProposedWindowSize, "Unexpected window size")
+			assert.Equal(t, ServiceConfirmed(ServiceConfirmedReadProperty), msg.ServiceID, "Unexpected service")
+			assert.Equal(t, []byte{0x19, 0x55}, msg.ServiceData, "Unexpected service data")
+		})
+	}
+
+	// A segmented message without room for the fields is an error
+	_, err := NewMessageFromBytes([]byte{0x08, 0x45, 0x01, 0x03, 0x04})
+	assert.Error(t, err, "Expected error without the service")
+}
+
+func TestUnconfirmedParameterClass(t *testing.T) {
+	iAm, err := NewIAmMessage(8, 1234, 1476, SegmentationNone, 260)
+	assert.NoError(t, err, "Unexpected error creating IAm")
+	whoIs, err := NewWhoisMessage(1, 1000)
+	assert.NoError(t, err, "Unexpected error creating WhoIs")
+
+	testCases := []struct {
+		name     string
+		msg      *UnconfirmedMessage
+		expected []byte
+	}{
+		// object ID, unsigned, enumerated, unsigned all have the class bit cleared
+		{"IAm", iAm, []byte{0x10, 0x00, 0xC4, 0x02, 0x00, 0x04, 0xD2, 0x22, 0x05, 0xC4, 0x91, 0x03, 0x22, 0x01,
+			0x04}},
+		{"WhoIs", whoIs, []byte{0x10, 0x08, 0x09, 0x01, 0x1A, 0x03, 0xE8}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			encoded, err := tCase.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.msg, decoded, "Decoded message does not match")
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	sequence, window := uint8(3), uint8(4)
+	confirmed := newConfirmedMessage(1, ServiceConfirmedReadProperty, []byte{0x19, 85})
+	confirmed.IsSegmented = true
+	confirmed.SequenceNumber = &sequence
+	confirmed.ProposedWindowSize = &window
+	complexAck := NewComplexAck(2, ServiceConfirmedReadProperty, []byte{0x3E, 0x91, 0x01, 0x3F})
+	whoIs, err := NewWhoisMessage(1, 1000)
+	assert.NoError(t, err, "Unexpected error creating WhoIs")
+	reject := &RejectMessage{MessageBase: MessageBase{PDUTypeReject}, OriginalInvokeID: 3, Reason: 1}
+	// An octet string, a context specific tag, and a constructed tag with another inside, decoded with the
+	// raw bytes, so every tag holds slices
+	serviceData := []byte{0x62, 0xAA, 0xBB, 0x09, 0x05, 0x1E, 0x09, 0x07, 0x1F}
+	tags, err := DecodeTags(bytes.NewBuffer(serviceData), WithRawCapture())
+	assert.NoError(t, err, "Unexpected error decoding tags")
+	withSlices := &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   ServiceUnconfirmedPrivateTransfer,
+		ServiceData: tags,
+	}
+
+	testCases := []struct {
+		name   string
+		msg    Message
+		mutate func(Message)
+	}{
+		{"confirmed", confirmed, func(m Message) {
+			c := m.(*ConfirmedMessage)
+			*c.SequenceNumber = 5
+			*c.ProposedWindowSize = 6
+			c.ServiceData[1] = 86
+		}},
+		{"complex ack", complexAck, func(m Message) {
+			m.(*ComplexAckMessage).ServiceData[2] = 0x00
+		}},
+		{"unconfirmed", whoIs, func(m Message) {
+			u := m.(*UnconfirmedMessage)
+			u.ServiceData[0].(*ContextSpecificUnsignedIntType).TagNumber = 2
+			u.ServiceData[1] = nil
+		}},
+		{"byte slices", withSlices, func(m Message) {
+			u := m.(*UnconfirmedMessage)
+			u.ServiceData[0].(*ApplicationOctetStringType).Value()[0] = 0x00
+			u.ServiceData[1].(*ContextSpecificRawType).Data()[0] = 0x00
+			nested := u.ServiceData[2].(*ConstructedType).Tags()[0]
+			nested.(*ContextSpecificRawType).Data()[0] = 0x00
+			for _, tag := range u.ServiceData {
+				tag.Raw()[0] = 0x00
+			}
+			nested.Raw()[0] = 0x00
+		}},
+		{"reject", reject, func(m Message) {
+			m.(*RejectMessage).Reason = 2
+		}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			expected, err := tCase.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding original")
+
+			clone := tCase.msg.Clone()
+			assert.Equal(t, tCase.msg, clone, "Clone does not match")
+			tCase.mutate(clone)
+
+			encoded, err := tCase.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding original")
+			assert.Equal(t, expected, encoded, "Changing the clone changed the original")
+		})
+	}
+
+	// The raw bytes aren't encoded, so check them separately
+	for i, expected := range [][]byte{serviceData[:3], serviceData[3:5], serviceData[5:]} {
+		assert.Equal(t, expected, withSlices.ServiceData[i].Raw(), "Changing the clone changed the raw bytes")
+	}
+}
+
+func TestIAmSegmentation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		segmentation Segmentation
+		expectedByte byte
+		canTransmit  bool
+		canReceive   bool
+	}{
+		{"both", SegmentationBoth, 0x00, true, true},
+		{"transmit", SegmentationTransmit, 0x01, true, false},
+		{"receive", SegmentationReceive, 0x02, false, true},
+		{"none", SegmentationNone, 0x03, false, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewIAmMessage(8, 1234, 1476, tCase.segmentation, 260)
+			assert.NoError(t, err, "Unexpected error creating IAm")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			// The segmentation is the enumerated tag after the object ID and max APDU length
+			assert.Equal(t, []byte{0x91, tCase.expectedByte}, encoded[10:12], "Segmentation not encoded")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			iAm, err := NewIAmFromMessage(decoded.(*UnconfirmedMessage))
+			assert.NoError(t, err, "Unexpected error getting IAm")
+			assert.Equal(t, &IAm{
+				DeviceID:              bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+				MaxAPDULengthAccepted: 1476,
+				Segmentation:          tCase.segmentation,
+				VendorID:              260,
+			}, iAm, "IAm does not match")
+			assert.Equal(t, tCase.canTransmit, iAm.Segmentation.CanTransmit(), "Unexpected transmit")
+			assert.Equal(t, tCase.canReceive, iAm.Segmentation.CanReceive(), "Unexpected receive")
+		})
+	}
+
+	_, err := NewIAmMessage(8, 1234, 1476, 4, 260)
+	assert.Equal(t, bacnet.ErrInvalidData, err, "Expected error for undefined segmentation")
+}
+
+func TestTruncatedIAm(t *testing.T) {
+	device := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	header := []byte{0x10, 0x00}
+	devID := []byte{0xC4, 0x02, 0x00, 0x04, 0xD2}
+
+	testCases := []struct {
+		name        string
+		params      []byte
+		expected    *IAm
+		expectedErr error
+	}{
+		{"all", []byte{0x22, 0x05, 0xC4, 0x91, 0x01, 0x22, 0x01, 0x04},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationTransmit, VendorID: 260},
+			nil},
+		{"extra", []byte{0x22, 0x05, 0xC4, 0x91, 0x01, 0x22, 0x01, 0x04, 0x21, 0x01},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationTransmit, VendorID: 260},
+			nil},
+		{"device ID only", nil, &IAm{DeviceID: device, Segmentation: SegmentationNone, Partial: true}, nil},
+		{"missing segmentation", []byte{0x22, 0x05, 0xC4},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationNone, Partial: true}, nil},
+		{"missing vendor", []byte{0x22, 0x05, 0xC4, 0x91, 0x03},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationNone, Partial: true}, nil},
+		{"wrong type", []byte{0x22, 0x05, 0xC4, 0x21, 0x03, 0x22, 0x01, 0x04},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationNone, VendorID: 260,
+				Partial: true}, nil},
+		{"undecodable", []byte{0x22, 0x05, 0xC4, 0x95},
+			&IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationNone, Partial: true}, nil},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			data := append(append(append([]byte{}, header...), devID...), tCase.params...)
+			decoded, err := NewMessageFromBytes(data)
+			assert.NoError(t, err, "Unexpected error decoding")
+			iAm, err := NewIAmFromMessage(decoded.(*UnconfirmedMessage))
+			assert.NoError(t, err, "Unexpected error getting IAm")
+			assert.Equal(t, tCase.expected, iAm, "IAm does not match")
+		})
+	}
+
+	// The device ID is required
+	_, err := NewMessageFromBytes(header)
+	assert.Error(t, err, "Expected error without a device ID")
+	_, err = NewMessageFromBytes([]byte{0x10, 0x00, 0x21, 0x01})
+	assert.Error(t, err, "Expected error when the first parameter isn't a device ID")
+}
+
+type warningRecorder struct {
+	warnings []string
+}
+
+func (r *warningRecorder) Printf(format string, v ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, v...))
+}
+
+func TestContextTaggedIAm(t *testing.T) {
+	device := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	expected := &IAm{DeviceID: device, MaxAPDULengthAccepted: 1476, Segmentation: SegmentationNone, VendorID: 260}
+
+	testCases := []struct {
+		name             string
+		params           []byte
+		expectedWarnings int
+	}{
+		{"all context", []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x1A, 0x05, 0xC4, 0x29, 0x03, 0x3A, 0x01, 0x04}, 4},
+		{"mixed", []byte{0xC4, 0x02, 0x00, 0x04, 0xD2, 0x1A, 0x05, 0xC4, 0x91, 0x03, 0x3A, 0x01, 0x04}, 2},
+		{"all application", []byte{0xC4, 0x02, 0x00, 0x04, 0xD2, 0x22, 0x05, 0xC4, 0x91, 0x03, 0x22, 0x01, 0x04}, 0},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			recorder := &warningRecorder{}
+			SetLogger(recorder)
+			defer SetLogger(nil)
+
+			decoded, err := NewMessageFromBytes(append([]byte{0x10, 0x00}, tCase.params...))
+			assert.NoError(t, err, "Unexpected error decoding")
+			iAm, err := NewIAmFromMessage(decoded.(*UnconfirmedMessage))
+			assert.NoError(t, err, "Unexpected error getting IAm")
+			assert.Equal(t, expected, iAm, "IAm does not match")
+			assert.Len(t, recorder.warnings, tCase.expectedWarnings, "Unexpected warnings")
+		})
+	}
+}
+
+func TestWhoIsMatches(t *testing.T) {
+	testCases := []struct {
+		name     string
+		low      uint
+		high     uint
+		instance uint32
+		expected bool
+	}{
+		{"full range low", 0, MaxInstanceNumber, 0, true},
+		{"full range", 0, MaxInstanceNumber, 1234, true},
+		{"full range high", 0, MaxInstanceNumber, MaxInstanceNumber - 1, true},
+		{"full range unconfigured", 0, MaxInstanceNumber, MaxInstanceNumber, false},
+		{"past full range", 0, 0xFFFFFFFF, 1234, true},
+		{"in range", 10, 20, 20, true},
+		{"below range", 10, 20, 9, false},
+		{"above range", 10, 20, 21, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewWhoisMessage(tCase.low, tCase.high)
+			assert.NoError(t, err, "Unexpected error creating WhoIs")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding WhoIs")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding WhoIs")
+
+			whoIs, err := NewWhoIsFromMessage(decoded.(*UnconfirmedMessage))
+			assert.NoError(t, err, "Unexpected error getting the range")
+			assert.Equal(t, &WhoIs{Low: tCase.low, High: tCase.high}, whoIs, "Unexpected range")
+			assert.Equal(t, tCase.expected, whoIs.Matches(tCase.instance), "Unexpected match")
+		})
+	}
+
+	// Without the range, it's all devices
+	whoIs, err := NewWhoIsFromMessage(&UnconfirmedMessage{ServiceID: ServiceUnconfirmedWhoIs})
+	assert.NoError(t, err, "Unexpected error getting the range")
+	assert.Equal(t, &WhoIs{Low: 0, High: MaxInstanceNumber}, whoIs, "Unexpected range")
+}
+
+func TestMessagePDUType(t *testing.T) {
+	whoIs, err := NewWhoisMessage(0, 10)
+	assert.NoError(t, err, "Unable to create WhoIs")
+	readProperty, err := NewReadPropertyMessage(1, bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1},
+		bacnet.PropertyIdentifierObjectName, nil)
+	assert.NoError(t, err, "Unable to create ReadProperty")
+	testCases := []struct {
+		name     string
+		msg      Message
+		expected PDUType
+	}{
+		{"confirmed", readProperty, PDUTypeConfirmedServiceRequest},
+		{"unconfirmed", whoIs, PDUTypeUnconfirmedServiceRequest},
+		{"simple ack", NewSimpleAck(1, ServiceConfirmedWriteProperty), PDUTypeSimpleAck},
+		{"complex ack", NewComplexAck(1, ServiceConfirmedReadProperty, []byte{0x0C, 0x02, 0x00, 0x00, 0x01}),
+			PDUTypeComplexAck},
+		{"segment ack", NewSegmentAck(1, 0, 4, false, false), PDUTypeSegmentAck},
+		{"error", NewErrorResponse(1, ServiceConfirmedReadProperty, ErrorClassProperty, ErrorCodeUnknownProperty),
+			PDUTypeError},
+		{"reject", NewReject(1, RejectReasonUnrecognizedService), PDUTypeReject},
+		{"abort", NewAbort(1, AbortReasonOther, true), PDUTypeAbort},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, tCase.msg.PDUType(), "Unexpected PDU type")
+
+			encoded, err := tCase.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.expected, decoded.PDUType(), "Unexpected PDU type after decoding")
+			assert.Equal(t, tCase.expected, decoded.Clone().PDUType(), "Unexpected PDU type of the clone")
+		})
+	}
+}
+
+func TestWhoIsDecoding(t *testing.T) {
+	testCases := []struct {
+		name        string
+		data        []byte
+		expected    *WhoIs
+		expectedErr bool
+	}{
+		{"global", []byte{0x10, 0x08}, &WhoIs{Low: 0, High: MaxInstanceNumber}, false},
+		{"range", []byte{0x10, 0x08, 0x09, 0x01, 0x1A, 0x03, 0xE8}, &WhoIs{Low: 1, High: 1000}, false},
+		{"one and a half", []byte{0x10, 0x08, 0x09, 0x01, 0x1A, 0x03}, nil, true},
+		{"low only", []byte{0x10, 0x08, 0x09, 0x01}, nil, true},
+		{"swapped tags", []byte{0x10, 0x08, 0x19, 0x01, 0x0A, 0x03, 0xE8}, nil, true},
+		{"extra byte", []byte{0x10, 0x08, 0x09, 0x01, 0x1A, 0x03, 0xE8, 0x00}, nil, true},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			decoded, err := NewMessageFromBytes(tCase.data)
+			if tCase.expectedErr {
+				assert.Error(t, err, "Expected error decoding")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error decoding")
+			msg, ok := decoded.(*UnconfirmedMessage)
+			assert.True(t, ok, "Unexpected message type")
+			whoIs, err := NewWhoIsFromMessage(msg)
+			assert.NoError(t, err, "Unexpected error getting the range")
+			assert.Equal(t, tCase.expected, whoIs, "Unexpected range")
+		})
+	}
+}
+
+func TestUnconfirmedWithoutParameters(t *testing.T) {
+	testCases := []struct {
+		name    string
+		service ServiceUnconfirmed
+		allowed bool
+	}{
+		{"whois", ServiceUnconfirmedWhoIs, true},
+		{"iam", ServiceUnconfirmedIAm, false},
+		{"whohas", ServiceUnconfirmedWhoHas, false},
+		{"utc time sync", ServiceUnconfirmedUTCTimeSync, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			data := []byte{byte(PDUTypeUnconfirmedServiceRequest), byte(tCase.service)}
+			decoded, err := NewMessageFromBytes(data)
+			if !tCase.allowed {
+				assert.Error(t, err, "Service needs parameters")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error decoding")
+			msg, ok := decoded.(*UnconfirmedMessage)
+			assert.True(t, ok, "Unexpected message type")
+			assert.Equal(t, tCase.service, msg.ServiceID, "Unexpected service")
+			assert.Empty(t, msg.ServiceData, "Should not have parameters")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, data, encoded, "Should encode back to the two bytes")
+		})
+	}
+}
+
+func TestServiceNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		service  fmt.Stringer
+		expected string
+	}{
+		{"WhoIs", ServiceUnconfirmed(ServiceUnconfirmedWhoIs), "WhoIs"},
+		{"IAm", ServiceUnconfirmedIAm, "IAm"},
+		{"ReadProperty", ServiceConfirmed(ServiceConfirmedReadProperty), "ReadProperty"},
+		{"unknown unconfirmed", ServiceUnconfirmed(42), "unknown(42)"},
+		{"unknown confirmed", ServiceConfirmed(ServiceConfirmedMax), "unknown(30)"},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, tCase.service.String(), "Unexpected name")
+		})
+	}
+}
+
+func TestNegotiatedAPDULength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		remote   MaxAPDULength
+		local    MaxAPDULength
+		expected uint
+	}{
+		{"remote smaller", MaxAPDULength480, MaxAPDULength1476, 480},
+		{"local smaller", MaxAPDULength1476, MaxAPDULength206, 206},
+		{"same", MaxAPDULength1024, MaxAPDULength1024, 1024},
+		{"smallest", MaxAPDULength50, MaxAPDULength1476, 50},
+		{"reserved remote", 9, MaxAPDULength480, 480},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, NegotiatedAPDULength(tCase.remote, tCase.local), "Unexpected length")
+		})
+	}
+}
+
+func TestMaxAPDULengthFromBytes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bytes    uint
+		expected MaxAPDULength
+	}{
+		{"exact", 480, MaxAPDULength480},
+		{"between", 1000, MaxAPDULength480},
+		{"largest", 1476, MaxAPDULength1476},
+		{"larger than largest", 9000, MaxAPDULength1476},
+		{"smaller than smallest", 10, MaxAPDULength50},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, MaxAPDULengthFromBytes(tCase.bytes), "Unexpected code")
+		})
+	}
+}
@@ -1,5 +1,14 @@
 package apdu
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
 // application encoding (as opposed to context specific)
 type (
 	ApplicationTag struct {
@@ -27,25 +36,51 @@ type (
 		ApplicationTypeBase
 		val uint
 	}
+	// ApplicationSignedIntType is the smallest two's complement of the value
 	ApplicationSignedIntType struct {
+		ApplicationTypeBase
+		val int
 	}
+	// ApplicationRealType is a 4 byte IEEE-754 float, like the ContextSpecificRealType
 	ApplicationRealType struct {
+		ApplicationTypeBase
+		val float32
 	}
 	ApplicationDoubleType struct {
 	}
 	ApplicationOctetStringType struct {
+		ApplicationTypeBase
+		val []byte
 	}
+	// ApplicationCharacterStringType is the character set byte, followed by the encoded string. We only
+	// support UTF-8.
 	ApplicationCharacterStringType struct {
+		ApplicationTypeBase
+		val string
 	}
 	ApplicationBitStringType struct {
 	}
+	// ApplicationEnumeratedType is encoded the same as the unsigned int, but the meaning of the value depends
+	// on what is being enumerated (error class, property identifier, etc.)
 	ApplicationEnumeratedType struct {
+		ApplicationTypeBase
+		val uint
 	}
+	// ApplicationDateType is 4 bytes, like the ContextSpecificDateType
 	ApplicationDateType struct {
+		ApplicationTypeBase
+		val bacnet.Date
 	}
+	// ApplicationTimeType is 4 bytes, like the ContextSpecificTimeType
 	ApplicationTimeType struct {
+		ApplicationTypeBase
+		val bacnet.Time
 	}
+	// ApplicationObjectIDType is packed like the ContextSpecificObjectIDType
 	ApplicationObjectIDType struct {
+		ApplicationTypeBase
+		objectType     uint32
+		objectInstance uint32
 	}
 )
 
@@ -53,11 +88,98 @@ var (
 	_ TagType = (*ApplicationNullType)(nil)
 	_ TagType = (*ApplicationBoolType)(nil)
 	_ TagType = (*ApplicationUnsignedIntType)(nil)
+	_ TagType = (*ApplicationSignedIntType)(nil)
+	_ TagType = (*ApplicationRealType)(nil)
+	_ TagType = (*ApplicationOctetStringType)(nil)
+	_ TagType = (*ApplicationCharacterStringType)(nil)
+	_ TagType = (*ApplicationEnumeratedType)(nil)
+	_ TagType = (*ApplicationDateType)(nil)
+	_ TagType = (*ApplicationTimeType)(nil)
+	_ TagType = (*ApplicationObjectIDType)(nil)
 )
 
+// Class is always the application class for these types
+func (p ApplicationTypeBase) Class() TagClass {
+	return TagApplicationClass
+}
+
+// NewApplicationTagFromBytes decodes the next application tag in the buffer. Unlike context specific tags,
+// the type is in the tag, so we can decode without knowing what to expect.
+func NewApplicationTagFromBytes(tagBuf *bytes.Buffer, opts ...DecodeOption) (TagType, error) {
+	o, err := newDecodeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return decodeWithRawCapture(tagBuf, o, newApplicationTagFromBytes)
+}
+
+// newApplicationTagFromBytes decodes the application tag with the decoder for its type.
+func newApplicationTagFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, _, err := peekTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagApplicationClass {
+		return nil, bacnet.ErrInvalidData
+	}
+	switch TagNumberType(tagNumber) {
+	case TagNumberDataNull:
+		return NewApplicationNullFromBytes(tagBuf)
+	case TagNumberDataBool:
+		return NewApplicationBoolFromBytes(tagBuf)
+	case TagNumberDataUnsignedInt:
+		return NewApplicationUnsignedIntFromBytes(tagBuf)
+	case TagNumberDataSignedInt:
+		return NewApplicationSignedIntFromBytes(tagBuf)
+	case TagNumberDataReal:
+		return NewApplicationRealFromBytes(tagBuf)
+	case TagNumberDataOctetString:
+		return NewApplicationOctetStringFromBytes(tagBuf)
+	case TagNumberDataCharacterString:
+		return NewApplicationCharacterStringFromBytes(tagBuf)
+	case TagNumberDataEnumerated:
+		return NewApplicationEnumeratedFromBytes(tagBuf)
+	case TagNumberDataDate:
+		return NewApplicationDateFromBytes(tagBuf)
+	case TagNumberDataTime:
+		return NewApplicationTimeFromBytes(tagBuf)
+	case TagNumberDataObjectID:
+		return NewApplicationObjectIDFromBytes(tagBuf)
+	default:
+		return nil, bacnet.ErrNotImplemented
+	}
+}
+
+// decodeApplicationTag decodes a primitive application tag, checking that it is the expected type.
+func decodeApplicationTag(tagBuf *bytes.Buffer, expected TagNumberType) ([]byte, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagApplicationClass || TagNumberType(tagNumber) != expected {
+		return nil, bacnet.ErrInvalidData
+	}
+	return data, nil
+}
+
+// NewApplicationNull creates a null application tag
+func NewApplicationNull() (TagType, error) {
+	return &ApplicationNullType{}, nil
+}
+
+// NewApplicationNullFromBytes decodes a null application tag from the buffer
+func NewApplicationNullFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	if _, err := decodeApplicationTag(tagBuf, TagNumberDataNull); err != nil {
+		return nil, err
+	}
+	return &ApplicationNullType{}, nil
+}
+
 func (p *ApplicationNullType) EncodeAsTagData(class TagClass) ([]byte, error) {
 	var control byte
-	encodeTagNumber(&control, uint8(TagNumberDataNull))
+	if _, err := encodeTagNumber(&control, uint8(TagNumberDataNull)); err != nil {
+		return nil, err
+	}
 	encodeClass(&control, class)
 	// NULL is just 0 for everything
 	return []byte{control}, nil
@@ -70,22 +192,351 @@ func (p *ApplicationBoolType) EncodeAsTagData(class TagClass) ([]byte, error) {
 	if class == TagContextSpecificClass {
 		// some kind of output?
 	}
-	encodeTagNumber(&control, uint8(TagNumberDataBool))
+	if _, err := encodeTagNumber(&control, uint8(TagNumberDataBool)); err != nil {
+		return nil, err
+	}
 	encodeClass(&control, class)
-	shift := 0
-	if !p.val {
-		shift = 1
+	if p.val {
+		control |= 0x01
 	}
-	control |= byte(1) << shift
 
 	// bool is encoded into the first byte
 	return []byte{control}, nil
 }
+
+// NewApplicationBool creates a bool application tag
+func NewApplicationBool(val bool) (TagType, error) {
+	return &ApplicationBoolType{val: val}, nil
+}
+
+// NewApplicationBoolFromBytes decodes a bool application tag from the buffer. The value is in the length
+// bits, so there is no data.
+func NewApplicationBoolFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, lvt, err := peekTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagApplicationClass || TagNumberType(tagNumber) != TagNumberDataBool || lvt > 1 {
+		return nil, bacnet.ErrInvalidData
+	}
+	tagBuf.Next(1)
+	return &ApplicationBoolType{val: lvt == 1}, nil
+}
+
+// Value returns the bool
+func (p *ApplicationBoolType) Value() bool {
+	return p.val
+}
+
+// NewApplicationUnsignedInt creates an unsigned int application tag
+func NewApplicationUnsignedInt(val uint) (TagType, error) {
+	return &ApplicationUnsignedIntType{val: val}, nil
+}
+
+// NewApplicationUnsignedIntFromBytes decodes an unsigned int application tag from the buffer
+func NewApplicationUnsignedIntFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataUnsignedInt)
+	if err != nil {
+		return nil, err
+	}
+	val, err := decodeUnsigned(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ApplicationUnsignedIntType{val: val}, nil
+}
+
+// Value returns the unsigned int
+func (p *ApplicationUnsignedIntType) Value() uint {
+	return p.val
+}
+
 func (p *ApplicationUnsignedIntType) EncodeAsTagData(class TagClass) ([]byte, error) {
-	var control byte
-	encodeTagNumber(&control, uint8(TagNumberDataUnsignedInt))
-	encodeClass(&control, class)
+	return encodeTag(uint8(TagNumberDataUnsignedInt), TagApplicationClass,
+		EncodeUint(p.val, GetUnsignedIntByteSize(p.val)))
+}
 
-	// This is not right
-	return []byte{control}, nil
+// NewApplicationSignedInt creates a signed int application tag
+func NewApplicationSignedInt(val int) (TagType, error) {
+	return &ApplicationSignedIntType{val: val}, nil
+}
+
+// NewApplicationSignedIntFromBytes decodes a signed int application tag from the buffer
+func NewApplicationSignedIntFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataSignedInt)
+	if err != nil {
+		return nil, err
+	}
+	val, err := decodeInteger(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ApplicationSignedIntType{val: val}, nil
+}
+
+// Value returns the signed int
+func (p *ApplicationSignedIntType) Value() int {
+	return p.val
+}
+
+func (p *ApplicationSignedIntType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataSignedInt), TagApplicationClass, encodeInteger(p.val))
+}
+
+// NewApplicationReal creates a real application tag
+func NewApplicationReal(val float32) (TagType, error) {
+	return &ApplicationRealType{val: val}, nil
+}
+
+// NewApplicationRealFromBytes decodes a real application tag from the buffer
+func NewApplicationRealFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataReal)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ApplicationRealType{val: math.Float32frombits(binary.BigEndian.Uint32(data))}, nil
+}
+
+// Value returns the real value
+func (p *ApplicationRealType) Value() float32 {
+	return p.val
+}
+
+func (p *ApplicationRealType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, math.Float32bits(p.val))
+	return encodeTag(uint8(TagNumberDataReal), TagApplicationClass, data)
+}
+
+// NewApplicationOctetString creates an octet string application tag. The bytes are not copied.
+func NewApplicationOctetString(val []byte) (TagType, error) {
+	return &ApplicationOctetStringType{val: val}, nil
+}
+
+// NewApplicationOctetStringFromBytes decodes an octet string application tag from the buffer
+func NewApplicationOctetStringFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataOctetString)
+	if err != nil {
+		return nil, err
+	}
+	// The data is a slice of the buffer, which the caller may reuse
+	val := make([]byte, len(data))
+	copy(val, data)
+	return &ApplicationOctetStringType{val: val}, nil
+}
+
+// Value returns the bytes
+func (p *ApplicationOctetStringType) Value() []byte {
+	return p.val
+}
+
+func (p *ApplicationOctetStringType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataOctetString), TagApplicationClass, p.val)
+}
+
+// CharacterSet is the first byte of a character string (20.2.9 in the spec)
+type CharacterSet uint8
+
+// The values for CharacterSet. We are explicit because these are transmitted.
+const (
+	CharacterSetUTF8      CharacterSet = 0 // was ANSI X3.4 in older revisions
+	CharacterSetIBMDBCS                = 1
+	CharacterSetJISX0208               = 2
+	CharacterSetUCS4                   = 3
+	CharacterSetUCS2                   = 4
+	CharacterSetISO8859_1              = 5
+)
+
+// encodeCharacterString encodes the string with the character set byte. We always encode as UTF-8.
+func encodeCharacterString(val string) []byte {
+	return append([]byte{byte(CharacterSetUTF8)}, val...)
+}
+
+// decodeCharacterString decodes the string after checking the character set. ISO 8859-1 is converted to
+// UTF-8, so the strings compare the same no matter how they were sent. The other character sets are not
+// implemented, and we don't want to return garbage.
+func decodeCharacterString(data []byte) (string, error) {
+	if len(data) < 1 {
+		return "", bacnet.ErrInvalidData
+	}
+	switch CharacterSet(data[0]) {
+	case CharacterSetUTF8:
+		return string(data[1:]), nil
+	case CharacterSetISO8859_1:
+		// The code points of ISO 8859-1 are the same as the first 256 of Unicode
+		runes := make([]rune, 0, len(data)-1)
+		for _, b := range data[1:] {
+			runes = append(runes, rune(b))
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("character set %d: %w", data[0], bacnet.ErrNotImplemented)
+	}
+}
+
+// NewApplicationCharacterString creates a character string application tag
+func NewApplicationCharacterString(val string) (TagType, error) {
+	return &ApplicationCharacterStringType{val: val}, nil
+}
+
+// NewApplicationCharacterStringFromBytes decodes a character string application tag from the buffer
+func NewApplicationCharacterStringFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataCharacterString)
+	if err != nil {
+		return nil, err
+	}
+	val, err := decodeCharacterString(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ApplicationCharacterStringType{val: val}, nil
+}
+
+// Value returns the string
+func (p *ApplicationCharacterStringType) Value() string {
+	return p.val
+}
+
+func (p *ApplicationCharacterStringType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataCharacterString), TagApplicationClass, encodeCharacterString(p.val))
+}
+
+// NewApplicationEnumerated creates an enumerated application tag
+func NewApplicationEnumerated(val uint) (TagType, error) {
+	return &ApplicationEnumeratedType{val: val}, nil
+}
+
+// NewApplicationEnumeratedFromBytes decodes an enumerated application tag from the buffer
+func NewApplicationEnumeratedFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataEnumerated)
+	if err != nil {
+		return nil, err
+	}
+	val, err := decodeUnsigned(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ApplicationEnumeratedType{val: val}, nil
+}
+
+// Value returns the enumerated value
+func (p *ApplicationEnumeratedType) Value() uint {
+	return p.val
+}
+
+func (p *ApplicationEnumeratedType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataEnumerated), TagApplicationClass,
+		EncodeUint(p.val, GetUnsignedIntByteSize(p.val)))
+}
+
+// NewApplicationDate creates a date application tag
+func NewApplicationDate(val bacnet.Date) (TagType, error) {
+	return &ApplicationDateType{val: val}, nil
+}
+
+// NewApplicationDateFromBytes decodes a date application tag from the buffer
+func NewApplicationDateFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataDate)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ApplicationDateType{
+		val: bacnet.Date{Year: data[0], Month: data[1], Day: data[2], Weekday: data[3]},
+	}, nil
+}
+
+// Value returns the date
+func (p *ApplicationDateType) Value() bacnet.Date {
+	return p.val
+}
+
+func (p *ApplicationDateType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataDate), TagApplicationClass,
+		[]byte{p.val.Year, p.val.Month, p.val.Day, p.val.Weekday})
+}
+
+// NewApplicationTime creates a time application tag
+func NewApplicationTime(val bacnet.Time) (TagType, error) {
+	return &ApplicationTimeType{val: val}, nil
+}
+
+// NewApplicationTimeFromBytes decodes a time application tag from the buffer
+func NewApplicationTimeFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataTime)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ApplicationTimeType{
+		val: bacnet.Time{Hour: data[0], Minute: data[1], Second: data[2], Hundredths: data[3]},
+	}, nil
+}
+
+// Value returns the time
+func (p *ApplicationTimeType) Value() bacnet.Time {
+	return p.val
+}
+
+func (p *ApplicationTimeType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataTime), TagApplicationClass,
+		[]byte{p.val.Hour, p.val.Minute, p.val.Second, p.val.Hundredths})
+}
+
+// NewApplicationObjectID creates an object identifier application tag
+func NewApplicationObjectID(objectType, objectInstance uint32) (TagType, error) {
+	if err := validateObjectID(objectType, objectInstance); err != nil {
+		return nil, err
+	}
+	return &ApplicationObjectIDType{
+		objectType:     objectType,
+		objectInstance: objectInstance,
+	}, nil
+}
+
+// NewApplicationObjectIDFromBytes decodes an object identifier application tag from the buffer
+func NewApplicationObjectIDFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	data, err := decodeApplicationTag(tagBuf, TagNumberDataObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	cache := currentObjectIDCache()
+	key := objectIDCacheKey{class: TagApplicationClass, packed: uint32(DecodeUint(data))}
+	if cache != nil {
+		if tag, ok := cache.get(key); ok {
+			return tag, nil
+		}
+	}
+	objectType, objectInstance := decodeObjectID(data)
+	tag := &ApplicationObjectIDType{
+		objectType:     objectType,
+		objectInstance: objectInstance,
+	}
+	if cache != nil {
+		cache.add(key, tag)
+	}
+	return tag, nil
+}
+
+// ObjectID returns the object type and instance as an object identifier
+func (p *ApplicationObjectIDType) ObjectID() bacnet.ObjectID {
+	return bacnet.ObjectID{
+		Type:     bacnet.ObjectType(p.objectType),
+		Instance: p.objectInstance,
+	}
+}
+
+func (p *ApplicationObjectIDType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(uint8(TagNumberDataObjectID), TagApplicationClass,
+		encodeObjectID(p.objectType, p.objectInstance))
 }
@@ -0,0 +1,144 @@
+package apdu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestCharacterStringCoding(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          []byte
+		expectedValue string
+		expectedErr   error
+	}{
+		{"utf-8", []byte{0x75, 0x06, 0x00, 'Z', 'o', 'n', 'e', '1'}, "Zone1", nil},
+		{"empty", []byte{0x71, 0x00}, "", nil},
+		{"jis", []byte{0x73, 0x02, 0x30, 0x21}, "", bacnet.ErrNotImplemented},
+		{"no character set", []byte{0x70}, "", bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tag, err := NewApplicationTagFromBytes(bytes.NewBuffer(tCase.data))
+			assert.ErrorIs(t, err, tCase.expectedErr, "Unexpected error decoding")
+			if tCase.expectedErr != nil {
+				return
+			}
+			strTag, ok := tag.(*ApplicationCharacterStringType)
+			assert.True(t, ok, "Unexpected tag type")
+			assert.Equal(t, tCase.expectedValue, strTag.Value(), "Unexpected value")
+
+			encoded, err := tag.EncodeAsTagData(TagApplicationClass)
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.data, encoded, "Unexpected encoding")
+		})
+	}
+
+	t.Run("context specific", func(t *testing.T) {
+		tag, err := NewContextSpecificCharacterString(3, "a longer object name")
+		assert.NoError(t, err, "Unexpected error")
+		encoded, err := tag.EncodeAsTagData(TagContextSpecificClass)
+		assert.NoError(t, err, "Unexpected error encoding")
+		assert.Equal(t, []byte{0x3D, 21, 0x00}, encoded[:3], "Unexpected extended length encoding")
+		decoded, err := NewContextSpecificCharacterStringFromBytes(bytes.NewBuffer(encoded))
+		assert.NoError(t, err, "Unexpected error decoding")
+		assert.Equal(t, tag, decoded, "Decoded tag does not match")
+
+		_, err = NewContextSpecificCharacterStringFromBytes(bytes.NewBuffer([]byte{0x3A, 0x02, 'x'}))
+		assert.ErrorIs(t, err, bacnet.ErrNotImplemented, "Expected error for JIS")
+	})
+}
+
+func TestApplicationDateTimeCoding(t *testing.T) {
+	date := bacnet.Date{Year: 126, Month: 10, Day: 16, Weekday: 5}
+	tod := bacnet.Time{Hour: 13, Minute: 5, Second: 30, Hundredths: bacnet.DateTimeWildcard}
+	dateTag, _ := NewApplicationDate(date)
+	timeTag, _ := NewApplicationTime(tod)
+	testCases := []struct {
+		name     string
+		tag      TagType
+		expected []byte
+	}{
+		{"date", dateTag, []byte{0xA4, 126, 10, 16, 5}},
+		{"time", timeTag, []byte{0xB4, 13, 5, 30, 0xFF}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			encoded, err := tCase.tag.EncodeAsTagData(TagApplicationClass)
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Unexpected encoding")
+
+			decoded, err := NewApplicationTagFromBytes(bytes.NewBuffer(encoded))
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.tag, decoded, "Decoded tag does not match")
+		})
+	}
+
+	// The context specific tags are not application dates or times
+	contextDate, _ := NewContextSpecificDate(10, date)
+	encoded, err := contextDate.EncodeAsTagData(TagContextSpecificClass)
+	assert.NoError(t, err, "Unexpected error encoding")
+	_, err = NewApplicationDateFromBytes(bytes.NewBuffer(encoded))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for a context specific date")
+
+	_, err = NewApplicationTimeFromBytes(bytes.NewBuffer([]byte{0xB3, 13, 5, 30}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for a short time")
+}
+
+func TestApplicationRealCoding(t *testing.T) {
+	tag, err := NewApplicationReal(72.5)
+	assert.NoError(t, err, "Unexpected error creating real")
+	encoded, err := tag.EncodeAsTagData(TagApplicationClass)
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x44, 0x42, 0x91, 0x00, 0x00}, encoded, "Unexpected encoding")
+
+	decoded, err := NewApplicationTagFromBytes(bytes.NewBuffer(encoded))
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Equal(t, tag, decoded, "Decoded tag does not match")
+
+	_, err = NewApplicationRealFromBytes(bytes.NewBuffer([]byte{0x42, 0x42, 0x91}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for a short real")
+}
+
+func TestApplicationSignedIntCoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		val      int
+		expected []byte
+	}{
+		{"zero", 0, []byte{0x31, 0x00}},
+		{"positive", 72, []byte{0x31, 0x48}},
+		{"minus one", -1, []byte{0x31, 0xFF}},
+		{"smallest one byte", -128, []byte{0x31, 0x80}},
+		{"positive two bytes", 128, []byte{0x32, 0x00, 0x80}},
+		{"smallest two bytes", -32768, []byte{0x32, 0x80, 0x00}},
+		{"three bytes", -32769, []byte{0x33, 0xFF, 0x7F, 0xFF}},
+		{"four bytes", 1 << 30, []byte{0x34, 0x40, 0x00, 0x00, 0x00}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tag, err := NewApplicationSignedInt(tCase.val)
+			assert.NoError(t, err, "Unexpected error creating signed int")
+			encoded, err := tag.EncodeAsTagData(TagApplicationClass)
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Unexpected encoding")
+
+			decoded, err := NewApplicationTagFromBytes(bytes.NewBuffer(encoded))
+			assert.NoError(t, err, "Unexpected error decoding")
+			if assert.IsType(t, &ApplicationSignedIntType{}, decoded, "Unexpected type") {
+				assert.Equal(t, tCase.val, decoded.(*ApplicationSignedIntType).Value(), "Unexpected value")
+			}
+		})
+	}
+
+	_, err := NewApplicationSignedIntFromBytes(bytes.NewBuffer([]byte{0x30}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for an empty signed int")
+	_, err = NewApplicationSignedIntFromBytes(bytes.NewBuffer([]byte{0x21, 0x01}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for an unsigned int")
+	_, err = NewApplicationSignedIntFromBytes(bytes.NewBuffer([]byte{0x39, 0x01}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for the context specific class")
+}
@@ -0,0 +1,147 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// AtomicWriteFile (15.2 in the spec) writes to a file object. We only support stream access, so the request
+// is:
+// File Identifier (application object ID)
+// 0: Stream Access (constructed), which has application tags:
+//    File Start Position (signed int)
+//    File Data (octet string)
+// The ack is the position that the data was actually written at:
+// 0: File Start Position (signed int)
+// A start position of -1 appends to the end of the file.
+
+// atomicWriteFileOverhead is the most that an unsegmented request takes besides the file data: the confirmed
+// request header (4), object ID (5), opening and closing tags (2), start position (5), and the octet
+// string tag with a 2 byte length (4).
+const atomicWriteFileOverhead = 20
+
+// AtomicWriteFileRequest is the decoded service data of an AtomicWriteFile request with stream access.
+type AtomicWriteFileRequest struct {
+	File  bacnet.ObjectID
+	Start int
+	Data  []byte
+}
+
+// MaxAtomicWriteFileData returns the most file data that fits in one AtomicWriteFile request when the APDU
+// can be maxLength.
+func MaxAtomicWriteFileData(maxLength MaxAPDULength) int {
+	return int(maxLength.Bytes()) - atomicWriteFileOverhead
+}
+
+// NewAtomicWriteFileMessage creates an AtomicWriteFile request to write the data to the file, starting at
+// start.
+func NewAtomicWriteFileMessage(invokeID uint8, file bacnet.ObjectID, start int, data []byte) (
+	*ConfirmedMessage, error) {
+	fileTag, err := NewApplicationObjectID(uint32(file.Type), file.Instance)
+	if err != nil {
+		return nil, err
+	}
+	fileBytes, err := fileTag.EncodeAsTagData(TagApplicationClass)
+	if err != nil {
+		return nil, err
+	}
+	startBytes, err := encodeTag(uint8(TagNumberDataSignedInt), TagApplicationClass, encodeInteger(start))
+	if err != nil {
+		return nil, err
+	}
+	dataTag, err := NewApplicationOctetString(data)
+	if err != nil {
+		return nil, err
+	}
+	dataBytes, err := dataTag.EncodeAsTagData(TagApplicationClass)
+	if err != nil {
+		return nil, err
+	}
+	opening, err := encodeOpeningTag(0)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := encodeClosingTag(0)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+atomicWriteFileOverhead))
+	buf.Write(fileBytes)
+	buf.Write(opening)
+	buf.Write(startBytes)
+	buf.Write(dataBytes)
+	buf.Write(closing)
+	return newConfirmedMessage(invokeID, ServiceConfirmedAtomicWriteFile, buf.Bytes()), nil
+}
+
+// NewAtomicWriteFileRequestFromBytes decodes the service data of an AtomicWriteFile request. Record access
+// is not implemented.
+func NewAtomicWriteFileRequestFromBytes(data []byte) (_ *AtomicWriteFileRequest, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	fileTag, err := NewApplicationObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if isOpeningTag(buf, 1) {
+		return nil, bacnet.ErrNotImplemented
+	}
+	if err := readOpeningTag(buf, 0); err != nil {
+		return nil, err
+	}
+	startData, err := decodeApplicationTag(buf, TagNumberDataSignedInt)
+	if err != nil {
+		return nil, err
+	}
+	start, err := decodeInteger(startData)
+	if err != nil {
+		return nil, err
+	}
+	dataTag, err := NewApplicationOctetStringFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := readClosingTag(buf, 0); err != nil {
+		return nil, err
+	}
+
+	return &AtomicWriteFileRequest{
+		File:  fileTag.(*ApplicationObjectIDType).ObjectID(),
+		Start: start,
+		Data:  dataTag.(*ApplicationOctetStringType).Value(),
+	}, nil
+}
+
+// NewAtomicWriteFileAckMessage creates the ComplexAck response for AtomicWriteFile, with the position that
+// the data was written at.
+func NewAtomicWriteFileAckMessage(invokeID uint8, start int) (*ComplexAckMessage, error) {
+	data, err := encodeTag(0, TagContextSpecificClass, encodeInteger(start))
+	if err != nil {
+		return nil, err
+	}
+	return NewComplexAck(invokeID, ServiceConfirmedAtomicWriteFile, data), nil
+}
+
+// NewAtomicWriteFileAckFromBytes decodes the service data of the ComplexAck for AtomicWriteFile, returning
+// the position that the data was written at.
+func NewAtomicWriteFileAckFromBytes(data []byte) (_ int, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	tagNumber, class, startData, err := decodeTag(buf)
+	if err != nil {
+		return 0, err
+	}
+	if class != TagContextSpecificClass {
+		return 0, bacnet.ErrInvalidData
+	}
+	if tagNumber == 1 {
+		// The start record, for record access
+		return 0, bacnet.ErrNotImplemented
+	}
+	if tagNumber != 0 {
+		return 0, bacnet.ErrInvalidData
+	}
+	return decodeInteger(startData)
+}
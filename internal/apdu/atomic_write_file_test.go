@@ -0,0 +1,55 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestAtomicWriteFileCoding(t *testing.T) {
+	file := bacnet.ObjectID{Type: bacnet.ObjectTypeFile, Instance: 1}
+	data := []byte("Chiller01 On-Time=4.3 Hours")
+
+	testCases := []struct {
+		name          string
+		start         int
+		expectedStart []byte
+	}{
+		// From the example in F.1.3 of the spec
+		{"example", 30, []byte{0x31, 0x1E}},
+		{"sign byte", 200, []byte{0x32, 0x00, 0xC8}},
+		{"append", -1, []byte{0x31, 0xFF}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewAtomicWriteFileMessage(85, file, tCase.start, data)
+			assert.NoError(t, err, "Unexpected error creating AtomicWriteFile")
+			expected := []byte{0xC4, 0x02, 0x80, 0x00, 0x01, 0x0E}
+			expected = append(expected, tCase.expectedStart...)
+			expected = append(append(expected, 0x65, 0x1B), data...)
+			expected = append(expected, 0x0F)
+			assert.Equal(t, expected, msg.ServiceData, "Request encoding not expected")
+
+			req, err := NewAtomicWriteFileRequestFromBytes(msg.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding request")
+			assert.Equal(t, &AtomicWriteFileRequest{File: file, Start: tCase.start, Data: data}, req,
+				"Decoded request does not match")
+
+			ack, err := NewAtomicWriteFileAckMessage(85, tCase.start)
+			assert.NoError(t, err, "Unexpected error creating ack")
+			start, err := NewAtomicWriteFileAckFromBytes(ack.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding ack")
+			assert.Equal(t, tCase.start, start, "Decoded start does not match")
+		})
+	}
+
+	// A full request must fit in the APDU
+	fullData := make([]byte, MaxAtomicWriteFileData(MaxAPDULength480))
+	msg, err := NewAtomicWriteFileMessage(1, file, 1<<30, fullData)
+	assert.NoError(t, err, "Unexpected error creating AtomicWriteFile")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.LessOrEqual(t, uint(len(encoded)), MaxAPDULength(MaxAPDULength480).Bytes(), "Request too large")
+}
@@ -2,7 +2,9 @@ package apdu
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 
 	"github.com/shigmas/modore/pkg/bacnet"
 )
@@ -52,23 +54,43 @@ type (
 		val uint
 	}
 
+	// ContextSpecificSignedIntType is the smallest two's complement of the value, like the
+	// ApplicationSignedIntType
 	ContextSpecificSignedIntType struct {
+		ContextSpecificTypeBase
+		val int64
 	}
+	// ContextSpecificRealType is a 4 byte IEEE-754 float
 	ContextSpecificRealType struct {
+		ContextSpecificTypeBase
+		val float32
 	}
 	ContextSpecificDoubleType struct {
 	}
 	ContextSpecificOctetStringType struct {
 	}
+	// ContextSpecificCharacterStringType is encoded like the ApplicationCharacterStringType
 	ContextSpecificCharacterStringType struct {
+		ContextSpecificTypeBase
+		val string
 	}
 	ContextSpecificBitStringType struct {
 	}
+	// ContextSpecificEnumeratedType is encoded like the unsigned int. The meaning of the value is up to the
+	// context.
 	ContextSpecificEnumeratedType struct {
+		ContextSpecificTypeBase
+		val uint
 	}
+	// ContextSpecificDateType is 4 bytes: year (since 1900), month, day and day of week
 	ContextSpecificDateType struct {
+		ContextSpecificTypeBase
+		val bacnet.Date
 	}
+	// ContextSpecificTimeType is 4 bytes: hour, minute, second and hundredths
 	ContextSpecificTimeType struct {
+		ContextSpecificTypeBase
+		val bacnet.Time
 	}
 
 	// ContextSpecificObjectIDType is 2 values which total 32 bits.
@@ -85,12 +107,37 @@ type (
 		objectType     uint32
 		objectInstance uint32
 	}
+
+	// ContextSpecificRawType is a primitive context specific tag decoded without knowing what type it is, so
+	// the data is kept as it was sent. The accessors interpret the data as one of the types.
+	ContextSpecificRawType struct {
+		ContextSpecificTypeBase
+		data []byte
+	}
+)
+
+var (
+	_ TagType = (*ContextSpecificBoolType)(nil)
+	_ TagType = (*ContextSpecificUnsignedIntType)(nil)
+	_ TagType = (*ContextSpecificSignedIntType)(nil)
+	_ TagType = (*ContextSpecificRealType)(nil)
+	_ TagType = (*ContextSpecificCharacterStringType)(nil)
+	_ TagType = (*ContextSpecificEnumeratedType)(nil)
+	_ TagType = (*ContextSpecificDateType)(nil)
+	_ TagType = (*ContextSpecificTimeType)(nil)
+	_ TagType = (*ContextSpecificObjectIDType)(nil)
+	_ TagType = (*ContextSpecificRawType)(nil)
 )
 
 func newContextSpecificTypeBase(tagNumber uint8) ContextSpecificTypeBase {
 	return ContextSpecificTypeBase{TagNumber: tagNumber}
 }
 
+// Class is always the context specific class for these types
+func (p ContextSpecificTypeBase) Class() TagClass {
+	return TagContextSpecificClass
+}
+
 func NewContextSpecificUnsignedInt(tagNumber uint8, val uint) (TagType, error) {
 	return &ContextSpecificUnsignedIntType{
 		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
@@ -99,44 +146,41 @@ func NewContextSpecificUnsignedInt(tagNumber uint8, val uint) (TagType, error) {
 
 }
 
+// NewContextSpecificUnsignedIntFromBytes decodes an unsigned int. A length of 0 is allowed, and decodes to 0.
 func NewContextSpecificUnsignedIntFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
-	control, err := tagBuf.ReadByte()
+	tagNumber, class, data, err := decodeTag(tagBuf)
 	if err != nil {
 		return nil, err
 	}
-	tagNumber, err := decodeTagNumber(control, tagBuf)
-	if err != nil {
-		return nil, err
-	}
-	tagClass := decodeClass(control)
-	if tagClass != TagContextSpecificClass {
-		return nil, fmt.Errorf("Expected ContextSpecificTagClass")
-	}
-	tagLen, err := decodeLength(control, tagBuf)
-	if err != nil {
-		return nil, err
+	if class != TagContextSpecificClass {
+		return nil, bacnet.ErrInvalidData
 	}
-	valBuf := make([]byte, tagLen)
-	bytesRead, err := tagBuf.Read(valBuf)
+
+	val, err := decodeUnsigned(data)
 	if err != nil {
 		return nil, err
-	} else if uint(bytesRead) != tagLen {
-		return nil, bacnet.ErrInsufficientData
 	}
-
 	return &ContextSpecificUnsignedIntType{
 		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
-		val:                     DecodeUint(valBuf),
+		val:                     val,
 	}, nil
 
 }
 
+// Value returns the unsigned int
+func (p *ContextSpecificUnsignedIntType) Value() uint {
+	return p.val
+}
+
 // This case doesn't use class type. But others do...?
 func (p *ContextSpecificUnsignedIntType) EncodeAsTagData(class TagClass) ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 1))
 
 	var control byte
-	tagBytes := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	tagBytes, err := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	if err != nil {
+		return nil, err
+	}
 	encodeClass(&control, TagContextSpecificClass)
 
 	length := GetUnsignedIntByteSize(p.val)
@@ -204,11 +248,19 @@ func NewContextSpecificUnsignedBoolromBytes(tagBuf *bytes.Buffer) (TagType, erro
 	}, nil
 }
 
+// Value returns the bool
+func (p *ContextSpecificBoolType) Value() bool {
+	return p.val
+}
+
 func (p *ContextSpecificBoolType) EncodeAsTagData(class TagClass) ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 1))
 
 	var control byte
-	tagBytes := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	tagBytes, err := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	if err != nil {
+		return nil, err
+	}
 	encodeClass(&control, TagContextSpecificClass)
 
 	lengthBytes, err := encodeLength(&control, 1)
@@ -239,8 +291,8 @@ func (p *ContextSpecificBoolType) EncodeAsTagData(class TagClass) ([]byte, error
 
 func NewContextSpecificObjectID(tagNumber uint8, objectType, objectInstance uint32) (TagType, error) {
 	// verify the values will fit
-	if objectType&0xFC00 != 0 || objectInstance&0xFF800000 != 0 {
-		return nil, bacnet.ErrInvalidData
+	if err := validateObjectID(objectType, objectInstance); err != nil {
+		return nil, err
 	}
 	return &ContextSpecificObjectIDType{
 		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
@@ -277,13 +329,28 @@ func NewContextSpecificObjectIDFromBytes(tagBuf *bytes.Buffer) (TagType, error)
 	} else if uint(bytesRead) != tagLen {
 		return nil, bacnet.ErrInsufficientData
 	}
-	stuffedValue := DecodeUint(valBuf)
+	cache := currentObjectIDCache()
+	key := objectIDCacheKey{
+		class:     TagContextSpecificClass,
+		tagNumber: tagNumber,
+		packed:    uint32(DecodeUint(valBuf)),
+	}
+	if cache != nil {
+		if tag, ok := cache.get(key); ok {
+			return tag, nil
+		}
+	}
+	objectType, objectInstance := decodeObjectID(valBuf)
 
-	return &ContextSpecificObjectIDType{
+	tag := &ContextSpecificObjectIDType{
 		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
-		objectType:              (uint32(stuffedValue) & 0xFFB00000) >> 22,
-		objectInstance:          uint32(stuffedValue) & 0x003FFFFF,
-	}, nil
+		objectType:              objectType,
+		objectInstance:          objectInstance,
+	}
+	if cache != nil {
+		cache.add(key, tag)
+	}
+	return tag, nil
 
 }
 
@@ -292,7 +359,10 @@ func (p *ContextSpecificObjectIDType) EncodeAsTagData(class TagClass) ([]byte, e
 	buf := bytes.NewBuffer(make([]byte, 0, 1))
 
 	var control byte
-	tagBytes := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	tagBytes, err := encodeTagNumber(&control, (uint8)(p.TagNumber))
+	if err != nil {
+		return nil, err
+	}
 	encodeClass(&control, TagContextSpecificClass)
 
 	lengthBytes, err := encodeLength(&control, 4)
@@ -311,9 +381,292 @@ func (p *ContextSpecificObjectIDType) EncodeAsTagData(class TagClass) ([]byte, e
 		buf.Write(lengthBytes)
 	}
 
-	// We have already validated that the values will fit in a 32 bit buffer, shift the
-	shiftedType := p.objectType << 22
-	stuffedVal := shiftedType & p.objectInstance
-	buf.Write(EncodeUint(uint(stuffedVal), 4))
+	// We have already validated that the values will fit in a 32 bit buffer
+	buf.Write(encodeObjectID(p.objectType, p.objectInstance))
 	return buf.Bytes(), nil
 }
+
+// ObjectID returns the object type and instance as an object identifier
+func (p *ContextSpecificObjectIDType) ObjectID() bacnet.ObjectID {
+	return bacnet.ObjectID{
+		Type:     bacnet.ObjectType(p.objectType),
+		Instance: p.objectInstance,
+	}
+}
+
+func NewContextSpecificEnumerated(tagNumber uint8, val uint) (TagType, error) {
+	return &ContextSpecificEnumeratedType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+func NewContextSpecificEnumeratedFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass {
+		return nil, bacnet.ErrInvalidData
+	}
+	val, err := decodeUnsigned(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ContextSpecificEnumeratedType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+// Value returns the enumerated value
+func (p *ContextSpecificEnumeratedType) Value() uint {
+	return p.val
+}
+
+func (p *ContextSpecificEnumeratedType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass, EncodeUint(p.val, GetUnsignedIntByteSize(p.val)))
+}
+
+func NewContextSpecificReal(tagNumber uint8, val float32) (TagType, error) {
+	return &ContextSpecificRealType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+func NewContextSpecificRealFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass || len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ContextSpecificRealType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     math.Float32frombits(binary.BigEndian.Uint32(data)),
+	}, nil
+}
+
+// Value returns the real
+func (p *ContextSpecificRealType) Value() float32 {
+	return p.val
+}
+
+func (p *ContextSpecificRealType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, math.Float32bits(p.val))
+	return encodeTag(p.TagNumber, TagContextSpecificClass, data)
+}
+
+func NewContextSpecificDate(tagNumber uint8, val bacnet.Date) (TagType, error) {
+	return &ContextSpecificDateType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+func NewContextSpecificDateFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass || len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ContextSpecificDateType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     bacnet.Date{Year: data[0], Month: data[1], Day: data[2], Weekday: data[3]},
+	}, nil
+}
+
+// Value returns the date
+func (p *ContextSpecificDateType) Value() bacnet.Date {
+	return p.val
+}
+
+func (p *ContextSpecificDateType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass,
+		[]byte{p.val.Year, p.val.Month, p.val.Day, p.val.Weekday})
+}
+
+func NewContextSpecificTime(tagNumber uint8, val bacnet.Time) (TagType, error) {
+	return &ContextSpecificTimeType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+func NewContextSpecificTimeFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass || len(data) != 4 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ContextSpecificTimeType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     bacnet.Time{Hour: data[0], Minute: data[1], Second: data[2], Hundredths: data[3]},
+	}, nil
+}
+
+// Value returns the time
+func (p *ContextSpecificTimeType) Value() bacnet.Time {
+	return p.val
+}
+
+func (p *ContextSpecificTimeType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass,
+		[]byte{p.val.Hour, p.val.Minute, p.val.Second, p.val.Hundredths})
+}
+
+func NewContextSpecificCharacterString(tagNumber uint8, val string) (TagType, error) {
+	return &ContextSpecificCharacterStringType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+func NewContextSpecificCharacterStringFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass {
+		return nil, bacnet.ErrInvalidData
+	}
+	val, err := decodeCharacterString(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ContextSpecificCharacterStringType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+// Value returns the string
+func (p *ContextSpecificCharacterStringType) Value() string {
+	return p.val
+}
+
+func (p *ContextSpecificCharacterStringType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass, encodeCharacterString(p.val))
+}
+
+// NewContextSpecificRawFromBytes decodes the next primitive context specific tag, keeping the data as is.
+func NewContextSpecificRawFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass {
+		return nil, fmt.Errorf("Expected ContextSpecificTagClass")
+	}
+	return &ContextSpecificRawType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		data:                    append([]byte{}, data...),
+	}, nil
+}
+
+// Data returns the data of the tag, without the control byte
+func (p *ContextSpecificRawType) Data() []byte {
+	return p.data
+}
+
+// Unsigned interprets the data as an unsigned int or enumerated value
+func (p *ContextSpecificRawType) Unsigned() uint {
+	return DecodeUint(p.data)
+}
+
+// ObjectID interprets the data as an object identifier
+func (p *ContextSpecificRawType) ObjectID() (bacnet.ObjectID, error) {
+	if len(p.data) != 4 {
+		return bacnet.ObjectID{}, bacnet.ErrInvalidData
+	}
+	objectType, objectInstance := decodeObjectID(p.data)
+	return bacnet.ObjectID{Type: bacnet.ObjectType(objectType), Instance: objectInstance}, nil
+}
+
+func (p *ContextSpecificRawType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass, p.data)
+}
+
+// NewContextSpecificSignedInt creates a signed int context specific tag
+func NewContextSpecificSignedInt(tagNumber uint8, val int64) (TagType, error) {
+	return &ContextSpecificSignedIntType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     val,
+	}, nil
+}
+
+// NewContextSpecificSignedIntFromBytes decodes a signed int. Unlike the unsigned int, it needs at least one
+// byte, since the sign is in the first one.
+func NewContextSpecificSignedIntFromBytes(tagBuf *bytes.Buffer) (TagType, error) {
+	tagNumber, class, data, err := decodeTag(tagBuf)
+	if err != nil {
+		return nil, err
+	}
+	if class != TagContextSpecificClass {
+		return nil, bacnet.ErrInvalidData
+	}
+	if len(data) == 0 || len(data) > maxUnsignedLength {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ContextSpecificSignedIntType{
+		ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber),
+		val:                     DecodeInt(data),
+	}, nil
+}
+
+// Value returns the signed int
+func (p *ContextSpecificSignedIntType) Value() int64 {
+	return p.val
+}
+
+func (p *ContextSpecificSignedIntType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	return encodeTag(p.TagNumber, TagContextSpecificClass, EncodeInt(p.val, GetSignedIntByteSize(p.val)))
+}
+
+// contextDecoders are the decoders of the context specific tags for the types that they can have.
+var contextDecoders = map[TagNumberType]func(*bytes.Buffer) (TagType, error){
+	TagNumberDataBool:            NewContextSpecificUnsignedBoolromBytes,
+	TagNumberDataUnsignedInt:     NewContextSpecificUnsignedIntFromBytes,
+	TagNumberDataSignedInt:       NewContextSpecificSignedIntFromBytes,
+	TagNumberDataReal:            NewContextSpecificRealFromBytes,
+	TagNumberDataCharacterString: NewContextSpecificCharacterStringFromBytes,
+	TagNumberDataEnumerated:      NewContextSpecificEnumeratedFromBytes,
+	TagNumberDataDate:            NewContextSpecificDateFromBytes,
+	TagNumberDataTime:            NewContextSpecificTimeFromBytes,
+	TagNumberDataObjectID:        NewContextSpecificObjectIDFromBytes,
+}
+
+// DecodeContextValue decodes the next context specific tag as the expected type. Unlike application tags,
+// the type isn't in the tag, so it has to come from where the tag is in the service. It returns
+// ErrNotImplemented for the types that we don't have context specific tags for.
+func DecodeContextValue(buf *bytes.Buffer, expected TagNumberType) (TagType, error) {
+	decode, ok := contextDecoders[expected]
+	if !ok {
+		return nil, bacnet.ErrNotImplemented
+	}
+	return decode(buf)
+}
+
+// DecodeContextValues decodes the context specific tags of a service, where the tag number is the position
+// in expected, which has the type of each one. All of the tags must be there, in order.
+func DecodeContextValues(buf *bytes.Buffer, expected []TagNumberType) ([]TagType, error) {
+	tags := make([]TagType, 0, len(expected))
+	for i, tagType := range expected {
+		if !isContextTag(buf, uint8(i)) {
+			return nil, bacnet.ErrInvalidData
+		}
+		tag, err := DecodeContextValue(buf, tagType)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
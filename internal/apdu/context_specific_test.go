@@ -1,9 +1,14 @@
 package apdu
 
 import (
+	"bytes"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 func TestUnsignedIntCoding(t *testing.T) {
@@ -15,3 +20,197 @@ func TestUnsignedIntCoding(t *testing.T) {
 	assert.NotNil(t, uTag, "Unable to encode int as Context Specific")
 
 }
+
+func TestLargeUnsignedIntCoding(t *testing.T) {
+	testCases := []struct {
+		name        string
+		val         uint
+		expected    []byte
+		expectedErr error
+	}{
+		{"5 bytes", 0x123456789A, []byte{0x0D, 0x05, 0x12, 0x34, 0x56, 0x78, 0x9A}, nil},
+		{"8 bytes", 0xFEDCBA9876543210, []byte{0x0D, 0x08, 0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10}, nil},
+		{"9 bytes", 0, []byte{0x0D, 0x09, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			bacnet.ErrValueTooLarge},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			if tCase.expectedErr == nil {
+				uTag, err := NewContextSpecificUnsignedInt(0, tCase.val)
+				assert.NoError(t, err, "Unexpected error")
+				encoded, err := uTag.EncodeAsTagData(TagContextSpecificClass)
+				assert.NoError(t, err, "Unexpected error encoding")
+				assert.Equal(t, tCase.expected, encoded, "Unexpected encoding")
+			}
+
+			decoded, err := NewContextSpecificUnsignedIntFromBytes(bytes.NewBuffer(tCase.expected))
+			assert.ErrorIs(t, err, tCase.expectedErr, "Unexpected error decoding")
+			if tCase.expectedErr == nil {
+				assert.Equal(t, tCase.val, decoded.(*ContextSpecificUnsignedIntType).Value(),
+					"Decoded value does not match")
+			}
+		})
+	}
+}
+
+func TestSignedIntCoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tag      uint8
+		val      int64
+		expected []byte
+	}{
+		{"minus one", 0, -1, []byte{0x09, 0xFF}},
+		{"positive", 1, 127, []byte{0x19, 0x7F}},
+		{"two bytes", 2, -129, []byte{0x2A, 0xFF, 0x7F}},
+		{"large tag number", 20, -32768, []byte{0xFA, 0x14, 0x80, 0x00}},
+		{"eight bytes", 3, math.MinInt64, []byte{0x3D, 0x08, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			sTag, err := NewContextSpecificSignedInt(tCase.tag, tCase.val)
+			assert.NoError(t, err, "Unexpected error")
+			encoded, err := sTag.EncodeAsTagData(TagContextSpecificClass)
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Unexpected encoding")
+
+			decoded, err := NewContextSpecificSignedIntFromBytes(bytes.NewBuffer(encoded))
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, sTag, decoded, "Decoded tag does not match")
+		})
+	}
+
+	_, err := NewContextSpecificSignedIntFromBytes(bytes.NewBuffer([]byte{0x31, 0xFF}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for the application class")
+	_, err = NewContextSpecificSignedIntFromBytes(bytes.NewBuffer([]byte{0x08}))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error without data")
+}
+
+func TestTagNumberLimit(t *testing.T) {
+	uTag, err := NewContextSpecificUnsignedInt(254, 381)
+	assert.NoError(t, err, "Unexpected error")
+	encoded, err := uTag.EncodeAsTagData(TagContextSpecificClass)
+	assert.NoError(t, err, "Unexpected error encoding tag number 254")
+	assert.Equal(t, []byte{0xFA, 254, 0x01, 0x7D}, encoded, "Unexpected encoding")
+
+	uTag, err = NewContextSpecificUnsignedInt(255, 381)
+	assert.NoError(t, err, "Unexpected error")
+	_, err = uTag.EncodeAsTagData(TagContextSpecificClass)
+	assert.Equal(t, bacnet.ErrValueTooLarge, err, "Expected error encoding tag number 255")
+}
+
+func TestZeroLengthDecoding(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    []byte
+		decoder func(*bytes.Buffer) (TagType, error)
+	}{
+		{"unsigned", []byte{0x08}, NewContextSpecificUnsignedIntFromBytes},
+		{"unsigned with trailing", []byte{0x18, 0x29, 0x05}, NewContextSpecificUnsignedIntFromBytes},
+		{"enumerated", []byte{0x28}, NewContextSpecificEnumeratedFromBytes},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(tCase.data)
+			tag, err := tCase.decoder(buf)
+			assert.NoError(t, err, "Unexpected error decoding zero length tag")
+			switch v := tag.(type) {
+			case *ContextSpecificUnsignedIntType:
+				assert.Equal(t, uint(0), v.Value(), "Unexpected value")
+			case *ContextSpecificEnumeratedType:
+				assert.Equal(t, uint(0), v.Value(), "Unexpected value")
+			default:
+				assert.Fail(t, "Unexpected tag type")
+			}
+			// The next tag should not have been consumed
+			assert.Equal(t, len(tCase.data)-1, buf.Len(), "Unexpected bytes consumed")
+		})
+	}
+}
+
+func TestDateTimeCoding(t *testing.T) {
+	loc := time.FixedZone("PST", -8*60*60)
+	testCases := []struct {
+		name         string
+		time         time.Time
+		expectedDate []byte
+		expectedTime []byte
+	}{
+		{"hundredths", time.Date(2024, time.March, 5, 13, 45, 30, 120000000, loc),
+			[]byte{0x0C, 124, 3, 5, 2}, []byte{0x1C, 13, 45, 30, 12}},
+		{"sunday midnight", time.Date(1999, time.December, 26, 0, 0, 0, 0, loc),
+			[]byte{0x0C, 99, 12, 26, 7}, []byte{0x1C, 0, 0, 0, 0}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			d, tod, err := bacnet.DateTimeFromTime(tCase.time)
+			assert.NoError(t, err, "Unexpected error converting time")
+			dateTag, err := NewContextSpecificDate(0, d)
+			assert.NoError(t, err, "Unexpected error creating date")
+			timeTag, err := NewContextSpecificTime(1, tod)
+			assert.NoError(t, err, "Unexpected error creating time")
+
+			encodedDate, err := dateTag.EncodeAsTagData(TagContextSpecificClass)
+			assert.NoError(t, err, "Unexpected error encoding date")
+			assert.Equal(t, tCase.expectedDate, encodedDate, "Unexpected date encoding")
+			encodedTime, err := timeTag.EncodeAsTagData(TagContextSpecificClass)
+			assert.NoError(t, err, "Unexpected error encoding time")
+			assert.Equal(t, tCase.expectedTime, encodedTime, "Unexpected time encoding")
+
+			buf := bytes.NewBuffer(append(encodedDate, encodedTime...))
+			decodedDate, err := NewContextSpecificDateFromBytes(buf)
+			assert.NoError(t, err, "Unexpected error decoding date")
+			decodedTime, err := NewContextSpecificTimeFromBytes(buf)
+			assert.NoError(t, err, "Unexpected error decoding time")
+			roundTrip, err := bacnet.DateTimeToTime(decodedDate.(*ContextSpecificDateType).Value(),
+				decodedTime.(*ContextSpecificTimeType).Value(), loc)
+			assert.NoError(t, err, "Unexpected error converting back to time")
+			assert.True(t, tCase.time.Equal(roundTrip), "Round trip time does not match")
+		})
+	}
+
+	t.Run("wildcards", func(t *testing.T) {
+		_, err := bacnet.DateToTime(bacnet.Date{Year: bacnet.DateTimeWildcard, Month: 1, Day: 1}, loc)
+		assert.Equal(t, bacnet.ErrUnspecifiedTime, err, "Expected error for any year")
+		_, err = bacnet.DateToTime(bacnet.Date{Year: 124, Month: 13, Day: 1}, loc)
+		assert.Equal(t, bacnet.ErrUnspecifiedTime, err, "Expected error for odd months")
+		_, err = bacnet.DateToTime(bacnet.Date{Year: 124, Month: 2, Day: 32}, loc)
+		assert.Equal(t, bacnet.ErrUnspecifiedTime, err, "Expected error for last day of month")
+		_, err = bacnet.TimeToTime(bacnet.Time{Hour: 12, Minute: bacnet.DateTimeWildcard}, loc)
+		assert.Equal(t, bacnet.ErrUnspecifiedTime, err, "Expected error for any minute")
+	})
+}
+
+func TestDecodeContextValue(t *testing.T) {
+	// Context tag 1 with the value 3, which could be either type
+	data := []byte{0x19, 0x03}
+	testCases := []struct {
+		name        string
+		expected    TagNumberType
+		expectedTag TagType
+		expectedErr error
+	}{
+		{"unsigned", TagNumberDataUnsignedInt, &ContextSpecificUnsignedIntType{
+			ContextSpecificTypeBase: newContextSpecificTypeBase(1), val: 3}, nil},
+		{"enumerated", TagNumberDataEnumerated, &ContextSpecificEnumeratedType{
+			ContextSpecificTypeBase: newContextSpecificTypeBase(1), val: 3}, nil},
+		{"not implemented", TagNumberDataDouble, nil, bacnet.ErrNotImplemented},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tag, err := DecodeContextValue(bytes.NewBuffer(data), tCase.expected)
+			assert.Equal(t, tCase.expectedErr, err, "Unexpected error")
+			assert.Equal(t, tCase.expectedTag, tag, "Unexpected tag")
+		})
+	}
+
+	tags, err := DecodeContextValues(bytes.NewBuffer([]byte{0x09, 0x05, 0x19, 0x03}),
+		[]TagNumberType{TagNumberDataUnsignedInt, TagNumberDataEnumerated})
+	assert.NoError(t, err, "Unexpected error decoding the values")
+	if assert.Len(t, tags, 2, "Unexpected number of tags") {
+		assert.IsType(t, &ContextSpecificUnsignedIntType{}, tags[0], "Expected unsigned")
+		assert.IsType(t, &ContextSpecificEnumeratedType{}, tags[1], "Expected enumerated")
+	}
+	_, err = DecodeContextValues(bytes.NewBuffer([]byte{0x19, 0x03}), []TagNumberType{TagNumberDataUnsignedInt})
+	assert.Equal(t, bacnet.ErrInvalidData, err, "Expected error for the wrong tag number")
+}
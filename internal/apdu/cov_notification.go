@@ -0,0 +1,137 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// UnconfirmedCOVNotification (16.3 in the spec) tells a subscriber that the monitored object changed. The
+// parameters are all context specific:
+// 0: Subscriber Process Identifier (unsigned)
+// 1: Initiating Device Identifier
+// 2: Monitored Object Identifier
+// 3: Time Remaining (unsigned, seconds)
+// 4: List of Values (constructed), which are PropertyValues
+
+// COVNotification is the service data of a COV notification.
+type COVNotification struct {
+	SubscriberProcessID uint
+	InitiatingDevice    bacnet.ObjectID
+	MonitoredObject     bacnet.ObjectID
+	TimeRemaining       uint
+	Values              []PropertyValue
+}
+
+// NewCOVNotificationMessage creates an unconfirmed COV notification.
+func NewCOVNotificationMessage(notification *COVNotification) (*UnconfirmedMessage, error) {
+	processTag, err := NewContextSpecificUnsignedInt(0, notification.SubscriberProcessID)
+	if err != nil {
+		return nil, err
+	}
+	deviceTag, err := NewContextSpecificObjectID(1, uint32(notification.InitiatingDevice.Type),
+		notification.InitiatingDevice.Instance)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewContextSpecificObjectID(2, uint32(notification.MonitoredObject.Type),
+		notification.MonitoredObject.Instance)
+	if err != nil {
+		return nil, err
+	}
+	timeTag, err := NewContextSpecificUnsignedInt(3, notification.TimeRemaining)
+	if err != nil {
+		return nil, err
+	}
+
+	valueTags, err := propertyValuesTags(notification.Values)
+	if err != nil {
+		return nil, err
+	}
+	listTag, err := NewConstructed(4, valueTags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   ServiceUnconfirmedCOVNotification,
+		ServiceData: []TagType{processTag, deviceTag, objTag, timeTag, listTag},
+	}, nil
+}
+
+// NewCOVNotificationFromMessage gets the notification from a decoded COV notification message.
+func NewCOVNotificationFromMessage(msg *UnconfirmedMessage) (*COVNotification, error) {
+	if msg.ServiceID != ServiceUnconfirmedCOVNotification {
+		return nil, bacnet.ErrInvalidData
+	}
+	data, err := encodeTags(msg.ServiceData, TagContextSpecificClass)
+	if err != nil {
+		return nil, err
+	}
+	return NewCOVNotificationFromBytes(data)
+}
+
+// decodeCOVNotification decodes the parameters of a COV notification message. The list of values is
+// constructed, so we decode the notification, and make the parameters from it.
+func decodeCOVNotification(data []byte) ([]TagType, error) {
+	notification, err := NewCOVNotificationFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := NewCOVNotificationMessage(notification)
+	if err != nil {
+		return nil, err
+	}
+	return msg.ServiceData, nil
+}
+
+// NewCOVNotificationFromBytes decodes the service data of a COV notification.
+func NewCOVNotificationFromBytes(data []byte) (_ *COVNotification, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	processTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	deviceTag, err := NewContextSpecificObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewContextSpecificObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	timeTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	process := processTag.(*ContextSpecificUnsignedIntType)
+	device := deviceTag.(*ContextSpecificObjectIDType)
+	obj := objTag.(*ContextSpecificObjectIDType)
+	remaining := timeTag.(*ContextSpecificUnsignedIntType)
+	if process.TagNumber != 0 || device.TagNumber != 1 || obj.TagNumber != 2 || remaining.TagNumber != 3 {
+		return nil, bacnet.ErrInvalidData
+	}
+	notification := COVNotification{
+		SubscriberProcessID: process.Value(),
+		InitiatingDevice:    device.ObjectID(),
+		MonitoredObject:     obj.ObjectID(),
+		TimeRemaining:       remaining.Value(),
+	}
+
+	if err := readOpeningTag(buf, 4); err != nil {
+		return nil, err
+	}
+	notification.Values, err = NewPropertyValuesFromBytes(buf, 4)
+	if err != nil {
+		return nil, err
+	}
+	if err := readClosingTag(buf, 4); err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &notification, nil
+}
@@ -0,0 +1,47 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestCOVNotificationCoding(t *testing.T) {
+	value, _ := NewApplicationEnumerated(1)
+	index := uint(8)
+	priority := uint(8)
+	notification := COVNotification{
+		SubscriberProcessID: 18,
+		InitiatingDevice:    bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 4},
+		MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeBinaryOutput, Instance: 10},
+		TimeRemaining:       60,
+		Values: []PropertyValue{
+			{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+				Values: []TagType{value}, Priority: &priority},
+			{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray,
+				ArrayIndex: &index}, Values: []TagType{value}},
+		},
+	}
+	msg, err := NewCOVNotificationMessage(&notification)
+	assert.NoError(t, err, "Unexpected error creating COV notification")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x10, 0x02,
+		0x09, 0x12, 0x1C, 0x02, 0x00, 0x00, 0x04, 0x2C, 0x01, 0x00, 0x00, 0x0A, 0x39, 0x3C,
+		0x4E,
+		0x09, 85, 0x2E, 0x91, 0x01, 0x2F, 0x39, 0x08,
+		0x09, 87, 0x19, 0x08, 0x2E, 0x91, 0x01, 0x2F,
+		0x4F}, encoded, "Encoding not expected")
+
+	decoded, err := NewCOVNotificationFromBytes(encoded[2:])
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Equal(t, &notification, decoded, "Decoded notification does not match")
+
+	decodedMsg, err := NewMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding the message")
+	fromMsg, err := NewCOVNotificationFromMessage(decodedMsg.(*UnconfirmedMessage))
+	assert.NoError(t, err, "Unexpected error getting the notification")
+	assert.Equal(t, &notification, fromMsg, "Notification from the message does not match")
+}
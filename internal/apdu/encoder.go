@@ -0,0 +1,41 @@
+package apdu
+
+import (
+	"sync"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// UnconfirmedEncoder encodes the service data of an unconfirmed service from its parameters. The service
+// choice is encoded before it.
+type UnconfirmedEncoder func(params []TagType) ([]byte, error)
+
+var (
+	unconfirmedEncoders    = make(map[ServiceUnconfirmed]UnconfirmedEncoder)
+	unconfirmedEncodersMux sync.RWMutex
+)
+
+// RegisterUnconfirmedEncoder sets the encoder for a service that isn't defined in the spec, like a vendor's
+// proprietary service, so its messages can be sent without changing this package. The defined services
+// always encode their parameters as tags, so they can't have an encoder. A nil encoder removes it.
+// Confirmed requests don't need an encoder, since their service data is already bytes.
+func RegisterUnconfirmedEncoder(service ServiceUnconfirmed, encoder UnconfirmedEncoder) error {
+	if service < ServiceUnconfirmedMax {
+		return bacnet.ErrInvalidData
+	}
+	unconfirmedEncodersMux.Lock()
+	defer unconfirmedEncodersMux.Unlock()
+	if encoder == nil {
+		delete(unconfirmedEncoders, service)
+	} else {
+		unconfirmedEncoders[service] = encoder
+	}
+	return nil
+}
+
+// unconfirmedEncoder gets the registered encoder for the service, or nil if it doesn't have one.
+func unconfirmedEncoder(service ServiceUnconfirmed) UnconfirmedEncoder {
+	unconfirmedEncodersMux.RLock()
+	defer unconfirmedEncodersMux.RUnlock()
+	return unconfirmedEncoders[service]
+}
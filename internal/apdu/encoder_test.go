@@ -0,0 +1,43 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestRegisterUnconfirmedEncoder(t *testing.T) {
+	const vendorService = ServiceUnconfirmed(64)
+	// The vendor's frame is its ID, then the value as one byte, without a tag.
+	encoder := func(params []TagType) ([]byte, error) {
+		data := []byte{0x01, 0x04}
+		for _, param := range params {
+			unsigned, ok := param.(*ApplicationUnsignedIntType)
+			if !ok {
+				return nil, bacnet.ErrInvalidData
+			}
+			data = append(data, byte(unsigned.Value()))
+		}
+		return data, nil
+	}
+	assert.Equal(t, bacnet.ErrInvalidData, RegisterUnconfirmedEncoder(ServiceUnconfirmedWhoIs, encoder),
+		"Expected error for a defined service")
+	assert.NoError(t, RegisterUnconfirmedEncoder(vendorService, encoder), "Unable to register encoder")
+
+	value, _ := NewApplicationUnsignedInt(7)
+	msg := &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   vendorService,
+		ServiceData: []TagType{value},
+	}
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x10, 64, 0x01, 0x04, 7}, encoded, "Expected the custom encoding")
+
+	assert.NoError(t, RegisterUnconfirmedEncoder(vendorService, nil), "Unable to remove encoder")
+	encoded, err = msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x10, 64, 0x21, 7}, encoded, "Expected the tags without the encoder")
+}
@@ -0,0 +1,206 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// UnconfirmedEventNotification (13.9 in the spec) tells a recipient that an object changed its event state.
+// The parameters are all context specific:
+// 0: Process Identifier (unsigned)
+// 1: Initiating Device Identifier
+// 2: Event Object Identifier
+// 3: Time Stamp (constructed choice of 0: time, 1: sequence number, 2: date time)
+// 4: Notification Class (unsigned)
+// 5: Priority (unsigned)
+// 6: Event Type (enumerated)
+// 7: Message Text (character string, optional)
+// 8: Notify Type (enumerated)
+// 9: Ack Required (bool, omitted for ack notifications)
+// 10: From State (enumerated, omitted for ack notifications)
+// 11: To State (enumerated)
+// 12: Event Values (constructed, optional)
+// We only use the time for the time stamp, and we don't have the event values, since most of them need bit
+// strings for the status flags.
+
+// EventNotification is the service data of an event notification. MessageText is omitted when it's empty.
+type EventNotification struct {
+	ProcessID         uint
+	InitiatingDevice  bacnet.ObjectID
+	EventObject       bacnet.ObjectID
+	TimeStamp         bacnet.Time
+	NotificationClass uint
+	Priority          uint
+	EventType         bacnet.EventType
+	MessageText       string
+	NotifyType        bacnet.NotifyType
+	AckRequired       bool
+	FromState         bacnet.EventState
+	ToState           bacnet.EventState
+}
+
+// NewEventNotificationMessage creates an unconfirmed event notification.
+func NewEventNotificationMessage(notification *EventNotification) (*UnconfirmedMessage, error) {
+	processTag, err := NewContextSpecificUnsignedInt(0, notification.ProcessID)
+	if err != nil {
+		return nil, err
+	}
+	deviceTag, err := NewContextSpecificObjectID(1, uint32(notification.InitiatingDevice.Type),
+		notification.InitiatingDevice.Instance)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewContextSpecificObjectID(2, uint32(notification.EventObject.Type),
+		notification.EventObject.Instance)
+	if err != nil {
+		return nil, err
+	}
+	timeTag, err := NewContextSpecificTime(0, notification.TimeStamp)
+	if err != nil {
+		return nil, err
+	}
+	timeStampTag, err := NewConstructed(3, []TagType{timeTag})
+	if err != nil {
+		return nil, err
+	}
+	classTag, err := NewContextSpecificUnsignedInt(4, notification.NotificationClass)
+	if err != nil {
+		return nil, err
+	}
+	priorityTag, err := NewContextSpecificUnsignedInt(5, notification.Priority)
+	if err != nil {
+		return nil, err
+	}
+	eventTypeTag, err := NewContextSpecificEnumerated(6, uint(notification.EventType))
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagType{processTag, deviceTag, objTag, timeStampTag, classTag, priorityTag, eventTypeTag}
+	if notification.MessageText != "" {
+		textTag, err := NewContextSpecificCharacterString(7, notification.MessageText)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, textTag)
+	}
+	notifyTypeTag, err := NewContextSpecificEnumerated(8, uint(notification.NotifyType))
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, notifyTypeTag)
+	if notification.NotifyType != bacnet.NotifyTypeAckNotification {
+		ackTag, err := NewContextSpecificBool(9, notification.AckRequired)
+		if err != nil {
+			return nil, err
+		}
+		fromTag, err := NewContextSpecificEnumerated(10, uint(notification.FromState))
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, ackTag, fromTag)
+	}
+	toTag, err := NewContextSpecificEnumerated(11, uint(notification.ToState))
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   ServiceUnconfirmedEventNotification,
+		ServiceData: append(tags, toTag),
+	}, nil
+}
+
+// NewEventNotificationFromBytes decodes the service data of an event notification. It returns
+// ErrNotImplemented for the time stamps and event values that we don't have.
+func NewEventNotificationFromBytes(data []byte) (_ *EventNotification, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	processTag, err := readContextTag(buf, 0, NewContextSpecificUnsignedIntFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	deviceTag, err := readContextTag(buf, 1, NewContextSpecificObjectIDFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := readContextTag(buf, 2, NewContextSpecificObjectIDFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := readOpeningTag(buf, 3); err != nil {
+		return nil, err
+	}
+	if !isContextTag(buf, 0) {
+		return nil, bacnet.ErrNotImplemented
+	}
+	timeTag, err := NewContextSpecificTimeFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := readClosingTag(buf, 3); err != nil {
+		return nil, err
+	}
+	classTag, err := readContextTag(buf, 4, NewContextSpecificUnsignedIntFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	priorityTag, err := readContextTag(buf, 5, NewContextSpecificUnsignedIntFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	eventTypeTag, err := readContextTag(buf, 6, NewContextSpecificEnumeratedFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	notification := EventNotification{
+		ProcessID:         processTag.(*ContextSpecificUnsignedIntType).Value(),
+		InitiatingDevice:  deviceTag.(*ContextSpecificObjectIDType).ObjectID(),
+		EventObject:       objTag.(*ContextSpecificObjectIDType).ObjectID(),
+		TimeStamp:         timeTag.(*ContextSpecificTimeType).Value(),
+		NotificationClass: classTag.(*ContextSpecificUnsignedIntType).Value(),
+		Priority:          priorityTag.(*ContextSpecificUnsignedIntType).Value(),
+		EventType:         bacnet.EventType(eventTypeTag.(*ContextSpecificEnumeratedType).Value()),
+	}
+
+	if isContextTag(buf, 7) {
+		textTag, err := NewContextSpecificCharacterStringFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		notification.MessageText = textTag.(*ContextSpecificCharacterStringType).Value()
+	}
+	notifyTypeTag, err := readContextTag(buf, 8, NewContextSpecificEnumeratedFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	notification.NotifyType = bacnet.NotifyType(notifyTypeTag.(*ContextSpecificEnumeratedType).Value())
+	if isContextTag(buf, 9) {
+		ackTag, err := NewContextSpecificUnsignedBoolromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		notification.AckRequired = ackTag.(*ContextSpecificBoolType).Value()
+	}
+	if isContextTag(buf, 10) {
+		fromTag, err := NewContextSpecificEnumeratedFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		notification.FromState = bacnet.EventState(fromTag.(*ContextSpecificEnumeratedType).Value())
+	}
+	toTag, err := readContextTag(buf, 11, NewContextSpecificEnumeratedFromBytes)
+	if err != nil {
+		return nil, err
+	}
+	notification.ToState = bacnet.EventState(toTag.(*ContextSpecificEnumeratedType).Value())
+
+	if isOpeningTag(buf, 12) {
+		return nil, bacnet.ErrNotImplemented
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &notification, nil
+}
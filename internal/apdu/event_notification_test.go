@@ -0,0 +1,70 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestEventNotificationCoding(t *testing.T) {
+	testCases := []struct {
+		name         string
+		notification EventNotification
+	}{
+		{"to offnormal", EventNotification{
+			ProcessID:         7,
+			InitiatingDevice:  bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+			EventObject:       bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+			TimeStamp:         bacnet.Time{Hour: 13, Minute: 30, Second: 5, Hundredths: 0},
+			NotificationClass: 3,
+			Priority:          100,
+			EventType:         bacnet.EventTypeOutOfRange,
+			NotifyType:        bacnet.NotifyTypeAlarm,
+			AckRequired:       true,
+			FromState:         bacnet.EventStateNormal,
+			ToState:           bacnet.EventStateHighLimit,
+		}},
+		{"message text", EventNotification{
+			InitiatingDevice: bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+			EventObject:      bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+			EventType:        bacnet.EventTypeOutOfRange,
+			MessageText:      "Zone too warm",
+			NotifyType:       bacnet.NotifyTypeEvent,
+			FromState:        bacnet.EventStateHighLimit,
+			ToState:          bacnet.EventStateNormal,
+		}},
+		{"ack notification", EventNotification{
+			InitiatingDevice: bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+			EventObject:      bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+			EventType:        bacnet.EventTypeOutOfRange,
+			NotifyType:       bacnet.NotifyTypeAckNotification,
+			ToState:          bacnet.EventStateHighLimit,
+		}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewEventNotificationMessage(&tCase.notification)
+			assert.NoError(t, err, "Unexpected error creating notification")
+			assert.Equal(t, ServiceUnconfirmed(ServiceUnconfirmedEventNotification), msg.ServiceID,
+				"Unexpected service")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding notification")
+
+			// The service data starts after the PDU type and service choice
+			decoded, err := NewEventNotificationFromBytes(encoded[2:])
+			assert.NoError(t, err, "Unexpected error decoding notification")
+			assert.Equal(t, &tCase.notification, decoded, "Decoded notification does not match")
+		})
+	}
+
+	t.Run("event values", func(t *testing.T) {
+		msg, err := NewEventNotificationMessage(&testCases[0].notification)
+		assert.NoError(t, err, "Unexpected error creating notification")
+		encoded, err := msg.Encode()
+		assert.NoError(t, err, "Unexpected error encoding notification")
+		_, err = NewEventNotificationFromBytes(append(encoded[2:], 0xCE, 0xCF))
+		assert.ErrorIs(t, err, bacnet.ErrNotImplemented, "Event values are not supported")
+	})
+}
@@ -0,0 +1,35 @@
+package apdu
+
+import "sync"
+
+// Logger receives the warnings about messages that don't follow the spec, but that we decode anyway.
+// *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var (
+	logger    Logger
+	loggerMux sync.RWMutex
+)
+
+// SetLogger sets the logger for the warnings from decoding and receiving. Without one, which is the default,
+// the warnings are dropped.
+func SetLogger(l Logger) {
+	loggerMux.Lock()
+	defer loggerMux.Unlock()
+	logger = l
+}
+
+func warnf(format string, v ...interface{}) {
+	loggerMux.RLock()
+	defer loggerMux.RUnlock()
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
+
+// Warnf sends a warning to the logger, so the transport drops the messages it can't handle the same way.
+func Warnf(format string, v ...interface{}) {
+	warnf(format, v...)
+}
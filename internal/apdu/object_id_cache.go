@@ -0,0 +1,88 @@
+package apdu
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// objectIDCache is a small LRU of decoded object identifier tags, keyed by the 4 packed bytes. With a
+	// lot of COV traffic, the same few object identifiers are decoded over and over, so the decoded tag is
+	// shared instead of allocating a new one each time. The tags are never modified after decoding, so
+	// sharing them is safe.
+	objectIDCache struct {
+		mux     sync.Mutex
+		size    int
+		entries map[objectIDCacheKey]*list.Element
+		order   *list.List // most recently used in front
+	}
+
+	// objectIDCacheKey includes the class and tag number, since those are part of the decoded tag.
+	objectIDCacheKey struct {
+		class     TagClass
+		tagNumber uint8
+		packed    uint32
+	}
+
+	objectIDCacheEntry struct {
+		key objectIDCacheKey
+		tag TagType
+	}
+)
+
+var (
+	// The cache is off until SetObjectIDCacheSize is called.
+	activeObjectIDCache *objectIDCache
+	objectIDCacheMux    sync.RWMutex
+)
+
+// SetObjectIDCacheSize turns on caching of decoded object identifiers, keeping the size most recently used.
+// A size of 0 or less turns it off, which is the default. The decoded tags are shared while caching is on,
+// so they must not be modified.
+func SetObjectIDCacheSize(size int) {
+	var cache *objectIDCache
+	if size > 0 {
+		cache = &objectIDCache{
+			size:    size,
+			entries: make(map[objectIDCacheKey]*list.Element, size),
+			order:   list.New(),
+		}
+	}
+	objectIDCacheMux.Lock()
+	defer objectIDCacheMux.Unlock()
+	activeObjectIDCache = cache
+}
+
+// currentObjectIDCache returns the cache, or nil if caching is off.
+func currentObjectIDCache() *objectIDCache {
+	objectIDCacheMux.RLock()
+	defer objectIDCacheMux.RUnlock()
+	return activeObjectIDCache
+}
+
+func (c *objectIDCache) get(key objectIDCacheKey) (TagType, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*objectIDCacheEntry).tag, true
+}
+
+// add caches the tag, removing the least recently used one if the cache is full.
+func (c *objectIDCache) add(key objectIDCacheKey, tag TagType) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*objectIDCacheEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&objectIDCacheEntry{key: key, tag: tag})
+}
@@ -0,0 +1,139 @@
+package apdu
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// encodedObjectIDs is a stream of the same few object identifiers, like a device sending COV notifications,
+// as application and context specific tags.
+func encodedObjectIDs(t testing.TB) [][]byte {
+	var encoded [][]byte
+	for i := 0; i < 8; i++ {
+		instance := uint32(i % 3)
+		appTag, err := NewApplicationObjectID(uint32(bacnet.ObjectTypeAnalogValue), instance)
+		assert.NoError(t, err, "Unexpected error creating application object id")
+		contextTag, err := NewContextSpecificObjectID(uint8(i%2), uint32(bacnet.ObjectTypeAnalogInput), instance)
+		assert.NoError(t, err, "Unexpected error creating context specific object id")
+		for _, tag := range []TagType{appTag, contextTag} {
+			data, err := tag.EncodeAsTagData(TagApplicationClass)
+			assert.NoError(t, err, "Unexpected error encoding")
+			encoded = append(encoded, data)
+		}
+	}
+	return encoded
+}
+
+func decodeObjectIDTag(data []byte) (TagType, error) {
+	if decodeClass(data[0]) == TagContextSpecificClass {
+		return NewContextSpecificObjectIDFromBytes(bytes.NewBuffer(data))
+	}
+	return NewApplicationObjectIDFromBytes(bytes.NewBuffer(data))
+}
+
+func TestObjectIDCache(t *testing.T) {
+	defer SetObjectIDCacheSize(0)
+	encoded := encodedObjectIDs(t)
+
+	SetObjectIDCacheSize(0)
+	var uncached []TagType
+	for _, data := range encoded {
+		tag, err := decodeObjectIDTag(data)
+		assert.NoError(t, err, "Unexpected error decoding uncached")
+		uncached = append(uncached, tag)
+	}
+
+	// Smaller than the number of different tags, so some are evicted
+	SetObjectIDCacheSize(4)
+	for i, data := range encoded {
+		tag, err := decodeObjectIDTag(data)
+		assert.NoError(t, err, "Unexpected error decoding cached")
+		assert.Equal(t, uncached[i], tag, "Cached and uncached decoding of %d differ", i)
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		first, err := decodeObjectIDTag(encoded[0])
+		assert.NoError(t, err, "Unexpected error decoding")
+		second, err := decodeObjectIDTag(encoded[0])
+		assert.NoError(t, err, "Unexpected error decoding")
+		assert.Same(t, first, second, "Expected the cached tag")
+	})
+
+	t.Run("evicted", func(t *testing.T) {
+		SetObjectIDCacheSize(1)
+		first, err := decodeObjectIDTag(encoded[0])
+		assert.NoError(t, err, "Unexpected error decoding")
+		_, err = decodeObjectIDTag(encoded[1])
+		assert.NoError(t, err, "Unexpected error decoding")
+		again, err := decodeObjectIDTag(encoded[0])
+		assert.NoError(t, err, "Unexpected error decoding")
+		assert.NotSame(t, first, again, "Expected the tag to be evicted")
+		assert.Equal(t, first, again, "Expected the same decoding after eviction")
+	})
+}
+
+func TestObjectIDCacheRawCapture(t *testing.T) {
+	defer SetObjectIDCacheSize(0)
+	encoded := encodedObjectIDs(t)
+	SetObjectIDCacheSize(16)
+
+	// Cached without raw capture, so it has no raw bytes
+	cached, err := decodeObjectIDTag(encoded[0])
+	assert.NoError(t, err, "Unexpected error decoding")
+
+	// Decoding with and without raw capture at the same time doesn't change the cached tag
+	var wg sync.WaitGroup
+	decoded := make([]TagType, 8)
+	for i := range decoded {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			decoded[i], _ = NewApplicationTagFromBytes(bytes.NewBuffer(encoded[0]), WithRawCapture())
+		}(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = decodeObjectIDTag(encoded[0])
+		}()
+	}
+	wg.Wait()
+	for i, tag := range decoded {
+		if assert.NotNil(t, tag, "Unable to decode %d", i) {
+			assert.NotSame(t, cached, tag, "Expected a copy of the cached tag for %d", i)
+			assert.Equal(t, encoded[0], tag.(*ApplicationObjectIDType).Raw(), "Unexpected raw bytes for %d", i)
+		}
+	}
+	assert.Nil(t, cached.(*ApplicationObjectIDType).Raw(), "The cached tag was modified")
+
+	again, err := decodeObjectIDTag(encoded[0])
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Same(t, cached, again, "Expected the cached tag without raw capture")
+	assert.Nil(t, again.(*ApplicationObjectIDType).Raw(), "Expected no raw bytes from the cache")
+}
+
+func BenchmarkObjectIDDecode(b *testing.B) {
+	defer SetObjectIDCacheSize(0)
+	encoded := encodedObjectIDs(b)
+	for _, bCase := range []struct {
+		name string
+		size int
+	}{
+		{"uncached", 0},
+		{"cached", 16},
+	} {
+		b.Run(bCase.name, func(b *testing.B) {
+			SetObjectIDCacheSize(bCase.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeObjectIDTag(encoded[i%len(encoded)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package apdu
+
+import (
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// NumPriorities is the number of priorities of a commandable property (19.2 in the spec). 1 is the highest
+// priority, and 16 is the lowest.
+const NumPriorities = 16
+
+// PriorityArray is the Priority_Array property of a commandable object. Index 0 is priority 1. A priority that
+// isn't commanded is NULL in the property, and nil here.
+type PriorityArray [NumPriorities]TagType
+
+// NewPriorityArrayFromValues decodes the priority array from the values of the property, like the values of
+// a ReadPropertyAck for the whole array.
+func NewPriorityArrayFromValues(values []TagType) (*PriorityArray, error) {
+	if len(values) != NumPriorities {
+		return nil, bacnet.ErrInvalidData
+	}
+	var priorities PriorityArray
+	for i, value := range values {
+		if value == nil {
+			return nil, bacnet.ErrInvalidData
+		}
+		if _, ok := value.(*ApplicationNullType); ok {
+			continue
+		}
+		priorities[i] = value
+	}
+	return &priorities, nil
+}
+
+// Get returns the value at the priority, which is 1 to 16, or nil if it isn't commanded.
+func (p *PriorityArray) Get(priority uint) TagType {
+	if priority < 1 || priority > NumPriorities {
+		return nil
+	}
+	return p[priority-1]
+}
+
+// Values returns the values of the property, for encoding. The priorities that aren't commanded are NULL.
+func (p *PriorityArray) Values() ([]TagType, error) {
+	values := make([]TagType, 0, NumPriorities)
+	for _, value := range p {
+		if value == nil {
+			null, err := NewApplicationNull()
+			if err != nil {
+				return nil, err
+			}
+			value = null
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
@@ -0,0 +1,50 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestPriorityArrayCoding(t *testing.T) {
+	binaryOutput := bacnet.ObjectID{Type: bacnet.ObjectTypeBinaryOutput, Instance: 7}
+	active, err := NewApplicationEnumerated(1)
+	assert.NoError(t, err, "Unexpected error creating enumerated")
+	inactive, err := NewApplicationEnumerated(0)
+	assert.NoError(t, err, "Unexpected error creating enumerated")
+
+	var priorities PriorityArray
+	priorities[7] = active
+	priorities[15] = inactive
+	values, err := priorities.Values()
+	assert.NoError(t, err, "Unexpected error getting values")
+	assert.Len(t, values, NumPriorities, "Unexpected number of values")
+
+	ackMsg, err := NewReadPropertyAckMessage(1, &ReadPropertyAck{
+		ObjectID: binaryOutput,
+		Property: bacnet.PropertyIdentifierPriorityArray,
+		Values:   values,
+	})
+	assert.NoError(t, err, "Unexpected error creating ack")
+	// The value is 7 NULLs, priority 8, 7 more NULLs, and priority 16
+	assert.Equal(t, []byte{0x0C, 0x01, 0x00, 0x00, 0x07, 0x19, 87, 0x3E,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x91, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x91, 0x00, 0x3F}, ackMsg.ServiceData, "Encoding not expected")
+
+	_, _, decodedValues, err := DecodeReadPropertyAck(ackMsg)
+	assert.NoError(t, err, "Unexpected error decoding ack")
+	decoded, err := NewPriorityArrayFromValues(decodedValues)
+	assert.NoError(t, err, "Unexpected error decoding priority array")
+	assert.Equal(t, &priorities, decoded, "Decoded priority array does not match")
+	assert.Equal(t, active, decoded.Get(8), "Unexpected value at priority 8")
+	assert.Equal(t, inactive, decoded.Get(16), "Unexpected value at priority 16")
+	assert.Nil(t, decoded.Get(1), "Priority 1 should not be commanded")
+	assert.Nil(t, decoded.Get(17), "Priority 17 does not exist")
+
+	t.Run("wrong length", func(t *testing.T) {
+		_, err := NewPriorityArrayFromValues(decodedValues[:15])
+		assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Too few values should fail")
+	})
+}
@@ -0,0 +1,99 @@
+package apdu
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// ConfirmedPrivateTransfer (16.2 in the spec) calls a vendor's proprietary service, and the device responds
+// with a ComplexAck. The request and the ack have the same parameters, which are context specific:
+// 0: Vendor ID (unsigned16)
+// 1: Service Number (unsigned)
+// 2: Service Parameters in the request, or the Result Block in the ack (constructed, optional). The contents
+//    are up to the vendor.
+
+// PrivateTransfer is the service data of a ConfirmedPrivateTransfer request or its ack. Parameters are the
+// service parameters of the request, or the result block of the ack, and are nil when they are omitted.
+type PrivateTransfer struct {
+	VendorID      uint16
+	ServiceNumber uint
+	Parameters    []TagType
+}
+
+// NewConfirmedPrivateTransferMessage creates a ConfirmedPrivateTransfer request.
+func NewConfirmedPrivateTransferMessage(invokeID uint8, transfer *PrivateTransfer) (*ConfirmedMessage, error) {
+	data, err := transfer.encode()
+	if err != nil {
+		return nil, err
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedPrivateTransfer, data), nil
+}
+
+// NewConfirmedPrivateTransferAckMessage creates the ComplexAck response for ConfirmedPrivateTransfer.
+func NewConfirmedPrivateTransferAckMessage(invokeID uint8, transfer *PrivateTransfer) (*ComplexAckMessage,
+	error) {
+	data, err := transfer.encode()
+	if err != nil {
+		return nil, err
+	}
+	return NewComplexAck(invokeID, ServiceConfirmedPrivateTransfer, data), nil
+}
+
+func (p *PrivateTransfer) encode() ([]byte, error) {
+	vendorTag, err := NewContextSpecificUnsignedInt(0, uint(p.VendorID))
+	if err != nil {
+		return nil, err
+	}
+	serviceTag, err := NewContextSpecificUnsignedInt(1, p.ServiceNumber)
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagType{vendorTag, serviceTag}
+	if p.Parameters != nil {
+		paramsTag, err := NewConstructed(2, p.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, paramsTag)
+	}
+	return encodeTags(tags, TagContextSpecificClass)
+}
+
+// NewPrivateTransferFromBytes decodes the service data of a ConfirmedPrivateTransfer request or its ack.
+func NewPrivateTransferFromBytes(data []byte) (_ *PrivateTransfer, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	vendorTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	serviceTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	vendor := vendorTag.(*ContextSpecificUnsignedIntType)
+	service := serviceTag.(*ContextSpecificUnsignedIntType)
+	if vendor.TagNumber != 0 || service.TagNumber != 1 || vendor.Value() > math.MaxUint16 {
+		return nil, bacnet.ErrInvalidData
+	}
+	transfer := PrivateTransfer{
+		VendorID:      uint16(vendor.Value()),
+		ServiceNumber: service.Value(),
+	}
+
+	if isOpeningTag(buf, 2) {
+		if err := readOpeningTag(buf, 2); err != nil {
+			return nil, err
+		}
+		transfer.Parameters, err = decodeTagsUntilClosing(buf, 2, 1, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &transfer, nil
+}
@@ -0,0 +1,59 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestConfirmedPrivateTransferCoding(t *testing.T) {
+	count, _ := NewApplicationUnsignedInt(72)
+	name, _ := NewApplicationCharacterString("ab")
+
+	testCases := []struct {
+		name     string
+		transfer PrivateTransfer
+		expected []byte
+	}{
+		{"parameters", PrivateTransfer{VendorID: 25, ServiceNumber: 8, Parameters: []TagType{count, name}},
+			[]byte{0x09, 0x19, 0x19, 0x08, 0x2E, 0x21, 0x48, 0x73, 0x00, 'a', 'b', 0x2F}},
+		{"no parameters", PrivateTransfer{VendorID: 25, ServiceNumber: 8}, []byte{0x09, 0x19, 0x19, 0x08}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewConfirmedPrivateTransferMessage(3, &tCase.transfer)
+			assert.NoError(t, err, "Unexpected error creating ConfirmedPrivateTransfer")
+			assert.Equal(t, tCase.expected, msg.ServiceData, "Encoding not expected")
+
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+
+			transfer, err := NewPrivateTransferFromBytes(decoded.(*ConfirmedMessage).ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding service data")
+			assert.Equal(t, &tCase.transfer, transfer, "Decoded request does not match")
+		})
+	}
+}
+
+func TestConfirmedPrivateTransferAck(t *testing.T) {
+	result, _ := NewApplicationEnumerated(1)
+	expected := &PrivateTransfer{VendorID: 25, ServiceNumber: 8, Parameters: []TagType{result}}
+	data := []byte{0x09, 0x19, 0x19, 0x08, 0x2E, 0x91, 0x01, 0x2F}
+
+	ack, err := NewConfirmedPrivateTransferAckMessage(3, expected)
+	assert.NoError(t, err, "Unexpected error creating ack")
+	assert.Equal(t, data, ack.ServiceData, "Ack encoding not expected")
+
+	transfer, err := NewPrivateTransferFromBytes(data)
+	assert.NoError(t, err, "Unexpected error decoding ack")
+	assert.Equal(t, expected, transfer, "Decoded ack does not match")
+
+	// The vendor ID is only 16 bits
+	_, err = NewPrivateTransferFromBytes([]byte{0x0B, 0x01, 0x00, 0x00, 0x19, 0x08})
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for a large vendor ID")
+}
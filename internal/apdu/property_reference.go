@@ -0,0 +1,74 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// PropertyReference (BACnetPropertyReference in the spec) is a property and an optional array index. It's
+// embedded in many services, but not always with the same tag numbers, so the tag numbers are relative to an
+// offset:
+// offset + 0: Property Identifier (enumerated)
+// offset + 1: Property Array Index (unsigned, optional)
+type PropertyReference struct {
+	Property   bacnet.PropertyIdentifier
+	ArrayIndex *uint
+}
+
+// Equal checks if the references are to the same property and element. An omitted index (the whole
+// property) is not the same as any index, including 0 (the length of the array).
+func (r *PropertyReference) Equal(other *PropertyReference) bool {
+	if r.Property != other.Property || (r.ArrayIndex == nil) != (other.ArrayIndex == nil) {
+		return false
+	}
+	return r.ArrayIndex == nil || *r.ArrayIndex == *other.ArrayIndex
+}
+
+// tags returns the context tags of the reference, starting at the tag offset.
+func (r *PropertyReference) tags(tagOffset uint8) ([]TagType, error) {
+	propTag, err := NewContextSpecificEnumerated(tagOffset, uint(r.Property))
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagType{propTag}
+	if r.ArrayIndex != nil {
+		indexTag, err := NewContextSpecificUnsignedInt(tagOffset+1, *r.ArrayIndex)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, indexTag)
+	}
+	return tags, nil
+}
+
+// Encode encodes the reference as context tags, starting at the tag offset.
+func (r *PropertyReference) Encode(tagOffset uint8) ([]byte, error) {
+	tags, err := r.tags(tagOffset)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTags(tags, TagContextSpecificClass)
+}
+
+// NewPropertyReferenceFromBytes decodes a reference that was encoded with the tag offset.
+func NewPropertyReferenceFromBytes(buf *bytes.Buffer, tagOffset uint8) (*PropertyReference, error) {
+	propTag, err := NewContextSpecificEnumeratedFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	prop := propTag.(*ContextSpecificEnumeratedType)
+	if prop.TagNumber != tagOffset {
+		return nil, bacnet.ErrInvalidData
+	}
+	ref := PropertyReference{Property: bacnet.PropertyIdentifier(prop.Value())}
+	if isContextTag(buf, tagOffset+1) {
+		indexTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		index := indexTag.(*ContextSpecificUnsignedIntType).Value()
+		ref.ArrayIndex = &index
+	}
+	return &ref, nil
+}
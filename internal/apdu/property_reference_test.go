@@ -0,0 +1,71 @@
+package apdu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestPropertyReferenceCoding(t *testing.T) {
+	index := uint(4)
+	testCases := []struct {
+		name      string
+		ref       PropertyReference
+		tagOffset uint8
+		expected  []byte
+	}{
+		{"no index", PropertyReference{Property: bacnet.PropertyIdentifierPresentValue}, 0, []byte{0x09, 85}},
+		{"index", PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray, ArrayIndex: &index}, 0,
+			[]byte{0x09, 87, 0x19, 0x04}},
+		{"offset", PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray, ArrayIndex: &index}, 2,
+			[]byte{0x29, 87, 0x39, 0x04}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			encoded, err := tCase.ref.Encode(tCase.tagOffset)
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			buf := bytes.NewBuffer(encoded)
+			decoded, err := NewPropertyReferenceFromBytes(buf, tCase.tagOffset)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, &tCase.ref, decoded, "Decoded reference does not match")
+			assert.Equal(t, 0, buf.Len(), "Not all bytes were decoded")
+
+			// The wrong offset is not the reference
+			_, err = NewPropertyReferenceFromBytes(bytes.NewBuffer(encoded), tCase.tagOffset+1)
+			assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for the wrong offset")
+		})
+	}
+}
+
+func TestPropertyReferenceEqual(t *testing.T) {
+	zero := uint(0)
+	anotherZero := uint(0)
+	five := uint(5)
+	whole := PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray}
+	length := PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray, ArrayIndex: &zero}
+	testCases := []struct {
+		name     string
+		a        PropertyReference
+		b        PropertyReference
+		expected bool
+	}{
+		{"whole", whole, whole, true},
+		{"same index", length, PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray,
+			ArrayIndex: &anotherZero}, true},
+		{"whole and length", whole, length, false},
+		{"different index", length, PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray,
+			ArrayIndex: &five}, false},
+		{"different property", whole, PropertyReference{Property: bacnet.PropertyIdentifierPresentValue}, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, tCase.a.Equal(&tCase.b), "Unexpected equality")
+			assert.Equal(t, tCase.expected, tCase.b.Equal(&tCase.a), "Equality is not symmetric")
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package apdu
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// PropertyValue (BACnetPropertyValue in the spec) is a property and its value. A list of them is in COV
+// notifications and WritePropertyMultiple, as a constructed tag. Each one is:
+// 0-1: PropertyReference with tag offset 0
+// 2: Value (constructed, containing application tags)
+// 3: Priority (unsigned, optional)
+
+// PropertyValue is the value of a property, and the priority that it was written with, if it's commandable.
+// Priority is nil if it's omitted.
+type PropertyValue struct {
+	PropertyReference
+	Values   []TagType
+	Priority *uint
+}
+
+// tags returns the context tags of the property value.
+func (v *PropertyValue) tags() ([]TagType, error) {
+	tags, err := v.PropertyReference.tags(0)
+	if err != nil {
+		return nil, err
+	}
+	valuesTag, err := NewConstructed(2, v.Values)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, valuesTag)
+	if v.Priority != nil {
+		priorityTag, err := NewContextSpecificUnsignedInt(3, *v.Priority)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, priorityTag)
+	}
+	return tags, nil
+}
+
+// propertyValuesTags returns the context tags of the list of property values, for the constructed tag that
+// contains them.
+func propertyValuesTags(values []PropertyValue) ([]TagType, error) {
+	var tags []TagType
+	for _, value := range values {
+		valueTags, err := value.tags()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, valueTags...)
+	}
+	return tags, nil
+}
+
+// EncodePropertyValues encodes the list of property values, without the opening and closing tags of the
+// list.
+func EncodePropertyValues(values []PropertyValue) ([]byte, error) {
+	tags, err := propertyValuesTags(values)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTags(tags, TagContextSpecificClass)
+}
+
+// NewPropertyValuesFromBytes decodes the list of property values, up to the closing tag of the list, which
+// is not read.
+func NewPropertyValuesFromBytes(buf *bytes.Buffer, closingTag uint8) ([]PropertyValue, error) {
+	var values []PropertyValue
+	for !isClosingTag(buf, closingTag) {
+		if buf.Len() == 0 {
+			return nil, fmt.Errorf("no closing tag %d: %w", closingTag, bacnet.ErrInvalidData)
+		}
+		ref, err := NewPropertyReferenceFromBytes(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		value := PropertyValue{PropertyReference: *ref}
+		if err := readOpeningTag(buf, 2); err != nil {
+			return nil, err
+		}
+		value.Values, err = decodeTagsUntilClosing(buf, 2, 1, nil)
+		if err != nil {
+			return nil, err
+		}
+		if isContextTag(buf, 3) {
+			priorityTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+			if err != nil {
+				return nil, err
+			}
+			priority := priorityTag.(*ContextSpecificUnsignedIntType).Value()
+			value.Priority = &priority
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
@@ -0,0 +1,38 @@
+package apdu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestPropertyValuesCoding(t *testing.T) {
+	enumValue, _ := NewApplicationEnumerated(1)
+	uintValue, _ := NewApplicationUnsignedInt(5)
+	index := uint(8)
+	priority := uint(8)
+	values := []PropertyValue{
+		{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+			Values: []TagType{enumValue}, Priority: &priority},
+		{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray,
+			ArrayIndex: &index}, Values: []TagType{uintValue}},
+	}
+	encoded, err := EncodePropertyValues(values)
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{
+		0x09, 85, 0x2E, 0x91, 0x01, 0x2F, 0x39, 0x08,
+		0x09, 87, 0x19, 0x08, 0x2E, 0x21, 0x05, 0x2F}, encoded, "Encoding not expected")
+
+	// The list ends at the closing tag, which is left for the caller
+	buf := bytes.NewBuffer(append(encoded, 0x1F))
+	decoded, err := NewPropertyValuesFromBytes(buf, 1)
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Equal(t, values, decoded, "Decoded values do not match")
+	assert.Equal(t, []byte{0x1F}, buf.Bytes(), "Closing tag should not be read")
+
+	_, err = NewPropertyValuesFromBytes(bytes.NewBuffer(encoded), 1)
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error without the closing tag")
+}
@@ -0,0 +1,158 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// ReadProperty (15.5 in the spec) is the most common confirmed service. The request parameters are all
+// context specific:
+// 0: Object Identifier
+// 1: Property Identifier (enumerated)
+// 2: Property Array Index (unsigned, optional)
+// The ack has the same parameters, followed by:
+// 3: Property Value (constructed, containing application tags)
+// For array properties, the array index determines what is in the property value:
+//  - omitted: the whole array, so the property value has every element
+//  - 0: the length of the array, as an unsigned int
+//  - N: the Nth element (1 based)
+
+// ReadPropertyRequest is the decoded service data of a ReadProperty request. The property and array index are
+// a PropertyReference with tag offset 1.
+type ReadPropertyRequest struct {
+	ObjectID bacnet.ObjectID
+	PropertyReference
+}
+
+// ReadPropertyAck is the decoded service data of the ComplexAck for ReadProperty. Values are the tags in the
+// property value, so it has one element for most properties, but an array or list may have more (or none).
+type ReadPropertyAck struct {
+	ObjectID   bacnet.ObjectID
+	Property   bacnet.PropertyIdentifier
+	ArrayIndex *uint
+	Values     []TagType
+}
+
+// NewReadPropertyMessage creates a ReadProperty request for the property of the object. arrayIndex is
+// optional, and only meaningful for array properties.
+func NewReadPropertyMessage(invokeID uint8, objectID bacnet.ObjectID, property bacnet.PropertyIdentifier,
+	arrayIndex *uint) (*ConfirmedMessage, error) {
+	tags, err := objectPropertyTags(objectID, &PropertyReference{Property: property, ArrayIndex: arrayIndex})
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodeTags(tags, TagContextSpecificClass)
+	if err != nil {
+		return nil, err
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedReadProperty, data), nil
+}
+
+// NewReadPropertyRequestFromBytes decodes the service data of a ReadProperty request.
+func NewReadPropertyRequestFromBytes(data []byte) (_ *ReadPropertyRequest, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	objectID, ref, err := decodeObjectPropertyReference(buf)
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &ReadPropertyRequest{ObjectID: objectID, PropertyReference: *ref}, nil
+}
+
+// NewReadPropertyAckMessage creates the ComplexAck response for ReadProperty. The values should be
+// application tags.
+func NewReadPropertyAckMessage(invokeID uint8, ack *ReadPropertyAck) (*ComplexAckMessage, error) {
+	tags, err := objectPropertyTags(ack.ObjectID, &PropertyReference{Property: ack.Property,
+		ArrayIndex: ack.ArrayIndex})
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodeTags(tags, TagContextSpecificClass)
+	if err != nil {
+		return nil, err
+	}
+	values, err := encodeConstructed(3, ack.Values, TagApplicationClass)
+	if err != nil {
+		return nil, err
+	}
+	return NewComplexAck(invokeID, ServiceConfirmedReadProperty, append(data, values...)), nil
+}
+
+// NewReadPropertyAckFromBytes decodes the service data of the ComplexAck for ReadProperty.
+func NewReadPropertyAckFromBytes(data []byte) (_ *ReadPropertyAck, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	objectID, ref, err := decodeObjectPropertyReference(buf)
+	if err != nil {
+		return nil, err
+	}
+	ack := ReadPropertyAck{
+		ObjectID:   objectID,
+		Property:   ref.Property,
+		ArrayIndex: ref.ArrayIndex,
+	}
+
+	if err := readOpeningTag(buf, 3); err != nil {
+		return nil, err
+	}
+	ack.Values, err = decodeTagsUntilClosing(buf, 3, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ack, nil
+}
+
+// DecodeReadPropertyAck decodes the result of a ReadProperty from its ComplexAck. The values are the
+// application tags in the property value, like ReadPropertyAck. It returns ErrInvalidData if the ack isn't for
+// ReadProperty, and ErrNotImplemented if it's a segment, since the connection reassembles the segments
+// before they get here.
+func DecodeReadPropertyAck(msg *ComplexAckMessage) (bacnet.ObjectID, bacnet.PropertyIdentifier, []TagType,
+	error) {
+	if msg.ServiceID != ServiceConfirmedReadProperty {
+		return bacnet.ObjectID{}, 0, nil, bacnet.ErrInvalidData
+	}
+	if msg.IsSegmented {
+		return bacnet.ObjectID{}, 0, nil, bacnet.ErrNotImplemented
+	}
+	ack, err := NewReadPropertyAckFromBytes(msg.ServiceData)
+	if err != nil {
+		return bacnet.ObjectID{}, 0, nil, err
+	}
+	return ack.ObjectID, ack.Property, ack.Values, nil
+}
+
+// objectPropertyTags returns the tags of the object and the property reference that ReadProperty and
+// WriteProperty start with: the object ID is tag 0, and the reference has tag offset 1.
+func objectPropertyTags(objectID bacnet.ObjectID, ref *PropertyReference) ([]TagType, error) {
+	objTag, err := NewContextSpecificObjectID(0, uint32(objectID.Type), objectID.Instance)
+	if err != nil {
+		return nil, err
+	}
+	refTags, err := ref.tags(1)
+	if err != nil {
+		return nil, err
+	}
+	return append([]TagType{objTag}, refTags...), nil
+}
+
+// decodeObjectPropertyReference decodes the object and property reference that were encoded by
+// objectPropertyTags.
+func decodeObjectPropertyReference(buf *bytes.Buffer) (bacnet.ObjectID, *PropertyReference, error) {
+	objTag, err := NewContextSpecificObjectIDFromBytes(buf)
+	if err != nil {
+		return bacnet.ObjectID{}, nil, err
+	}
+	obj := objTag.(*ContextSpecificObjectIDType)
+	if obj.TagNumber != 0 {
+		return bacnet.ObjectID{}, nil, bacnet.ErrInvalidData
+	}
+	ref, err := NewPropertyReferenceFromBytes(buf, 1)
+	if err != nil {
+		return bacnet.ObjectID{}, nil, err
+	}
+	return obj.ObjectID(), ref, nil
+}
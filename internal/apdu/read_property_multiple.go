@@ -0,0 +1,231 @@
+package apdu
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// ReadPropertyMultiple (15.7 in the spec) reads several properties of several objects in one request. The
+// request is a list of read access specifications, which are:
+// 0: Object Identifier
+// 1: List of Property References (constructed), which are PropertyReferences with tag offset 0
+// The ack is a list of read access results, which nest the results for each property in the object:
+// 0: Object Identifier
+// 1: List of Results (constructed), which are, for each property:
+//    2-3: PropertyReference with tag offset 2
+//    4: Property Value (constructed, containing application tags), or
+//    5: Property Access Error (constructed, containing the application enumerated error class and code)
+
+type (
+	// ReadAccessSpec is the object and the properties to read from it.
+	ReadAccessSpec struct {
+		ObjectID   bacnet.ObjectID
+		Properties []PropertyReference
+	}
+
+	// ReadAccessResult is the results of reading the properties of one object.
+	ReadAccessResult struct {
+		ObjectID bacnet.ObjectID
+		Results  []ReadResult
+	}
+
+	// ReadResult is the result of reading one property. If the property couldn't be read, AccessError is set
+	// instead of the Values.
+	ReadResult struct {
+		PropertyReference
+		Values      []TagType
+		AccessError *PropertyAccessError
+	}
+
+	// PropertyAccessError is the reason that a property in ReadPropertyMultiple couldn't be read.
+	PropertyAccessError struct {
+		Class ErrorClass
+		Code  ErrorCode
+	}
+)
+
+// NewReadAccessSpec creates the spec to read the properties of the object, without array indexes. They can be
+// bacnet.PropertyIdentifierAll, Required or Optional to read those properties without naming them.
+func NewReadAccessSpec(objectID bacnet.ObjectID, properties ...bacnet.PropertyIdentifier) ReadAccessSpec {
+	spec := ReadAccessSpec{ObjectID: objectID}
+	for _, property := range properties {
+		spec.Properties = append(spec.Properties, PropertyReference{Property: property})
+	}
+	return spec
+}
+
+// NewReadPropertyMultipleMessage creates a ReadPropertyMultiple request for the objects and properties.
+func NewReadPropertyMultipleMessage(invokeID uint8, specs []ReadAccessSpec) (*ConfirmedMessage, error) {
+	var data []byte
+	for _, spec := range specs {
+		objTag, err := NewContextSpecificObjectID(0, uint32(spec.ObjectID.Type), spec.ObjectID.Instance)
+		if err != nil {
+			return nil, err
+		}
+		objBytes, err := objTag.EncodeAsTagData(TagContextSpecificClass)
+		if err != nil {
+			return nil, err
+		}
+		var refs []TagType
+		for _, ref := range spec.Properties {
+			refTags, err := ref.tags(0)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, refTags...)
+		}
+		refBytes, err := encodeConstructed(1, refs, TagContextSpecificClass)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, objBytes...)
+		data = append(data, refBytes...)
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedReadPropertyMultiple, data), nil
+}
+
+// NewReadPropertyMultipleAckMessage creates the ComplexAck response for ReadPropertyMultiple. The values
+// should be application tags.
+func NewReadPropertyMultipleAckMessage(invokeID uint8, results []ReadAccessResult) (*ComplexAckMessage, error) {
+	var data []byte
+	for _, result := range results {
+		objTag, err := NewContextSpecificObjectID(0, uint32(result.ObjectID.Type), result.ObjectID.Instance)
+		if err != nil {
+			return nil, err
+		}
+		objBytes, err := objTag.EncodeAsTagData(TagContextSpecificClass)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, objBytes...)
+
+		opening, err := encodeOpeningTag(1)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, opening...)
+		for _, res := range result.Results {
+			resBytes, err := encodeReadResult(&res)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, resBytes...)
+		}
+		closing, err := encodeClosingTag(1)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, closing...)
+	}
+	return NewComplexAck(invokeID, ServiceConfirmedReadPropertyMultiple, data), nil
+}
+
+// encodeReadResult encodes the result of one property, inside the list of results.
+func encodeReadResult(res *ReadResult) ([]byte, error) {
+	data, err := res.PropertyReference.Encode(2)
+	if err != nil {
+		return nil, err
+	}
+
+	var valueBytes []byte
+	if res.AccessError != nil {
+		classTag, err := NewApplicationEnumerated(uint(res.AccessError.Class))
+		if err != nil {
+			return nil, err
+		}
+		codeTag, err := NewApplicationEnumerated(uint(res.AccessError.Code))
+		if err != nil {
+			return nil, err
+		}
+		valueBytes, err = encodeConstructed(5, []TagType{classTag, codeTag}, TagApplicationClass)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		valueBytes, err = encodeConstructed(4, res.Values, TagApplicationClass)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(data, valueBytes...), nil
+}
+
+// NewReadPropertyMultipleAckFromBytes decodes the service data of the ComplexAck for ReadPropertyMultiple.
+// Each opening tag must be matched by its closing tag, or it's invalid.
+func NewReadPropertyMultipleAckFromBytes(data []byte) (_ []ReadAccessResult, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	results := []ReadAccessResult{}
+	for buf.Len() > 0 {
+		objTag, err := NewContextSpecificObjectIDFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		obj := objTag.(*ContextSpecificObjectIDType)
+		if obj.TagNumber != 0 {
+			return nil, bacnet.ErrInvalidData
+		}
+		result := ReadAccessResult{ObjectID: obj.ObjectID()}
+
+		if err := readOpeningTag(buf, 1); err != nil {
+			return nil, err
+		}
+		for !isClosingTag(buf, 1) {
+			if buf.Len() == 0 {
+				return nil, fmt.Errorf("no closing tag 1: %w", bacnet.ErrInvalidData)
+			}
+			res, err := decodeReadResult(buf)
+			if err != nil {
+				return nil, err
+			}
+			result.Results = append(result.Results, *res)
+		}
+		if err := readClosingTag(buf, 1); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// decodeReadResult decodes the result of one property, inside the list of results.
+func decodeReadResult(buf *bytes.Buffer) (*ReadResult, error) {
+	ref, err := NewPropertyReferenceFromBytes(buf, 2)
+	if err != nil {
+		return nil, err
+	}
+	res := ReadResult{PropertyReference: *ref}
+
+	if isOpeningTag(buf, 5) {
+		if err := readOpeningTag(buf, 5); err != nil {
+			return nil, err
+		}
+		classTag, err := NewApplicationEnumeratedFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		codeTag, err := NewApplicationEnumeratedFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		if err := readClosingTag(buf, 5); err != nil {
+			return nil, err
+		}
+		res.AccessError = &PropertyAccessError{
+			Class: ErrorClass(classTag.(*ApplicationEnumeratedType).Value()),
+			Code:  ErrorCode(codeTag.(*ApplicationEnumeratedType).Value()),
+		}
+		return &res, nil
+	}
+
+	if err := readOpeningTag(buf, 4); err != nil {
+		return nil, err
+	}
+	res.Values, err = decodeTagsUntilClosing(buf, 4, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
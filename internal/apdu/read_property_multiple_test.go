@@ -0,0 +1,150 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestReadPropertyMultipleEncoding(t *testing.T) {
+	specs := []ReadAccessSpec{
+		{
+			ObjectID: bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 16},
+			Properties: []PropertyReference{
+				{Property: bacnet.PropertyIdentifierPresentValue},
+				{Property: bacnet.PropertyIdentifierUnits},
+			},
+		},
+	}
+	msg, err := NewReadPropertyMultipleMessage(241, specs)
+	assert.NoError(t, err, "Unexpected error creating ReadPropertyMultiple")
+	// This is the example in F.3.7 of the spec, with our max APDU length
+	assert.Equal(t, []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x09, 0x55, 0x09, 0x75, 0x1F}, msg.ServiceData,
+		"Unexpected service data")
+
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	decoded, err := NewMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Equal(t, msg, decoded, "Decoded message does not match")
+}
+
+func TestReadAllProperties(t *testing.T) {
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 16}
+	testCases := []struct {
+		name     string
+		property bacnet.PropertyIdentifier
+		expected byte
+	}{
+		{"all", bacnet.PropertyIdentifierAll, 0x08},
+		{"optional", bacnet.PropertyIdentifierOptional, 0x50},
+		{"required", bacnet.PropertyIdentifierRequired, 0x69},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			spec := NewReadAccessSpec(objectID, tCase.property)
+			msg, err := NewReadPropertyMultipleMessage(1, []ReadAccessSpec{spec})
+			assert.NoError(t, err, "Unexpected error creating ReadPropertyMultiple")
+			assert.Equal(t, []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x09, tCase.expected, 0x1F}, msg.ServiceData,
+				"Unexpected service data")
+		})
+	}
+
+	// The ack for all has every property of the object, like any other list of results
+	ack := []byte{
+		0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E,
+		0x29, 0x4B, 0x4E, 0xC4, 0x00, 0x00, 0x00, 0x10, 0x4F,
+		0x29, 0x4D, 0x4E, 0x75, 0x05, 0x00, 'T', 'e', 'm', 'p', 0x4F,
+		0x29, 0x55, 0x4E, 0x44, 0x42, 0xC8, 0x00, 0x00, 0x4F,
+		0x1F,
+	}
+	idTag, _ := NewApplicationObjectID(uint32(objectID.Type), objectID.Instance)
+	nameTag, _ := NewApplicationCharacterString("Temp")
+	valueTag, _ := NewApplicationReal(100)
+	expected := []ReadAccessResult{
+		{
+			ObjectID: objectID,
+			Results: []ReadResult{
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierObjectIdentifier},
+					Values: []TagType{idTag}},
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierObjectName},
+					Values: []TagType{nameTag}},
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+					Values: []TagType{valueTag}},
+			},
+		},
+	}
+	decoded, err := NewReadPropertyMultipleAckFromBytes(ack)
+	assert.NoError(t, err, "Unexpected error decoding ack")
+	assert.Equal(t, expected, decoded, "Decoded results do not match")
+}
+
+func TestReadPropertyMultipleAckCoding(t *testing.T) {
+	name16, _ := NewApplicationCharacterString("Temp")
+	units, _ := NewApplicationEnumerated(62)
+	name17, _ := NewApplicationCharacterString("Hum")
+	results := []ReadAccessResult{
+		{
+			ObjectID: bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 16},
+			Results: []ReadResult{
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierObjectName},
+					Values: []TagType{name16}},
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierUnits},
+					Values: []TagType{units}},
+			},
+		},
+		{
+			ObjectID: bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 17},
+			Results: []ReadResult{
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierObjectName},
+					Values: []TagType{name17}},
+				{PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+					AccessError: &PropertyAccessError{
+						Class: ErrorClassProperty,
+						Code:  ErrorCodeUnknownProperty,
+					}},
+			},
+		},
+	}
+	expected := []byte{
+		0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E,
+		0x29, 0x4D, 0x4E, 0x75, 0x05, 0x00, 'T', 'e', 'm', 'p', 0x4F,
+		0x29, 0x75, 0x4E, 0x91, 0x3E, 0x4F,
+		0x1F,
+		0x0C, 0x00, 0x00, 0x00, 0x11, 0x1E,
+		0x29, 0x4D, 0x4E, 0x74, 0x00, 'H', 'u', 'm', 0x4F,
+		0x29, 0x55, 0x5E, 0x91, 0x02, 0x91, 0x20, 0x5F,
+		0x1F,
+	}
+
+	ack, err := NewReadPropertyMultipleAckMessage(241, results)
+	assert.NoError(t, err, "Unexpected error creating ack")
+	assert.Equal(t, expected, ack.ServiceData, "Unexpected service data")
+
+	decoded, err := NewReadPropertyMultipleAckFromBytes(ack.ServiceData)
+	assert.NoError(t, err, "Unexpected error decoding ack")
+	assert.Equal(t, results, decoded, "Decoded results do not match")
+}
+
+func TestReadPropertyMultipleAckUnbalanced(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"missing value closing", []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x29, 0x75, 0x4E, 0x91, 0x3E}},
+		{"missing list closing", []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x29, 0x75, 0x4E, 0x91, 0x3E, 0x4F}},
+		{"list closed in value", []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x29, 0x75, 0x4E, 0x91, 0x3E, 0x1F}},
+		{"value closed by list", []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x29, 0x75, 0x4E, 0x91, 0x3E, 0x1F,
+			0x4F}},
+		{"error not closed", []byte{0x0C, 0x00, 0x00, 0x00, 0x10, 0x1E, 0x29, 0x55, 0x5E, 0x91, 0x02, 0x91, 0x20,
+			0x1F}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			_, err := NewReadPropertyMultipleAckFromBytes(tCase.data)
+			assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected invalid data for unbalanced tags")
+		})
+	}
+}
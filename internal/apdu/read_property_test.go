@@ -0,0 +1,288 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestReadPropertyEncoding(t *testing.T) {
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 3}
+	msg, err := NewReadPropertyMessage(1, objectID, bacnet.PropertyIdentifierPresentValue, nil)
+	assert.NoError(t, err, "Unexpected error creating ReadProperty")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding ReadProperty")
+	assert.Equal(t, []byte{0x00, 0x05, 1, 12, 0x0C, 0x00, 0x00, 0x00, 0x03, 0x19, 85}, encoded,
+		"Encoding not expected")
+
+	decoded, err := NewMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding ReadProperty")
+	assert.Equal(t, msg, decoded, "Decoded message does not match")
+}
+
+func TestReadPropertyParameters(t *testing.T) {
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	index := uint(3)
+	testCases := []struct {
+		name       string
+		arrayIndex *uint
+	}{
+		{"no index", nil},
+		{"index", &index},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewReadPropertyMessage(1, objectID, bacnet.PropertyIdentifierObjectList, tCase.arrayIndex)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding ReadProperty")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding ReadProperty")
+
+			confirmed := decoded.(*ConfirmedMessage)
+			params, err := confirmed.Parameters()
+			assert.NoError(t, err, "Unexpected error decoding parameters")
+			expectedLen := 2
+			if tCase.arrayIndex != nil {
+				expectedLen = 3
+			}
+			assert.Len(t, params, expectedLen, "Unexpected number of parameters")
+
+			objParam, ok := params[0].(*ContextSpecificRawType)
+			assert.True(t, ok, "Object ID is not a context specific tag")
+			assert.Equal(t, uint8(0), objParam.TagNumber, "Unexpected object ID tag number")
+			decodedID, err := objParam.ObjectID()
+			assert.NoError(t, err, "Unexpected error getting object ID")
+			assert.Equal(t, objectID, decodedID, "Object ID does not match")
+
+			propParam, ok := params[1].(*ContextSpecificRawType)
+			assert.True(t, ok, "Property ID is not a context specific tag")
+			assert.Equal(t, uint8(1), propParam.TagNumber, "Unexpected property ID tag number")
+			assert.Equal(t, uint(bacnet.PropertyIdentifierObjectList), propParam.Unsigned(),
+				"Property ID does not match")
+			if tCase.arrayIndex != nil {
+				assert.Equal(t, *tCase.arrayIndex, params[2].(*ContextSpecificRawType).Unsigned(),
+					"Array index does not match")
+			}
+
+			// The second call returns the cached parameters
+			cached, err := confirmed.Parameters()
+			assert.NoError(t, err, "Unexpected error getting cached parameters")
+			assert.Same(t, params[0], cached[0], "Parameters were decoded again")
+		})
+	}
+}
+
+func TestDefaultMaxAPDULength(t *testing.T) {
+	defer SetDefaultMaxAPDULength(DefaultMaxAPDULength())
+
+	testCases := []struct {
+		name          string
+		maxLength     MaxAPDULength
+		expectedByte1 byte
+		expectedBytes uint
+	}{
+		{"50", MaxAPDULength50, 0x00, 50},
+		{"480", MaxAPDULength480, 0x03, 480},
+		{"1476", MaxAPDULength1476, 0x05, 1476},
+	}
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			SetDefaultMaxAPDULength(tCase.maxLength)
+
+			msg, err := NewReadPropertyMessage(1, objectID, bacnet.PropertyIdentifierObjectName, nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding ReadProperty")
+			assert.Equal(t, tCase.expectedByte1, encoded[1], "Max APDU length not encoded")
+
+			iAm, err := NewDefaultIAmMessage(1234, SegmentationNone, 999)
+			assert.NoError(t, err, "Unexpected error creating IAm")
+			maxLength, ok := iAm.ServiceData[1].(*ApplicationUnsignedIntType)
+			assert.True(t, ok, "Unexpected type for max APDU length")
+			assert.Equal(t, tCase.expectedBytes, maxLength.Value(), "Max APDU length not in IAm")
+		})
+	}
+}
+
+func TestReadPropertyArrayIndex(t *testing.T) {
+	device := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	objects := []bacnet.ObjectID{
+		device,
+		{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+		{Type: bacnet.ObjectTypeBinaryOutput, Instance: 2},
+	}
+	var objectTags []TagType
+	for _, obj := range objects {
+		tag, err := NewApplicationObjectID(uint32(obj.Type), obj.Instance)
+		assert.NoError(t, err, "Unexpected error creating object ID")
+		objectTags = append(objectTags, tag)
+	}
+	lengthTag, err := NewApplicationUnsignedInt(uint(len(objects)))
+	assert.NoError(t, err, "Unexpected error creating length")
+
+	zero := uint(0)
+	one := uint(1)
+	testCases := []struct {
+		name            string
+		arrayIndex      *uint
+		expectedRequest []byte
+		values          []TagType
+	}{
+		{"element", &one, []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x19, 76, 0x29, 1}, objectTags[:1]},
+		{"length", &zero, []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x19, 76, 0x29, 0}, []TagType{lengthTag}},
+		{"whole array", nil, []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x19, 76}, objectTags},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			req, err := NewReadPropertyMessage(5, device, bacnet.PropertyIdentifierObjectList, tCase.arrayIndex)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			assert.Equal(t, tCase.expectedRequest, req.ServiceData, "Request encoding not expected")
+			decodedReq, err := NewReadPropertyRequestFromBytes(req.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding request")
+			assert.Equal(t, &ReadPropertyRequest{
+				ObjectID: device,
+				PropertyReference: PropertyReference{
+					Property:   bacnet.PropertyIdentifierObjectList,
+					ArrayIndex: tCase.arrayIndex,
+				},
+			}, decodedReq, "Decoded request does not match")
+
+			ack := ReadPropertyAck{
+				ObjectID:   device,
+				Property:   bacnet.PropertyIdentifierObjectList,
+				ArrayIndex: tCase.arrayIndex,
+				Values:     tCase.values,
+			}
+			ackMsg, err := NewReadPropertyAckMessage(5, &ack)
+			assert.NoError(t, err, "Unexpected error creating ack")
+			encoded, err := ackMsg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding ack")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding ack")
+			complexAck, ok := decoded.(*ComplexAckMessage)
+			assert.True(t, ok, "Decoded message is not a ComplexAck")
+			assert.Equal(t, ServiceConfirmed(ServiceConfirmedReadProperty), complexAck.ServiceID,
+				"Unexpected service")
+			decodedAck, err := NewReadPropertyAckFromBytes(complexAck.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding ack service data")
+			assert.Equal(t, &ack, decodedAck, "Decoded ack does not match")
+		})
+	}
+
+	t.Run("whole array values", func(t *testing.T) {
+		ackMsg, err := NewReadPropertyAckMessage(5, &ReadPropertyAck{
+			ObjectID: device,
+			Property: bacnet.PropertyIdentifierObjectList,
+			Values:   objectTags,
+		})
+		assert.NoError(t, err, "Unexpected error creating ack")
+		decodedAck, err := NewReadPropertyAckFromBytes(ackMsg.ServiceData)
+		assert.NoError(t, err, "Unexpected error decoding ack service data")
+		assert.Nil(t, decodedAck.ArrayIndex, "Array index should be omitted")
+		assert.Equal(t, len(objects), len(decodedAck.Values), "Unexpected number of elements")
+		for i, val := range decodedAck.Values {
+			objTag, ok := val.(*ApplicationObjectIDType)
+			assert.True(t, ok, "Element is not an object ID")
+			assert.Equal(t, objects[i], objTag.ObjectID(), "Element mismatch")
+		}
+	})
+}
+
+func TestReadPropertyAckValues(t *testing.T) {
+	binaryOutput := bacnet.ObjectID{Type: bacnet.ObjectTypeBinaryOutput, Instance: 7}
+	active, err := NewApplicationEnumerated(1)
+	assert.NoError(t, err, "Unexpected error creating enumerated")
+	null, err := NewApplicationNull()
+	assert.NoError(t, err, "Unexpected error creating null")
+
+	priorityArray := make([]TagType, 16)
+	for i := range priorityArray {
+		priorityArray[i] = null
+	}
+	priorityArray[7] = active
+
+	testCases := []struct {
+		name     string
+		property bacnet.PropertyIdentifier
+		// header is everything before the constructed value: the object ID and property ID
+		header []byte
+		values []byte
+	}{
+		{"present value", bacnet.PropertyIdentifierPresentValue,
+			[]byte{0x0C, 0x01, 0x00, 0x00, 0x07, 0x19, 85}, []byte{0x91, 0x01}},
+		{"priority array", bacnet.PropertyIdentifierPriorityArray,
+			[]byte{0x0C, 0x01, 0x00, 0x00, 0x07, 0x19, 87},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x91, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			data := append(append(append([]byte{}, tCase.header...), 0x3E), tCase.values...)
+			data = append(data, 0x3F)
+			ack, err := NewReadPropertyAckFromBytes(data)
+			assert.NoError(t, err, "Unexpected error decoding ack")
+			assert.Equal(t, binaryOutput, ack.ObjectID, "Unexpected object")
+			assert.Equal(t, tCase.property, ack.Property, "Unexpected property")
+			assert.Nil(t, ack.ArrayIndex, "Unexpected array index")
+
+			switch tCase.property {
+			case bacnet.PropertyIdentifierPresentValue:
+				assert.Equal(t, []TagType{active}, ack.Values, "Scalar should be a one element slice")
+			case bacnet.PropertyIdentifierPriorityArray:
+				assert.Equal(t, priorityArray, ack.Values, "Unexpected priority array")
+			}
+		})
+	}
+}
+
+func TestDecodeReadPropertyAck(t *testing.T) {
+	analogValue := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 2}
+	presentValue, err := NewApplicationReal(21.5)
+	assert.NoError(t, err, "Unexpected error creating real")
+	null, err := NewApplicationNull()
+	assert.NoError(t, err, "Unexpected error creating null")
+	priorityArray := make([]TagType, 16)
+	for i := range priorityArray {
+		priorityArray[i] = null
+	}
+	priorityArray[15] = presentValue
+
+	testCases := []struct {
+		name     string
+		property bacnet.PropertyIdentifier
+		values   []TagType
+	}{
+		{"present value", bacnet.PropertyIdentifierPresentValue, []TagType{presentValue}},
+		{"priority array", bacnet.PropertyIdentifierPriorityArray, priorityArray},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			ackMsg, err := NewReadPropertyAckMessage(9, &ReadPropertyAck{
+				ObjectID: analogValue,
+				Property: tCase.property,
+				Values:   tCase.values,
+			})
+			assert.NoError(t, err, "Unexpected error creating ack")
+			encoded, err := ackMsg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding ack")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding ack")
+
+			objectID, property, values, err := DecodeReadPropertyAck(decoded.(*ComplexAckMessage))
+			assert.NoError(t, err, "Unexpected error decoding result")
+			assert.Equal(t, analogValue, objectID, "Unexpected object")
+			assert.Equal(t, tCase.property, property, "Unexpected property")
+			assert.Equal(t, tCase.values, values, "Unexpected values")
+		})
+	}
+
+	t.Run("other service", func(t *testing.T) {
+		_, _, _, err := DecodeReadPropertyAck(NewComplexAck(9, ServiceConfirmedReadPropertyMultiple, nil))
+		assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Ack for another service should fail")
+	})
+}
@@ -0,0 +1,575 @@
+package apdu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// Responses are the PDUs that a server sends back for a confirmed request. They all carry the invoke ID of the
+// request that they are responding to (the "original invoke ID" in the spec), but unlike the requests, the
+// remainder of the encoding depends on the PDU type. See 20.1.4 - 20.1.9 in the spec.
+
+// ErrorClass is the class of error in an Error PDU. (18.1 in the spec)
+type ErrorClass uint8
+
+// The values for ErrorClass. We are explicit because these are transmitted.
+const (
+	ErrorClassDevice        ErrorClass = 0
+	ErrorClassObject                   = 1
+	ErrorClassProperty                 = 2
+	ErrorClassResources                = 3
+	ErrorClassSecurity                 = 4
+	ErrorClassServices                 = 5
+	ErrorClassVT                       = 6
+	ErrorClassCommunication            = 7
+)
+
+// ErrorCode is the specific error in an Error PDU. There are a lot of these, and these are only the common
+// ones. (18.2 - 18.9 in the spec)
+type ErrorCode uint8
+
+// The values for ErrorCode. We are explicit because these are transmitted.
+const (
+	ErrorCodeOther                             ErrorCode = 0
+	ErrorCodeConfigurationInProgress                     = 2
+	ErrorCodeDeviceBusy                                  = 3
+	ErrorCodeDynamicCreationNotSupported                 = 4
+	ErrorCodeFileAccessDenied                            = 5
+	ErrorCodeInconsistentParameters                      = 7
+	ErrorCodeInvalidDataType                             = 9
+	ErrorCodeInvalidFileAccessMethod                     = 10
+	ErrorCodeInvalidFileStartPosition                    = 11
+	ErrorCodeInvalidParameterDataType                    = 13
+	ErrorCodeMissingRequiredParameter                    = 16
+	ErrorCodeNoSpaceForObject                            = 18
+	ErrorCodeNoSpaceToWriteProperty                      = 20
+	ErrorCodeObjectDeletionNotPermitted                  = 23
+	ErrorCodeObjectIdentifierAlreadyExists               = 24
+	ErrorCodeReadAccessDenied                            = 27
+	ErrorCodeServiceRequestDenied                        = 29
+	ErrorCodeTimeout                                     = 30
+	ErrorCodeUnknownObject                               = 31
+	ErrorCodeUnknownProperty                             = 32
+	ErrorCodeUnsupportedObjectType                       = 36
+	ErrorCodeValueOutOfRange                             = 37
+	ErrorCodeWriteAccessDenied                           = 40
+	ErrorCodeCharacterSetNotSupported                    = 41
+	ErrorCodeInvalidArrayIndex                           = 42
+	ErrorCodeCOVSubscriptionFailed                       = 43
+	ErrorCodeNotCOVProperty                              = 44
+	ErrorCodeOptionalFunctionalityNotSupported           = 45
+	ErrorCodeDatatypeNotSupported                        = 47
+	ErrorCodeDuplicateName                               = 48
+	ErrorCodeDuplicateObjectID                           = 49
+	ErrorCodePropertyIsNotAnArray                        = 50
+)
+
+// RejectReason is the reason a confirmed request was rejected. (18.9 in the spec)
+type RejectReason uint8
+
+// The values for RejectReason. We are explicit because these are transmitted.
+const (
+	RejectReasonOther                    RejectReason = 0
+	RejectReasonBufferOverflow                        = 1
+	RejectReasonInconsistentParameters                = 2
+	RejectReasonInvalidParameterDataType              = 3
+	RejectReasonInvalidTag                            = 4
+	RejectReasonMissingRequiredParameter              = 5
+	RejectReasonParameterOutOfRange                   = 6
+	RejectReasonTooManyArguments                      = 7
+	RejectReasonUndefinedEnumeration                  = 8
+	RejectReasonUnrecognizedService                   = 9
+)
+
+// AbortReason is the reason a transaction was aborted. (18.10 in the spec)
+type AbortReason uint8
+
+// The values for AbortReason. We are explicit because these are transmitted.
+const (
+	AbortReasonOther                         AbortReason = 0
+	AbortReasonBufferOverflow                            = 1
+	AbortReasonInvalidAPDUInThisState                    = 2
+	AbortReasonPreemptedByHigherPriorityTask             = 3
+	AbortReasonSegmentationNotSupported                  = 4
+	AbortReasonSecurityError                             = 5
+	AbortReasonInsufficientSecurity                      = 6
+	AbortReasonWindowSizeOutOfRange                      = 7
+	AbortReasonApplicationExceededReplyTime              = 8
+	AbortReasonOutOfResources                            = 9
+	AbortReasonTSMTimeout                                = 10
+	AbortReasonAPDUTooLong                               = 11
+)
+
+type (
+	// InvokeIDCarrier is implemented by all of the responses, so they can be matched with the request that they
+	// are responding to without a type switch.
+	InvokeIDCarrier interface {
+		InvokeID() uint8
+	}
+
+	// SimpleAckMessage acknowledges a confirmed request that has no results to return (e.g. WriteProperty):
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      | 0 | 0 | 0 | 0 |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Service ACK Choice            |
+	//  |---|---|---|---|---|---|---|---|
+	SimpleAckMessage struct {
+		MessageBase
+		OriginalInvokeID uint8
+		ServiceID        ServiceConfirmed
+	}
+
+	// ComplexAckMessage returns the results of a confirmed request (e.g. ReadProperty). Like the confirmed
+	// request, it may be segmented:
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      |SEG|MOR| 0 | 0 |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Sequence Number               | Only present if SEG = 1
+	//  |---|---|---|---|---|---|---|---|
+	//  | Proposed Window Size          | Only present if SEG = 1
+	//  |---|---|---|---|---|---|---|---|
+	//  | Service ACK Choice            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Service ACK                   |
+	//  |      .                        |
+	//  |      .                        |
+	//  |---|---|---|---|---|---|---|---|
+	ComplexAckMessage struct {
+		MessageBase
+		IsSegmented        bool
+		DoSegmentsFollow   bool
+		OriginalInvokeID   uint8
+		SequenceNumber     *uint8 // if IsSegmented is true
+		ProposedWindowSize *uint8 // if IsSegmented is true
+		ServiceID          ServiceConfirmed
+		ServiceData        []byte
+	}
+
+	// SegmentAckMessage acknowledges segments of a segmented message. Either side can send it, so it has the
+	// server bit like the abort. NAK is set for a negative ack.
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      | 0 | 0|NAK|SRV|
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Sequence Number               |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Actual Window Size            |
+	//  |---|---|---|---|---|---|---|---|
+	SegmentAckMessage struct {
+		MessageBase
+		IsNegativeAck    bool
+		FromServer       bool
+		OriginalInvokeID uint8
+		SequenceNumber   uint8
+		ActualWindowSize uint8
+	}
+
+	// ErrorMessage is sent by the server when a confirmed request could not be executed. The error class and
+	// code are encoded as application enumerated tags:
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      | 0 | 0 | 0 | 0 |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Error Choice (Service)        |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Error Class (enumerated tag)  |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Error Code (enumerated tag)   |
+	//  |---|---|---|---|---|---|---|---|
+	ErrorMessage struct {
+		MessageBase
+		OriginalInvokeID uint8
+		ServiceID        ServiceConfirmed
+		Class            ErrorClass
+		Code             ErrorCode
+	}
+
+	// RejectMessage is sent by the server when a confirmed request is malformed, so it doesn't include the
+	// service. The low nibble of the first byte is unused:
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      | 0 | 0 | 0 | 0 |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Reject Reason                 |
+	//  |---|---|---|---|---|---|---|---|
+	RejectMessage struct {
+		MessageBase
+		OriginalInvokeID uint8
+		Reason           RejectReason
+	}
+
+	// AbortMessage can be sent by either the client or the server, so the server bit (SRV) indicates which
+	// side sent it:
+	//    7   6   5   4   3   2   1   0
+	//  |---|---|---|---|---|---|---|---|
+	//  | PDU Type      | 0 | 0 | 0 |SRV|
+	//  |---|---|---|---|---|---|---|---|
+	//  | Original Invoke ID            |
+	//  |---|---|---|---|---|---|---|---|
+	//  | Abort Reason                  |
+	//  |---|---|---|---|---|---|---|---|
+	AbortMessage struct {
+		MessageBase
+		FromServer       bool
+		OriginalInvokeID uint8
+		Reason           AbortReason
+	}
+)
+
+var (
+	_ (Message) = (*SimpleAckMessage)(nil)
+	_ (Message) = (*ComplexAckMessage)(nil)
+	_ (Message) = (*SegmentAckMessage)(nil)
+	_ (Message) = (*ErrorMessage)(nil)
+	_ (Message) = (*RejectMessage)(nil)
+	_ (Message) = (*AbortMessage)(nil)
+
+	_ (InvokeIDCarrier) = (*SimpleAckMessage)(nil)
+	_ (InvokeIDCarrier) = (*ComplexAckMessage)(nil)
+	_ (InvokeIDCarrier) = (*SegmentAckMessage)(nil)
+	_ (InvokeIDCarrier) = (*ErrorMessage)(nil)
+	_ (InvokeIDCarrier) = (*RejectMessage)(nil)
+	_ (InvokeIDCarrier) = (*AbortMessage)(nil)
+)
+
+// NewSimpleAck creates a SimpleAck PDU for the confirmed request with the invoke ID.
+func NewSimpleAck(invokeID uint8, service ServiceConfirmed) *SimpleAckMessage {
+	return &SimpleAckMessage{
+		MessageBase:      MessageBase{PDUTypeSimpleAck},
+		OriginalInvokeID: invokeID,
+		ServiceID:        service,
+	}
+}
+
+func newSimpleAckMessageFromBytes(pdu PDUType, data []byte) (*SimpleAckMessage, error) {
+	if len(data) < 3 {
+		return nil, errors.New("insufficient length for message type")
+	}
+
+	return &SimpleAckMessage{
+		MessageBase:      MessageBase{pdu},
+		OriginalInvokeID: data[1],
+		ServiceID:        ServiceConfirmed(data[2]),
+	}, nil
+}
+
+// InvokeID returns the invoke ID of the request
+func (sm *SimpleAckMessage) InvokeID() uint8 {
+	return sm.OriginalInvokeID
+}
+
+// Encode encodes the SimpleAck PDU
+func (sm *SimpleAckMessage) Encode() ([]byte, error) {
+	return []byte{byte(sm.ServiceType), sm.OriginalInvokeID, byte(sm.ServiceID)}, nil
+}
+
+// Clone copies the message. There are no references to copy.
+func (sm *SimpleAckMessage) Clone() Message {
+	clone := *sm
+	return &clone
+}
+
+// NewComplexAck creates an unsegmented ComplexAck PDU for the confirmed request with the invoke ID. The data
+// is the already encoded results.
+func NewComplexAck(invokeID uint8, service ServiceConfirmed, data []byte) *ComplexAckMessage {
+	return &ComplexAckMessage{
+		MessageBase:      MessageBase{PDUTypeComplexAck},
+		OriginalInvokeID: invokeID,
+		ServiceID:        service,
+		ServiceData:      data,
+	}
+}
+
+func newComplexAckMessageFromBytes(pdu PDUType, data []byte) (*ComplexAckMessage, error) {
+	if len(data) < 3 {
+		return nil, errors.New("insufficient length for message type")
+	}
+	control := data[0]
+	msg := ComplexAckMessage{
+		MessageBase:      MessageBase{pdu},
+		IsSegmented:      (control & 0x08) != 0,
+		DoSegmentsFollow: (control & 0x04) != 0,
+		OriginalInvokeID: data[1],
+	}
+	currByteIndex := 2
+	if msg.IsSegmented {
+		if len(data) < 5 {
+			return nil, errors.New("insufficient length for message type")
+		}
+		seqNumber := data[currByteIndex]
+		currByteIndex++
+		msg.SequenceNumber = &seqNumber
+		winSize := data[currByteIndex]
+		currByteIndex++
+		msg.ProposedWindowSize = &winSize
+	}
+	msg.ServiceID = ServiceConfirmed(data[currByteIndex])
+	currByteIndex++
+	msg.ServiceData = data[currByteIndex:]
+
+	return &msg, nil
+}
+
+// InvokeID returns the invoke ID of the request
+func (cm *ComplexAckMessage) InvokeID() uint8 {
+	return cm.OriginalInvokeID
+}
+
+// Encode encodes the ComplexAck PDU
+func (cm *ComplexAckMessage) Encode() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 3+len(cm.ServiceData)))
+
+	control := byte(cm.ServiceType)
+	if cm.IsSegmented {
+		control |= 0x08
+	}
+	if cm.DoSegmentsFollow {
+		control |= 0x04
+	}
+	buf.WriteByte(control)
+	buf.WriteByte(cm.OriginalInvokeID)
+	if cm.IsSegmented {
+		if cm.SequenceNumber == nil || cm.ProposedWindowSize == nil {
+			return nil, errors.New("segmented message requires a sequence number and window size")
+		}
+		buf.WriteByte(*cm.SequenceNumber)
+		buf.WriteByte(*cm.ProposedWindowSize)
+	}
+	buf.WriteByte(byte(cm.ServiceID))
+	buf.Write(cm.ServiceData)
+
+	return buf.Bytes(), nil
+}
+
+// Clone copies the message, including the service data and the segmentation fields
+func (cm *ComplexAckMessage) Clone() Message {
+	clone := *cm
+	clone.SequenceNumber = cloneUint8(cm.SequenceNumber)
+	clone.ProposedWindowSize = cloneUint8(cm.ProposedWindowSize)
+	clone.ServiceData = cloneBytes(cm.ServiceData)
+	return &clone
+}
+
+// NewSegmentAck creates a SegmentAck PDU for the segment with the sequence number.
+func NewSegmentAck(invokeID, sequenceNumber, windowSize uint8, negative, fromServer bool) *SegmentAckMessage {
+	return &SegmentAckMessage{
+		MessageBase:      MessageBase{PDUTypeSegmentAck},
+		IsNegativeAck:    negative,
+		FromServer:       fromServer,
+		OriginalInvokeID: invokeID,
+		SequenceNumber:   sequenceNumber,
+		ActualWindowSize: windowSize,
+	}
+}
+
+func newSegmentAckMessageFromBytes(pdu PDUType, data []byte) (*SegmentAckMessage, error) {
+	if len(data) < 4 {
+		return nil, errors.New("insufficient length for message type")
+	}
+
+	return &SegmentAckMessage{
+		MessageBase:      MessageBase{pdu},
+		IsNegativeAck:    (data[0] & 0x02) != 0,
+		FromServer:       (data[0] & 0x01) != 0,
+		OriginalInvokeID: data[1],
+		SequenceNumber:   data[2],
+		ActualWindowSize: data[3],
+	}, nil
+}
+
+// InvokeID returns the invoke ID of the segmented message
+func (sm *SegmentAckMessage) InvokeID() uint8 {
+	return sm.OriginalInvokeID
+}
+
+// Encode encodes the SegmentAck PDU
+func (sm *SegmentAckMessage) Encode() ([]byte, error) {
+	control := byte(sm.ServiceType)
+	if sm.IsNegativeAck {
+		control |= 0x02
+	}
+	if sm.FromServer {
+		control |= 0x01
+	}
+	return []byte{control, sm.OriginalInvokeID, sm.SequenceNumber, sm.ActualWindowSize}, nil
+}
+
+// Clone copies the message. There are no references to copy.
+func (sm *SegmentAckMessage) Clone() Message {
+	clone := *sm
+	return &clone
+}
+
+// NewErrorResponse creates an Error PDU in response to the confirmed request with the invoke ID.
+func NewErrorResponse(invokeID uint8, service ServiceConfirmed, class ErrorClass, code ErrorCode) *ErrorMessage {
+	return &ErrorMessage{
+		MessageBase:      MessageBase{PDUTypeError},
+		OriginalInvokeID: invokeID,
+		ServiceID:        service,
+		Class:            class,
+		Code:             code,
+	}
+}
+
+func newErrorMessageFromBytes(pdu PDUType, data []byte) (*ErrorMessage, error) {
+	if len(data) < 3 {
+		return nil, errors.New("insufficient length for message type")
+	}
+	buf := bytes.NewBuffer(data[3:])
+	classTag, err := NewApplicationEnumeratedFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	codeTag, err := NewApplicationEnumeratedFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ErrorMessage{
+		MessageBase:      MessageBase{pdu},
+		OriginalInvokeID: data[1],
+		ServiceID:        ServiceConfirmed(data[2]),
+		Class:            ErrorClass(classTag.(*ApplicationEnumeratedType).Value()),
+		Code:             ErrorCode(codeTag.(*ApplicationEnumeratedType).Value()),
+	}, nil
+}
+
+// InvokeID returns the invoke ID of the request
+func (em *ErrorMessage) InvokeID() uint8 {
+	return em.OriginalInvokeID
+}
+
+// Encode encodes the Error PDU
+func (em *ErrorMessage) Encode() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 7))
+
+	buf.WriteByte(byte(em.ServiceType))
+	buf.WriteByte(em.OriginalInvokeID)
+	buf.WriteByte(byte(em.ServiceID))
+
+	for _, val := range []uint{uint(em.Class), uint(em.Code)} {
+		tag, err := NewApplicationEnumerated(val)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := tag.EncodeAsTagData(TagApplicationClass)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bs)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Clone copies the message. There are no references to copy.
+func (em *ErrorMessage) Clone() Message {
+	clone := *em
+	return &clone
+}
+
+// NewReject creates a Reject PDU for the confirmed request with the invoke ID.
+func NewReject(invokeID uint8, reason RejectReason) *RejectMessage {
+	return &RejectMessage{
+		MessageBase:      MessageBase{PDUTypeReject},
+		OriginalInvokeID: invokeID,
+		Reason:           reason,
+	}
+}
+
+// newRejectMessageFromBytes decodes the Reject. It has no service choice, so it is exactly the invoke ID and reason after
+// the control byte.
+func newRejectMessageFromBytes(pdu PDUType, data []byte) (*RejectMessage, error) {
+	if len(data) < 3 {
+		return nil, errors.New("insufficient length for message type")
+	}
+	if len(data) > 3 {
+		return nil, fmt.Errorf("reject has %d bytes after the reason: %w", len(data)-3, bacnet.ErrInvalidData)
+	}
+
+	return &RejectMessage{
+		MessageBase:      MessageBase{pdu},
+		OriginalInvokeID: data[1],
+		Reason:           RejectReason(data[2]),
+	}, nil
+}
+
+// InvokeID returns the invoke ID of the request
+func (rm *RejectMessage) InvokeID() uint8 {
+	return rm.OriginalInvokeID
+}
+
+// Encode encodes the Reject PDU
+func (rm *RejectMessage) Encode() ([]byte, error) {
+	return []byte{byte(rm.ServiceType), rm.OriginalInvokeID, byte(rm.Reason)}, nil
+}
+
+// Clone copies the message. There are no references to copy.
+func (rm *RejectMessage) Clone() Message {
+	clone := *rm
+	return &clone
+}
+
+// NewAbort creates an Abort PDU for the transaction with the invoke ID. fromServer should be true if we are
+// responding as the server.
+func NewAbort(invokeID uint8, reason AbortReason, fromServer bool) *AbortMessage {
+	return &AbortMessage{
+		MessageBase:      MessageBase{PDUTypeAbort},
+		FromServer:       fromServer,
+		OriginalInvokeID: invokeID,
+		Reason:           reason,
+	}
+}
+
+// newAbortMessageFromBytes decodes the Abort. It has no service choice, so it is exactly the invoke ID and reason after
+// the control byte.
+func newAbortMessageFromBytes(pdu PDUType, data []byte) (*AbortMessage, error) {
+	if len(data) < 3 {
+		return nil, errors.New("insufficient length for message type")
+	}
+	if len(data) > 3 {
+		return nil, fmt.Errorf("abort has %d bytes after the reason: %w", len(data)-3, bacnet.ErrInvalidData)
+	}
+
+	return &AbortMessage{
+		MessageBase:      MessageBase{pdu},
+		FromServer:       (data[0] & 0x01) != 0,
+		OriginalInvokeID: data[1],
+		Reason:           AbortReason(data[2]),
+	}, nil
+}
+
+// InvokeID returns the invoke ID of the transaction
+func (am *AbortMessage) InvokeID() uint8 {
+	return am.OriginalInvokeID
+}
+
+// Encode encodes the Abort PDU
+func (am *AbortMessage) Encode() ([]byte, error) {
+	control := byte(am.ServiceType)
+	if am.FromServer {
+		control |= 0x01
+	}
+	return []byte{control, am.OriginalInvokeID, byte(am.Reason)}, nil
+}
+
+// Clone copies the message. There are no references to copy.
+func (am *AbortMessage) Clone() Message {
+	clone := *am
+	return &clone
+}
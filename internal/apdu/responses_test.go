@@ -0,0 +1,155 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestErrorCoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		invokeID uint8
+		service  ServiceConfirmed
+		class    ErrorClass
+		code     ErrorCode
+		expected []byte
+	}{
+		{"unknown property", 3, ServiceConfirmedReadProperty, ErrorClassProperty, ErrorCodeUnknownProperty,
+			[]byte{0x50, 3, 12, 0x91, 2, 0x91, 32}},
+		{"unknown object", 0xFE, ServiceConfirmedWriteProperty, ErrorClassObject, ErrorCodeUnknownObject,
+			[]byte{0x50, 0xFE, 15, 0x91, 1, 0x91, 31}},
+		{"zero values", 0, ServiceConfirmedAcknowledgeAlarm, ErrorClassDevice, ErrorCodeOther,
+			[]byte{0x50, 0, 0, 0x91, 0, 0x91, 0}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg := NewErrorResponse(tCase.invokeID, tCase.service, tCase.class, tCase.code)
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+		})
+	}
+}
+
+func TestRejectCoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		invokeID uint8
+		reason   RejectReason
+		expected []byte
+	}{
+		{"missing required parameter", 7, RejectReasonMissingRequiredParameter, []byte{0x60, 7, 5}},
+		{"unrecognized service", 0x80, RejectReasonUnrecognizedService, []byte{0x60, 0x80, 9}},
+		{"other", 0, RejectReasonOther, []byte{0x60, 0, 0}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg := NewReject(tCase.invokeID, tCase.reason)
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+		})
+	}
+}
+
+func TestAbortCoding(t *testing.T) {
+	testCases := []struct {
+		name       string
+		invokeID   uint8
+		reason     AbortReason
+		fromServer bool
+		expected   []byte
+	}{
+		{"segmentation not supported from server", 12, AbortReasonSegmentationNotSupported, true,
+			[]byte{0x71, 12, 4}},
+		{"buffer overflow from client", 12, AbortReasonBufferOverflow, false, []byte{0x70, 12, 1}},
+		{"apdu too long from server", 0xFF, AbortReasonAPDUTooLong, true, []byte{0x71, 0xFF, 11}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg := NewAbort(tCase.invokeID, tCase.reason, tCase.fromServer)
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+			abort, ok := decoded.(*AbortMessage)
+			assert.True(t, ok, "Decoded message is not an Abort")
+			assert.Equal(t, tCase.fromServer, abort.FromServer, "Server bit mismatch")
+		})
+	}
+}
+
+func TestRejectAbortLength(t *testing.T) {
+	testCases := []struct {
+		name        string
+		data        []byte
+		expected    Message
+		expectedErr error
+	}{
+		{"reject", []byte{0x60, 7, 5}, NewReject(7, RejectReasonMissingRequiredParameter), nil},
+		{"reject with trailing byte", []byte{0x60, 7, 5, 12}, nil, bacnet.ErrInvalidData},
+		{"abort", []byte{0x71, 12, 4}, NewAbort(12, AbortReasonSegmentationNotSupported, true), nil},
+		{"abort with trailing byte", []byte{0x71, 12, 4, 0}, nil, bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			decoded, err := NewMessageFromBytes(tCase.data)
+			if tCase.expectedErr != nil {
+				assert.ErrorIs(t, err, tCase.expectedErr, "Expected error for the trailing byte")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.expected, decoded, "Decoded message does not match")
+		})
+	}
+}
+
+func TestInvokeIDCarrier(t *testing.T) {
+	testCases := []struct {
+		name     string
+		msg      Message
+		expected []byte
+	}{
+		{"simple ack", NewSimpleAck(10, ServiceConfirmedWriteProperty), []byte{0x20, 10, 15}},
+		{"complex ack", NewComplexAck(11, ServiceConfirmedReadProperty, []byte{0x09, 0x01}),
+			[]byte{0x30, 11, 12, 0x09, 0x01}},
+		{"segment ack", NewSegmentAck(12, 3, 4, true, false), []byte{0x42, 12, 3, 4}},
+		{"error", NewErrorResponse(13, ServiceConfirmedReadProperty, ErrorClassObject, ErrorCodeUnknownObject),
+			[]byte{0x50, 13, 12, 0x91, 1, 0x91, 31}},
+		{"reject", NewReject(14, RejectReasonInvalidTag), []byte{0x60, 14, 4}},
+		{"abort", NewAbort(15, AbortReasonOther, true), []byte{0x71, 15, 0}},
+	}
+	for i, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			expectedID := uint8(10 + i)
+			carrier, ok := tCase.msg.(InvokeIDCarrier)
+			assert.True(t, ok, "Message is not an InvokeIDCarrier")
+			assert.Equal(t, expectedID, carrier.InvokeID(), "Unexpected invoke ID")
+
+			encoded, err := tCase.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.msg, decoded, "Decoded message does not match")
+			carrier, ok = decoded.(InvokeIDCarrier)
+			assert.True(t, ok, "Decoded message is not an InvokeIDCarrier")
+			assert.Equal(t, expectedID, carrier.InvokeID(), "Unexpected invoke ID after decoding")
+		})
+	}
+}
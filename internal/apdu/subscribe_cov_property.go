@@ -0,0 +1,145 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// SubscribeCOVProperty (13.15 in the spec) subscribes to changes of one property of an object, instead of the
+// standard properties that SubscribeCOV reports. The request parameters are all context specific:
+// 0: Subscriber Process Identifier (unsigned)
+// 1: Monitored Object Identifier
+// 2: Issue Confirmed Notifications (bool, optional)
+// 3: Lifetime (unsigned, optional)
+// 4: Monitored Property (constructed PropertyReference with tag offset 0)
+// 5: COV Increment (real, optional)
+// Leaving out both 2 and 3 cancels the subscription.
+
+// SubscribeCOVPropertyRequest is the service data of a SubscribeCOVProperty request. The optional parameters
+// are nil when they are omitted.
+type SubscribeCOVPropertyRequest struct {
+	SubscriberProcessID uint
+	MonitoredObject     bacnet.ObjectID
+	IssueConfirmed      *bool
+	Lifetime            *uint
+	MonitoredProperty   PropertyReference
+	COVIncrement        *float32
+}
+
+// NewSubscribeCOVPropertyMessage creates a SubscribeCOVProperty request.
+func NewSubscribeCOVPropertyMessage(invokeID uint8, req *SubscribeCOVPropertyRequest) (*ConfirmedMessage,
+	error) {
+	processTag, err := NewContextSpecificUnsignedInt(0, req.SubscriberProcessID)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewContextSpecificObjectID(1, uint32(req.MonitoredObject.Type), req.MonitoredObject.Instance)
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagType{processTag, objTag}
+	if req.IssueConfirmed != nil {
+		confirmedTag, err := NewContextSpecificBool(2, *req.IssueConfirmed)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, confirmedTag)
+	}
+	if req.Lifetime != nil {
+		lifetimeTag, err := NewContextSpecificUnsignedInt(3, *req.Lifetime)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, lifetimeTag)
+	}
+
+	refTags, err := req.MonitoredProperty.tags(0)
+	if err != nil {
+		return nil, err
+	}
+	refTag, err := NewConstructed(4, refTags)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, refTag)
+
+	if req.COVIncrement != nil {
+		incrementTag, err := NewContextSpecificReal(5, *req.COVIncrement)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, incrementTag)
+	}
+
+	data, err := encodeTags(tags, TagContextSpecificClass)
+	if err != nil {
+		return nil, err
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedSubscribeCOVProperty, data), nil
+}
+
+// NewSubscribeCOVPropertyRequestFromBytes decodes the service data of a SubscribeCOVProperty request.
+func NewSubscribeCOVPropertyRequestFromBytes(data []byte) (_ *SubscribeCOVPropertyRequest, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	processTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewContextSpecificObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	process := processTag.(*ContextSpecificUnsignedIntType)
+	obj := objTag.(*ContextSpecificObjectIDType)
+	if process.TagNumber != 0 || obj.TagNumber != 1 {
+		return nil, bacnet.ErrInvalidData
+	}
+	req := SubscribeCOVPropertyRequest{
+		SubscriberProcessID: process.Value(),
+		MonitoredObject:     obj.ObjectID(),
+	}
+
+	if isContextTag(buf, 2) {
+		confirmedTag, err := NewContextSpecificUnsignedBoolromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		confirmed := confirmedTag.(*ContextSpecificBoolType).Value()
+		req.IssueConfirmed = &confirmed
+	}
+	if isContextTag(buf, 3) {
+		lifetimeTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		lifetime := lifetimeTag.(*ContextSpecificUnsignedIntType).Value()
+		req.Lifetime = &lifetime
+	}
+
+	if err := readOpeningTag(buf, 4); err != nil {
+		return nil, err
+	}
+	ref, err := NewPropertyReferenceFromBytes(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	req.MonitoredProperty = *ref
+	if err := readClosingTag(buf, 4); err != nil {
+		return nil, err
+	}
+
+	if isContextTag(buf, 5) {
+		incrementTag, err := NewContextSpecificRealFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		increment := incrementTag.(*ContextSpecificRealType).Value()
+		req.COVIncrement = &increment
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &req, nil
+}
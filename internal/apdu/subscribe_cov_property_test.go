@@ -0,0 +1,59 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestSubscribeCOVPropertyCoding(t *testing.T) {
+	confirmed := true
+	lifetime := uint(300)
+	increment := float32(0.5)
+	index := uint(2)
+
+	testCases := []struct {
+		name     string
+		req      SubscribeCOVPropertyRequest
+		expected []byte
+	}{
+		{"present value with increment", SubscribeCOVPropertyRequest{
+			SubscriberProcessID: 18,
+			MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 10},
+			IssueConfirmed:      &confirmed,
+			Lifetime:            &lifetime,
+			MonitoredProperty:   PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+			COVIncrement:        &increment,
+		}, []byte{0x09, 0x12, 0x1C, 0x00, 0x00, 0x00, 0x0A, 0x29, 0x01, 0x3A, 0x01, 0x2C, 0x4E, 0x09, 85, 0x4F,
+			0x5C, 0x3F, 0x00, 0x00, 0x00}},
+		{"cancel with array index", SubscribeCOVPropertyRequest{
+			SubscriberProcessID: 18,
+			MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+			MonitoredProperty: PropertyReference{
+				Property:   bacnet.PropertyIdentifierPriorityArray,
+				ArrayIndex: &index,
+			},
+		}, []byte{0x09, 0x12, 0x1C, 0x00, 0x80, 0x00, 0x01, 0x4E, 0x09, 87, 0x19, 0x02, 0x4F}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewSubscribeCOVPropertyMessage(9, &tCase.req)
+			assert.NoError(t, err, "Unexpected error creating SubscribeCOVProperty")
+			assert.Equal(t, ServiceConfirmed(ServiceConfirmedSubscribeCOVProperty), msg.ServiceID,
+				"Unexpected service")
+			assert.Equal(t, tCase.expected, msg.ServiceData, "Encoding not expected")
+
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, msg, decoded, "Decoded message does not match")
+
+			req, err := NewSubscribeCOVPropertyRequestFromBytes(decoded.(*ConfirmedMessage).ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding service data")
+			assert.Equal(t, &tCase.req, req, "Decoded request does not match")
+		})
+	}
+}
@@ -2,6 +2,10 @@ package apdu
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
 
 	"github.com/shigmas/modore/pkg/bacnet"
 )
@@ -90,29 +94,136 @@ type (
 		// XXX: Pass in a byte Buffer for efficiency. In this case, tags are part of an APDU. In fact,
 		// maybe all encodings can take a byte.Buffer. That way, everything is in one buffer
 		EncodeAsTagData(class TagClass) ([]byte, error)
+		// Class is the class that the type is encoded as, so a list of parameters can have both classes.
+		Class() TagClass
+		// Raw is the bytes that the tag was decoded from, if they were captured.
+		Raw() []byte
 	}
 
 	// Base for all types
 	TagTypeBase struct {
+		// raw is the bytes that the tag was decoded from, if it was decoded WithRawCapture
+		raw []byte
+	}
+
+	// rawCapturer is implemented by the types through TagTypeBase
+	rawCapturer interface {
+		setRaw(raw []byte)
+	}
+
+	// DecodeOption changes how a message or its tags are decoded. The options only apply to that decode, so
+	// decoders with different options don't affect each other.
+	DecodeOption func(o *decodeOptions)
+
+	decodeOptions struct {
+		rawCapture bool
+		maxDepth   int
+	}
+
+	// ConstructedType is the tags between an opening and closing tag with the same (context specific) tag
+	// number. The tags inside may be constructed too, like the values of some properties.
+	ConstructedType struct {
+		ContextSpecificTypeBase
+		tags []TagType
 	}
 )
 
+var _ TagType = (*ConstructedType)(nil)
+
+// DefaultMaxConstructedDepth is how deeply constructed tags can be nested when decoding, without
+// WithMaxConstructedDepth. There is nothing in the spec that comes close to this.
+const DefaultMaxConstructedDepth = 64
+
+// WithMaxConstructedDepth limits how deeply constructed tags can be nested when decoding, so a malicious packet
+// can't nest opening tags until the stack is exhausted. Like WithRawCapture, it applies to the decoders that
+// take options, and the others use DefaultMaxConstructedDepth. The depth must be at least 1.
+func WithMaxConstructedDepth(depth int) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxDepth = depth
+	}
+}
+
+// WithRawCapture keeps the bytes that each tag was decoded from. It applies to the decoders that don't know
+// what to expect (NewApplicationTagFromBytes, DecodeTags, and the values that services decode with them),
+// since those are what can be passed on without fully understanding them. It is off by default, since it
+// copies every tag.
+func WithRawCapture() DecodeOption {
+	return func(o *decodeOptions) {
+		o.rawCapture = true
+	}
+}
+
+func newDecodeOptions(opts []DecodeOption) (*decodeOptions, error) {
+	o := &decodeOptions{maxDepth: DefaultMaxConstructedDepth}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.maxDepth <= 0 {
+		return nil, fmt.Errorf("max constructed depth %d: %w", o.maxDepth, bacnet.ErrInvalidData)
+	}
+	return o, nil
+}
+
+// maxConstructedDepth is how deeply constructed tags can be nested. Decoders that aren't given options get
+// the default.
+func (o *decodeOptions) maxConstructedDepth() int {
+	if o == nil {
+		return DefaultMaxConstructedDepth
+	}
+	return o.maxDepth
+}
+
+// captureRaw is whether the tags keep their bytes. Decoders that aren't given options pass nil.
+func (o *decodeOptions) captureRaw() bool {
+	return o != nil && o.rawCapture
+}
+
+// Raw returns the bytes that the tag was decoded from, including the control byte, or nil if it wasn't
+// decoded WithRawCapture. For a constructed tag, it's from the opening tag to the closing tag.
+func (p *TagTypeBase) Raw() []byte {
+	return p.raw
+}
+
+func (p *TagTypeBase) setRaw(raw []byte) {
+	p.raw = raw
+}
+
+// decodeWithRawCapture calls the decoder, and if raw capture is on, keeps a copy of the bytes it consumed in
+// a copy of the decoded tag. The decoder may return a tag that is shared, like a cached object identifier,
+// so that one isn't changed.
+func decodeWithRawCapture(buf *bytes.Buffer, o *decodeOptions,
+	decode func(*bytes.Buffer) (TagType, error)) (TagType, error) {
+	if !o.captureRaw() {
+		return decode(buf)
+	}
+	start := buf.Bytes()
+	tag, err := decode(buf)
+	if err != nil {
+		return nil, err
+	}
+	tag = cloneTag(tag)
+	if capturer, ok := tag.(rawCapturer); ok {
+		capturer.setRaw(append([]byte{}, start[:len(start)-buf.Len()]...))
+	}
+	return tag, nil
+}
+
 // Functions for parameters common to application and context specific
 
 // For application parameters/tags, they are under 14 and will fit in the control byte. For context specific,
-// they *can* be larger than 14, the nibble is set to F and the tag is first byte in the slice. Tag numbers
-// larger than 255 are not supported.
-func encodeTagNumber(control *byte, tagNumber uint8) []byte {
+// they *can* be larger than 14, the nibble is set to F and the tag is first byte in the slice. The spec
+// only allows up to 254 in that byte (255 is reserved), so larger tag numbers are an error.
+func encodeTagNumber(control *byte, tagNumber uint8) ([]byte, error) {
 	if tagNumber <= 14 {
 		*control = byte(tagNumber << 4)
-		return nil
-	} else {
+		return nil, nil
+	} else if tagNumber <= 254 {
 		*control = byte(0xF0 | *control)
 		tagBytes := make([]byte, 1)
 		tagBytes[0] = tagNumber
-		return tagBytes
+		return tagBytes, nil
 	}
-	// No errors. uint8 is our max.
+	return nil, bacnet.ErrValueTooLarge
 }
 
 // The decode functions will sometimes read from the byte slice, so we use a buffer to keep track of how much of
@@ -216,8 +327,383 @@ func decodeLength(control byte, data *bytes.Buffer) (uint, error) {
 	return 0, bacnet.ErrInvalidData
 }
 
+// encodeTag writes a complete primitive tag: the control byte, the overflow tag number and length bytes if
+// they are needed, then the data itself.
+func encodeTag(tagNumber uint8, class TagClass, data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+1))
+
+	var control byte
+	tagBytes, err := encodeTagNumber(&control, tagNumber)
+	if err != nil {
+		return nil, err
+	}
+	encodeClass(&control, class)
+	lengthBytes, err := encodeLength(&control, uint(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte(control)
+	if tagBytes != nil {
+		buf.Write(tagBytes)
+	}
+	if lengthBytes != nil {
+		buf.Write(lengthBytes)
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
+
+// decodeTag reads a complete primitive tag from the buffer, returning the tag number, class, and the data
+// bytes. It is the inverse of encodeTag.
+func decodeTag(buf *bytes.Buffer) (uint8, TagClass, []byte, error) {
+	control, err := buf.ReadByte()
+	if err != nil {
+		return 0, 0, nil, newDecodeError(buf, "tag", bacnet.ErrInsufficientData)
+	}
+	tagNumber, err := decodeTagNumber(control, buf)
+	if err != nil {
+		return 0, 0, nil, newDecodeError(buf, "tag number", err)
+	}
+	class := decodeClass(control)
+	length, err := decodeLength(control, buf)
+	if err != nil {
+		return 0, 0, nil, newDecodeError(buf, "tag length", err)
+	}
+	if uint(buf.Len()) < length {
+		// The data runs out at the end of the buffer
+		buf.Next(buf.Len())
+		return 0, 0, nil, newDecodeError(buf, fmt.Sprintf("tag %d data of %d bytes", tagNumber, length),
+			bacnet.ErrInsufficientData)
+	}
+	return tagNumber, class, buf.Next(int(length)), nil
+}
+
+// DecodeError is a failure to decode a tag. Err is the sentinel error, like bacnet.ErrInsufficientData, and
+// Offset is the byte where decoding failed, from the start of the data passed to NewMessageFromBytes or the
+// service decoder.
+type DecodeError struct {
+	Offset  int
+	Context string
+	Err     error
+
+	// remaining is the bytes left after the offset, which locates the offset in data that contains the buffer.
+	remaining int
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s at offset %d: %v", e.Context, e.Offset, e.Err)
+}
+
+// Unwrap returns the sentinel error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newDecodeError creates the error where the buffer failed. Until the error is located, the offset is from
+// the start of the buffer's unread data when the error happened, which isn't useful.
+func newDecodeError(buf *bytes.Buffer, context string, err error) error {
+	return &DecodeError{Context: context, Err: err, remaining: buf.Len()}
+}
+
+// locateDecodeError sets the offset of a DecodeError in the data that was decoded. Decoders read the data
+// from the front, so the bytes that remain after the failure locate it.
+func locateDecodeError(err *error, length int) {
+	var decodeErr *DecodeError
+	if errors.As(*err, &decodeErr) {
+		decodeErr.Offset = length - decodeErr.remaining
+	}
+}
+
+// Constructed tags are context specific tags that wrap other tags. The opening and closing tags have the
+// same tag number, and the length bits are 110 for opening and 111 for closing.
+const (
+	openingTagFlag = 0x06
+	closingTagFlag = 0x07
+)
+
+// encodeOpeningTag encodes the opening tag of a constructed tag
+func encodeOpeningTag(tagNumber uint8) ([]byte, error) {
+	return encodeConstructedTag(tagNumber, openingTagFlag)
+}
+
+// encodeClosingTag encodes the closing tag of a constructed tag
+func encodeClosingTag(tagNumber uint8) ([]byte, error) {
+	return encodeConstructedTag(tagNumber, closingTagFlag)
+}
+
+func encodeConstructedTag(tagNumber uint8, flag byte) ([]byte, error) {
+	var control byte
+	tagBytes, err := encodeTagNumber(&control, tagNumber)
+	if err != nil {
+		return nil, err
+	}
+	encodeClass(&control, TagContextSpecificClass)
+	control |= flag
+	return append([]byte{control}, tagBytes...), nil
+}
+
+// peekTag reads the tag number, class, and the length/value/type bits of the next tag without consuming
+// anything from the buffer.
+func peekTag(buf *bytes.Buffer) (uint8, TagClass, uint8, error) {
+	peekBuf := bytes.NewBuffer(buf.Bytes())
+	control, err := peekBuf.ReadByte()
+	if err != nil {
+		return 0, 0, 0, bacnet.ErrInsufficientData
+	}
+	tagNumber, err := decodeTagNumber(control, peekBuf)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return tagNumber, decodeClass(control), control & 0x07, nil
+}
+
+// isOpeningTag checks if the next tag in the buffer is the opening tag with the tag number
+func isOpeningTag(buf *bytes.Buffer, tagNumber uint8) bool {
+	num, class, lvt, err := peekTag(buf)
+	return err == nil && num == tagNumber && class == TagContextSpecificClass && lvt == openingTagFlag
+}
+
+// isClosingTag checks if the next tag in the buffer is the closing tag with the tag number
+func isClosingTag(buf *bytes.Buffer, tagNumber uint8) bool {
+	num, class, lvt, err := peekTag(buf)
+	return err == nil && num == tagNumber && class == TagContextSpecificClass && lvt == closingTagFlag
+}
+
+// readOpeningTag consumes the opening tag with the tag number, or returns an error if the next tag is
+// something else.
+func readOpeningTag(buf *bytes.Buffer, tagNumber uint8) error {
+	return readConstructedTag(buf, tagNumber, openingTagFlag)
+}
+
+// readClosingTag consumes the closing tag with the tag number, or returns an error if the next tag is
+// something else.
+func readClosingTag(buf *bytes.Buffer, tagNumber uint8) error {
+	return readConstructedTag(buf, tagNumber, closingTagFlag)
+}
+
+func readConstructedTag(buf *bytes.Buffer, tagNumber uint8, flag byte) error {
+	num, class, lvt, err := peekTag(buf)
+	if err != nil {
+		return err
+	}
+	if num != tagNumber || class != TagContextSpecificClass || lvt != flag {
+		return bacnet.ErrInvalidData
+	}
+	control, _ := buf.ReadByte()
+	_, err = decodeTagNumber(control, buf)
+	return err
+}
+
+// isContextTag checks if the next tag in the buffer is a primitive context specific tag with the tag number.
+// It's for the optional parameters of a service.
+func isContextTag(buf *bytes.Buffer, tagNumber uint8) bool {
+	num, class, lvt, err := peekTag(buf)
+	return err == nil && num == tagNumber && class == TagContextSpecificClass && lvt != openingTagFlag &&
+		lvt != closingTagFlag
+}
+
+// readContextTag decodes the next tag with decode, or returns an error if it isn't the context specific tag
+// with the tag number.
+func readContextTag(buf *bytes.Buffer, tagNumber uint8, decode func(*bytes.Buffer) (TagType, error)) (TagType,
+	error) {
+	if !isContextTag(buf, tagNumber) {
+		return nil, bacnet.ErrInvalidData
+	}
+	return decode(buf)
+}
+
+// encodeConstructed encodes the tags between the opening and closing tags with the tag number.
+func encodeConstructed(tagNumber uint8, tags []TagType, class TagClass) ([]byte, error) {
+	opening, err := encodeOpeningTag(tagNumber)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := encodeTags(tags, class)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := encodeClosingTag(tagNumber)
+	if err != nil {
+		return nil, err
+	}
+	encoded := append(opening, contents...)
+	return append(encoded, closing...), nil
+}
+
+// decodeTagsUntilClosing decodes tags until the closing tag with the tag number, which is also consumed. This
+// is for property values, which are application tags, or constructed tags containing them. depth is how many
+// constructed tags we are already in.
+func decodeTagsUntilClosing(buf *bytes.Buffer, tagNumber uint8, depth int, o *decodeOptions) ([]TagType, error) {
+	tags := []TagType{}
+	for !isClosingTag(buf, tagNumber) {
+		if buf.Len() == 0 {
+			return nil, fmt.Errorf("no closing tag %d: %w", tagNumber, bacnet.ErrInvalidData)
+		}
+		tag, err := decodeValueTag(buf, depth, o)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, readClosingTag(buf, tagNumber)
+}
+
+// DecodeTags decodes all of the tags in the buffer. Since we don't know what type the data of a primitive
+// context specific tag is, those are decoded as ContextSpecificRawType.
+func DecodeTags(buf *bytes.Buffer, opts ...DecodeOption) ([]TagType, error) {
+	o, err := newDecodeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagType{}
+	for buf.Len() > 0 {
+		tag, err := decodeValueTag(buf, 0, o)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// decodeValueTag decodes the next application, context specific, or constructed tag.
+func decodeValueTag(buf *bytes.Buffer, depth int, o *decodeOptions) (TagType, error) {
+	_, class, _, err := peekTag(buf)
+	if err != nil {
+		return nil, err
+	}
+	if class == TagApplicationClass {
+		return decodeWithRawCapture(buf, o, newApplicationTagFromBytes)
+	}
+	return decodeWithRawCapture(buf, o, func(buf *bytes.Buffer) (TagType, error) {
+		return decodeContextValueTag(buf, depth, o)
+	})
+}
+
+// decodeContextValueTag decodes the next context specific or constructed tag.
+func decodeContextValueTag(buf *bytes.Buffer, depth int, o *decodeOptions) (TagType, error) {
+	tagNumber, _, lvt, err := peekTag(buf)
+	if err != nil {
+		return nil, err
+	}
+	if lvt == closingTagFlag {
+		// Not the closing tag we're looking for, if we are looking for one
+		return nil, bacnet.ErrInvalidData
+	}
+	if lvt != openingTagFlag {
+		return NewContextSpecificRawFromBytes(buf)
+	}
+	if depth >= o.maxConstructedDepth() {
+		return nil, fmt.Errorf("constructed tags nested more than %d deep: %w", o.maxConstructedDepth(),
+			bacnet.ErrInvalidData)
+	}
+	if err := readOpeningTag(buf, tagNumber); err != nil {
+		return nil, err
+	}
+	tags, err := decodeTagsUntilClosing(buf, tagNumber, depth+1, o)
+	if err != nil {
+		return nil, err
+	}
+	return &ConstructedType{ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber), tags: tags}, nil
+}
+
+// NewConstructed creates a constructed tag around the tags.
+func NewConstructed(tagNumber uint8, tags []TagType) (TagType, error) {
+	return &ConstructedType{ContextSpecificTypeBase: newContextSpecificTypeBase(tagNumber), tags: tags}, nil
+}
+
+// Tags returns the tags inside the constructed tag
+func (p *ConstructedType) Tags() []TagType {
+	return p.tags
+}
+
+// EncodeAsTagData encodes the opening tag, the tags inside in their own class, and the closing tag. It is
+// always context specific, so the class is ignored.
+func (p *ConstructedType) EncodeAsTagData(class TagClass) ([]byte, error) {
+	encoded, err := encodeOpeningTag(p.TagNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range p.tags {
+		bs, err := tag.EncodeAsTagData(tag.Class())
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, bs...)
+	}
+	closing, err := encodeClosingTag(p.TagNumber)
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, closing...), nil
+}
+
+// encodeTags encodes the tags, in order, as they would be in the service data of a message.
+func encodeTags(tags []TagType, class TagClass) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(tags)))
+	for _, tag := range tags {
+		bs, err := tag.EncodeAsTagData(class)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bs)
+	}
+	return buf.Bytes(), nil
+}
+
+// cloneTag copies the struct that the tag points to, and the slices in it, including the tags inside a
+// constructed tag, so changing the clone doesn't change the original.
+func cloneTag(tag TagType) TagType {
+	val := reflect.ValueOf(tag)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return tag
+	}
+	clone := reflect.New(val.Elem().Type())
+	clone.Elem().Set(val.Elem())
+	cloned := clone.Interface().(TagType)
+
+	switch t := cloned.(type) {
+	case *ApplicationOctetStringType:
+		t.val = cloneBytes(t.val)
+	case *ContextSpecificRawType:
+		t.data = cloneBytes(t.data)
+	case *ConstructedType:
+		if t.tags != nil {
+			tags := make([]TagType, len(t.tags))
+			for i, nested := range t.tags {
+				tags[i] = cloneTag(nested)
+			}
+			t.tags = tags
+		}
+	}
+	if capturer, ok := cloned.(rawCapturer); ok {
+		capturer.setRaw(cloneBytes(cloned.Raw()))
+	}
+	return cloned
+}
+
 // Encoding and decoding helpers
 
+// validateObjectID checks that the type fits in 10 bits and the instance in 22 bits.
+func validateObjectID(objectType, objectInstance uint32) error {
+	if objectType&0xFFFFFC00 != 0 || objectInstance&0xFFC00000 != 0 {
+		return bacnet.ErrInvalidData
+	}
+	return nil
+}
+
+// encodeObjectID packs the object type and instance into 4 bytes. They must already be validated.
+func encodeObjectID(objectType, objectInstance uint32) []byte {
+	return EncodeUint(uint(objectType<<22|objectInstance), 4)
+}
+
+// decodeObjectID unpacks the object type and instance from the 4 bytes.
+func decodeObjectID(data []byte) (uint32, uint32) {
+	stuffedValue := uint32(DecodeUint(data))
+	return (stuffedValue & 0xFFC00000) >> 22, stuffedValue & 0x003FFFFF
+}
+
 // GetUnsignedIntByteSize returns the number of bytes the int will take. If an uint64 will fit into 3 bytes,
 // we will encoded to fit in 3 bytes.
 func GetUnsignedIntByteSize(val uint) uint {
@@ -261,6 +747,69 @@ func EncodeUint(val uint, numBytes uint) []byte {
 	return buf
 }
 
+// maxUnsignedLength is the most bytes an unsigned or enumerated value can have, which is also what fits in a
+// uint.
+const maxUnsignedLength = 8
+
+// decodeUnsigned decodes the data of an unsigned or enumerated tag, which can be 0 to 8 bytes.
+func decodeUnsigned(data []byte) (uint, error) {
+	if len(data) > maxUnsignedLength {
+		return 0, bacnet.ErrValueTooLarge
+	}
+	return DecodeUint(data), nil
+}
+
+// GetSignedIntByteSize returns the number of bytes of the smallest two's complement of the int. The top bit
+// is the sign, so 127 fits in 1 byte, but 128 needs 2.
+func GetSignedIntByteSize(val int64) uint {
+	var numBytes uint = 1
+	for numBytes < 8 && (val < -(1<<(numBytes*8-1)) || val >= 1<<(numBytes*8-1)) {
+		numBytes++
+	}
+	return numBytes
+}
+
+// EncodeInt encodes the two's complement of the int in numBytes, like EncodeUint. With the size from
+// GetSignedIntByteSize, it's the smallest encoding.
+func EncodeInt(val int64, numBytes uint) []byte {
+	buf := make([]byte, numBytes)
+	for i := uint(0); i < numBytes; i++ {
+		// The shift is arithmetic, so the bytes past the value are the sign
+		buf[numBytes-1-i] = byte(val >> (i * 8))
+	}
+	return buf
+}
+
+// DecodeInt takes the two's complement of arbitrary size, up to 8 bytes, and converts it back to the int.
+// The top bit of the first byte is the sign.
+func DecodeInt(raw []byte) int64 {
+	if len(raw) == 0 {
+		return 0
+	}
+	var val int64
+	if raw[0]&0x80 != 0 {
+		val = -1
+	}
+	for _, b := range raw {
+		val = val<<8 | int64(b)
+	}
+	return val
+}
+
+// encodeInteger encodes the smallest two's complement of the value, like the signed int tags.
+func encodeInteger(val int) []byte {
+	return EncodeInt(int64(val), GetSignedIntByteSize(int64(val)))
+}
+
+// decodeInteger decodes the data of a signed int tag, which can be 1 to 8 bytes, as long as it fits in an
+// int.
+func decodeInteger(data []byte) (int, error) {
+	if len(data) == 0 || len(data) > maxUnsignedLength || len(data)*8 > bits.UintSize {
+		return 0, bacnet.ErrInvalidData
+	}
+	return int(DecodeInt(data)), nil
+}
+
 // DecodeUint takes the raw byte array of arbitrary sizes and converts it back to the uint
 func DecodeUint(raw []byte) uint {
 
@@ -2,6 +2,7 @@ package apdu
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -22,12 +23,15 @@ func TestTagEncodings(t *testing.T) {
 			{"fixed", 11, 0xB0, nil, nil},
 			{"edge", 14, 0xE0, nil, nil},
 			{"overflow", 20, 0xF0, []byte{20}, nil},
+			{"overflow edge", 254, 0xF0, []byte{254}, nil},
+			{"reserved", 255, 0, nil, bacnet.ErrValueTooLarge},
 		}
 		for _, tCase := range testEncodeCases {
 			t.Run(tCase.name, func(t *testing.T) {
 				var control byte
 
-				trailingBytes := encodeTagNumber(&control, tCase.num)
+				trailingBytes, err := encodeTagNumber(&control, tCase.num)
+				assert.Equal(t, tCase.expectedError, err, "Error mismatch")
 				assert.Equal(t, tCase.expectedByte, control, "Control byte mismatch")
 				assert.True(t, reflect.DeepEqual(tCase.expectedBytes, trailingBytes), "Trailing bytes mismatch")
 			})
@@ -163,3 +167,138 @@ func TestLengthEncoding(t *testing.T) {
 
 	})
 }
+
+func TestObjectIDBoundaries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		objType  uint32
+		instance uint32
+		expected []byte
+	}{
+		{"widest", 0x3FF, 0x3FFFFF, []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+		{"narrowest", 0, 0, []byte{0x00, 0x00, 0x00, 0x00}},
+		{"max type", 0x3FF, 0, []byte{0xFF, 0xC0, 0x00, 0x00}},
+		{"max instance", 0, 0x3FFFFF, []byte{0x00, 0x3F, 0xFF, 0xFF}},
+		{"device", bacnet.ObjectTypeDevice, 4194302, []byte{0x02, 0x3F, 0xFF, 0xFE}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, encodeObjectID(tCase.objType, tCase.instance), "Unexpected packing")
+			objType, instance := decodeObjectID(tCase.expected)
+			assert.Equal(t, tCase.objType, objType, "Type bled into instance")
+			assert.Equal(t, tCase.instance, instance, "Instance bled into type")
+
+			expectedID := bacnet.ObjectID{Type: bacnet.ObjectType(tCase.objType), Instance: tCase.instance}
+			contextTag, err := NewContextSpecificObjectID(1, tCase.objType, tCase.instance)
+			assert.NoError(t, err, "Unexpected error creating context tag")
+			encoded, err := contextTag.EncodeAsTagData(TagContextSpecificClass)
+			assert.NoError(t, err, "Unexpected error encoding context tag")
+			decoded, err := NewContextSpecificObjectIDFromBytes(bytes.NewBuffer(encoded))
+			assert.NoError(t, err, "Unexpected error decoding context tag")
+			assert.Equal(t, expectedID, decoded.(*ContextSpecificObjectIDType).ObjectID(), "Context tag mismatch")
+
+			appTag, err := NewApplicationObjectID(tCase.objType, tCase.instance)
+			assert.NoError(t, err, "Unexpected error creating application tag")
+			encoded, err = appTag.EncodeAsTagData(TagApplicationClass)
+			assert.NoError(t, err, "Unexpected error encoding application tag")
+			decoded, err = NewApplicationTagFromBytes(bytes.NewBuffer(encoded))
+			assert.NoError(t, err, "Unexpected error decoding application tag")
+			assert.Equal(t, expectedID, decoded.(*ApplicationObjectIDType).ObjectID(), "Application tag mismatch")
+		})
+	}
+
+	_, err := NewApplicationObjectID(0x400, 0)
+	assert.Equal(t, bacnet.ErrInvalidData, err, "Type wider than 10 bits should fail")
+	_, err = NewApplicationObjectID(0, 0x400000)
+	assert.Equal(t, bacnet.ErrInvalidData, err, "Instance wider than 22 bits should fail")
+}
+
+func TestConstructedDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		var data []byte
+		for i := 0; i < depth; i++ {
+			data = append(data, 0x0E)
+		}
+		data = append(data, 0x91, 0x01)
+		for i := 0; i < depth; i++ {
+			data = append(data, 0x0F)
+		}
+		return data
+	}
+
+	testCases := []struct {
+		name        string
+		maxDepth    int
+		depth       int
+		expectedErr error
+	}{
+		{"default", DefaultMaxConstructedDepth, 2, nil},
+		{"at limit", 3, 3, nil},
+		{"past limit", 3, 4, bacnet.ErrInvalidData},
+		{"malicious", DefaultMaxConstructedDepth, 100, bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			data := nested(tCase.depth)
+			tags, err := DecodeTags(bytes.NewBuffer(data), WithMaxConstructedDepth(tCase.maxDepth))
+			if tCase.expectedErr != nil {
+				assert.ErrorIs(t, err, tCase.expectedErr, "Expected depth error")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, 1, len(tags), "Unexpected number of tags")
+			encoded, err := tags[0].EncodeAsTagData(tags[0].Class())
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, data, encoded, "Round trip does not match")
+		})
+	}
+
+	// Property values are inside a constructed tag, so they are limited by the default
+	ack := append([]byte{0x0C, 0x01, 0x00, 0x00, 0x07, 0x19, 85, 0x3E}, nested(100)...)
+	_, err := NewReadPropertyAckFromBytes(append(ack, 0x3F))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected depth error in ack")
+}
+
+func TestRawCapture(t *testing.T) {
+	unsigned := []byte{0x21, 0x48}
+	context := []byte{0x19, 0x55}
+	name := []byte{0x75, 0x05, 0x00, 'T', 'e', 'm', 'p'}
+	enumerated := []byte{0x91, 0x03}
+	constructed := append(append(append([]byte{0x3E}, name...), enumerated...), 0x3F)
+	data := append(append(append([]byte{}, unsigned...), context...), constructed...)
+
+	testCases := []struct {
+		name     string
+		opts     []DecodeOption
+		expected [][]byte
+		inner    [][]byte
+	}{
+		{"capture", []DecodeOption{WithRawCapture()}, [][]byte{unsigned, context, constructed},
+			[][]byte{name, enumerated}},
+		{"no capture", nil, [][]byte{nil, nil, nil}, [][]byte{nil, nil}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tags, err := DecodeTags(bytes.NewBuffer(data), tCase.opts...)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Len(t, tags, len(tCase.expected), "Unexpected number of tags")
+			for i, tag := range tags {
+				assert.Equal(t, tCase.expected[i], tag.Raw(), "Unexpected raw bytes for tag %d", i)
+			}
+			for i, tag := range tags[2].(*ConstructedType).Tags() {
+				assert.Equal(t, tCase.inner[i], tag.Raw(), "Unexpected raw bytes for inner tag %d", i)
+			}
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	// IAm with an object ID tag that has 2 of its 4 bytes
+	data := []byte{0x10, 0x00, 0xC4, 0x02, 0x00}
+	_, err := NewMessageFromBytes(data)
+	assert.ErrorIs(t, err, bacnet.ErrInsufficientData, "Expected insufficient data")
+	var decodeErr *DecodeError
+	if assert.True(t, errors.As(err, &decodeErr), "Expected a DecodeError") {
+		assert.Equal(t, len(data), decodeErr.Offset, "Offset should be where the data ran out")
+	}
+}
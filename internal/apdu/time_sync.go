@@ -0,0 +1,81 @@
+package apdu
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// TimeSynchronization and UTCTimeSynchronization (16.7 and 16.8 in the spec) set the clock of the devices
+// that receive them. The parameters are application tags:
+// - Date
+// - Time
+// TimeSynchronization has the local time of the device, and UTCTimeSynchronization has UTC, which the device
+// converts with its own UTC_Offset and Daylight_Savings_Status.
+
+// NewUTCTimeSyncMessage creates a UTCTimeSynchronization for t, which is converted to UTC.
+func NewUTCTimeSyncMessage(t time.Time) (*UnconfirmedMessage, error) {
+	return newTimeSyncMessage(ServiceUnconfirmedUTCTimeSync, t.UTC())
+}
+
+// NewTimeSyncMessage creates a TimeSynchronization for t, which should already be in the local time of the
+// devices.
+func NewTimeSyncMessage(t time.Time) (*UnconfirmedMessage, error) {
+	return newTimeSyncMessage(ServiceUnconfirmedTimeSync, t)
+}
+
+func newTimeSyncMessage(service ServiceUnconfirmed, t time.Time) (*UnconfirmedMessage, error) {
+	date, timeOfDay, err := bacnet.DateTimeFromTime(t)
+	if err != nil {
+		return nil, err
+	}
+	dateTag, err := NewApplicationDate(date)
+	if err != nil {
+		return nil, err
+	}
+	timeTag, err := NewApplicationTime(timeOfDay)
+	if err != nil {
+		return nil, err
+	}
+	return &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   service,
+		ServiceData: []TagType{dateTag, timeTag},
+	}, nil
+}
+
+// NewTimeSyncFromMessage gets the time from a decoded TimeSynchronization or UTCTimeSynchronization. The time
+// of a UTCTimeSynchronization is in UTC, and the time of a TimeSynchronization is in loc.
+func NewTimeSyncFromMessage(msg *UnconfirmedMessage, loc *time.Location) (time.Time, error) {
+	if msg.ServiceID == ServiceUnconfirmedUTCTimeSync {
+		loc = time.UTC
+	} else if msg.ServiceID != ServiceUnconfirmedTimeSync {
+		return time.Time{}, bacnet.ErrInvalidData
+	}
+	if len(msg.ServiceData) != 2 {
+		return time.Time{}, bacnet.ErrInvalidData
+	}
+	dateTag, dateOK := msg.ServiceData[0].(*ApplicationDateType)
+	timeTag, timeOK := msg.ServiceData[1].(*ApplicationTimeType)
+	if !dateOK || !timeOK {
+		return time.Time{}, bacnet.ErrInvalidData
+	}
+	return bacnet.DateTimeToTime(dateTag.Value(), timeTag.Value(), loc)
+}
+
+// decodeTimeSync decodes the date and time parameters of the time synchronization services.
+func decodeTimeSync(buf *bytes.Buffer) ([]TagType, error) {
+	dateTag, err := NewApplicationDateFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	timeTag, err := NewApplicationTimeFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return []TagType{dateTag, timeTag}, nil
+}
@@ -0,0 +1,80 @@
+package apdu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestUTCTimeSync(t *testing.T) {
+	utc := time.Date(2024, time.March, 10, 18, 45, 30, 250*int(time.Millisecond), time.UTC)
+	testCases := []struct {
+		name string
+		time time.Time
+	}{
+		{"utc", utc},
+		// The same instant in another zone is converted to UTC
+		{"other zone", utc.In(time.FixedZone("", -5*60*60))},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewUTCTimeSyncMessage(tCase.time)
+			assert.NoError(t, err, "Unexpected error creating UTCTimeSynchronization")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding UTCTimeSynchronization")
+			// Sunday, which is 7, and the hundredths
+			assert.Equal(t, []byte{0x10, 0x09, 0xA4, 124, 3, 10, 7, 0xB4, 18, 45, 30, 25}, encoded,
+				"Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding UTCTimeSynchronization")
+			synced, err := NewTimeSyncFromMessage(decoded.(*UnconfirmedMessage), time.Local)
+			assert.NoError(t, err, "Unexpected error getting the time")
+			assert.Equal(t, utc, synced, "Time does not match")
+		})
+	}
+
+	t.Run("local", func(t *testing.T) {
+		local := time.Date(2024, time.March, 10, 13, 45, 30, 0, time.UTC)
+		msg, err := NewTimeSyncMessage(local)
+		assert.NoError(t, err, "Unexpected error creating TimeSynchronization")
+		loc := time.FixedZone("", 2*60*60)
+		synced, err := NewTimeSyncFromMessage(msg, loc)
+		assert.NoError(t, err, "Unexpected error getting the time")
+		assert.Equal(t, time.Date(2024, time.March, 10, 13, 45, 30, 0, loc), synced,
+			"Local time should be in the location")
+	})
+
+	t.Run("extra parameters", func(t *testing.T) {
+		_, err := NewMessageFromBytes([]byte{0x10, 0x09, 0xA4, 124, 3, 10, 7, 0xB4, 18, 45, 30, 25, 0x21, 1})
+		assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Extra parameters should fail")
+	})
+}
+
+func TestDeviceLocalTime(t *testing.T) {
+	utc := time.Date(2024, time.July, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name            string
+		utcOffset       int
+		daylightSavings bool
+		expectedHour    int
+	}{
+		{"east of Greenwich", -300, false, 17},
+		{"east with daylight savings", -300, true, 18},
+		{"west of Greenwich", 300, false, 7},
+		{"west with daylight savings", 300, true, 8},
+		{"utc", 0, false, 12},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			local := bacnet.DeviceLocalTime(utc, tCase.utcOffset, tCase.daylightSavings)
+			assert.True(t, utc.Equal(local), "Should be the same instant")
+			assert.Equal(t, tCase.expectedHour, local.Hour(), "Unexpected local hour")
+			assert.Equal(t, bacnet.Time{Hour: uint8(tCase.expectedHour)}, bacnet.TimeFromTime(local),
+				"Unexpected BACnet time")
+		})
+	}
+}
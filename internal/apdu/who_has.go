@@ -0,0 +1,193 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// WhoHas (16.9 in the spec) asks the devices that have an object to answer with IHave. The parameters are
+// context specific:
+// 0: Device Instance Range Low Limit (unsigned, optional)
+// 1: Device Instance Range High Limit (unsigned, optional)
+// The object is a choice of:
+// 2: Object Identifier
+// 3: Object Name (character string)
+// IHave (16.10 in the spec) has application tags, like IAm:
+// - Device Identifier
+// - Object Identifier
+// - Object Name
+
+type (
+	// WhoHas is the decoded WhoHas request. The device range is like WhoIs. The object is ObjectID, if it's
+	// set, or else ObjectName.
+	WhoHas struct {
+		WhoIs
+		ObjectID   *bacnet.ObjectID
+		ObjectName string
+	}
+
+	// IHave is the decoded IHave response
+	IHave struct {
+		DeviceID   bacnet.ObjectID
+		ObjectID   bacnet.ObjectID
+		ObjectName string
+	}
+)
+
+// NewWhoHasMessage creates a WhoHas. The range is only encoded if it's not all devices.
+func NewWhoHasMessage(whoHas *WhoHas) (*UnconfirmedMessage, error) {
+	var tags []TagType
+	if whoHas.Low != 0 || whoHas.High < MaxInstanceNumber {
+		lowTag, err := NewContextSpecificUnsignedInt(0, whoHas.Low)
+		if err != nil {
+			return nil, err
+		}
+		highTag, err := NewContextSpecificUnsignedInt(1, whoHas.High)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, lowTag, highTag)
+	}
+	if whoHas.ObjectID != nil {
+		objTag, err := NewContextSpecificObjectID(2, uint32(whoHas.ObjectID.Type), whoHas.ObjectID.Instance)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, objTag)
+	} else {
+		nameTag, err := NewContextSpecificCharacterString(3, whoHas.ObjectName)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, nameTag)
+	}
+	return &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   ServiceUnconfirmedWhoHas,
+		ServiceData: tags,
+	}, nil
+}
+
+// NewWhoHasFromMessage gets the range and object from a decoded WhoHas message. Without the range, it's 0 to
+// MaxInstanceNumber.
+func NewWhoHasFromMessage(msg *UnconfirmedMessage) (*WhoHas, error) {
+	if msg.ServiceID != ServiceUnconfirmedWhoHas {
+		return nil, bacnet.ErrInvalidData
+	}
+	whoHas := WhoHas{WhoIs: WhoIs{Low: 0, High: MaxInstanceNumber}}
+	params := msg.ServiceData
+	switch len(params) {
+	case 1:
+	case 3:
+		low, lowOK := params[0].(*ContextSpecificUnsignedIntType)
+		high, highOK := params[1].(*ContextSpecificUnsignedIntType)
+		if !lowOK || !highOK {
+			return nil, bacnet.ErrInvalidData
+		}
+		whoHas.Low = low.Value()
+		whoHas.High = high.Value()
+		params = params[2:]
+	default:
+		return nil, bacnet.ErrInvalidData
+	}
+	switch object := params[0].(type) {
+	case *ContextSpecificObjectIDType:
+		id := object.ObjectID()
+		whoHas.ObjectID = &id
+	case *ContextSpecificCharacterStringType:
+		whoHas.ObjectName = object.Value()
+	default:
+		return nil, bacnet.ErrInvalidData
+	}
+	return &whoHas, nil
+}
+
+// decodeWhoHas decodes the parameters of a WhoHas. The range must have both limits or neither, and the
+// object is the only other parameter.
+func decodeWhoHas(buf *bytes.Buffer) ([]TagType, error) {
+	var params []TagType
+	if isContextTag(buf, 0) {
+		lowTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		highTag, err := readContextTag(buf, 1, NewContextSpecificUnsignedIntFromBytes)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, lowTag, highTag)
+	}
+	var objTag TagType
+	var err error
+	switch {
+	case isContextTag(buf, 2):
+		objTag, err = NewContextSpecificObjectIDFromBytes(buf)
+	case isContextTag(buf, 3):
+		objTag, err = NewContextSpecificCharacterStringFromBytes(buf)
+	default:
+		return nil, bacnet.ErrInvalidData
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return append(params, objTag), nil
+}
+
+// NewIHaveMessage creates an IHave.
+func NewIHaveMessage(iHave *IHave) (*UnconfirmedMessage, error) {
+	devTag, err := NewApplicationObjectID(uint32(iHave.DeviceID.Type), iHave.DeviceID.Instance)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewApplicationObjectID(uint32(iHave.ObjectID.Type), iHave.ObjectID.Instance)
+	if err != nil {
+		return nil, err
+	}
+	nameTag, err := NewApplicationCharacterString(iHave.ObjectName)
+	if err != nil {
+		return nil, err
+	}
+	return &UnconfirmedMessage{
+		MessageBase: MessageBase{PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   ServiceUnconfirmedIHave,
+		ServiceData: []TagType{devTag, objTag, nameTag},
+	}, nil
+}
+
+// NewIHaveFromMessage gets the device and object from a decoded IHave message.
+func NewIHaveFromMessage(msg *UnconfirmedMessage) (*IHave, error) {
+	if msg.ServiceID != ServiceUnconfirmedIHave || len(msg.ServiceData) != 3 {
+		return nil, bacnet.ErrInvalidData
+	}
+	devTag, devOK := msg.ServiceData[0].(*ApplicationObjectIDType)
+	objTag, objOK := msg.ServiceData[1].(*ApplicationObjectIDType)
+	nameTag, nameOK := msg.ServiceData[2].(*ApplicationCharacterStringType)
+	if !devOK || !objOK || !nameOK {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &IHave{DeviceID: devTag.ObjectID(), ObjectID: objTag.ObjectID(), ObjectName: nameTag.Value()}, nil
+}
+
+// decodeIHave decodes the parameters of an IHave.
+func decodeIHave(buf *bytes.Buffer) ([]TagType, error) {
+	devTag, err := NewApplicationObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	objTag, err := NewApplicationObjectIDFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	nameTag, err := NewApplicationCharacterStringFromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return []TagType{devTag, objTag, nameTag}, nil
+}
@@ -0,0 +1,72 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestWhoHasCoding(t *testing.T) {
+	inputID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}
+	testCases := []struct {
+		name     string
+		whoHas   WhoHas
+		expected []byte
+	}{
+		{"name", WhoHas{WhoIs: WhoIs{Low: 0, High: MaxInstanceNumber}, ObjectName: "Zone"},
+			[]byte{0x10, 0x07, 0x3D, 0x05, 0x00, 'Z', 'o', 'n', 'e'}},
+		{"id with range", WhoHas{WhoIs: WhoIs{Low: 10, High: 20}, ObjectID: &inputID},
+			[]byte{0x10, 0x07, 0x09, 10, 0x19, 20, 0x2C, 0x00, 0x00, 0x00, 0x01}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg, err := NewWhoHasMessage(&tCase.whoHas)
+			assert.NoError(t, err, "Unexpected error creating WhoHas")
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding WhoHas")
+			assert.Equal(t, tCase.expected, encoded, "Encoding not expected")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding WhoHas")
+			whoHas, err := NewWhoHasFromMessage(decoded.(*UnconfirmedMessage))
+			assert.NoError(t, err, "Unexpected error getting WhoHas")
+			assert.Equal(t, &tCase.whoHas, whoHas, "Decoded WhoHas does not match")
+		})
+	}
+
+	t.Run("iso 8859-1 name", func(t *testing.T) {
+		decoded, err := NewMessageFromBytes([]byte{0x10, 0x07, 0x3D, 0x05, 0x05, 'C', 'a', 'f', 0xE9})
+		assert.NoError(t, err, "Unexpected error decoding WhoHas")
+		whoHas, err := NewWhoHasFromMessage(decoded.(*UnconfirmedMessage))
+		assert.NoError(t, err, "Unexpected error getting WhoHas")
+		assert.Equal(t, "Café", whoHas.ObjectName, "Name should be converted to UTF-8")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		// Only the low limit
+		_, err := NewMessageFromBytes([]byte{0x10, 0x07, 0x09, 10, 0x3D, 0x02, 0x00, 'Z'})
+		assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Range without the high limit should fail")
+		// No object
+		_, err = NewMessageFromBytes([]byte{0x10, 0x07, 0x09, 10, 0x19, 20})
+		assert.ErrorIs(t, err, bacnet.ErrInvalidData, "WhoHas without the object should fail")
+	})
+}
+
+func TestIHaveCoding(t *testing.T) {
+	iHave := IHave{
+		DeviceID:   bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+		ObjectID:   bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+		ObjectName: "Zone",
+	}
+	msg, err := NewIHaveMessage(&iHave)
+	assert.NoError(t, err, "Unexpected error creating IHave")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding IHave")
+	decoded, err := NewMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding IHave")
+	decodedIHave, err := NewIHaveFromMessage(decoded.(*UnconfirmedMessage))
+	assert.NoError(t, err, "Unexpected error getting IHave")
+	assert.Equal(t, &iHave, decodedIHave, "Decoded IHave does not match")
+}
@@ -0,0 +1,80 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// WriteProperty (15.9 in the spec) writes one property of an object, and the device responds with a
+// SimpleAck. The parameters are all context specific:
+// 0: Object Identifier
+// 1-2: PropertyReference with tag offset 1, like ReadProperty
+// 3: Property Value (constructed, containing application tags)
+// 4: Priority (unsigned, optional), for commandable properties
+
+// WritePropertyRequest is the service data of a WriteProperty request. Like in ReadProperty, the array index
+// is nil to write the whole property, 0 for the length of the array, and N for the Nth element. Priority is
+// nil if it's omitted.
+type WritePropertyRequest struct {
+	ObjectID bacnet.ObjectID
+	PropertyReference
+	Values   []TagType
+	Priority *uint
+}
+
+// NewWritePropertyMessage creates a WriteProperty request. The values should be application tags.
+func NewWritePropertyMessage(invokeID uint8, req *WritePropertyRequest) (*ConfirmedMessage, error) {
+	tags, err := objectPropertyTags(req.ObjectID, &req.PropertyReference)
+	if err != nil {
+		return nil, err
+	}
+	valuesTag, err := NewConstructed(3, req.Values)
+	if err != nil {
+		return nil, err
+	}
+	tags = append(tags, valuesTag)
+	if req.Priority != nil {
+		priorityTag, err := NewContextSpecificUnsignedInt(4, *req.Priority)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, priorityTag)
+	}
+	data, err := encodeTags(tags, TagContextSpecificClass)
+	if err != nil {
+		return nil, err
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedWriteProperty, data), nil
+}
+
+// NewWritePropertyRequestFromBytes decodes the service data of a WriteProperty request.
+func NewWritePropertyRequestFromBytes(data []byte) (_ *WritePropertyRequest, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	objectID, ref, err := decodeObjectPropertyReference(buf)
+	if err != nil {
+		return nil, err
+	}
+	req := WritePropertyRequest{ObjectID: objectID, PropertyReference: *ref}
+
+	if err := readOpeningTag(buf, 3); err != nil {
+		return nil, err
+	}
+	req.Values, err = decodeTagsUntilClosing(buf, 3, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	if isContextTag(buf, 4) {
+		priorityTag, err := NewContextSpecificUnsignedIntFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		priority := priorityTag.(*ContextSpecificUnsignedIntType).Value()
+		req.Priority = &priority
+	}
+	if buf.Len() > 0 {
+		return nil, bacnet.ErrInvalidData
+	}
+	return &req, nil
+}
@@ -0,0 +1,77 @@
+package apdu
+
+import (
+	"bytes"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// WritePropertyMultiple (15.10 in the spec) writes several properties of several objects in one request. The
+// request is a list of write access specifications, which are:
+// 0: Object Identifier
+// 1: List of Properties (constructed), which are PropertyValues
+// The device responds with a SimpleAck.
+
+// WriteAccessSpec is the object and the values to write to its properties.
+type WriteAccessSpec struct {
+	ObjectID bacnet.ObjectID
+	Values   []PropertyValue
+}
+
+// NewWritePropertyMultipleMessage creates a WritePropertyMultiple request for the objects and values. The
+// values should be application tags.
+func NewWritePropertyMultipleMessage(invokeID uint8, specs []WriteAccessSpec) (*ConfirmedMessage, error) {
+	var data []byte
+	for _, spec := range specs {
+		objTag, err := NewContextSpecificObjectID(0, uint32(spec.ObjectID.Type), spec.ObjectID.Instance)
+		if err != nil {
+			return nil, err
+		}
+		objBytes, err := objTag.EncodeAsTagData(TagContextSpecificClass)
+		if err != nil {
+			return nil, err
+		}
+		valueTags, err := propertyValuesTags(spec.Values)
+		if err != nil {
+			return nil, err
+		}
+		valueBytes, err := encodeConstructed(1, valueTags, TagContextSpecificClass)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, objBytes...)
+		data = append(data, valueBytes...)
+	}
+	return newConfirmedMessage(invokeID, ServiceConfirmedWritePropertyMultiple, data), nil
+}
+
+// NewWritePropertyMultipleFromBytes decodes the service data of a WritePropertyMultiple request.
+func NewWritePropertyMultipleFromBytes(data []byte) (_ []WriteAccessSpec, err error) {
+	defer locateDecodeError(&err, len(data))
+	buf := bytes.NewBuffer(data)
+	specs := []WriteAccessSpec{}
+	for buf.Len() > 0 {
+		objTag, err := NewContextSpecificObjectIDFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		obj := objTag.(*ContextSpecificObjectIDType)
+		if obj.TagNumber != 0 {
+			return nil, bacnet.ErrInvalidData
+		}
+		spec := WriteAccessSpec{ObjectID: obj.ObjectID()}
+
+		if err := readOpeningTag(buf, 1); err != nil {
+			return nil, err
+		}
+		spec.Values, err = NewPropertyValuesFromBytes(buf, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := readClosingTag(buf, 1); err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
@@ -0,0 +1,31 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestWritePropertyMultipleCoding(t *testing.T) {
+	value, _ := NewApplicationEnumerated(1)
+	priority := uint(16)
+	specs := []WriteAccessSpec{
+		{ObjectID: bacnet.ObjectID{Type: bacnet.ObjectTypeBinaryOutput, Instance: 10},
+			Values: []PropertyValue{{PropertyReference: PropertyReference{
+				Property: bacnet.PropertyIdentifierPresentValue}, Values: []TagType{value}, Priority: &priority}}},
+	}
+	msg, err := NewWritePropertyMultipleMessage(1, specs)
+	assert.NoError(t, err, "Unexpected error creating request")
+	assert.Equal(t, ServiceConfirmed(ServiceConfirmedWritePropertyMultiple), msg.ServiceID, "Unexpected service")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	serviceData := encoded[4:]
+	assert.Equal(t, []byte{0x0C, 0x01, 0x00, 0x00, 0x0A,
+		0x1E, 0x09, 85, 0x2E, 0x91, 0x01, 0x2F, 0x39, 0x10, 0x1F}, serviceData, "Encoding not expected")
+
+	decoded, err := NewWritePropertyMultipleFromBytes(serviceData)
+	assert.NoError(t, err, "Unexpected error decoding")
+	assert.Equal(t, specs, decoded, "Decoded specs do not match")
+}
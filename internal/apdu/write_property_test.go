@@ -0,0 +1,73 @@
+package apdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestArrayIndexAcrossServices(t *testing.T) {
+	object := bacnet.ObjectID{Type: bacnet.ObjectTypeBinaryOutput, Instance: 10}
+	objectBytes := []byte{0x0C, 0x01, 0x00, 0x00, 0x0A, 0x19, 87}
+	null, _ := NewApplicationNull()
+	priority := uint(8)
+	zero := uint(0)
+	five := uint(5)
+	testCases := []struct {
+		name       string
+		arrayIndex *uint
+		indexBytes []byte
+	}{
+		{"omitted", nil, nil},
+		{"index 0", &zero, []byte{0x29, 0x00}},
+		{"index 5", &five, []byte{0x29, 0x05}},
+	}
+	for _, tCase := range testCases {
+		ref := PropertyReference{Property: bacnet.PropertyIdentifierPriorityArray, ArrayIndex: tCase.arrayIndex}
+		expectedRef := append(append([]byte{}, objectBytes...), tCase.indexBytes...)
+
+		t.Run("ReadProperty "+tCase.name, func(t *testing.T) {
+			msg, err := NewReadPropertyMessage(1, object, ref.Property, ref.ArrayIndex)
+			assert.NoError(t, err, "Unexpected error creating request")
+			assert.Equal(t, expectedRef, msg.ServiceData, "Encoding not expected")
+
+			decoded, err := NewReadPropertyRequestFromBytes(msg.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.arrayIndex, decoded.ArrayIndex, "Unexpected array index")
+			assert.True(t, ref.Equal(&decoded.PropertyReference), "References should be equal")
+		})
+
+		t.Run("WriteProperty "+tCase.name, func(t *testing.T) {
+			req := WritePropertyRequest{
+				ObjectID:          object,
+				PropertyReference: ref,
+				Values:            []TagType{null},
+				Priority:          &priority,
+			}
+			msg, err := NewWritePropertyMessage(1, &req)
+			assert.NoError(t, err, "Unexpected error creating request")
+			assert.Equal(t, ServiceConfirmed(ServiceConfirmedWriteProperty), msg.ServiceID, "Unexpected service")
+			assert.Equal(t, append(expectedRef, 0x3E, 0x00, 0x3F, 0x49, 0x08), msg.ServiceData,
+				"Encoding not expected")
+
+			decoded, err := NewWritePropertyRequestFromBytes(msg.ServiceData)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, &req, decoded, "Decoded request does not match")
+		})
+	}
+
+	t.Run("no priority", func(t *testing.T) {
+		req := WritePropertyRequest{
+			ObjectID:          object,
+			PropertyReference: PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+			Values:            []TagType{null},
+		}
+		msg, err := NewWritePropertyMessage(1, &req)
+		assert.NoError(t, err, "Unexpected error creating request")
+		decoded, err := NewWritePropertyRequestFromBytes(msg.ServiceData)
+		assert.NoError(t, err, "Unexpected error decoding")
+		assert.Equal(t, &req, decoded, "Decoded request does not match")
+	})
+}
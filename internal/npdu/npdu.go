@@ -7,6 +7,7 @@ import (
 	"fmt"
 
 	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 const (
@@ -24,6 +25,30 @@ const (
 	LifeSafetyMessage                               = 0b11
 )
 
+var priorityNames = map[NetworkMessagePriority]string{
+	NormalMessage:            "Normal",
+	UrgentMessage:            "Urgent",
+	CriticalEquipmentMessage: "CriticalEquipment",
+	LifeSafetyMessage:        "LifeSafety",
+}
+
+// String returns the name of the priority, or unknown with the number for the ones that don't fit in 2 bits.
+func (p NetworkMessagePriority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(p))
+}
+
+// Validate checks that the priority fits in the 2 bits of the control byte. A larger value would set the
+// other bits of the control byte when it's encoded.
+func (p NetworkMessagePriority) Validate() error {
+	if p > LifeSafetyMessage {
+		return bacnet.ErrValueTooLarge
+	}
+	return nil
+}
+
 // NetworkLayerMessageType is present if bit 7 of the Control byte is set.
 type NetworkLayerMessageType uint8
 
@@ -43,8 +68,14 @@ const (
 	NetworkLayerNetworkNumberIsMessage           = 0x13
 	// X'14' to X'7F': Reserved for use by ASHRAE
 	// X'80' to X'FF': Available for vendor proprietary messages
+	NetworkLayerProprietaryMessageMin = 0x80
 )
 
+// IsProprietary is true for the vendor proprietary message types, which are followed by the vendor ID.
+func (t NetworkLayerMessageType) IsProprietary() bool {
+	return t >= NetworkLayerProprietaryMessageMin
+}
+
 type (
 	// BACnet NPDU (Network Protocol Data Unit) is a byte level protocol over the network. They are either
 	// one, two, or variable bytes in length. To avoid using a 2 byte slice, we use a uint16 for the two
@@ -55,8 +86,8 @@ type (
 	// Control has the following format
 	// This is the interpretation of the second byte in the BACnet NPDU message
 	// The member names are quite long, yet some of them are still unclear.
-	// IsBACNetConfirmedRequestPDUPresent: if this is false, it means another type of reply is present (not
-	//    that there is no reply)
+	// DataExpectingReply: the sender expects a reply, like for a confirmed request, or some network layer
+	//    messages. (It's not whether the APDU is confirmed, although they usually go together.)
 	// ServiceSpecifiesPresent: SNET, SLEN, and SADR values must be set
 	// DestinationSpecifiersPresent: DNET, DLEN, and DADR values must be set, as well as Hop Count.
 	// IsNDSUNetworkLayerMessage: Network Service Data Unit. If false, it is a BACnet APDU (Application
@@ -64,19 +95,19 @@ type (
 	// This will be encoded as a byte
 	//   7   6   5   4   3   2   1   0
 	// |---|---|---|---|---|---|---|---|
-	// | N | R | D | R | S | C | Prio  |
+	// | N | R | D | R | S | E | Prio  |
 	// N: 1: Network layer, 0: APDU (no message type - see message struct)
 	// bits 6 and 4 are Reserved: 0
 	// D: Destination Address specifier 0: not present, 1: Present
 	// S: Source Address specifier 0: not present, 1: Present
-	// C: flag for Confirmed request PDU
+	// E: Data expecting reply
 	// Priority: See NetworkMessagePriority
 	Control struct {
-		Priority                           NetworkMessagePriority
-		IsBACNetConfirmedRequestPDUPresent bool
-		SourceAddressPresent               bool
-		DestinationAddressPresent          bool
-		IsNDSUNetworkLayerMessage          bool
+		Priority                  NetworkMessagePriority
+		DataExpectingReply        bool
+		SourceAddressPresent      bool
+		DestinationAddressPresent bool
+		IsNDSUNetworkLayerMessage bool
 	}
 
 	// Address is the address information for Source and Destination, although the values differ slightly.
@@ -99,8 +130,16 @@ type (
 	// Message is the interface for MessageBase. This will be moved to the bacnet package.
 	Message interface {
 		GetMessageType() NetworkLayerMessageType
+		GetPriority() NetworkMessagePriority
 		GetAPDUMessage() apdu.Message
+		// IsExpectingReply is the data expecting reply bit of the control, which should be set for confirmed
+		// requests
+		IsExpectingReply() bool
 		Encode() ([]byte, error)
+		// ReEncode encodes a message that was decoded WithRawCapture as it was received
+		ReEncode() ([]byte, error)
+		// Clone makes a deep copy, so a decoded message can be shared across goroutines and changed
+		Clone() Message
 	}
 
 	// MessageBase is the literal struct to send across the wire. This struct will be encoded and decoded off
@@ -126,39 +165,44 @@ var _ Message = (*MessageBase)(nil)
 
 // NewControl creates a control byte from the values. We return the actual struct instead of a pointer because
 // that is how it will generally be used.
-func newControl(priority NetworkMessagePriority, isBACNetConfirmedRequestPDUPresent, sourceAddressPresent,
+func newControl(priority NetworkMessagePriority, dataExpectingReply, sourceAddressPresent,
 	destinationAddressPresent, isNDSUNetworkLayerMessage bool) Control {
 	return Control{
-		Priority:                           priority,
-		IsBACNetConfirmedRequestPDUPresent: isBACNetConfirmedRequestPDUPresent,
-		SourceAddressPresent:               sourceAddressPresent,
-		DestinationAddressPresent:          destinationAddressPresent,
-		IsNDSUNetworkLayerMessage:          isNDSUNetworkLayerMessage,
+		Priority:                  priority,
+		DataExpectingReply:        dataExpectingReply,
+		SourceAddressPresent:      sourceAddressPresent,
+		DestinationAddressPresent: destinationAddressPresent,
+		IsNDSUNetworkLayerMessage: isNDSUNetworkLayerMessage,
 	}
 }
 
 // NewMessage creates an NPDUMessage. Depending on the control information, different portions of the
 // message will be valid and others will be nil. This is kind of low level, and numerous other
-// constructors can be made
-func NewMessage(priority NetworkMessagePriority, isConfirmed, isNetworkNessage bool, dest, src *Address,
+// constructors can be made. The hop count is only encoded with a destination, so it is ignored if dest is nil.
+// expectingReply sets the data expecting reply bit, which should be set for confirmed requests.
+func NewMessage(priority NetworkMessagePriority, expectingReply, isNetworkNessage bool, dest, src *Address,
 	hopCount uint8, messageType NetworkLayerMessageType, vendorID *uint16, apdu apdu.Message) *MessageBase {
 	hasSrcAddr := src != nil
 	hasDestAddr := dest != nil
-	control := newControl(priority, isConfirmed, hasSrcAddr, hasDestAddr, isNetworkNessage)
+	control := newControl(priority, expectingReply, hasSrcAddr, hasDestAddr, isNetworkNessage)
+	var hops *uint8
+	if hasDestAddr {
+		hops = &hopCount
+	}
 	return &MessageBase{
 		ProtocolVersion: DefaultProtocolVersion,
 		Control:         control,
 		Destination:     dest,
 		Source:          src,
-		HopCount:        &hopCount,
+		HopCount:        hops,
 		MessageType:     messageType,
 		VendorID:        vendorID,
 		APDU:            apdu,
 	}
 }
 
-// NewMessageFromBytes decodees the byte back into a Message.
-func NewMessageFromBytes(data []byte) (*MessageBase, error) {
+// NewMessageFromBytes decodees the byte back into a Message. The options are for decoding the APDU.
+func NewMessageFromBytes(data []byte, opts ...apdu.DecodeOption) (*MessageBase, error) {
 	if len(data) < 2 {
 		return nil, errors.New("bytes do not contain an NPDU message")
 	}
@@ -174,14 +218,14 @@ func NewMessageFromBytes(data []byte) (*MessageBase, error) {
 	if control.DestinationAddressPresent {
 		destAddr, err := readAddress(buf)
 		if err != nil {
-			return nil, errors.New("Error decoding address")
+			return nil, fmt.Errorf("Error decoding address: %w", err)
 		}
 		message.Destination = destAddr
 	}
 	if control.SourceAddressPresent {
 		srcAddr, err := readAddress(buf)
 		if err != nil {
-			return nil, errors.New("Error decoding address")
+			return nil, fmt.Errorf("Error decoding address: %w", err)
 		}
 		message.Source = srcAddr
 	}
@@ -199,27 +243,92 @@ func NewMessageFromBytes(data []byte) (*MessageBase, error) {
 		}
 		// Exception to the pointer for optional members.
 		message.MessageType = NetworkLayerMessageType(mType)
+		if message.MessageType.IsProprietary() {
+			vendorID, err := readDoubleByte(buf)
+			if err != nil {
+				return nil, errors.New("Error decoding vendor ID")
+			}
+			message.VendorID = &vendorID
+		}
 	} else {
 		// Pass the rest of the bytes to get the message
-		msg, err := apdu.NewMessageFromBytes(buf.Bytes())
+		msg, err := apdu.NewMessageFromBytes(buf.Bytes(), opts...)
 		if err != nil {
-			return nil, err
+			return nil, &APDUDecodeError{APDU: append([]byte{}, buf.Bytes()...), Err: err}
 		}
 		message.APDU = msg
 	}
 	return &message, nil
 }
 
+// APDUDecodeError is returned when the NPDU was decoded, but the APDU in it couldn't be, usually because it's a
+// service that we don't implement. It has the bytes of the APDU, so they can be passed on or logged.
+type APDUDecodeError struct {
+	APDU []byte
+	Err  error
+}
+
+func (e *APDUDecodeError) Error() string {
+	return fmt.Sprintf("unable to decode APDU: %v", e.Err)
+}
+
+func (e *APDUDecodeError) Unwrap() error {
+	return e.Err
+}
+
 // GetMessageType gets the type from the message.
 func (m *MessageBase) GetMessageType() NetworkLayerMessageType {
 	return m.MessageType
 }
 
+// GetPriority gets the priority from the control information. The APDU doesn't have a priority, so this is
+// how an application can tell if a message is urgent.
+func (m *MessageBase) GetPriority() NetworkMessagePriority {
+	return m.Control.Priority
+}
+
+// IsExpectingReply checks if the sender expects a reply. A confirmed request without it shouldn't be
+// answered, since the sender isn't waiting for the response.
+func (m *MessageBase) IsExpectingReply() bool {
+	return m.Control.DataExpectingReply
+}
+
 // GetAPDUMessage gets the APDU message contained in the message
 func (m *MessageBase) GetAPDUMessage() apdu.Message {
 	return m.APDU
 }
 
+// Clone copies the message, including the addresses, the optional fields, and the APDU.
+func (m *MessageBase) Clone() Message {
+	clone := *m
+	clone.Destination = m.Destination.clone()
+	clone.Source = m.Source.clone()
+	if m.HopCount != nil {
+		hopCount := *m.HopCount
+		clone.HopCount = &hopCount
+	}
+	if m.VendorID != nil {
+		vendorID := *m.VendorID
+		clone.VendorID = &vendorID
+	}
+	if m.APDU != nil {
+		clone.APDU = m.APDU.Clone()
+	}
+	return &clone
+}
+
+// clone copies the address, which may be nil.
+func (a *Address) clone() *Address {
+	if a == nil {
+		return nil
+	}
+	clone := *a
+	if a.Addr != nil {
+		clone.Addr = append([]byte{}, a.Addr...)
+	}
+	return &clone
+}
+
 // Add this method to byte.Buffer for our usage. I actually don't know if it's big or little endian yet, so this
 // is to encapsulate that.
 func readDoubleByte(buf *bytes.Buffer) (uint16, error) {
@@ -262,7 +371,7 @@ func encodeControl(ctrl Control) byte {
 		encoded |= 1
 	}
 	encoded = encoded << 1
-	if ctrl.IsBACNetConfirmedRequestPDUPresent {
+	if ctrl.DataExpectingReply {
 		encoded |= 1
 	}
 	// next one is two bits
@@ -272,6 +381,16 @@ func encodeControl(ctrl Control) byte {
 	return encoded
 }
 
+// Byte returns the encoded control byte, for logging or comparing it as it is on the wire.
+func (c Control) Byte() byte {
+	return encodeControl(c)
+}
+
+// ControlFromByte decodes the control byte. The reserved bits are ignored.
+func ControlFromByte(data byte) Control {
+	return decodeControl(data)
+}
+
 // XXX - Do I have the bits backwards?
 func decodeControl(data byte) Control {
 	// Start from the most significant bytes, and shift
@@ -279,7 +398,7 @@ func decodeControl(data byte) Control {
 	ctrl.IsNDSUNetworkLayerMessage = (data & 0b10000000) != 0
 	ctrl.DestinationAddressPresent = (data & 0b00100000) != 0
 	ctrl.SourceAddressPresent = (data & 0b00001000) != 0
-	ctrl.IsBACNetConfirmedRequestPDUPresent = (data & 0b00000100) != 0
+	ctrl.DataExpectingReply = (data & 0b00000100) != 0
 	ctrl.Priority = (NetworkMessagePriority)(data & 0b00000011)
 
 	return ctrl
@@ -314,22 +433,86 @@ func readAddress(buf *bytes.Buffer) (*Address, error) {
 	}
 	addr.Length = b
 	if addr.Length > 0 {
-		// read uses len, not capacity
-		addrBuf := make([]byte, addr.Length)
-		bytesRead, e := buf.Read(addrBuf)
-		if e != nil {
-			return nil, e
-		}
-		if bytesRead != int(addr.Length) {
-			return nil, fmt.Errorf("read %d bytes, expected %d bytes", bytesRead, addr.Length)
+		// Don't trust the length: the message may be truncated, or lie about it.
+		if buf.Len() < int(addr.Length) {
+			return nil, fmt.Errorf("address length is %d, but %d bytes remain: %w", addr.Length, buf.Len(),
+				bacnet.ErrInsufficientData)
 		}
-		addr.Addr = addrBuf
+		addr.Addr = append([]byte{}, buf.Next(int(addr.Length))...)
 	}
 	return &addr, nil
 }
 
-// Encode a message
+// Validate checks that the control flags are consistent with the rest of the message, so Encode doesn't
+// panic on a nil field or write a message that can't be decoded.
+func (m *MessageBase) Validate() error {
+	if err := m.Control.Priority.Validate(); err != nil {
+		return fmt.Errorf("invalid priority %d: %w", m.Control.Priority, err)
+	}
+	if m.Control.DestinationAddressPresent != (m.Destination != nil) {
+		return fmt.Errorf("destination present flag is %t, but destination is %v",
+			m.Control.DestinationAddressPresent, m.Destination)
+	}
+	if m.Control.SourceAddressPresent != (m.Source != nil) {
+		return fmt.Errorf("source present flag is %t, but source is %v", m.Control.SourceAddressPresent, m.Source)
+	}
+	if m.Destination != nil {
+		if m.HopCount == nil {
+			return errors.New("hop count is required with a destination")
+		}
+		if err := validateAddress(m.Destination); err != nil {
+			return fmt.Errorf("invalid destination: %w", err)
+		}
+	}
+	if m.Source != nil {
+		if err := validateAddress(m.Source); err != nil {
+			return fmt.Errorf("invalid source: %w", err)
+		}
+		// A source can't be a broadcast
+		if m.Source.Length == 0 || m.Source.Network == 0xFFFF {
+			return errors.New("source address can not be a broadcast")
+		}
+	}
+	if m.Control.IsNDSUNetworkLayerMessage {
+		if m.APDU != nil {
+			return errors.New("network layer message can not have application data (APDU)")
+		}
+		if m.MessageType.IsProprietary() != (m.VendorID != nil) {
+			return fmt.Errorf("vendor ID is required for proprietary message types, and only them (type %d)",
+				m.MessageType)
+		}
+	} else if m.APDU == nil {
+		return errors.New("message was not a NDSU, but does not have application data (APDU)")
+	}
+	return nil
+}
+
+// validateAddress checks the address length. A length of 0 is a broadcast, so the address isn't written.
+func validateAddress(addr *Address) error {
+	if addr.Length > 0 && int(addr.Length) != len(addr.Addr) {
+		return fmt.Errorf("address length is %d, but has %d bytes", addr.Length, len(addr.Addr))
+	}
+	return nil
+}
+
+// Encode a message. It calls Validate first, so an inconsistent message is an error instead of bad bytes.
 func (m *MessageBase) Encode() ([]byte, error) {
+	return m.encode(func(msg apdu.Message) ([]byte, error) {
+		return msg.Encode()
+	})
+}
+
+// ReEncode encodes the message like Encode, but the APDU is encoded with apdu.ReEncode, so a message that was
+// decoded WithRawCapture is encoded as it was received.
+func (m *MessageBase) ReEncode() ([]byte, error) {
+	return m.encode(apdu.ReEncode)
+}
+
+// encode encodes the NPDU, and the APDU with encodeAPDU.
+func (m *MessageBase) encode(encodeAPDU func(msg apdu.Message) ([]byte, error)) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
 	// We only know that it will be 2 bytes plus data.
 	b := make([]byte, 0, 3)
 	buf := bytes.NewBuffer(b)
@@ -355,21 +538,25 @@ func (m *MessageBase) Encode() ([]byte, error) {
 			return nil, e
 		}
 	}
-	// vendorID goes in between message type and APDU, but I don't which one it accompanies.
+	// There is no APDU with a network layer message. The vendor ID follows the message type only for
+	// proprietary message types.
 	if m.Control.IsNDSUNetworkLayerMessage {
 		if e := buf.WriteByte((byte)(m.MessageType)); e != nil {
 			return nil, e
 		}
-	} else if m.APDU != nil {
-		apduBytes, e := m.APDU.Encode()
+		if m.MessageType.IsProprietary() {
+			if e := writeDoubleByte(buf, *m.VendorID); e != nil {
+				return nil, e
+			}
+		}
+	} else {
+		apduBytes, e := encodeAPDU(m.APDU)
 		if e != nil {
 			return nil, e
 		}
 		if _, e = buf.Write(apduBytes); e != nil {
 			return nil, e
 		}
-	} else {
-		return nil, fmt.Errorf("Message was not a NDSU, but does not have application data (APDU)")
 	}
 
 	return buf.Bytes(), nil
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 func TestDoubleByte(t *testing.T) {
@@ -64,7 +65,7 @@ func TestNPDUCoding(t *testing.T) {
 func TestControl(t *testing.T) {
 	t.Run("TestCreateControl", func(t *testing.T) {
 		ctrl := newControl(UrgentMessage, false, true, false, true)
-		assert.False(t, ctrl.IsBACNetConfirmedRequestPDUPresent, "Unexpected value")
+		assert.False(t, ctrl.DataExpectingReply, "Unexpected value")
 		assert.True(t, ctrl.SourceAddressPresent, "Unexpected value")
 		assert.False(t, ctrl.DestinationAddressPresent, "Unexpected value")
 		assert.True(t, ctrl.IsNDSUNetworkLayerMessage, "Unexpected value")
@@ -75,8 +76,7 @@ func TestControl(t *testing.T) {
 			assert.Equal(t, uint8(0b10001001), encoded, "Unexpected encoding")
 
 			decodedCtrl := decodeControl(encoded)
-			assert.Equal(t, ctrl.IsBACNetConfirmedRequestPDUPresent, decodedCtrl.IsBACNetConfirmedRequestPDUPresent,
-				"unexpected value")
+			assert.Equal(t, ctrl.DataExpectingReply, decodedCtrl.DataExpectingReply, "unexpected value")
 			assert.Equal(t, ctrl.SourceAddressPresent, decodedCtrl.SourceAddressPresent, "Unexpected value")
 			assert.Equal(t, ctrl.DestinationAddressPresent, decodedCtrl.DestinationAddressPresent, "Unexpected value")
 			assert.Equal(t, ctrl.IsNDSUNetworkLayerMessage, decodedCtrl.IsNDSUNetworkLayerMessage, "Unexpected value")
@@ -84,6 +84,46 @@ func TestControl(t *testing.T) {
 		})
 
 	})
+
+	t.Run("TestDataExpectingReply", func(t *testing.T) {
+		readProp := &apdu.ConfirmedMessage{
+			MessageBase:       apdu.MessageBase{ServiceType: apdu.PDUTypeConfirmedServiceRequest},
+			MaxLengthAccepted: apdu.MaxAPDULength1476,
+			InvokeID:          1,
+			ServiceID:         apdu.ServiceConfirmedReadProperty,
+			ServiceData:       []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x19, 0x4D},
+		}
+		msg := NewMessage(NormalMessage, true, false, nil, nil, 0, 0, nil, readProp)
+		assert.True(t, msg.Control.DataExpectingReply, "Unexpected value")
+		assert.Equal(t, uint8(0b00000100), encodeControl(msg.Control), "Unexpected encoding")
+
+		encoded, err := msg.Encode()
+		assert.NoError(t, err, "Unexpected error encoding")
+		decoded, err := NewMessageFromBytes(encoded)
+		assert.NoError(t, err, "Unexpected error decoding")
+		assert.True(t, decoded.Control.DataExpectingReply, "Data expecting reply not decoded")
+		assert.Equal(t, readProp, decoded.APDU, "APDU did not match")
+	})
+}
+
+func TestControlByte(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ctrl     Control
+		expected byte
+	}{
+		{"empty", Control{}, 0x00},
+		{"expecting reply", newControl(NormalMessage, true, false, false, false), 0x04},
+		{"life safety with addresses", newControl(LifeSafetyMessage, false, true, true, false), 0x2B},
+		{"network message", newControl(UrgentMessage, false, true, false, true), 0x89},
+		{"everything", newControl(CriticalEquipmentMessage, true, true, true, true), 0xAE},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, tCase.ctrl.Byte(), "Unexpected byte")
+			assert.Equal(t, tCase.ctrl, ControlFromByte(tCase.ctrl.Byte()), "Control did not round trip")
+		})
+	}
 }
 
 func TestAddress(t *testing.T) {
@@ -115,3 +155,317 @@ func TestAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestTruncatedAddress(t *testing.T) {
+	decodeAddress := func(data []byte) error {
+		_, err := readAddress(bytes.NewBuffer(data))
+		return err
+	}
+	decodeMessage := func(data []byte) error {
+		_, err := NewMessageFromBytes(data)
+		return err
+	}
+	testCases := []struct {
+		name   string
+		decode func(data []byte) error
+		data   []byte
+	}{
+		// claims 8 bytes of address, but only has 3
+		{"address", decodeAddress, []byte{0x00, 0x05, 8, 1, 2, 3}},
+		{"destination", decodeMessage, []byte{1, 0x20, 0x00, 0x05, 8, 1, 2, 3}},
+		{"source", decodeMessage, []byte{1, 0x08, 0x00, 0x05, 8, 1, 2, 3}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := tCase.decode(tCase.data)
+			assert.ErrorIs(t, err, bacnet.ErrInsufficientData, "Expected error for a truncated address")
+		})
+	}
+}
+
+func TestNPDUAddressCoding(t *testing.T) {
+	dest := &Address{Network: 0x0102, Length: 1, Addr: []byte{0x44}}
+	src := &Address{Network: 0x0A0B, Length: 6, Addr: []byte{192, 168, 3, 16, 0xBA, 0xC0}}
+	testCases := []struct {
+		name     string
+		dest     *Address
+		src      *Address
+		expected []byte
+	}{
+		{"neither", nil, nil, []byte{1, 0x00, 16, 8, 9, 0, 26, 3, 231}},
+		{"destination only", dest, nil,
+			[]byte{1, 0x20, 0x01, 0x02, 1, 0x44, 0xFE, 16, 8, 9, 0, 26, 3, 231}},
+		{"source only", nil, src,
+			[]byte{1, 0x08, 0x0A, 0x0B, 6, 192, 168, 3, 16, 0xBA, 0xC0, 16, 8, 9, 0, 26, 3, 231}},
+		{"both", dest, src,
+			[]byte{1, 0x28, 0x01, 0x02, 1, 0x44, 0x0A, 0x0B, 6, 192, 168, 3, 16, 0xBA, 0xC0, 0xFE, 16, 8, 9, 0, 26,
+				3, 231}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			appMsg, err := apdu.NewWhoisMessage(0, 999)
+			assert.NoError(t, err, "Unexpected error creating test APDU Message")
+			npduMsg := NewMessage(NormalMessage, false, false, tCase.dest, tCase.src, 0xFE, NetworkLayerWhoIsMessage,
+				nil, appMsg)
+
+			npduBytes, err := npduMsg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding NPDU Message")
+			assert.Equal(t, tCase.expected, npduBytes, "Encoding not expected")
+
+			npduDecoded, err := NewMessageFromBytes(npduBytes)
+			assert.NoError(t, err, "Unable to decode valid message")
+			assert.Equal(t, npduMsg, npduDecoded, "Decoded message did not match")
+		})
+	}
+}
+
+func TestNPDURoundTrip(t *testing.T) {
+	broadcast := &Address{Network: 0xFFFF}
+	dest := &Address{Network: 0x0102, Length: 1, Addr: []byte{0x44}}
+	src := &Address{Network: 0x0A0B, Length: 2, Addr: []byte{0x01, 0x02}}
+	whoIs, err := apdu.NewWhoisMessage(0, 999)
+	assert.NoError(t, err, "Unexpected error creating WhoIs")
+	readProperty, err := apdu.NewReadPropertyMessage(1,
+		bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}, bacnet.PropertyIdentifierPresentValue, nil)
+	assert.NoError(t, err, "Unexpected error creating ReadProperty")
+	vendorID := uint16(0x0104)
+
+	testCases := []struct {
+		name           string
+		priority       NetworkMessagePriority
+		expectingReply bool
+		networkMessage bool
+		dest           *Address
+		src            *Address
+		hopCount       uint8
+		messageType    NetworkLayerMessageType
+		vendorID       *uint16
+		apdu           apdu.Message
+		// expected is the NPDU header, which the APDU follows
+		expected []byte
+	}{
+		{"destination with hop count", NormalMessage, false, false, broadcast, nil, 0xFF, 0, nil, whoIs,
+			[]byte{1, 0x20, 0xFF, 0xFF, 0, 0xFF}},
+		{"source only", LifeSafetyMessage, true, false, nil, src, 0, 0, nil, readProperty,
+			[]byte{1, 0x0F, 0x0A, 0x0B, 2, 0x01, 0x02}},
+		{"both", UrgentMessage, true, false, dest, src, 7, 0, nil, readProperty,
+			[]byte{1, 0x2D, 0x01, 0x02, 1, 0x44, 0x0A, 0x0B, 2, 0x01, 0x02, 7}},
+		{"network message", NormalMessage, false, true, nil, nil, 0, NetworkLayerWhatIsNetworkNumberMessage, nil,
+			nil, []byte{1, 0x80, 0x12}},
+		{"network message with destination", NormalMessage, false, true, broadcast, nil, 0xFF,
+			NetworkLayerWhatIsNetworkNumberMessage, nil, nil, []byte{1, 0xA0, 0xFF, 0xFF, 0, 0xFF, 0x12}},
+		{"proprietary network message", NormalMessage, false, true, nil, nil, 0, NetworkLayerProprietaryMessageMin,
+			&vendorID, nil, []byte{1, 0x80, 0x80, 0x01, 0x04}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			npduMsg := NewMessage(tCase.priority, tCase.expectingReply, tCase.networkMessage, tCase.dest, tCase.src,
+				tCase.hopCount, tCase.messageType, tCase.vendorID, tCase.apdu)
+			expected := tCase.expected
+			if tCase.apdu != nil {
+				apduBytes, err := tCase.apdu.Encode()
+				assert.NoError(t, err, "Unexpected error encoding APDU")
+				expected = append(append([]byte{}, expected...), apduBytes...)
+			}
+
+			npduBytes, err := npduMsg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding NPDU Message")
+			assert.Equal(t, expected, npduBytes, "Encoding not expected")
+
+			npduDecoded, err := NewMessageFromBytes(npduBytes)
+			assert.NoError(t, err, "Unable to decode valid message")
+			assert.Equal(t, npduMsg, npduDecoded, "Decoded message did not match")
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	whoIs, err := apdu.NewWhoisMessage(0, 999)
+	assert.NoError(t, err, "Unable to create WhoIs message")
+	hops := uint8(0xFE)
+	addr := &Address{Network: 5, Length: 2, Addr: []byte{1, 2}}
+
+	testCases := []struct {
+		name        string
+		msg         *MessageBase
+		expectedErr string
+	}{
+		{"valid", NewMessage(NormalMessage, false, false, addr, addr, hops, 0, nil, whoIs), ""},
+		{"destination without flag", &MessageBase{Destination: addr, HopCount: &hops, APDU: whoIs},
+			"destination present flag"},
+		{"destination flag without destination",
+			&MessageBase{Control: Control{DestinationAddressPresent: true}, HopCount: &hops, APDU: whoIs},
+			"destination present flag"},
+		{"source flag without source", &MessageBase{Control: Control{SourceAddressPresent: true}, APDU: whoIs},
+			"source present flag"},
+		{"no hop count", &MessageBase{Control: Control{DestinationAddressPresent: true}, Destination: addr,
+			APDU: whoIs}, "hop count is required"},
+		{"address length", NewMessage(NormalMessage, false, false, &Address{Network: 5, Length: 6, Addr: []byte{1}},
+			nil, hops, 0, nil, whoIs), "address length is 6"},
+		{"broadcast source", NewMessage(NormalMessage, false, false, nil, &Address{Network: 5}, hops, 0, nil, whoIs),
+			"can not be a broadcast"},
+		{"no APDU", NewMessage(NormalMessage, false, false, nil, nil, hops, 0, nil, nil), "does not have application"},
+		{"network message with APDU", NewMessage(NormalMessage, false, true, nil, nil, hops,
+			NetworkLayerWhoIsMessage, nil, whoIs), "can not have application data"},
+		{"priority out of range", NewMessage(4, false, false, nil, nil, hops, 0, nil, whoIs), "invalid priority 4"},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			err := tCase.msg.Validate()
+			_, encodeErr := tCase.msg.Encode()
+			if tCase.expectedErr == "" {
+				assert.NoError(t, err, "Unexpected validation error")
+				assert.NoError(t, encodeErr, "Unexpected encoding error")
+				return
+			}
+			if assert.Error(t, err, "Expected a validation error") {
+				assert.Contains(t, err.Error(), tCase.expectedErr, "Unexpected validation error")
+			}
+			assert.Equal(t, err, encodeErr, "Encode should return the validation error")
+		})
+	}
+}
+
+func TestPriority(t *testing.T) {
+	testCases := []struct {
+		name        string
+		priority    NetworkMessagePriority
+		expected    string
+		expectedErr error
+	}{
+		{"normal", NormalMessage, "Normal", nil},
+		{"urgent", UrgentMessage, "Urgent", nil},
+		{"critical equipment", CriticalEquipmentMessage, "CriticalEquipment", nil},
+		{"life safety", LifeSafetyMessage, "LifeSafety", nil},
+		{"out of range", 4, "unknown(4)", bacnet.ErrValueTooLarge},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			assert.Equal(t, tCase.expected, tCase.priority.String(), "Unexpected name")
+			assert.Equal(t, tCase.expectedErr, tCase.priority.Validate(), "Unexpected validation")
+		})
+	}
+}
+
+func TestVendorID(t *testing.T) {
+	vendorID := uint16(999)
+	testCases := []struct {
+		name          string
+		messageType   NetworkLayerMessageType
+		vendorID      *uint16
+		expectedBytes []byte
+	}{
+		{"standard", NetworkLayerWhatIsNetworkNumberMessage, nil, []byte{0x01, 0x80, 0x12}},
+		{"proprietary", 0x80, &vendorID, []byte{0x01, 0x80, 0x80, 0x03, 0xE7}},
+		{"last proprietary", 0xFF, &vendorID, []byte{0x01, 0x80, 0xFF, 0x03, 0xE7}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			msg := NewMessage(NormalMessage, false, true, nil, nil, 0, tCase.messageType, tCase.vendorID, nil)
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.expectedBytes, encoded, "Unexpected encoding")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.messageType, decoded.MessageType, "MessageType did not match")
+			assert.Equal(t, tCase.vendorID, decoded.VendorID, "VendorID did not match")
+		})
+	}
+
+	t.Run("proprietary without vendor", func(t *testing.T) {
+		_, err := NewMessage(NormalMessage, false, true, nil, nil, 0, 0x80, nil, nil).Encode()
+		assert.Error(t, err, "Expected error without a vendor ID")
+		_, err = NewMessage(NormalMessage, false, true, nil, nil, 0, NetworkLayerIAmMessage, &vendorID, nil).Encode()
+		assert.Error(t, err, "Expected error with a vendor ID for a standard message")
+	})
+}
+
+func TestClone(t *testing.T) {
+	dest := &Address{Network: 0x0102, Length: 1, Addr: []byte{0x44}}
+	src := &Address{Network: 0x0A0B, Length: 6, Addr: []byte{192, 168, 3, 16, 0xBA, 0xC0}}
+	appMsg := &apdu.ConfirmedMessage{
+		MessageBase: apdu.MessageBase{ServiceType: apdu.PDUTypeConfirmedServiceRequest},
+		InvokeID:    1,
+		ServiceID:   apdu.ServiceConfirmedReadProperty,
+		ServiceData: []byte{0x0C, 0x02, 0x00, 0x04, 0xD2, 0x19, 77},
+	}
+	original := NewMessage(NormalMessage, true, false, dest, src, 0xFE, 0, nil, appMsg)
+	expected, err := original.Encode()
+	assert.NoError(t, err, "Unexpected error encoding original")
+
+	clone, ok := original.Clone().(*MessageBase)
+	assert.True(t, ok, "Clone is not a *MessageBase")
+	assert.Equal(t, original, clone, "Clone does not match")
+
+	*clone.HopCount = 1
+	clone.Destination.Addr[0] = 0x55
+	clone.Source.Network = 0x0C0D
+	clone.Source.Addr[5] = 0xC1
+	cloneAPDU := clone.APDU.(*apdu.ConfirmedMessage)
+	cloneAPDU.InvokeID = 2
+	cloneAPDU.ServiceData[6] = 78
+
+	encoded, err := original.Encode()
+	assert.NoError(t, err, "Unexpected error encoding original")
+	assert.Equal(t, expected, encoded, "Changing the clone changed the original")
+	assert.Equal(t, uint8(0xFE), *original.HopCount, "Hop count is shared")
+	assert.Equal(t, uint8(1), appMsg.InvokeID, "APDU is shared")
+}
+
+func TestReEncode(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+		// exact is whether Encode also gives the original bytes
+		exact bool
+	}{
+		// ReadProperty ack for the present value of AI 1, which is a REAL, from a device on network 5
+		{"ReadProperty ack", []byte{1, 0x08, 0x00, 0x05, 1, 0x44,
+			0x30, 0x01, 0x0C, 0x0C, 0x00, 0x00, 0x00, 0x01, 0x19, 85, 0x3E, 0x44, 0x42, 0x90, 0x00, 0x00, 0x3F},
+			true},
+		// IAm with the max APDU length and vendor ID in more bytes than they need
+		{"IAm", []byte{1, 0x00,
+			0x10, 0x00, 0xC4, 0x02, 0x00, 0x04, 0xD2, 0x24, 0x00, 0x00, 0x05, 0xC4, 0x91, 0x03, 0x22, 0x00, 0x01},
+			false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			decoded, err := NewMessageFromBytes(tCase.data, apdu.WithRawCapture())
+			assert.NoError(t, err, "Unexpected error decoding")
+			reEncoded, err := decoded.ReEncode()
+			assert.NoError(t, err, "Unexpected error re-encoding")
+			assert.Equal(t, tCase.data, reEncoded, "Re-encoding is not the original")
+
+			encoded, err := decoded.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			assert.Equal(t, tCase.exact, bytes.Equal(tCase.data, encoded), "Unexpected encoding")
+		})
+	}
+}
+
+func TestExpectingReply(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expectingReply bool
+	}{
+		{"expecting reply", true},
+		{"not expecting reply", false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			readProperty, err := apdu.NewReadPropertyMessage(1,
+				bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}, bacnet.PropertyIdentifierPresentValue,
+				nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			npduMsg := NewMessage(NormalMessage, tCase.expectingReply, false, nil, nil, 0xFF, 0, nil, readProperty)
+			encoded, err := npduMsg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+
+			decoded, err := NewMessageFromBytes(encoded)
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, tCase.expectingReply, decoded.IsExpectingReply(), "Unexpected expecting reply")
+			assert.IsType(t, &apdu.ConfirmedMessage{}, decoded.GetAPDUMessage(), "Expected a confirmed request")
+		})
+	}
+}
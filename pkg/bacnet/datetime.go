@@ -0,0 +1,122 @@
+package bacnet
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnspecifiedTime is returned when converting a BACnet date or time that has wildcard (or other
+// non-concrete) fields to a time.Time.
+var ErrUnspecifiedTime = errors.New("date or time is not a concrete time")
+
+// DateTimeWildcard is the value of a date or time field that is unspecified ("any").
+const DateTimeWildcard = 0xFF
+
+// Date is a BACnet date (20.2.12 in the spec). Year is the offset from 1900, and Weekday is 1 (Monday)
+// to 7 (Sunday). Any field can be DateTimeWildcard. Month also has 13 (odd) and 14 (even), and Day has
+// 32 (last day of the month), 33 (odd) and 34 (even), which are patterns, not dates.
+type Date struct {
+	Year    uint8
+	Month   uint8
+	Day     uint8
+	Weekday uint8
+}
+
+// Time is a BACnet time (20.2.13 in the spec). Any field can be DateTimeWildcard.
+type Time struct {
+	Hour       uint8
+	Minute     uint8
+	Second     uint8
+	Hundredths uint8
+}
+
+const (
+	dateYearOffset = 1900
+	maxDateYear    = dateYearOffset + 254
+)
+
+// DateToTime converts the date to midnight of that day in loc. The weekday is ignored, since it follows
+// from the date.
+func DateToTime(d Date, loc *time.Location) (time.Time, error) {
+	if d.Year == DateTimeWildcard || d.Month < 1 || d.Month > 12 || d.Day < 1 || d.Day > 31 {
+		return time.Time{}, ErrUnspecifiedTime
+	}
+	t := time.Date(dateYearOffset+int(d.Year), time.Month(d.Month), int(d.Day), 0, 0, 0, 0, loc)
+	// time.Date normalizes, so Feb 30 would be in March.
+	if t.Day() != int(d.Day) {
+		return time.Time{}, ErrInvalidData
+	}
+	return t, nil
+}
+
+// TimeToTime converts the time to that time of day on January 1 of year 0 in loc. Combine it with a date
+// with DateTimeToTime.
+func TimeToTime(t Time, loc *time.Location) (time.Time, error) {
+	if t.Hour > 23 || t.Minute > 59 || t.Second > 59 || t.Hundredths > 99 {
+		// includes wildcards
+		return time.Time{}, ErrUnspecifiedTime
+	}
+	return time.Date(0, time.January, 1, int(t.Hour), int(t.Minute), int(t.Second),
+		int(t.Hundredths)*int(10*time.Millisecond), loc), nil
+}
+
+// DateTimeToTime converts the date and time to a time.Time in loc.
+func DateTimeToTime(d Date, t Time, loc *time.Location) (time.Time, error) {
+	date, err := DateToTime(d, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timeOfDay, err := TimeToTime(t, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), timeOfDay.Hour(), timeOfDay.Minute(),
+		timeOfDay.Second(), timeOfDay.Nanosecond(), loc), nil
+}
+
+// DateFromTime converts the date of t. Only years from 1900 to 2154 can be represented.
+func DateFromTime(t time.Time) (Date, error) {
+	if t.Year() < dateYearOffset || t.Year() > maxDateYear {
+		return Date{}, ErrValueTooLarge
+	}
+	weekday := uint8(t.Weekday())
+	if t.Weekday() == time.Sunday {
+		weekday = 7
+	}
+	return Date{
+		Year:    uint8(t.Year() - dateYearOffset),
+		Month:   uint8(t.Month()),
+		Day:     uint8(t.Day()),
+		Weekday: weekday,
+	}, nil
+}
+
+// TimeFromTime converts the time of day of t. Anything less than a hundredth of a second is truncated.
+func TimeFromTime(t time.Time) Time {
+	return Time{
+		Hour:       uint8(t.Hour()),
+		Minute:     uint8(t.Minute()),
+		Second:     uint8(t.Second()),
+		Hundredths: uint8(t.Nanosecond() / int(10*time.Millisecond)),
+	}
+}
+
+// DateTimeFromTime converts t to a BACnet date and time.
+func DateTimeFromTime(t time.Time) (Date, Time, error) {
+	d, err := DateFromTime(t)
+	if err != nil {
+		return Date{}, Time{}, err
+	}
+	return d, TimeFromTime(t), nil
+}
+
+// DeviceLocalTime converts the UTC time to the local time of a device, with the values of its UTC_Offset and
+// Daylight_Savings_Status properties. The offset is in minutes, and like the spec, it's positive west of
+// Greenwich, so it's subtracted from UTC. Daylight savings adds an hour.
+func DeviceLocalTime(utc time.Time, utcOffset int, daylightSavings bool) time.Time {
+	offset := -utcOffset * 60
+	if daylightSavings {
+		offset += 60 * 60
+	}
+	return utc.In(time.FixedZone("", offset))
+}
@@ -0,0 +1,44 @@
+package bacnet
+
+// EventState is the state of an object for event reporting. Normal and fault are the same for every event
+// type, and the others are the offnormal states. (BACnetEventState in 21 of the spec)
+type EventState uint8
+
+// The values for EventState. We are explicit because these are transmitted.
+const (
+	EventStateNormal          EventState = 0
+	EventStateFault                      = 1
+	EventStateOffnormal                  = 2
+	EventStateHighLimit                  = 3
+	EventStateLowLimit                   = 4
+	EventStateLifeSafetyAlarm            = 5
+)
+
+// IsOffnormal checks if the state is one of the offnormal states, which are everything but normal and fault.
+func (s EventState) IsOffnormal() bool {
+	return s != EventStateNormal && s != EventStateFault
+}
+
+// EventType is the algorithm that generated an event. (BACnetEventType in 21 of the spec)
+type EventType uint16
+
+// The values for EventType. These are only the ones that we use.
+const (
+	EventTypeChangeOfBitstring EventType = 0
+	EventTypeChangeOfState               = 1
+	EventTypeChangeOfValue               = 2
+	EventTypeCommandFailure              = 3
+	EventTypeFloatingLimit               = 4
+	EventTypeOutOfRange                  = 5
+)
+
+// NotifyType is whether an event notification is for an alarm or an event, or an acknowledgement of one.
+// (BACnetNotifyType in 21 of the spec)
+type NotifyType uint8
+
+// The values for NotifyType
+const (
+	NotifyTypeAlarm           NotifyType = 0
+	NotifyTypeEvent                      = 1
+	NotifyTypeAckNotification            = 2
+)
@@ -0,0 +1,78 @@
+package bacnet
+
+// ObjectType is the type of object in a device. These are 10 bits when they are encoded in an object
+// identifier, so they won't fit in a uint8. (BACnetObjectType in 21 of the spec)
+type ObjectType uint16
+
+// The values for ObjectType. We are explicit because these are transmitted.
+const (
+	ObjectTypeAnalogInput       ObjectType = 0
+	ObjectTypeAnalogOutput                 = 1
+	ObjectTypeAnalogValue                  = 2
+	ObjectTypeBinaryInput                  = 3
+	ObjectTypeBinaryOutput                 = 4
+	ObjectTypeBinaryValue                  = 5
+	ObjectTypeCalendar                     = 6
+	ObjectTypeCommand                      = 7
+	ObjectTypeDevice                       = 8
+	ObjectTypeEventEnrollment              = 9
+	ObjectTypeFile                         = 10
+	ObjectTypeGroup                        = 11
+	ObjectTypeLoop                         = 12
+	ObjectTypeMultiStateInput              = 13
+	ObjectTypeMultiStateOutput             = 14
+	ObjectTypeNotificationClass            = 15
+	ObjectTypeProgram                      = 16
+	ObjectTypeSchedule                     = 17
+	ObjectTypeAveraging                    = 18
+	ObjectTypeMultiStateValue              = 19
+	ObjectTypeTrendLog                     = 20
+)
+
+// PropertyIdentifier identifies a property of an object. (BACnetPropertyIdentifier in 21 of the spec)
+type PropertyIdentifier uint32
+
+// The values for PropertyIdentifier. There are hundreds of these, so these are only the ones that we use.
+// We are explicit because these are transmitted. All, Optional and Required aren't properties. They stand for
+// those properties of the object in ReadPropertyMultiple.
+const (
+	PropertyIdentifierAll                        PropertyIdentifier = 8
+	PropertyIdentifierAPDUTimeout                PropertyIdentifier = 11
+	PropertyIdentifierApplicationSoftwareVersion                    = 12
+	PropertyIdentifierCOVIncrement                                  = 22
+	PropertyIdentifierDaylightSavingsStatus                         = 24
+	PropertyIdentifierDescription                                   = 28
+	PropertyIdentifierDeviceAddressBinding                          = 30
+	PropertyIdentifierEventState                                    = 36
+	PropertyIdentifierFirmwareRevision                              = 44
+	PropertyIdentifierMaxAPDULengthAccepted                         = 62
+	PropertyIdentifierModelName                                     = 70
+	PropertyIdentifierNumberOfAPDURetries                           = 73
+	PropertyIdentifierObjectIdentifier                              = 75
+	PropertyIdentifierObjectList                                    = 76
+	PropertyIdentifierObjectName                                    = 77
+	PropertyIdentifierObjectType                                    = 79
+	PropertyIdentifierOptional                                      = 80
+	PropertyIdentifierOutOfService                                  = 81
+	PropertyIdentifierPresentValue                                  = 85
+	PropertyIdentifierPriorityArray                                 = 87
+	PropertyIdentifierProtocolVersion                               = 98
+	PropertyIdentifierRelinquishDefault                             = 104
+	PropertyIdentifierRequired                                      = 105
+	PropertyIdentifierSegmentationSupported                         = 107
+	PropertyIdentifierStatusFlags                                   = 111
+	PropertyIdentifierSystemStatus                                  = 112
+	PropertyIdentifierUnits                                         = 117
+	PropertyIdentifierUTCOffset                                     = 119
+	PropertyIdentifierVendorIdentifier                              = 120
+	PropertyIdentifierVendorName                                    = 121
+	PropertyIdentifierProtocolRevision                              = 139
+	PropertyIdentifierDatabaseRevision                              = 155
+)
+
+// ObjectID identifies an object within a device. Only the device object's ID needs to be unique across the
+// network.
+type ObjectID struct {
+	Type     ObjectType
+	Instance uint32
+}
@@ -0,0 +1,53 @@
+package device
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/shigmas/modore/internal/apdu"
+)
+
+// COVChanged checks if the value changed enough since the last notification to send another one (13.1 in
+// the spec). Reals and unsigned values must change by at least the COV increment, and the other types, like
+// enumerated, notify on any change. If the values aren't the same type, it changed. A nil value, like the
+// last value before the first notification, is always a change.
+func COVChanged(last, current apdu.TagType, increment float32) (bool, error) {
+	if last == nil || current == nil {
+		return true, nil
+	}
+	switch lastValue := last.(type) {
+	case *apdu.ApplicationRealType:
+		if currentValue, ok := current.(*apdu.ApplicationRealType); ok {
+			return changedByIncrement(float64(lastValue.Value()), float64(currentValue.Value()), increment), nil
+		}
+	case *apdu.ApplicationUnsignedIntType:
+		if currentValue, ok := current.(*apdu.ApplicationUnsignedIntType); ok {
+			return changedByIncrement(float64(lastValue.Value()), float64(currentValue.Value()), increment), nil
+		}
+	case *apdu.ApplicationEnumeratedType:
+		if currentValue, ok := current.(*apdu.ApplicationEnumeratedType); ok {
+			return lastValue.Value() != currentValue.Value(), nil
+		}
+	default:
+		lastBytes, err := last.EncodeAsTagData(last.Class())
+		if err != nil {
+			return false, err
+		}
+		currentBytes, err := current.EncodeAsTagData(current.Class())
+		if err != nil {
+			return false, err
+		}
+		return !bytes.Equal(lastBytes, currentBytes), nil
+	}
+	return true, nil
+}
+
+// changedByIncrement checks if the difference is at least the increment. With no increment, any change is
+// enough.
+func changedByIncrement(last, current float64, increment float32) bool {
+	diff := math.Abs(current - last)
+	if increment <= 0 {
+		return diff != 0
+	}
+	return diff >= float64(increment)
+}
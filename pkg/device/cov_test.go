@@ -0,0 +1,63 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+)
+
+func TestCOVChanged(t *testing.T) {
+	realValue := func(val float32) apdu.TagType {
+		tag, _ := apdu.NewApplicationReal(val)
+		return tag
+	}
+	unsignedValue := func(val uint) apdu.TagType {
+		tag, _ := apdu.NewApplicationUnsignedInt(val)
+		return tag
+	}
+	enumeratedValue := func(val uint) apdu.TagType {
+		tag, _ := apdu.NewApplicationEnumerated(val)
+		return tag
+	}
+	testCases := []struct {
+		name      string
+		last      apdu.TagType
+		current   apdu.TagType
+		increment float32
+		expected  bool
+	}{
+		{"real below increment", realValue(72.0), realValue(72.4), 0.5, false},
+		{"real decrease below increment", realValue(72.0), realValue(71.6), 0.5, false},
+		{"real at increment", realValue(72.0), realValue(72.5), 0.5, true},
+		{"real above increment", realValue(72.0), realValue(71.0), 0.5, true},
+		{"real no increment", realValue(72.0), realValue(72.1), 0, true},
+		{"real unchanged", realValue(72.0), realValue(72.0), 0, false},
+		{"unsigned below increment", unsignedValue(100), unsignedValue(104), 5, false},
+		{"unsigned decrease above increment", unsignedValue(100), unsignedValue(90), 5, true},
+		{"enumerated changed", enumeratedValue(0), enumeratedValue(1), 5, true},
+		{"enumerated unchanged", enumeratedValue(1), enumeratedValue(1), 0, false},
+		{"type changed", enumeratedValue(1), unsignedValue(1), 5, true},
+		{"first notification", nil, realValue(72.0), 0.5, true},
+		{"no current value", realValue(72.0), nil, 0.5, true},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			changed, err := COVChanged(tCase.last, tCase.current, tCase.increment)
+			assert.NoError(t, err, "Unexpected error")
+			assert.Equal(t, tCase.expected, changed, "Unexpected change")
+		})
+	}
+
+	t.Run("other types", func(t *testing.T) {
+		on, _ := apdu.NewApplicationBool(true)
+		off, _ := apdu.NewApplicationBool(false)
+		changed, err := COVChanged(on, off, 1)
+		assert.NoError(t, err, "Unexpected error")
+		assert.True(t, changed, "Any change should notify")
+		changed, err = COVChanged(on, on, 1)
+		assert.NoError(t, err, "Unexpected error")
+		assert.False(t, changed, "No change should not notify")
+	})
+}
@@ -0,0 +1,111 @@
+package device
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+var (
+	// ErrObjectExists is returned when an object with the ID is already in the database
+	ErrObjectExists = errors.New("object already exists")
+	// ErrObjectNotFound is returned when there is no object with the ID to update
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrDuplicateName is returned when an object name is already used. Names must be unique within a
+	// device (12.1.5 in the spec)
+	ErrDuplicateName = errors.New("object name already exists in the device")
+)
+
+type (
+	// Object is an object in the device. Alarm is the intrinsic reporting of an analog object, if it has it.
+	Object struct {
+		ID    bacnet.ObjectID
+		Name  string
+		Alarm *AnalogAlarm
+	}
+
+	// Database holds the objects of a device. Objects are keyed by their ObjectID, but can also be looked up
+	// by their name, since WhoHas and many clients use the name.
+	Database struct {
+		mux     sync.RWMutex
+		objects map[bacnet.ObjectID]*Object
+		names   map[string]bacnet.ObjectID
+	}
+)
+
+// NewDatabase creates an empty object database
+func NewDatabase() *Database {
+	return &Database{
+		objects: make(map[bacnet.ObjectID]*Object),
+		names:   make(map[string]bacnet.ObjectID),
+	}
+}
+
+// Add adds a new object to the database.
+func (d *Database) Add(obj *Object) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if _, ok := d.objects[obj.ID]; ok {
+		return ErrObjectExists
+	}
+	if _, ok := d.names[obj.Name]; ok {
+		return ErrDuplicateName
+	}
+	d.objects[obj.ID] = obj
+	d.names[obj.Name] = obj.ID
+	return nil
+}
+
+// Update replaces an existing object, which may have been renamed.
+func (d *Database) Update(obj *Object) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	existing, ok := d.objects[obj.ID]
+	if !ok {
+		return ErrObjectNotFound
+	}
+	if id, ok := d.names[obj.Name]; ok && id != obj.ID {
+		return ErrDuplicateName
+	}
+	delete(d.names, existing.Name)
+	d.objects[obj.ID] = obj
+	d.names[obj.Name] = obj.ID
+	return nil
+}
+
+// Remove removes the object from the database, if it exists.
+func (d *Database) Remove(id bacnet.ObjectID) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if existing, ok := d.objects[id]; ok {
+		delete(d.names, existing.Name)
+		delete(d.objects, id)
+	}
+}
+
+// Get returns a copy of the object with the ID, so renaming it doesn't break the name index. Changes must
+// go through Update. The Alarm isn't copied, so its event state is still tracked with UpdateAlarm.
+func (d *Database) Get(id bacnet.ObjectID) (*Object, bool) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	obj, ok := d.objects[id]
+	if !ok {
+		return nil, false
+	}
+	objCopy := *obj
+	return &objCopy, true
+}
+
+// FindByName returns the ID of the object with the name
+func (d *Database) FindByName(name string) (bacnet.ObjectID, bool) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+
+	id, ok := d.names[name]
+	return id, ok
+}
@@ -0,0 +1,60 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestDatabaseNames(t *testing.T) {
+	deviceID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	inputID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}
+
+	db := NewDatabase()
+	assert.NoError(t, db.Add(&Object{ID: deviceID, Name: "Controller"}), "Unexpected error adding device")
+	assert.NoError(t, db.Add(&Object{ID: inputID, Name: "Zone Temp"}), "Unexpected error adding input")
+
+	t.Run("lookup", func(t *testing.T) {
+		id, ok := db.FindByName("Zone Temp")
+		assert.True(t, ok, "Name not found")
+		assert.Equal(t, inputID, id, "Unexpected object for name")
+		_, ok = db.FindByName("Outside Air")
+		assert.False(t, ok, "Unexpectedly found name")
+	})
+
+	t.Run("duplicate rejected", func(t *testing.T) {
+		dupID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 2}
+		assert.Equal(t, ErrDuplicateName, db.Add(&Object{ID: dupID, Name: "Zone Temp"}),
+			"Expected duplicate name error")
+		_, ok := db.Get(dupID)
+		assert.False(t, ok, "Rejected object was added")
+		assert.Equal(t, ErrDuplicateName, db.Update(&Object{ID: deviceID, Name: "Zone Temp"}),
+			"Expected duplicate name error on update")
+	})
+
+	t.Run("get returns a copy", func(t *testing.T) {
+		obj, ok := db.Get(inputID)
+		assert.True(t, ok, "Object not found")
+		obj.Name = "Renamed"
+		stored, _ := db.Get(inputID)
+		assert.Equal(t, "Zone Temp", stored.Name, "Renaming the copy changed the stored object")
+		id, ok := db.FindByName("Zone Temp")
+		assert.True(t, ok, "Name index changed")
+		assert.Equal(t, inputID, id, "Unexpected object for name")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		assert.NoError(t, db.Update(&Object{ID: inputID, Name: "Room Temp"}), "Unexpected error renaming")
+		_, ok := db.FindByName("Zone Temp")
+		assert.False(t, ok, "Old name still indexed")
+		id, ok := db.FindByName("Room Temp")
+		assert.True(t, ok, "New name not indexed")
+		assert.Equal(t, inputID, id, "Unexpected object for name")
+
+		db.Remove(inputID)
+		_, ok = db.FindByName("Room Temp")
+		assert.False(t, ok, "Removed object still indexed")
+	})
+}
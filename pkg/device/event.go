@@ -0,0 +1,154 @@
+package device
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// EventTransitions are the flags for the transitions of the event state, which is how Event_Enable and
+// Ack_Required select the transitions that they apply to.
+type EventTransitions uint8
+
+// The values for EventTransitions
+const (
+	EventTransitionToOffnormal EventTransitions = 0x01
+	EventTransitionToFault                      = 0x02
+	EventTransitionToNormal                     = 0x04
+)
+
+type (
+	// AnalogAlarm is the intrinsic reporting of an analog object, with the out of range algorithm (13.3.6 in
+	// the spec). Once the value is past an enabled limit, it has to come back inside it by the deadband to
+	// return to normal. We don't have time delays, so the transitions happen on the value that crosses the
+	// limit.
+	AnalogAlarm struct {
+		HighLimit       float32
+		LowLimit        float32
+		Deadband        float32
+		HighLimitEnable bool
+		LowLimitEnable  bool
+		// EventEnable are the transitions that generate notifications. The event state changes either way.
+		EventEnable       EventTransitions
+		AckRequired       EventTransitions
+		NotificationClass uint
+		Priority          uint
+		NotifyType        bacnet.NotifyType
+
+		mux   sync.Mutex
+		state bacnet.EventState
+	}
+)
+
+// EventState returns the current event state, which starts as normal.
+func (a *AnalogAlarm) EventState() bacnet.EventState {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.state
+}
+
+// UpdateAlarm evaluates the new present value of the object with its alarm. If the event state changed, and
+// the transition is enabled, it returns the notification for it, from the device. The process ID is left for
+// the sender to fill in for each recipient. Otherwise, including when the object doesn't have an alarm, it
+// returns nil.
+func (o *Object) UpdateAlarm(device bacnet.ObjectID, value float32) *apdu.EventNotification {
+	if o.Alarm == nil {
+		return nil
+	}
+	from, to := o.Alarm.evaluate(value)
+	return o.Alarm.notification(device, o.ID, from, to)
+}
+
+// SetAlarmFault sets or clears the fault of the object, for when its value isn't reliable. The alarm stays in
+// fault until it's cleared, and then returns to normal. It returns the notification like UpdateAlarm.
+func (o *Object) SetAlarmFault(device bacnet.ObjectID, fault bool) *apdu.EventNotification {
+	if o.Alarm == nil {
+		return nil
+	}
+	from, to := o.Alarm.setFault(fault)
+	return o.Alarm.notification(device, o.ID, from, to)
+}
+
+// evaluate moves to the event state for the value, and returns the previous and new states.
+func (a *AnalogAlarm) evaluate(value float32) (bacnet.EventState, bacnet.EventState) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	from := a.state
+	switch a.state {
+	case bacnet.EventStateFault:
+		// Only clearing the fault leaves the fault state
+	case bacnet.EventStateHighLimit:
+		if a.LowLimitEnable && value < a.LowLimit {
+			a.state = bacnet.EventStateLowLimit
+		} else if !a.HighLimitEnable || value < a.HighLimit-a.Deadband {
+			a.state = bacnet.EventStateNormal
+		}
+	case bacnet.EventStateLowLimit:
+		if a.HighLimitEnable && value > a.HighLimit {
+			a.state = bacnet.EventStateHighLimit
+		} else if !a.LowLimitEnable || value > a.LowLimit+a.Deadband {
+			a.state = bacnet.EventStateNormal
+		}
+	default:
+		if a.HighLimitEnable && value > a.HighLimit {
+			a.state = bacnet.EventStateHighLimit
+		} else if a.LowLimitEnable && value < a.LowLimit {
+			a.state = bacnet.EventStateLowLimit
+		}
+	}
+	return from, a.state
+}
+
+// setFault moves to or out of the fault state, and returns the previous and new states.
+func (a *AnalogAlarm) setFault(fault bool) (bacnet.EventState, bacnet.EventState) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	from := a.state
+	if fault {
+		a.state = bacnet.EventStateFault
+	} else if a.state == bacnet.EventStateFault {
+		a.state = bacnet.EventStateNormal
+	}
+	return from, a.state
+}
+
+// notification creates the notification for the transition, or returns nil if the state didn't change or the
+// transition isn't enabled.
+func (a *AnalogAlarm) notification(device, object bacnet.ObjectID,
+	from, to bacnet.EventState) *apdu.EventNotification {
+	if from == to {
+		return nil
+	}
+	transition := transitionTo(to)
+	if a.EventEnable&transition == 0 {
+		return nil
+	}
+	return &apdu.EventNotification{
+		InitiatingDevice:  device,
+		EventObject:       object,
+		TimeStamp:         bacnet.TimeFromTime(time.Now()),
+		NotificationClass: a.NotificationClass,
+		Priority:          a.Priority,
+		EventType:         bacnet.EventTypeOutOfRange,
+		NotifyType:        a.NotifyType,
+		AckRequired:       a.AckRequired&transition != 0,
+		FromState:         from,
+		ToState:           to,
+	}
+}
+
+// transitionTo gets the transition for the new event state.
+func transitionTo(state bacnet.EventState) EventTransitions {
+	switch state {
+	case bacnet.EventStateNormal:
+		return EventTransitionToNormal
+	case bacnet.EventStateFault:
+		return EventTransitionToFault
+	default:
+		return EventTransitionToOffnormal
+	}
+}
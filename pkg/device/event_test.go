@@ -0,0 +1,110 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestAnalogAlarm(t *testing.T) {
+	deviceID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	newInput := func() *Object {
+		return &Object{
+			ID:   bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+			Name: "Zone Temp",
+			Alarm: &AnalogAlarm{
+				HighLimit:       80,
+				LowLimit:        60,
+				Deadband:        2,
+				HighLimitEnable: true,
+				LowLimitEnable:  true,
+				EventEnable: EventTransitionToOffnormal | EventTransitionToFault |
+					EventTransitionToNormal,
+				AckRequired:       EventTransitionToOffnormal,
+				NotificationClass: 3,
+				Priority:          100,
+			},
+		}
+	}
+
+	t.Run("high limit and deadband", func(t *testing.T) {
+		input := newInput()
+		assert.Nil(t, input.UpdateAlarm(deviceID, 79), "Value within the limits should not notify")
+
+		notification := input.UpdateAlarm(deviceID, 81)
+		if assert.NotNil(t, notification, "Value past the high limit should notify") {
+			assert.Equal(t, deviceID, notification.InitiatingDevice, "Unexpected device")
+			assert.Equal(t, input.ID, notification.EventObject, "Unexpected object")
+			assert.Equal(t, bacnet.EventType(bacnet.EventTypeOutOfRange), notification.EventType,
+				"Unexpected event type")
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateNormal), notification.FromState,
+				"Unexpected from state")
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateHighLimit), notification.ToState,
+				"Unexpected to state")
+			assert.True(t, notification.ToState.IsOffnormal(), "High limit should be offnormal")
+			assert.True(t, notification.AckRequired, "To offnormal should require an ack")
+			assert.Equal(t, uint(3), notification.NotificationClass, "Unexpected notification class")
+			assert.Equal(t, uint(100), notification.Priority, "Unexpected priority")
+		}
+		assert.Nil(t, input.UpdateAlarm(deviceID, 85), "Staying past the limit should not notify again")
+		assert.Nil(t, input.UpdateAlarm(deviceID, 79), "Value within the deadband should not return to normal")
+		assert.Equal(t, bacnet.EventState(bacnet.EventStateHighLimit), input.Alarm.EventState(),
+			"Should still be at the high limit")
+
+		notification = input.UpdateAlarm(deviceID, 77.5)
+		if assert.NotNil(t, notification, "Value past the deadband should notify") {
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateHighLimit), notification.FromState,
+				"Unexpected from state")
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateNormal), notification.ToState,
+				"Unexpected to state")
+			assert.False(t, notification.AckRequired, "To normal should not require an ack")
+		}
+	})
+
+	t.Run("low limit", func(t *testing.T) {
+		input := newInput()
+		notification := input.UpdateAlarm(deviceID, 59)
+		if assert.NotNil(t, notification, "Value past the low limit should notify") {
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateLowLimit), notification.ToState,
+				"Unexpected to state")
+		}
+		notification = input.UpdateAlarm(deviceID, 81)
+		if assert.NotNil(t, notification, "Value past the high limit should notify") {
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateLowLimit), notification.FromState,
+				"Unexpected from state")
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateHighLimit), notification.ToState,
+				"Unexpected to state")
+		}
+	})
+
+	t.Run("fault", func(t *testing.T) {
+		input := newInput()
+		notification := input.SetAlarmFault(deviceID, true)
+		if assert.NotNil(t, notification, "Fault should notify") {
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateFault), notification.ToState,
+				"Unexpected to state")
+		}
+		assert.Nil(t, input.UpdateAlarm(deviceID, 90), "Value should be ignored in fault")
+		notification = input.SetAlarmFault(deviceID, false)
+		if assert.NotNil(t, notification, "Clearing the fault should notify") {
+			assert.Equal(t, bacnet.EventState(bacnet.EventStateNormal), notification.ToState,
+				"Unexpected to state")
+		}
+	})
+
+	t.Run("transition not enabled", func(t *testing.T) {
+		input := newInput()
+		input.Alarm.EventEnable = EventTransitionToOffnormal
+		assert.NotNil(t, input.UpdateAlarm(deviceID, 81), "To offnormal is enabled")
+		assert.Nil(t, input.UpdateAlarm(deviceID, 70), "To normal is not enabled")
+		assert.Equal(t, bacnet.EventState(bacnet.EventStateNormal), input.Alarm.EventState(),
+			"State should change without the notification")
+	})
+
+	t.Run("no alarm", func(t *testing.T) {
+		input := &Object{ID: bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 2}, Name: "Other"}
+		assert.Nil(t, input.UpdateAlarm(deviceID, 1000), "Object without an alarm should not notify")
+	})
+}
@@ -2,9 +2,14 @@ package transport
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 
 	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 // BVLC is the BACnet Virtual Link Layer. It allows us to talk to any type of network. But, really,
@@ -30,6 +35,8 @@ const (
 	BVLCType = 0x81
 	// Header is 4 bytes
 	BVLCHeaderLength = 4
+	// BVLCMaxLength is the longest frame, including the header, that fits in the length field
+	BVLCMaxLength = 0xFFFF
 )
 
 // BVLCFunction is something that I need to research
@@ -42,16 +49,30 @@ const (
 	BVLCFunctioncBroadcastDistributionTable                   = 2
 	BVLCFunctioncBroadcastDistributionTableAck                = 3
 	BVLCFunctioncForwardedNPDU                                = 4
+	BVLCFunctioncRegisterForeignDevice                        = 5
+	BVLCFunctioncReadForeignDeviceTable                       = 6
+	BVLCFunctioncReadForeignDeviceTableAck                    = 7
+	BVLCFunctioncDeleteForeignDeviceTableEntry                = 8
+	BVLCFunctioncDistributeBroadcastToNetwork                 = 9
 	BVLCFunctioncUnicast                                      = 10
 	BVLCFunctioncBroadcast                                    = 11
 )
 
 type (
+	// StreamResult is one decoded message, or the error that ended the stream.
+	StreamResult struct {
+		Message *BVLCMessage
+		Err     error
+	}
+
 	// BVLCMessage has 4 pieces, only two of which are settable:
 	// Type: There is // Length is also sent, but we will calculate it from the data.
 	BVLCMessage struct {
 		Function BVLCFunction
 		Data     []byte
+		// Sender is the address that the message was received from, so it can be answered. It's nil for
+		// the messages that we create, and isn't encoded.
+		Sender *net.UDPAddr
 	}
 )
 
@@ -70,6 +91,11 @@ func verifyFunction(maybe byte) bool {
 		val == BVLCFunctioncBroadcastDistributionTable ||
 		val == BVLCFunctioncBroadcastDistributionTableAck ||
 		val == BVLCFunctioncForwardedNPDU ||
+		val == BVLCFunctioncRegisterForeignDevice ||
+		val == BVLCFunctioncReadForeignDeviceTable ||
+		val == BVLCFunctioncReadForeignDeviceTableAck ||
+		val == BVLCFunctioncDeleteForeignDeviceTableEntry ||
+		val == BVLCFunctioncDistributeBroadcastToNetwork ||
 		val == BVLCFunctioncUnicast ||
 		val == BVLCFunctioncBroadcast
 
@@ -117,8 +143,13 @@ func NewBVLCMessageFromBytes(encoded []byte) (*BVLCMessage, error) {
 	}, nil
 }
 
-// BVLCEncode encodes a BVLCMessage
-func (m *BVLCMessage) Encode() []byte {
+// BVLCEncode encodes a BVLCMessage. The length in the header is 2 bytes, so the data can only be up to
+// 65531 bytes.
+func (m *BVLCMessage) Encode() ([]byte, error) {
+	if len(m.Data)+BVLCHeaderLength > BVLCMaxLength {
+		return nil, fmt.Errorf("BVLC frame of %d bytes is longer than %d: %w", len(m.Data)+BVLCHeaderLength,
+			BVLCMaxLength, bacnet.ErrValueTooLarge)
+	}
 	buf := bytes.NewBuffer(make([]byte, 0, 4))
 
 	// all bytes, or uint8, except for length, so endian only matters for that.
@@ -129,7 +160,69 @@ func (m *BVLCMessage) Encode() []byte {
 	buf.Write(apdu.EncodeUint((uint)(len(m.Data)+4), 2))
 	buf.Write(m.Data)
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 // BVLCDecode decodes a BVLCMessage
+
+// DecodeStream decodes the BVLC messages from a stream of back to back frames, like from a file or TCP. Each
+// frame is read using the length in its header. The channel is closed at the end of the stream, after
+// an error, or when the context is done. The end of the stream is only an error if it's in the middle of a
+// frame. A read can't be interrupted, so when the context is done, the reader is closed if it's an io.Closer.
+// Otherwise, the caller must stop the reader, or the decoder waits for it before closing the channel.
+func DecodeStream(ctx context.Context, r io.Reader) <-chan StreamResult {
+	ch := make(chan StreamResult)
+	finished := make(chan struct{})
+	if closer, ok := r.(io.Closer); ok {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = closer.Close()
+			case <-finished:
+			}
+		}()
+	}
+	go func() {
+		defer close(ch)
+		defer close(finished)
+		for {
+			msg, err := readBVLCFrame(r)
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return
+			}
+			select {
+			case ch <- StreamResult{Message: msg, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// readBVLCFrame reads exactly one frame. io.EOF is only returned if there was nothing to read.
+func readBVLCFrame(r io.Reader) (*BVLCMessage, error) {
+	header := make([]byte, BVLCHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("incomplete BVLC header: %w", err)
+		}
+		return nil, err
+	}
+	msgLength := apdu.DecodeUint(header[2:])
+	if msgLength < BVLCHeaderLength {
+		return nil, fmt.Errorf("BVLC length %d is shorter than the header", msgLength)
+	}
+	frame := make([]byte, msgLength)
+	copy(frame, header)
+	if _, err := io.ReadFull(r, frame[BVLCHeaderLength:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("incomplete BVLC data: %w", err)
+	}
+	return NewBVLCMessageFromBytes(frame)
+}
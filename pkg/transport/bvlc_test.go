@@ -1,9 +1,13 @@
 package transport
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -21,7 +25,8 @@ func TestBVLCCoding(t *testing.T) {
 	npduEncoded, err := npduMsg.Encode()
 	assert.NoError(t, err, "Unable to create NPDU message")
 	bvlcMsg := NewBVLCMessage(BVLCFunctioncBroadcast, npduEncoded)
-	bvlcEncoded := bvlcMsg.Encode()
+	bvlcEncoded, err := bvlcMsg.Encode()
+	assert.NoError(t, err, "Unable to encode BVLC message")
 	assert.True(t, reflect.DeepEqual(expectedBytes, bvlcEncoded), "Encoding does not match expected")
 
 	decodedMsg, err := NewBVLCMessageFromBytes(bvlcEncoded)
@@ -30,6 +35,27 @@ func TestBVLCCoding(t *testing.T) {
 	assert.True(t, reflect.DeepEqual(npduEncoded, decodedMsg.Data), "Decoded message contents do not match")
 }
 
+func TestBVLCMaxLength(t *testing.T) {
+	testCases := []struct {
+		name        string
+		dataLength  int
+		expectedErr error
+	}{
+		{"max", BVLCMaxLength - BVLCHeaderLength, nil},
+		{"too long", BVLCMaxLength - BVLCHeaderLength + 1, bacnet.ErrValueTooLarge},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			encoded, err := NewBVLCMessage(BVLCFunctioncUnicast, make([]byte, tCase.dataLength)).Encode()
+			assert.ErrorIs(t, err, tCase.expectedErr, "Unexpected error encoding")
+			if tCase.expectedErr == nil {
+				assert.Equal(t, []byte{0xFF, 0xFF}, encoded[2:4], "Unexpected length")
+				assert.Len(t, encoded, BVLCMaxLength, "Unexpected frame length")
+			}
+		})
+	}
+}
+
 func TestBVLCDecoding(t *testing.T) {
 	// Make sure we can handle more messages (that maybe we can't handle)
 	testCases := []struct {
@@ -50,10 +76,75 @@ func TestBVLCDecoding(t *testing.T) {
 			// BVLC should contain valid data (at least this does), so decode the contents too.
 			npduMsg, err := npdu.NewMessageFromBytes(decodedMsg.Data)
 			if tCase.expectedError != nil {
-				assert.Equal(t, tCase.expectedError, err, "Error does not match")
+				assert.ErrorIs(t, err, tCase.expectedError, "Error does not match")
 			} else {
 				fmt.Printf("type: %v\n", npduMsg.MessageType)
 			}
 		})
 	}
 }
+
+// A global WhoIs is the smallest datagram that we get, since the APDU is only the type and service.
+func TestTwoByteWhoIsDatagram(t *testing.T) {
+	decodedMsg, err := NewBVLCMessageFromBytes([]byte{0x81, 0x0B, 0x00, 0x08, 0x01, 0x00, 0x10, 0x08})
+	assert.NoError(t, err, "Unexpected error decoding BVLC")
+	npduMsg, err := npdu.NewMessageFromBytes(decodedMsg.Data)
+	assert.NoError(t, err, "Unexpected error decoding NPDU")
+	msg, ok := npduMsg.APDU.(*apdu.UnconfirmedMessage)
+	assert.True(t, ok, "Unexpected APDU type")
+	assert.Equal(t, apdu.ServiceUnconfirmed(apdu.ServiceUnconfirmedWhoIs), msg.ServiceID, "Unexpected service")
+	assert.Empty(t, msg.ServiceData, "Global WhoIs should not have parameters")
+	whoIs, err := apdu.NewWhoIsFromMessage(msg)
+	assert.NoError(t, err, "Unexpected error getting the range")
+	assert.Equal(t, &apdu.WhoIs{Low: 0, High: apdu.MaxInstanceNumber}, whoIs, "Should be all devices")
+}
+
+func TestDecodeStream(t *testing.T) {
+	var frames []*BVLCMessage
+	var stream []byte
+	for i := uint(0); i < 3; i++ {
+		whoIs, err := apdu.NewWhoisMessage(i, i+100)
+		assert.NoError(t, err, "Unable to create WhoIs message")
+		npduEncoded, err := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount,
+			npdu.NetworkLayerWhoIsMessage, nil, whoIs).Encode()
+		assert.NoError(t, err, "Unable to create NPDU message")
+		frame := NewBVLCMessage(BVLCFunctioncBroadcast, npduEncoded)
+		frames = append(frames, frame)
+		encoded, err := frame.Encode()
+		assert.NoError(t, err, "Unable to encode BVLC message")
+		stream = append(stream, encoded...)
+	}
+
+	t.Run("three frames", func(t *testing.T) {
+		var decoded []*BVLCMessage
+		for result := range DecodeStream(context.Background(), bytes.NewReader(stream)) {
+			assert.NoError(t, result.Err, "Unexpected error decoding stream")
+			decoded = append(decoded, result.Message)
+		}
+		assert.Equal(t, frames, decoded, "Decoded frames do not match")
+	})
+
+	t.Run("truncated frame", func(t *testing.T) {
+		var results []StreamResult
+		for result := range DecodeStream(context.Background(), bytes.NewReader(stream[:len(stream)-2])) {
+			results = append(results, result)
+		}
+		assert.Equal(t, 3, len(results), "Unexpected number of results")
+		assert.ErrorIs(t, results[2].Err, io.ErrUnexpectedEOF, "Expected an error for the truncated frame")
+	})
+
+	t.Run("cancelled while reading", func(t *testing.T) {
+		// Nothing is written, so the decoder blocks until the reader is closed
+		reader, writer := io.Pipe()
+		defer writer.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := DecodeStream(ctx, reader)
+		cancel()
+		select {
+		case result, ok := <-ch:
+			assert.False(t, ok, "Unexpected result %v", result)
+		case <-time.After(time.Second):
+			assert.Fail(t, "The channel wasn't closed")
+		}
+	})
+}
@@ -2,12 +2,15 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/shigmas/modore/internal/apdu"
 	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 // Just some experimentation
@@ -26,8 +29,18 @@ const (
 	DefaultPort = 0xBAC0
 	// DefaultHopCount is the max? number of hops?
 	DefaultHopCount uint8 = 0xFF
+	// DefaultSegmentWindow is the window size we propose for segmented messages. 1 works with every device,
+	// but it is slow.
+	DefaultSegmentWindow uint8 = 1
+	// MaxSegmentWindow is the largest window size that the spec allows (5.3)
+	MaxSegmentWindow uint8 = 127
 
 	udpNetwork = "udp4"
+
+	// LocalNetwork is the network number for our own network, so there is no DNET.
+	LocalNetwork uint16 = 0
+	// GlobalBroadcastNetwork is the DNET for a broadcast to all networks.
+	GlobalBroadcastNetwork uint16 = 0xFFFF
 )
 
 type (
@@ -41,9 +54,20 @@ type (
 		RegisterBVLCHandler(filter BVLCFunction, handler BVLCMessageHandler)
 		RegisterNPDUHandler(filter npdu.NetworkLayerMessageType, handler NPDUMessageHandler)
 		RegisterAPDUHandler(filter apdu.ServiceUnconfirmed, handler APDUMessageHandler)
+		// UnregisterAPDUHandler removes the handler from all of the filters that it was registered for.
+		UnregisterAPDUHandler(handler APDUMessageHandler)
+		// RegisterConfirmedAPDUHandler registers for the confirmed requests of the service, which are delivered as
+		// a *ConfirmedRequest.
+		RegisterConfirmedAPDUHandler(service apdu.ServiceConfirmed, handler APDUMessageHandler)
 		GetBVLCHandlers() map[uint8][]BVLCMessageHandler
 		GetNPDUHandlers() map[uint8][]NPDUMessageHandler
 		GetAPDUHandlers() map[uint8][]APDUMessageHandler
+		GetConfirmedAPDUHandlers() map[uint8][]APDUMessageHandler
+		// The Ordered functions return the handlers that match, in the order that they get messages.
+		OrderedBVLCHandlers(function BVLCFunction) []BVLCMessageHandler
+		OrderedNPDUHandlers() []NPDUMessageHandler
+		OrderedAPDUHandlers(service apdu.ServiceUnconfirmed) []APDUMessageHandler
+		OrderedConfirmedAPDUHandlers(service apdu.ServiceConfirmed) []APDUMessageHandler
 	}
 
 	// Connection is the interface for connection to BACnet
@@ -57,21 +81,127 @@ type (
 		SourceAddress() *npdu.Address
 		BroadcastAddress() *npdu.Address
 		DestinationAddress(dest net.IP) *npdu.Address
+		// LocalIP and BroadcastIP are the IPs that the addresses are made from
+		LocalIP() net.IP
+		BroadcastIP() net.IP
+		// LocalAddr is the address that the connection is bound to, which has the port that was chosen when
+		// it was bound to port 0.
+		LocalAddr() net.Addr
 		// These will change in the future, I think
-		SendConfirmedMessage(priority npdu.NetworkMessagePriority, msgType npdu.NetworkLayerMessageType,
-			msg *apdu.ConfirmedMessage) error
+		SendConfirmedMessage(dest net.IP, priority npdu.NetworkMessagePriority,
+			msgType npdu.NetworkLayerMessageType, msg *apdu.ConfirmedMessage) error
 		SendUnconfirmedMessage(destination *npdu.Address, priority npdu.NetworkMessagePriority,
 			msgType npdu.NetworkLayerMessageType, msg *apdu.UnconfirmedMessage) error
+		// DistributeUnconfirmedMessage sends the message to the BBMD as a Forwarded-NPDU, so it is broadcast on
+		// the BBMD's network without registering as a foreign device.
+		DistributeUnconfirmedMessage(bbmd net.IP, priority npdu.NetworkMessagePriority,
+			msgType npdu.NetworkLayerMessageType, msg *apdu.UnconfirmedMessage) error
+		// SendResponse sends the response to a confirmed request back to the device at dest.
+		SendResponse(dest net.IP, msg apdu.Message) error
+		// SendAndReceive sends the confirmed request and waits for the response to it. It's sent again
+		// without a response, like the RetryStrategy of the connection says, and fails with ErrNoResponse
+		// after the last attempt, even if the context allows longer.
+		SendAndReceive(ctx context.Context, dest net.IP, msg *apdu.ConfirmedMessage) (apdu.Message, error)
+		// Ping checks that the device with the instance at dest is reachable, and returns the round trip time.
+		// The connection must be started.
+		Ping(ctx context.Context, dest net.IP, deviceInstance uint) (time.Duration, error)
+		// Watch gives the received messages that match to the msgs channel, which holds size of them before
+		// more are dropped. done is closed when the connection is stopped or closed, and cancel stops watching.
+		Watch(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int) (msgs <-chan apdu.Message,
+			done <-chan struct{}, cancel func())
+		// Drain discards the received messages that haven't been handled yet, and the messages collected
+		// for a WhoIs in progress, so they don't leak into the next operation. The responses to requests in
+		// progress, like SendAndReceive and Ping, and the messages of Watch are kept, but a message that
+		// hadn't been handled yet is lost, even if it was one of those.
+		Drain()
+		// WhoIs broadcasts a WhoIs for the device instance range, and collects the IAms until the context is
+		// done. The connection must be started.
+		WhoIs(ctx context.Context, low, high uint) ([]apdu.IAm, error)
+		// ReadObjectList reads the object list of the device. The connection must be started.
+		ReadObjectList(ctx context.Context, dest net.IP, deviceInstance uint) ([]bacnet.ObjectID, error)
+		// ReadProperties reads the properties with ReadPropertyMultiple, or ReadProperty if the device doesn't
+		// support it. The connection must be started.
+		ReadProperties(ctx context.Context, dest net.IP, specs []apdu.ReadAccessSpec) ([]apdu.ReadAccessResult,
+			error)
 	}
 
 	connection struct {
 		wg           sync.WaitGroup
 		stopFunction func()
+		dataChannel  chan incomingData
+		dataMux      sync.Mutex // Drain can be called while the connection is starting
 		ip4Addr      net.IP
 		mask         uint16
-		bacnetConn   *net.UDPConn // BACnet is UDP, so this is "the" connection
+		bacnetConn   PacketConn // BACnet is UDP, so this is "the" connection
+		bindAddr     *net.UDPAddr
+		port         uint16
 		broadcastIP  net.IP
 		router       MessageRouter
+		routerMux    sync.RWMutex
+		waiters      []*responseWaiter
+		waitersMux   sync.Mutex
+
+		// segmentation settings for confirmed requests and their acks
+		segmentWindow uint8
+		maxSegments   uint8
+
+		// sourceNetwork is the network number that we are on, for the source specifier of the NPDUs that we
+		// send. It's 0 unless we are reachable through a router from other networks, like a gateway.
+		sourceNetwork uint16
+		sourceMAC     []byte
+
+		// duplicates is nil unless duplicate datagrams are dropped
+		duplicates *duplicateFilter
+		// filterSelf drops the datagrams from our own address, like the copies of our broadcasts
+		filterSelf bool
+
+		// when to send confirmed requests again without a response
+		retry RetryStrategy
+
+		// outstanding confirmed requests. pendingSlots is nil if there is no limit.
+		pendingSlots chan struct{}
+		invokeIDs    map[uint8]bool
+		nextInvokeID uint8
+		invokeIDMux  sync.Mutex
+
+		// the devices, by IP, that rejected ReadPropertyMultiple
+		noRPM    map[string]bool
+		noRPMMux sync.Mutex
+
+		// the max APDU lengths, by IP, from the IAms of the devices
+		peerMaxAPDU    map[string]apdu.MaxAPDULength
+		peerMaxAPDUMux sync.Mutex
+	}
+
+	// PacketConn is the packet I/O of the connection. *net.UDPConn is the default, but tests can use an
+	// in-memory implementation, like MemoryPacketConn, to avoid real sockets.
+	PacketConn interface {
+		ReadFrom(p []byte) (int, net.Addr, error)
+		WriteTo(p []byte, addr net.Addr) (int, error)
+		LocalAddr() net.Addr
+		Close() error
+	}
+
+	// ConnectionOption configures optional settings of the connection
+	ConnectionOption func(c *connection)
+
+	// responseWaiter is registered while we are waiting for a response to something that we sent. Waiters
+	// see every APDU message that comes in, and don't consume them, so the messages are still routed. closed
+	// is closed if the connection is stopped or closed while we are waiting.
+	responseWaiter struct {
+		matches func(sender *net.UDPAddr, msg apdu.Message) bool
+		ch      chan apdu.Message
+		closed  chan struct{}
+		// kept is set for the waiters that Drain doesn't empty: the responses to our requests, and Watch
+		kept bool
+	}
+
+	// BroadcastTarget is where to send a broadcast: the NPDU destination, and the BVLC function and IP for
+	// the B/IP frame.
+	BroadcastTarget struct {
+		Destination *npdu.Address
+		Function    BVLCFunction
+		IP          net.IP
 	}
 
 	incomingData struct {
@@ -83,9 +213,77 @@ type (
 
 var _ Connection = (*connection)(nil)
 
+// WithSegmentWindow sets the proposed window size for the segmented requests that we send, and the actual
+// window size in the segment acks for the segmented responses we receive. It must be from 1 to
+// MaxSegmentWindow.
+func WithSegmentWindow(size uint8) ConnectionOption {
+	return func(c *connection) {
+		c.segmentWindow = size
+	}
+}
+
+// WithMaxSegments sets the max segments accepted code (apdu.MaxSegments) for our confirmed requests. If it's
+// set, we also tell the device that we accept segmented responses. The code must fit in 3 bits.
+func WithMaxSegments(maxSegments uint8) ConnectionOption {
+	return func(c *connection) {
+		c.maxSegments = maxSegments
+	}
+}
+
+// WithPacketConn uses the PacketConn instead of listening on the UDP port. The connection owns it, so it
+// will be closed when the connection is closed.
+func WithPacketConn(conn PacketConn) ConnectionOption {
+	return func(c *connection) {
+		c.bacnetConn = conn
+	}
+}
+
+// WithPort uses a port other than DefaultPort, like the others in the 0xBAC0 to 0xBACF range that the spec
+// allows for separate B/IP networks on the same subnet. It's the port we listen on, unless WithBindAddress is
+// used, and the port in the addresses of what we send.
+func WithPort(port uint16) ConnectionOption {
+	return func(c *connection) {
+		c.port = port
+	}
+}
+
+// WithBindAddress listens on the address instead of the default, which is the BACnet port on all interfaces.
+// The IP/mask of the connection are still used for the source and broadcast addresses of what we send, so a
+// receiver with multiple interfaces can listen on all of them, but send from one.
+func WithBindAddress(addr *net.UDPAddr) ConnectionOption {
+	return func(c *connection) {
+		c.bindAddr = addr
+	}
+}
+
+// WithSelfFilter drops the datagrams that come from our own address. The socket gets a copy of each
+// broadcast that we send, so without it, we would handle our own WhoIs, for example. It's off by default,
+// since tests with a MemoryPacketConn talk to themselves.
+func WithSelfFilter() ConnectionOption {
+	return func(c *connection) {
+		c.filterSelf = true
+	}
+}
+
+// WithSourceNetwork sets the network number that we are on. The NPDUs that we send have it as the source
+// (SNET), with our B/IP address as the SADR, unless WithSourceMAC sets a different one, so the replies can be
+// routed back to us from other networks.
+func WithSourceNetwork(network uint16) ConnectionOption {
+	return func(c *connection) {
+		c.sourceNetwork = network
+	}
+}
+
+// WithSourceMAC sets the SADR in the source of the NPDUs that we send. It's only used with WithSourceNetwork.
+func WithSourceMAC(mac []byte) ConnectionOption {
+	return func(c *connection) {
+		c.sourceMAC = append([]byte{}, mac...)
+	}
+}
+
 // NewConnection creates a connection that will send and receive from the specified IP/mask. We'll need
 // a more flexible way that can take the *type/class* of interface
-func NewConnection(ip4Addr []byte, netMask uint16) (Connection, error) {
+func NewConnection(ip4Addr []byte, netMask uint16, opts ...ConnectionOption) (Connection, error) {
 	mask := net.CIDRMask((int)(netMask), 32)
 	ip := net.IP(ip4Addr)
 
@@ -94,42 +292,116 @@ func NewConnection(ip4Addr []byte, netMask uint16) (Connection, error) {
 		broadcast[i] = ip[i] | ^mask[i]
 	}
 
-	udp, err := net.ResolveUDPAddr(udpNetwork, fmt.Sprintf(":%d", DefaultPort))
-	if err != nil {
-		return nil, fmt.Errorf("unable to resolve UDP Address for port %d: %w", DefaultPort, err)
+	c := connection{
+		ip4Addr:       ip,
+		broadcastIP:   broadcast,
+		segmentWindow: DefaultSegmentWindow,
+		maxSegments:   uint8(apdu.MaxSegmentsUnspecified),
+		retry:         DefaultRetryStrategy(),
+		port:          DefaultPort,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if err := c.retry.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.validateSegmentation(); err != nil {
+		return nil, err
+	}
+	if c.bacnetConn != nil {
+		return &c, nil
+	}
+
+	udp := c.bindAddr
+	if udp == nil {
+		var err error
+		udp, err = net.ResolveUDPAddr(udpNetwork, fmt.Sprintf(":%d", c.port))
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve UDP Address for port %d: %w", c.port, err)
+		}
 	}
 	conn, err := net.ListenUDP("udp", udp)
 	if err != nil {
 		return nil, fmt.Errorf("unable to listen on UDP: %w", err)
 	}
-	return &connection{
-		bacnetConn:  conn,
-		broadcastIP: broadcast,
-	}, nil
+	c.bacnetConn = conn
+	return &c, nil
 }
 
+// SetMessageRouter sets the router for the messages that we receive. It can be changed while the connection
+// is started, and the next message goes to the new router.
 func (c *connection) SetMessageRouter(r MessageRouter) {
+	c.routerMux.Lock()
+	defer c.routerMux.Unlock()
 	c.router = r
 }
 
+func (c *connection) messageRouter() MessageRouter {
+	c.routerMux.RLock()
+	defer c.routerMux.RUnlock()
+	return c.router
+}
+
 func (c *connection) Start() {
 	ctx, stopFunc := context.WithCancel(context.Background())
 	dataChannel := make(chan incomingData, 1)
-	go c.startListener(dataChannel)
+	go c.startListener(ctx.Done(), dataChannel)
 	c.wg.Add(1)
 	go c.loopForever(ctx.Done(), dataChannel)
 	c.stopFunction = stopFunc
+	c.dataMux.Lock()
+	c.dataChannel = dataChannel
+	c.dataMux.Unlock()
+}
+
+// Drain discards the datagrams that the listener has received, but the loop hasn't handled, and the
+// messages that are buffered for the waiters that aren't kept. It doesn't block, so anything that arrives
+// after is kept.
+func (c *connection) Drain() {
+	c.dataMux.Lock()
+	dataChannel := c.dataChannel
+	c.dataMux.Unlock()
+	for drained := false; !drained; {
+		select {
+		case <-dataChannel:
+		default:
+			drained = true
+		}
+	}
+
+	c.waitersMux.Lock()
+	defer c.waitersMux.Unlock()
+	for _, w := range c.waiters {
+		if w.kept {
+			continue
+		}
+		for drained := false; !drained; {
+			select {
+			case <-w.ch:
+			default:
+				drained = true
+			}
+		}
+	}
 }
 
-// loop forever.
-func (c *connection) startListener(ch chan<- incomingData) {
+// loop forever, or at least until the connection is closed.
+func (c *connection) startListener(doneCh <-chan struct{}, ch chan<- incomingData) {
 	for {
 		b := make([]byte, 2048)
 		// this doesn't block, I guess. So, just loop. If we need to, we can add a pause, I guess.
-		i, adr, err := c.bacnetConn.ReadFromUDP(b)
+		i, addr, err := c.bacnetConn.ReadFrom(b)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		adr, _ := addr.(*net.UDPAddr)
 		if i > 0 {
-			fmt.Printf("Received %d bytes: %v\n", i, b[:i])
-			ch <- incomingData{err, adr, b[:i]}
+			select {
+			case ch <- incomingData{err, adr, b[:i]}:
+			case <-doneCh:
+				return
+			}
 		}
 	}
 }
@@ -140,15 +412,27 @@ func (c *connection) loopForever(doneCh <-chan struct{}, listenCh <-chan incomin
 		select {
 		case incoming := <-listenCh:
 			if incoming.err != nil {
-				fmt.Println("Received error: ", incoming.err)
+				apdu.Warnf("receive error: %v", incoming.err)
 			} else {
+				if c.filterSelf && c.isFromSelf(incoming.sender) {
+					continue
+				}
 				msg, err := NewBVLCMessageFromBytes(incoming.data)
 				if err != nil {
-					return
+					// Not BACnet, or garbled, so there's nothing to route
+					continue
+				}
+				if c.duplicates != nil && c.duplicates.isDuplicate(incoming.sender, msg) {
+					continue
+				}
+				msg.Sender = incoming.sender
+				c.offerToWaiters(incoming.sender, msg)
+				router := c.messageRouter()
+				if router == nil {
+					continue
 				}
-				fmt.Printf("msg function: %d\n", msg.Function)
-				if err = c.router.RouteMessage(msg); err != nil {
-					fmt.Printf("RouteMessage Error: %v\n", err)
+				if err = router.RouteMessage(msg); err != nil {
+					apdu.Warnf("unable to route message from %s: %v", incoming.sender, err)
 				}
 			}
 		case <-doneCh:
@@ -157,20 +441,25 @@ func (c *connection) loopForever(doneCh <-chan struct{}, listenCh <-chan incomin
 	}
 
 }
+
+// Stop stops receiving, so the requests that are waiting for a response return ErrConnectionClosed.
 func (c *connection) Stop() {
 	if c.stopFunction != nil {
 		c.stopFunction()
 		c.wg.Wait()
 	}
+	c.cancelWaiters()
 }
 
+// Close closes the packet connection. Like Stop, the requests that are waiting return ErrConnectionClosed.
 func (c *connection) Close() error {
+	c.cancelWaiters()
 	return c.bacnetConn.Close()
 }
 
 // SourceAddress converts from an IP to the npdu.Address type to be encoded.
 func (c *connection) SourceAddress() *npdu.Address {
-	addrBytes := append(c.ip4Addr, apdu.EncodeUint(DefaultPort, 2)...)
+	addrBytes := append(c.ip4Addr, apdu.EncodeUint(uint(c.port), 2)...)
 	return &npdu.Address{
 		Network: 0,
 		Length:  net.IPv4len + 2,
@@ -178,8 +467,38 @@ func (c *connection) SourceAddress() *npdu.Address {
 	}
 }
 
+// sourceSpecifier is the source of the NPDUs that we send. It's nil, so the source is absent, unless the
+// source network is configured.
+func (c *connection) sourceSpecifier() *npdu.Address {
+	if c.sourceNetwork == 0 {
+		return nil
+	}
+	addr := c.SourceAddress()
+	addr.Network = c.sourceNetwork
+	if c.sourceMAC != nil {
+		addr.Length = uint8(len(c.sourceMAC))
+		addr.Addr = append([]byte{}, c.sourceMAC...)
+	}
+	return addr
+}
+
+// LocalIP returns a copy of the IP that the connection was created with.
+func (c *connection) LocalIP() net.IP {
+	return append(net.IP{}, c.ip4Addr...)
+}
+
+// LocalAddr returns the address of the socket, rather than the one it was bound with.
+func (c *connection) LocalAddr() net.Addr {
+	return c.bacnetConn.LocalAddr()
+}
+
+// BroadcastIP returns a copy of the local broadcast IP, which is calculated from the IP and mask.
+func (c *connection) BroadcastIP() net.IP {
+	return append(net.IP{}, c.broadcastIP...)
+}
+
 func (c *connection) BroadcastAddress() *npdu.Address {
-	addrBytes := append(c.broadcastIP, apdu.EncodeUint(DefaultPort, 2)...)
+	addrBytes := append(c.broadcastIP, apdu.EncodeUint(uint(c.port), 2)...)
 	return &npdu.Address{
 		Network: 0,
 		Length:  0, // somehow, we don't really need length in these situations. Such is BACnet
@@ -188,7 +507,7 @@ func (c *connection) BroadcastAddress() *npdu.Address {
 }
 
 func (c *connection) DestinationAddress(dest net.IP) *npdu.Address {
-	addrBytes := append(dest, apdu.EncodeUint(DefaultPort, 2)...)
+	addrBytes := append(dest, apdu.EncodeUint(uint(c.port), 2)...)
 	return &npdu.Address{
 		Network: 0,
 		Length:  net.IPv4len + 2,
@@ -196,36 +515,153 @@ func (c *connection) DestinationAddress(dest net.IP) *npdu.Address {
 	}
 }
 
+// NewBroadcastTarget creates the target for a broadcast on the network. For the local network, it's just a
+// local broadcast with no DNET. For a remote network, the DNET is the network with a zero length address,
+// which means broadcast on that network. If the BBMD is known, the frame is sent directly to it, otherwise,
+// it's broadcast locally for the routers to forward. A global broadcast is never narrowed to a unicast: with a
+// BBMD, it's a Distribute-Broadcast-To-Network, so the BBMD broadcasts it everywhere it reaches.
+func NewBroadcastTarget(localBroadcast net.IP, network uint16, bbmd net.IP) *BroadcastTarget {
+	if network == LocalNetwork {
+		return &BroadcastTarget{
+			Function: BVLCFunctioncBroadcast,
+			IP:       localBroadcast,
+		}
+	}
+	target := BroadcastTarget{
+		Destination: &npdu.Address{
+			Network: network,
+			Length:  0,
+		},
+		Function: BVLCFunctioncBroadcast,
+		IP:       localBroadcast,
+	}
+	if bbmd != nil {
+		target.Function = BVLCFunctioncUnicast
+		if network == GlobalBroadcastNetwork {
+			target.Function = BVLCFunctioncDistributeBroadcastToNetwork
+		}
+		target.IP = bbmd
+	}
+	return &target
+}
+
+// isFromSelf checks if the sender is our own address, which is our IP with the BACnet port, like the
+// SourceAddress.
+func (c *connection) isFromSelf(sender *net.UDPAddr) bool {
+	return sender != nil && sender.IP.Equal(c.ip4Addr) && sender.Port == int(c.port)
+}
+
 func (c *connection) udpAddr(ipAddr net.IP) net.Addr {
 	return &net.UDPAddr{
 		IP:   ipAddr,
-		Port: DefaultPort,
+		Port: int(c.port),
 	}
 }
 
-func (c *connection) SendConfirmedMessage(priority npdu.NetworkMessagePriority,
+// SendConfirmedMessage sends the request directly to the device at dest. Confirmed requests always expect a
+// reply, so the NPDU has the data expecting reply bit set.
+func (c *connection) SendConfirmedMessage(dest net.IP, priority npdu.NetworkMessagePriority,
 	msgType npdu.NetworkLayerMessageType, msg *apdu.ConfirmedMessage) error {
-	return fmt.Errorf("Not implemented")
+	c.prepareConfirmedMessage(msg)
+	npduMsg := npdu.NewMessage(priority, true, false, nil, c.sourceSpecifier(), DefaultHopCount, msgType, nil, msg)
+	return c.send(dest, BVLCFunctioncUnicast, npduMsg)
+}
+
+// SendResponse sends the response (an ack, error, reject or abort) to the device that sent us the confirmed
+// request. Responses don't expect a reply.
+func (c *connection) SendResponse(dest net.IP, msg apdu.Message) error {
+	npduMsg := npdu.NewMessage(npdu.NormalMessage, false, false, nil, c.sourceSpecifier(), DefaultHopCount, 0, nil,
+		msg)
+	return c.send(dest, BVLCFunctioncUnicast, npduMsg)
+}
+
+// validateSegmentation checks that the segmentation settings can be encoded in our requests and segment acks.
+func (c *connection) validateSegmentation() error {
+	if c.segmentWindow == 0 {
+		return fmt.Errorf("segment window of 0: %w", bacnet.ErrInvalidData)
+	}
+	if c.segmentWindow > MaxSegmentWindow {
+		return fmt.Errorf("segment window %d is larger than %d: %w", c.segmentWindow, MaxSegmentWindow,
+			bacnet.ErrValueTooLarge)
+	}
+	if err := apdu.MaxSegments(c.maxSegments).Validate(); err != nil {
+		return fmt.Errorf("max segments code %d: %w", c.maxSegments, err)
+	}
+	return nil
+}
+
+// prepareConfirmedMessage applies the connection's segmentation settings to the request. The builders in apdu
+// don't know about the connection, so they use the defaults.
+func (c *connection) prepareConfirmedMessage(msg *apdu.ConfirmedMessage) {
+	msg.MaxSegmentsAccepted = c.maxSegments
+	msg.IsSegmentResponseAccepted = c.maxSegments != uint8(apdu.MaxSegmentsUnspecified)
+	if msg.IsSegmented {
+		window := c.segmentWindow
+		msg.ProposedWindowSize = &window
+	}
+}
+
+// newSegmentAck creates the ack for a segment of a response. The actual window size is ours, unless the
+// device proposed a smaller one.
+func (c *connection) newSegmentAck(invokeID, sequenceNumber, proposedWindow uint8,
+	negative bool) *apdu.SegmentAckMessage {
+	window := c.segmentWindow
+	if proposedWindow > 0 && proposedWindow < window {
+		window = proposedWindow
+	}
+	return apdu.NewSegmentAck(invokeID, sequenceNumber, window, negative, false)
 }
 
 // SendUnconfirmedMessage will be adapted as I hardcode less stuff
 // This handles all three "layers": APDU, NPDU, and BVLC. If we continue to do it like this, we
-// can have one byte stream that eventually gets sent over the UDP connection.
+// can have one byte stream that eventually gets sent over the UDP connection. The destination is the NPDU
+// destination, so it should be nil for devices on our network. The message is broadcast on our network.
 func (c *connection) SendUnconfirmedMessage(destination *npdu.Address, priority npdu.NetworkMessagePriority,
 	msgType npdu.NetworkLayerMessageType, msg *apdu.UnconfirmedMessage) error {
+	return c.sendUnconfirmed(c.broadcastIP, BVLCFunctioncBroadcast, destination, priority, msgType, msg)
+}
+
+// sendUnconfirmed wraps the message in the NPDU and BVLC layers and sends it to the IP.
+func (c *connection) sendUnconfirmed(ip net.IP, function BVLCFunction, destination *npdu.Address,
+	priority npdu.NetworkMessagePriority, msgType npdu.NetworkLayerMessageType, msg *apdu.UnconfirmedMessage) error {
 
-	npduMsg := npdu.NewMessage(priority, false, false, c.SourceAddress(), destination, DefaultHopCount, msgType,
+	npduMsg := npdu.NewMessage(priority, false, false, destination, c.sourceSpecifier(), DefaultHopCount, msgType,
 		nil, msg)
+	return c.send(ip, function, npduMsg)
+}
 
+// DistributeUnconfirmedMessage sends the message to the BBMD in a Forwarded-NPDU frame. The frame has our
+// B/IP address as the originating address, so the BBMD broadcasts it on its network as if it had forwarded
+// it from us, and the devices there reply to us directly.
+func (c *connection) DistributeUnconfirmedMessage(bbmd net.IP, priority npdu.NetworkMessagePriority,
+	msgType npdu.NetworkLayerMessageType, msg *apdu.UnconfirmedMessage) error {
+	npduMsg := npdu.NewMessage(priority, false, false, nil, c.sourceSpecifier(), DefaultHopCount, msgType, nil, msg)
 	npduBytes, err := npduMsg.Encode()
 	if err != nil {
 		return err
 	}
+	// The data of a Forwarded-NPDU starts with the 6 byte B/IP address of where it came from.
+	source := c.SourceAddress().Addr
+	data := append(append(make([]byte, 0, len(source)+len(npduBytes)), source...), npduBytes...)
+	return c.sendBVLC(bbmd, NewBVLCMessage(BVLCFunctioncForwardedNPDU, data))
+}
 
-	// I think this is either unicast or broadcast. But, this should be passed in.
-	bvlcMsg := NewBVLCMessage(BVLCFunctioncBroadcast, npduBytes)
-	msgBytes := bvlcMsg.Encode()
-	bytesWritten, err := c.bacnetConn.WriteTo(msgBytes, c.udpAddr(c.broadcastIP))
+// send wraps the NPDU message in the BVLC layer and sends it to the IP.
+func (c *connection) send(ip net.IP, function BVLCFunction, npduMsg *npdu.MessageBase) error {
+	npduBytes, err := npduMsg.Encode()
+	if err != nil {
+		return err
+	}
+	return c.sendBVLC(ip, NewBVLCMessage(function, npduBytes))
+}
+
+// sendBVLC encodes the BVLC message and sends it to the IP.
+func (c *connection) sendBVLC(ip net.IP, bvlcMsg *BVLCMessage) error {
+	msgBytes, err := bvlcMsg.Encode()
+	if err != nil {
+		return err
+	}
+	bytesWritten, err := c.bacnetConn.WriteTo(msgBytes, c.udpAddr(ip))
 	if err != nil {
 		return err
 	}
@@ -234,3 +670,124 @@ func (c *connection) SendUnconfirmedMessage(destination *npdu.Address, priority
 	}
 	return nil
 }
+
+// Ping sends a WhoIs for only the device instance directly to the IP, and waits for the IAm from the device.
+// Other devices behind a router at the IP don't answer, since they aren't in the range.
+func (c *connection) Ping(ctx context.Context, dest net.IP, deviceInstance uint) (time.Duration, error) {
+	whoIs, err := apdu.NewWhoisMessage(deviceInstance, deviceInstance)
+	if err != nil {
+		return 0, err
+	}
+	waiter := c.addWaiter(func(sender *net.UDPAddr, msg apdu.Message) bool {
+		unconfirmed, ok := msg.(*apdu.UnconfirmedMessage)
+		if !ok || unconfirmed.ServiceID != apdu.ServiceUnconfirmedIAm || !sender.IP.Equal(dest) {
+			return false
+		}
+		iAm, err := apdu.NewIAmFromMessage(unconfirmed)
+		return err == nil && uint(iAm.DeviceID.Instance) == deviceInstance
+	})
+	defer c.removeWaiter(waiter)
+
+	start := time.Now()
+	if err := c.sendUnconfirmed(dest, BVLCFunctioncUnicast, nil, npdu.NormalMessage,
+		npdu.NetworkLayerWhoIsMessage, whoIs); err != nil {
+		return 0, err
+	}
+	select {
+	case <-waiter.ch:
+		return time.Since(start), nil
+	case <-waiter.closed:
+		return 0, ErrConnectionClosed
+	case <-ctx.Done():
+		return 0, fmt.Errorf("no IAm received from %s: %w", dest, ctx.Err())
+	}
+}
+
+// Watch adds a waiter for messages that aren't responses to our requests, like notifications, which can be
+// watched for as long as the connection is started.
+func (c *connection) Watch(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int) (
+	<-chan apdu.Message, <-chan struct{}, func()) {
+	waiter := c.addKeptWaiter(matches, size)
+	return waiter.ch, waiter.closed, func() {
+		c.removeWaiter(waiter)
+	}
+}
+
+// addWaiter adds a waiter for a response, which Drain keeps.
+func (c *connection) addWaiter(matches func(sender *net.UDPAddr, msg apdu.Message) bool) *responseWaiter {
+	return c.addKeptWaiter(matches, 1)
+}
+
+// addKeptWaiter is like addBufferedWaiter, but Drain doesn't empty it, since its reader is waiting for the
+// messages that are in it.
+func (c *connection) addKeptWaiter(matches func(sender *net.UDPAddr, msg apdu.Message) bool,
+	size int) *responseWaiter {
+	return c.insertWaiter(matches, size, true)
+}
+
+// addBufferedWaiter adds a waiter that can hold more than one message, for when we expect more than one
+// response.
+func (c *connection) addBufferedWaiter(matches func(sender *net.UDPAddr, msg apdu.Message) bool,
+	size int) *responseWaiter {
+	return c.insertWaiter(matches, size, false)
+}
+
+func (c *connection) insertWaiter(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int,
+	kept bool) *responseWaiter {
+	waiter := &responseWaiter{
+		matches: matches,
+		ch:      make(chan apdu.Message, size),
+		closed:  make(chan struct{}),
+		kept:    kept,
+	}
+	c.waitersMux.Lock()
+	defer c.waitersMux.Unlock()
+	c.waiters = append(c.waiters, waiter)
+	return waiter
+}
+
+func (c *connection) removeWaiter(waiter *responseWaiter) {
+	c.waitersMux.Lock()
+	defer c.waitersMux.Unlock()
+	for i, w := range c.waiters {
+		if w == waiter {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// cancelWaiters unblocks all of the waiters, and removes them, since they won't get a response.
+func (c *connection) cancelWaiters() {
+	c.waitersMux.Lock()
+	defer c.waitersMux.Unlock()
+	for _, w := range c.waiters {
+		close(w.closed)
+	}
+	c.waiters = nil
+}
+
+// offerToWaiters decodes the APDU message, if there is one, and gives it to the waiters that match it. The
+// IAms are also remembered for the max APDU length of the device, even if no one is waiting for them.
+func (c *connection) offerToWaiters(sender *net.UDPAddr, msg *BVLCMessage) {
+	if msg.Function != BVLCFunctioncBroadcast && msg.Function != BVLCFunctioncUnicast {
+		return
+	}
+	npduMsg, err := npdu.NewMessageFromBytes(msg.Data)
+	if err != nil || npduMsg.APDU == nil {
+		return
+	}
+	c.rememberPeer(sender, npduMsg.APDU)
+
+	c.waitersMux.Lock()
+	defer c.waitersMux.Unlock()
+	for _, w := range c.waiters {
+		if w.matches(sender, npduMsg.APDU) {
+			// Don't block the loop if the waiter already has its response
+			select {
+			case w.ch <- npduMsg.APDU:
+			default:
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package transport
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/shigmas/modore/internal/apdu"
 	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 )
 
 type (
@@ -22,9 +24,16 @@ type (
 	TestRouter struct {
 		listenCh chan *BVLCMessage
 	}
+
+	// loopbackResponder answers WhoIs messages that include device 1234 with an IAm sent to the loopback
+	// address.
+	loopbackResponder struct {
+		conn *connection
+	}
 )
 
 var _ MessageRouter = (*TestRouter)(nil)
+var _ MessageRouter = (*loopbackResponder)(nil)
 var _ APDUMessageHandler = (*TestHandler)(nil)
 
 // Very crappy implementation. But, this was easy to adapt to the old test.
@@ -48,6 +57,27 @@ func (r *TestRouter) RouteMessage(message *BVLCMessage) error {
 	return nil
 }
 
+func (r *loopbackResponder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.UnconfirmedMessage)
+	if !ok || msg.ServiceID != apdu.ServiceUnconfirmedWhoIs {
+		return nil
+	}
+	whoIs, err := apdu.NewWhoIsFromMessage(msg)
+	if err != nil || !whoIs.Matches(1234) {
+		return err
+	}
+	iAm, err := apdu.NewIAmMessage(8, 1234, 1476, apdu.SegmentationNone, 999)
+	if err != nil {
+		return err
+	}
+	return r.conn.sendUnconfirmed(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, nil, npdu.NormalMessage,
+		npdu.NetworkLayerIAmMessage, iAm)
+}
+
 func NewTestHandler(ch APDUMessageChannel) *TestHandler {
 	return &TestHandler{ch, nil}
 }
@@ -90,6 +120,84 @@ func TestNewConnection(t *testing.T) {
 	assert.NoError(t, conn.Close(), "Error closing connection")
 }
 
+func TestBindAddress(t *testing.T) {
+	addr := []byte{192, 168, 3, 16}
+	// Port 0, so we don't need the BACnet port to be free
+	conn, err := NewConnection(addr, 24, WithBindAddress(&net.UDPAddr{IP: net.IPv4zero, Port: 0}))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	defer func() {
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	realConn, ok := conn.(*connection)
+	assert.True(t, ok, "Unable to cast to concrete type")
+	udpConn, ok := realConn.bacnetConn.(*net.UDPConn)
+	assert.True(t, ok, "Expected a UDP connection")
+	bound := udpConn.LocalAddr().(*net.UDPAddr)
+	assert.True(t, bound.IP.IsUnspecified(), "Not bound to all interfaces")
+	assert.NotEqual(t, 0, bound.Port, "Not bound to a port")
+
+	// What we send still comes from the configured address
+	assert.Equal(t, []byte{192, 168, 3, 16, 0xBA, 0xC0}, conn.SourceAddress().Addr, "Unexpected source")
+	assert.Equal(t, net.IP{192, 168, 3, 255}, conn.BroadcastIP(), "Unexpected broadcast IP")
+	assert.Equal(t, []byte{192, 168, 3, 255, 0xBA, 0xC0}, conn.BroadcastAddress().Addr,
+		"Unexpected broadcast address")
+}
+
+func TestLocalAddr(t *testing.T) {
+	// Port 0 gets an ephemeral port from the system
+	conn, err := NewConnection([]byte{127, 0, 0, 1}, 8,
+		WithBindAddress(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	defer func() {
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if assert.True(t, ok, "Expected a UDP address") {
+		assert.NotEqual(t, 0, local.Port, "Expected the port that was bound")
+		assert.True(t, local.IP.Equal(net.IPv4(127, 0, 0, 1)), "Unexpected IP")
+	}
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	memConn, err := NewConnection([]byte{127, 0, 0, 1}, 8, WithPacketConn(NewMemoryPacketConn(loopback)))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	defer memConn.Close()
+	assert.Equal(t, loopback, memConn.LocalAddr(), "Expected the address of the memory connection")
+}
+
+func TestAddressPortBytes(t *testing.T) {
+	// The port follows the IP in the B/IP address, big endian, so a byte order mistake would send to the wrong
+	// port without any other error.
+	testCases := []struct {
+		name      string
+		opts      []ConnectionOption
+		port      int
+		portBytes []byte
+	}{
+		{"default", nil, 0xBAC0, []byte{0xBA, 0xC0}},
+		{"configured", []ConnectionOption{WithPort(0xBAC3)}, 0xBAC3, []byte{0xBA, 0xC3}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tCase.port}
+			opts := append(tCase.opts, WithPacketConn(NewMemoryPacketConn(loopback)))
+			conn, err := NewConnection([]byte{192, 168, 3, 16}, 24, opts...)
+			assert.NoError(t, err, "Unexpected error creating connection")
+			defer conn.Close()
+
+			assert.Equal(t, append([]byte{192, 168, 3, 16}, tCase.portBytes...), conn.SourceAddress().Addr,
+				"Unexpected source address")
+			assert.Equal(t, append([]byte{10, 0, 0, 7}, tCase.portBytes...),
+				conn.DestinationAddress(net.IP{10, 0, 0, 7}).Addr, "Unexpected destination address")
+			assert.Equal(t, append([]byte{192, 168, 3, 255}, tCase.portBytes...), conn.BroadcastAddress().Addr,
+				"Unexpected broadcast address")
+			dest := conn.(*connection).udpAddr(net.IP{10, 0, 0, 7}).(*net.UDPAddr)
+			assert.Equal(t, tCase.port, dest.Port, "Unexpected port to send to")
+		})
+	}
+}
+
 func TestStartStopConnection(t *testing.T) {
 	addr := []byte{192, 168, 3, 16}
 	conn, err := NewConnection(addr, 24)
@@ -108,6 +216,40 @@ func TestStartStopConnection(t *testing.T) {
 	assert.NoError(t, conn.Close(), "Error closing connection")
 }
 
+func TestPing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		instance uint
+		timeout  time.Duration
+		expected bool
+	}{
+		{"device", 1234, 2 * time.Second, true},
+		{"other device", 1235, 200 * time.Millisecond, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t)
+			conn.SetMessageRouter(&loopbackResponder{conn})
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), tCase.timeout)
+			defer cancel()
+			rtt, err := conn.Ping(ctx, net.IPv4(127, 0, 0, 1), tCase.instance)
+			if tCase.expected {
+				assert.NoError(t, err, "Unexpected error pinging loopback")
+				assert.Greater(t, rtt, time.Duration(0), "Round trip time should be positive")
+			} else {
+				assert.ErrorIs(t, err, context.DeadlineExceeded, "Expected no IAm from another device")
+			}
+			assert.Empty(t, conn.waiters, "Waiter was not removed")
+		})
+	}
+}
+
 // This test doesn't always receive its who is back, so don't run it for CI
 func NoTestWhoIs(t *testing.T) {
 	addr := []byte{192, 168, 3, 16}
@@ -144,3 +286,396 @@ func NoTestWhoIs(t *testing.T) {
 	assert.NotNil(t, apduHandler.msg, "Message Never received")
 
 }
+
+func TestBroadcastTarget(t *testing.T) {
+	localBroadcast := net.IP{192, 168, 1, 255}
+	bbmd := net.IP{10, 0, 0, 1}
+	whoIs, err := apdu.NewWhoisMessage(0, apdu.MaxInstanceNumber)
+	assert.NoError(t, err, "Unable to create WhoIs")
+	whoIsBytes, err := whoIs.Encode()
+	assert.NoError(t, err, "Unable to encode WhoIs")
+
+	testCases := []struct {
+		name             string
+		network          uint16
+		bbmd             net.IP
+		expectedDest     *npdu.Address
+		expectedFunction BVLCFunction
+		expectedIP       net.IP
+		// expectedNPDU is the NPDU header before the APDU
+		expectedNPDU []byte
+	}{
+		{"local", LocalNetwork, nil, nil, BVLCFunctioncBroadcast, localBroadcast, []byte{0x01, 0x00}},
+		{"local ignores bbmd", LocalNetwork, bbmd, nil, BVLCFunctioncBroadcast, localBroadcast,
+			[]byte{0x01, 0x00}},
+		// A remote broadcast has the DNET, a DLEN of 0, and the hop count
+		{"remote", 5, nil, &npdu.Address{Network: 5}, BVLCFunctioncBroadcast, localBroadcast,
+			[]byte{0x01, 0x20, 0x00, 0x05, 0x00, DefaultHopCount}},
+		{"remote through bbmd", 5, bbmd, &npdu.Address{Network: 5}, BVLCFunctioncUnicast, bbmd,
+			[]byte{0x01, 0x20, 0x00, 0x05, 0x00, DefaultHopCount}},
+		{"global", GlobalBroadcastNetwork, nil, &npdu.Address{Network: 0xFFFF}, BVLCFunctioncBroadcast,
+			localBroadcast, []byte{0x01, 0x20, 0xFF, 0xFF, 0x00, DefaultHopCount}},
+		// The BBMD distributes a global broadcast, instead of it being sent to only the BBMD
+		{"global through bbmd", GlobalBroadcastNetwork, bbmd, &npdu.Address{Network: 0xFFFF},
+			BVLCFunctioncDistributeBroadcastToNetwork, bbmd, []byte{0x01, 0x20, 0xFF, 0xFF, 0x00, DefaultHopCount}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			target := NewBroadcastTarget(localBroadcast, tCase.network, tCase.bbmd)
+			assert.Equal(t, tCase.expectedDest, target.Destination, "Unexpected destination")
+			assert.Equal(t, tCase.expectedFunction, target.Function, "Unexpected function")
+			assert.Equal(t, tCase.expectedIP, target.IP, "Unexpected IP")
+
+			msg := npdu.NewMessage(npdu.NormalMessage, false, false, target.Destination, nil, DefaultHopCount, 0,
+				nil, whoIs)
+			encoded, err := msg.Encode()
+			assert.NoError(t, err, "Unable to encode NPDU")
+			assert.Equal(t, append(append([]byte{}, tCase.expectedNPDU...), whoIsBytes...), encoded,
+				"Unexpected NPDU")
+		})
+	}
+}
+
+func TestDistributeUnconfirmedMessage(t *testing.T) {
+	c, packetConn := newMemoryConnectionAt(t, net.IP{192, 168, 1, 10}, 24)
+	defer func() {
+		assert.NoError(t, c.Close(), "Error closing connection")
+	}()
+
+	whoIs, err := apdu.NewWhoisMessage(0, apdu.MaxInstanceNumber)
+	assert.NoError(t, err, "Unable to create WhoIs")
+	err = c.DistributeUnconfirmedMessage(net.IP{10, 0, 0, 1}, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage,
+		whoIs)
+	assert.NoError(t, err, "Unable to distribute WhoIs")
+
+	buf := make([]byte, 1500)
+	n, _, err := packetConn.ReadFrom(buf)
+	assert.NoError(t, err, "Unable to read what was sent")
+	msg, err := NewBVLCMessageFromBytes(buf[:n])
+	assert.NoError(t, err, "Unable to decode BVLC")
+	assert.Equal(t, BVLCFunction(BVLCFunctioncForwardedNPDU), msg.Function, "Unexpected BVLC function")
+	assert.Equal(t, []byte{192, 168, 1, 10, 0xBA, 0xC0}, msg.Data[:6], "Unexpected originating address")
+
+	npduMsg, err := npdu.NewMessageFromBytes(msg.Data[6:])
+	assert.NoError(t, err, "Unable to decode NPDU")
+	assert.Equal(t, whoIs, npduMsg.APDU, "Unexpected APDU")
+}
+
+func TestSourceNetwork(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []ConnectionOption
+		expectedNPDU []byte
+	}{
+		{
+			name:         "absent",
+			expectedNPDU: []byte{0x01, 0x00},
+		},
+		{
+			name:         "network",
+			opts:         []ConnectionOption{WithSourceNetwork(5)},
+			expectedNPDU: []byte{0x01, 0x08, 0x00, 0x05, 0x06, 192, 168, 1, 10, 0xBA, 0xC0},
+		},
+		{
+			name:         "network and MAC",
+			opts:         []ConnectionOption{WithSourceNetwork(0x1234), WithSourceMAC([]byte{0x2A})},
+			expectedNPDU: []byte{0x01, 0x08, 0x12, 0x34, 0x01, 0x2A},
+		},
+		{
+			// Without the network, there is no source specifier
+			name:         "MAC only",
+			opts:         []ConnectionOption{WithSourceMAC([]byte{0x2A})},
+			expectedNPDU: []byte{0x01, 0x00},
+		},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			c, packetConn := newMemoryConnectionAt(t, net.IP{192, 168, 1, 10}, 24, tCase.opts...)
+			defer func() {
+				assert.NoError(t, c.Close(), "Error closing connection")
+			}()
+
+			whoIs, err := apdu.NewWhoisMessage(0, apdu.MaxInstanceNumber)
+			assert.NoError(t, err, "Unable to create WhoIs")
+			err = c.SendUnconfirmedMessage(nil, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage, whoIs)
+			assert.NoError(t, err, "Unable to send WhoIs")
+
+			buf := make([]byte, 1500)
+			n, _, err := packetConn.ReadFrom(buf)
+			assert.NoError(t, err, "Unable to read what was sent")
+			msg, err := NewBVLCMessageFromBytes(buf[:n])
+			assert.NoError(t, err, "Unable to decode BVLC")
+			assert.Equal(t, tCase.expectedNPDU, msg.Data[:len(tCase.expectedNPDU)], "Unexpected NPDU header")
+
+			npduMsg, err := npdu.NewMessageFromBytes(msg.Data)
+			assert.NoError(t, err, "Unable to decode NPDU")
+			assert.Equal(t, whoIs, npduMsg.APDU, "Unexpected APDU")
+		})
+	}
+}
+
+func TestConnectionIPs(t *testing.T) {
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	testCases := []struct {
+		name              string
+		ip                net.IP
+		mask              uint16
+		expectedBroadcast net.IP
+	}{
+		{"/24", net.IP{192, 168, 1, 42}, 24, net.IP{192, 168, 1, 255}},
+		{"/16", net.IP{10, 1, 2, 3}, 16, net.IP{10, 1, 255, 255}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, err := NewConnection(tCase.ip, tCase.mask, WithPacketConn(NewMemoryPacketConn(loopback)))
+			assert.NoError(t, err, "Unexpected error creating connection")
+			defer conn.Close()
+
+			assert.Equal(t, tCase.ip, conn.LocalIP(), "Unexpected local IP")
+			assert.Equal(t, tCase.expectedBroadcast, conn.BroadcastIP(), "Unexpected broadcast IP")
+
+			// These are copies, so changing them doesn't change the connection
+			conn.BroadcastIP()[3] = 0
+			assert.Equal(t, tCase.expectedBroadcast, conn.BroadcastIP(), "Broadcast IP was changed")
+		})
+	}
+}
+
+func TestSegmentationOptionValidation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		opts        []ConnectionOption
+		expectedErr error
+	}{
+		{"defaults", nil, nil},
+		{"largest window", []ConnectionOption{WithSegmentWindow(MaxSegmentWindow)}, nil},
+		{"no window", []ConnectionOption{WithSegmentWindow(0)}, bacnet.ErrInvalidData},
+		{"window too large", []ConnectionOption{WithSegmentWindow(MaxSegmentWindow + 1)}, bacnet.ErrValueTooLarge},
+		{"most segments", []ConnectionOption{WithMaxSegments(uint8(apdu.MaxSegmentsMoreThan64))}, nil},
+		{"max segments too large", []ConnectionOption{WithMaxSegments(uint8(apdu.MaxSegmentsMoreThan64) + 1)},
+			bacnet.ErrValueTooLarge},
+	}
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			opts := append([]ConnectionOption{WithPacketConn(NewMemoryPacketConn(loopback))}, tCase.opts...)
+			conn, err := NewConnection([]byte{127, 0, 0, 1}, 8, opts...)
+			if tCase.expectedErr != nil {
+				assert.ErrorIs(t, err, tCase.expectedErr, "Expected invalid segmentation settings")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error creating connection")
+			assert.NoError(t, conn.Close(), "Error closing connection")
+		})
+	}
+}
+
+func TestSegmentationOptions(t *testing.T) {
+	conn, err := NewConnection([]byte{127, 0, 0, 1}, 8, WithSegmentWindow(4),
+		WithMaxSegments(uint8(apdu.MaxSegments16)))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	defer conn.Close()
+	realConn, ok := conn.(*connection)
+	assert.True(t, ok, "Unable to cast to concrete type")
+
+	specs := []apdu.ReadAccessSpec{
+		{
+			ObjectID:   bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+			Properties: []apdu.PropertyReference{{Property: bacnet.PropertyIdentifierObjectName}},
+		},
+	}
+	msg, err := apdu.NewReadPropertyMultipleMessage(1, specs)
+	assert.NoError(t, err, "Unexpected error creating ReadPropertyMultiple")
+	realConn.prepareConfirmedMessage(msg)
+	assert.Equal(t, uint8(apdu.MaxSegments16), msg.MaxSegmentsAccepted, "Max segments not applied")
+	assert.True(t, msg.IsSegmentResponseAccepted, "Segmented response should be accepted")
+	assert.Nil(t, msg.ProposedWindowSize, "Unsegmented request has no window")
+
+	// The first segment of a segmented request proposes our window
+	seq := uint8(0)
+	msg.IsSegmented = true
+	msg.DoSegmentsFollow = true
+	msg.SequenceNumber = &seq
+	realConn.prepareConfirmedMessage(msg)
+	if assert.NotNil(t, msg.ProposedWindowSize, "Window not applied") {
+		assert.Equal(t, uint8(4), *msg.ProposedWindowSize, "Unexpected window size")
+	}
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x0E, 0x45, 1, 0, 4}, encoded[:5], "Unexpected header")
+
+	ack := realConn.newSegmentAck(1, 0, 16, false)
+	assert.Equal(t, uint8(4), ack.ActualWindowSize, "Unexpected ack window size")
+	ack = realConn.newSegmentAck(1, 0, 2, false)
+	assert.Equal(t, uint8(2), ack.ActualWindowSize, "Unexpected ack window size for a smaller proposal")
+}
+
+func TestMemoryPacketConn(t *testing.T) {
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	conn, err := NewConnection([]byte{127, 0, 0, 1}, 8, WithPacketConn(NewMemoryPacketConn(loopback)))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	realConn, ok := conn.(*connection)
+	assert.True(t, ok, "Unable to cast to concrete type")
+	conn.SetMessageRouter(&loopbackResponder{realConn})
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	// The WhoIs loops back to the responder, which sends the IAm, which loops back to Ping.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rtt, err := conn.Ping(ctx, loopback.IP, 1234)
+	assert.NoError(t, err, "Unexpected error pinging over memory transport")
+	assert.Greater(t, rtt, time.Duration(0), "Unexpected round trip time")
+}
+
+func TestSetMessageRouterWhileStarted(t *testing.T) {
+	const (
+		swaps     = 50
+		markerLow = 7
+	)
+	conn, _ := newMemoryConnection(t)
+	first := &whoIsRecorder{lows: make(chan uint, swaps)}
+	second := &whoIsRecorder{lows: make(chan uint, swaps+1)}
+	conn.SetMessageRouter(first)
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	// Swap the router while the loop is routing, which the race detector would catch if it weren't guarded.
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for i := 0; i < swaps; i++ {
+			if i%2 == 0 {
+				conn.SetMessageRouter(second)
+			} else {
+				conn.SetMessageRouter(first)
+			}
+		}
+	}()
+	for i := 0; i < swaps; i++ {
+		whoIs, err := apdu.NewWhoisMessage(1, 100)
+		assert.NoError(t, err, "Unable to create WhoIs")
+		assert.NoError(t, conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage,
+			whoIs), "Unable to send WhoIs")
+	}
+	<-swapped
+
+	// After the last swap, the messages go to the new router.
+	conn.SetMessageRouter(second)
+	whoIs, err := apdu.NewWhoisMessage(markerLow, 100)
+	assert.NoError(t, err, "Unable to create WhoIs")
+	assert.NoError(t, conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage, whoIs),
+		"Unable to send WhoIs")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case low := <-second.lows:
+			if low == markerLow {
+				return
+			}
+		case low := <-first.lows:
+			assert.NotEqual(t, uint(markerLow), low, "Old router got the message after the swap")
+		case <-timeout:
+			assert.Fail(t, "New router didn't get the message")
+			return
+		}
+	}
+}
+
+func TestSendCustomUnconfirmedService(t *testing.T) {
+	const vendorService = apdu.ServiceUnconfirmed(65)
+	frame := []byte{0x01, 0x04, 0xAB}
+	assert.NoError(t, apdu.RegisterUnconfirmedEncoder(vendorService, func([]apdu.TagType) ([]byte, error) {
+		return frame, nil
+	}), "Unable to register encoder")
+	defer func() {
+		assert.NoError(t, apdu.RegisterUnconfirmedEncoder(vendorService, nil), "Unable to remove encoder")
+	}()
+
+	conn, packetConn := newMemoryConnection(t)
+	defer func() {
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+	msg := &apdu.UnconfirmedMessage{
+		MessageBase: apdu.MessageBase{ServiceType: apdu.PDUTypeUnconfirmedServiceRequest},
+		ServiceID:   vendorService,
+	}
+	assert.NoError(t, conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, 0, msg), "Unable to send")
+
+	buf := make([]byte, 1500)
+	n, _, err := packetConn.ReadFrom(buf)
+	assert.NoError(t, err, "Unable to read what was sent")
+	bvlcMsg, err := NewBVLCMessageFromBytes(buf[:n])
+	assert.NoError(t, err, "Unable to decode BVLC")
+	// The NPDU is the version and control, with no addresses for a local broadcast
+	assert.Equal(t, append([]byte{0x10, byte(vendorService)}, frame...), bvlcMsg.Data[2:],
+		"Expected the APDU from the custom encoder")
+}
+
+func TestDrainWhileStarting(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.Drain()
+	}()
+	conn.Start()
+	<-done
+	conn.Stop()
+	assert.NoError(t, conn.Close(), "Error closing connection")
+}
+
+func TestDrain(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	// Draining before the connection is started has nothing to do
+	conn.Drain()
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	waiter := conn.addBufferedWaiter(func(sender *net.UDPAddr, msg apdu.Message) bool {
+		iAm, ok := msg.(*apdu.UnconfirmedMessage)
+		return ok && iAm.ServiceID == apdu.ServiceUnconfirmedIAm
+	}, 4)
+	defer conn.removeWaiter(waiter)
+	// A waiter with a reader, like Watch, keeps its messages
+	kept := conn.addKeptWaiter(func(sender *net.UDPAddr, msg apdu.Message) bool {
+		iAm, ok := msg.(*apdu.UnconfirmedMessage)
+		return ok && iAm.ServiceID == apdu.ServiceUnconfirmedIAm
+	}, 4)
+	defer conn.removeWaiter(kept)
+
+	sendIAm := func(instance uint32) {
+		iAm, err := apdu.NewDefaultIAmMessage(instance, apdu.SegmentationNone, 260)
+		assert.NoError(t, err, "Unexpected error creating IAm")
+		assert.NoError(t, conn.sendUnconfirmed(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, nil,
+			npdu.NormalMessage, 0, iAm), "Unable to send IAm")
+	}
+
+	// The stale messages from a previous operation
+	sendIAm(1)
+	sendIAm(2)
+	assert.Eventually(t, func() bool { return len(waiter.ch) == 2 && len(kept.ch) == 2 }, time.Second,
+		10*time.Millisecond, "Stale messages were not received")
+	conn.Drain()
+	assert.Empty(t, waiter.ch, "Messages were not drained")
+	assert.Len(t, kept.ch, 2, "Messages of a kept waiter were drained")
+
+	sendIAm(3)
+	select {
+	case msg := <-waiter.ch:
+		iAm, err := apdu.NewIAmFromMessage(msg.(*apdu.UnconfirmedMessage))
+		assert.NoError(t, err, "Unexpected error getting IAm")
+		assert.Equal(t, uint32(3), iAm.DeviceID.Instance, "Expected only the new message")
+	case <-time.After(time.Second):
+		assert.Fail(t, "New message was not received after the drain")
+	}
+}
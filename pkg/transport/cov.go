@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// COVDispatcher coalesces the COV notifications for each subscription, so a burst of changes sends one
+	// notification. The first change to an object starts the interval, and the changes during it replace the
+	// values of the same properties. At the end of the interval, one notification is sent with the latest
+	// values.
+	COVDispatcher struct {
+		interval time.Duration
+		send     func(subscriber *net.UDPAddr, msg *apdu.UnconfirmedMessage) error
+		errs     chan error
+
+		mux     sync.Mutex
+		pending map[covKey]*pendingCOVNotification
+		timers  map[covKey]*time.Timer
+		stopped bool
+	}
+
+	// covKey identifies a subscription. Notifications for different subscribers aren't coalesced. Process IDs
+	// are only unique on the subscriber's device, so the subscriber's address is part of it.
+	covKey struct {
+		subscriber          string
+		subscriberProcessID uint
+		monitoredObject     bacnet.ObjectID
+	}
+
+	// pendingCOVNotification is a notification waiting for the end of the interval, and where it goes.
+	pendingCOVNotification struct {
+		subscriber   *net.UDPAddr
+		notification *apdu.COVNotification
+	}
+)
+
+// covDispatcherErrorBuffer is how many send errors are kept before they are dropped.
+const covDispatcherErrorBuffer = 8
+
+// NewCOVDispatcher creates a dispatcher that sends the coalesced notifications to their subscribers with send,
+// which is called from a timer goroutine.
+func NewCOVDispatcher(interval time.Duration,
+	send func(subscriber *net.UDPAddr, msg *apdu.UnconfirmedMessage) error) *COVDispatcher {
+	return &COVDispatcher{
+		interval: interval,
+		send:     send,
+		errs:     make(chan error, covDispatcherErrorBuffer),
+		pending:  make(map[covKey]*pendingCOVNotification),
+		timers:   make(map[covKey]*time.Timer),
+	}
+}
+
+// Errors returns the channel of errors from building or sending the notifications. If they aren't read,
+// they are dropped once the buffer is full.
+func (d *COVDispatcher) Errors() <-chan error {
+	return d.errs
+}
+
+// Notify queues the notification for the subscriber, merging it with the one that is waiting for the
+// subscription, if there is one. After Stop, the notification is dropped.
+func (d *COVDispatcher) Notify(subscriber *net.UDPAddr, notification *apdu.COVNotification) {
+	key := covKey{
+		subscriber:          subscriber.String(),
+		subscriberProcessID: notification.SubscriberProcessID,
+		monitoredObject:     notification.MonitoredObject,
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.stopped {
+		return
+	}
+
+	if pending, ok := d.pending[key]; ok {
+		mergeCOVNotification(pending.notification, notification)
+		return
+	}
+	merged := *notification
+	merged.Values = append([]apdu.PropertyValue{}, notification.Values...)
+	d.pending[key] = &pendingCOVNotification{subscriber: subscriber, notification: &merged}
+	d.timers[key] = time.AfterFunc(d.interval, func() {
+		d.dispatch(key)
+	})
+}
+
+// Stop cancels the notifications that haven't been sent, and drops the ones that are queued after.
+func (d *COVDispatcher) Stop() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.stopped = true
+	for key, timer := range d.timers {
+		timer.Stop()
+		delete(d.timers, key)
+		delete(d.pending, key)
+	}
+}
+
+func (d *COVDispatcher) dispatch(key covKey) {
+	d.mux.Lock()
+	pending, ok := d.pending[key]
+	delete(d.pending, key)
+	delete(d.timers, key)
+	d.mux.Unlock()
+	if !ok {
+		return
+	}
+
+	msg, err := apdu.NewCOVNotificationMessage(pending.notification)
+	if err == nil {
+		err = d.send(pending.subscriber, msg)
+	}
+	if err != nil {
+		select {
+		case d.errs <- err:
+		default:
+		}
+	}
+}
+
+// mergeCOVNotification replaces the values in pending with the newer ones from the notification, and adds the
+// properties that weren't there. The time remaining is from the newer one too.
+func mergeCOVNotification(pending, notification *apdu.COVNotification) {
+	pending.TimeRemaining = notification.TimeRemaining
+	for _, value := range notification.Values {
+		replaced := false
+		for i, existing := range pending.Values {
+			if existing.PropertyReference.Equal(&value.PropertyReference) {
+				pending.Values[i] = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			pending.Values = append(pending.Values, value)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type sentCOVNotification struct {
+	subscriber *net.UDPAddr
+	msg        *apdu.UnconfirmedMessage
+}
+
+func newTestCOVDispatcher(interval time.Duration) (*COVDispatcher, chan sentCOVNotification) {
+	sent := make(chan sentCOVNotification, 4)
+	dispatcher := NewCOVDispatcher(interval, func(subscriber *net.UDPAddr, msg *apdu.UnconfirmedMessage) error {
+		sent <- sentCOVNotification{subscriber: subscriber, msg: msg}
+		return nil
+	})
+	return dispatcher, sent
+}
+
+func newTestCOVNotification(presentValue uint) *apdu.COVNotification {
+	value, _ := apdu.NewApplicationUnsignedInt(presentValue)
+	return &apdu.COVNotification{
+		SubscriberProcessID: 18,
+		InitiatingDevice:    bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 4},
+		MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+		TimeRemaining:       60 - presentValue,
+		Values: []apdu.PropertyValue{{
+			PropertyReference: apdu.PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+			Values:            []apdu.TagType{value},
+		}},
+	}
+}
+
+func TestCOVDispatcher(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	dispatcher, sent := newTestCOVDispatcher(interval)
+	defer dispatcher.Stop()
+
+	// The same process ID on different devices is a different subscription
+	first := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: DefaultPort}
+	second := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: DefaultPort}
+	for i := uint(1); i <= 3; i++ {
+		dispatcher.Notify(first, newTestCOVNotification(i))
+	}
+	dispatcher.Notify(second, newTestCOVNotification(4))
+
+	expected := map[string]*apdu.COVNotification{
+		first.String():  newTestCOVNotification(3),
+		second.String(): newTestCOVNotification(4),
+	}
+	for range expected {
+		select {
+		case received := <-sent:
+			encoded, err := received.msg.Encode()
+			assert.NoError(t, err, "Unexpected error encoding")
+			decoded, err := apdu.NewCOVNotificationFromBytes(encoded[2:])
+			assert.NoError(t, err, "Unexpected error decoding")
+			assert.Equal(t, expected[received.subscriber.String()], decoded,
+				"Expected the last value for %s", received.subscriber)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Timed out waiting for the notification")
+		}
+	}
+
+	// Only the one notification for each subscriber was sent
+	select {
+	case <-sent:
+		assert.Fail(t, "Notifications were not coalesced")
+	case <-time.After(4 * interval):
+	}
+}
+
+func TestCOVDispatcherStop(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	dispatcher, sent := newTestCOVDispatcher(interval)
+	subscriber := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: DefaultPort}
+
+	// Stop cancels what is waiting, and nothing is queued after it
+	dispatcher.Notify(subscriber, newTestCOVNotification(1))
+	dispatcher.Stop()
+	dispatcher.Notify(subscriber, newTestCOVNotification(2))
+	select {
+	case <-sent:
+		assert.Fail(t, "Notification was sent after Stop")
+	case <-time.After(4 * interval):
+	}
+	assert.Empty(t, dispatcher.timers, "Timer was started after Stop")
+}
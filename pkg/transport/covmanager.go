@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+const (
+	// covRenewFraction is how much of the lifetime passes before the subscriptions are renewed, so there is
+	// time for the retries before the device drops them.
+	covRenewFraction = 0.5
+	// covManagerBuffer is how many notifications and errors are kept before they are dropped.
+	covManagerBuffer = 16
+)
+
+type (
+	// COVSubscription is a property of an object on a device to subscribe to with SubscribeCOVProperty. The
+	// device instance is how we recognize the device's IAm when it restarts. Increment is the COV increment
+	// for analog values, or nil for the device's own.
+	COVSubscription struct {
+		Device         net.IP
+		DeviceInstance uint32
+		Object         bacnet.ObjectID
+		Property       bacnet.PropertyIdentifier
+		Increment      *float32
+	}
+
+	// COVConnection is the part of the Connection that the COVManager uses.
+	COVConnection interface {
+		SendAndReceive(ctx context.Context, dest net.IP, msg *apdu.ConfirmedMessage) (apdu.Message, error)
+		Watch(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int) (msgs <-chan apdu.Message,
+			done <-chan struct{}, cancel func())
+	}
+
+	// COVManager keeps COV subscriptions alive. They are renewed before their lifetime runs out, and renewed
+	// immediately when the device announces itself with an IAm, since a device that restarted has forgotten
+	// them. The notifications for the subscriptions are delivered on a channel.
+	COVManager struct {
+		conn          COVConnection
+		processID     uint
+		lifetime      time.Duration
+		subscriptions []COVSubscription
+		notifications chan *apdu.COVNotification
+		errs          chan error
+		// restarted has the instances of the devices that sent an IAm, so they are resubscribed
+		restarted chan uint32
+
+		cancel func()
+		wg     sync.WaitGroup
+	}
+)
+
+var _ COVConnection = (Connection)(nil)
+
+// NewCOVManager creates a manager for the subscriptions, which are made with the subscriber process ID and
+// the lifetime when it's started. The lifetime is sent in seconds, so it must be at least a second, and it's
+// rounded up to a whole second.
+func NewCOVManager(conn COVConnection, processID uint, lifetime time.Duration,
+	subscriptions ...COVSubscription) (*COVManager, error) {
+	if lifetime < time.Second {
+		return nil, fmt.Errorf("lifetime %s: %w", lifetime, bacnet.ErrInvalidData)
+	}
+	return &COVManager{
+		conn:          conn,
+		processID:     processID,
+		lifetime:      lifetime,
+		subscriptions: append([]COVSubscription{}, subscriptions...),
+		notifications: make(chan *apdu.COVNotification, covManagerBuffer),
+		errs:          make(chan error, covManagerBuffer),
+		restarted:     make(chan uint32, covManagerBuffer),
+	}, nil
+}
+
+// Notifications returns the channel of the COV notifications for our subscriber process ID. If they aren't
+// read, they are dropped once the buffer is full.
+func (m *COVManager) Notifications() <-chan *apdu.COVNotification {
+	return m.notifications
+}
+
+// Errors returns the channel of errors from renewing the subscriptions. Like the notifications, they are
+// dropped once the buffer is full.
+func (m *COVManager) Errors() <-chan error {
+	return m.errs
+}
+
+// Start subscribes to all of the properties, and returns the first error if any of them failed. Otherwise,
+// the subscriptions are managed until Stop is called or the connection is stopped. The connection must be
+// started.
+func (m *COVManager) Start(ctx context.Context) error {
+	// Watch before subscribing, so we don't miss an IAm or a notification that arrives right after
+	msgs, done, cancelWatch := m.conn.Watch(func(sender *net.UDPAddr, msg apdu.Message) bool {
+		unconfirmed, ok := msg.(*apdu.UnconfirmedMessage)
+		return ok && (unconfirmed.ServiceID == apdu.ServiceUnconfirmedIAm ||
+			unconfirmed.ServiceID == apdu.ServiceUnconfirmedCOVNotification)
+	}, covManagerBuffer)
+	for _, sub := range m.subscriptions {
+		if err := m.subscribe(ctx, sub); err != nil {
+			cancelWatch()
+			return err
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.wg.Add(2)
+	go m.receive(runCtx, msgs, done, cancelWatch)
+	go m.renew(runCtx)
+	return nil
+}
+
+// Stop stops managing the subscriptions. They aren't cancelled, so the device drops them when the lifetime
+// runs out.
+func (m *COVManager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.wg.Wait()
+	}
+}
+
+// receive handles the messages that we watch for. Renewing is done by renew, so the messages are read while
+// a device is slow to answer.
+func (m *COVManager) receive(ctx context.Context, msgs <-chan apdu.Message, done <-chan struct{},
+	cancelWatch func()) {
+	defer m.wg.Done()
+	defer cancelWatch()
+	for {
+		select {
+		case msg := <-msgs:
+			m.handleMessage(msg.(*apdu.UnconfirmedMessage))
+		case <-done:
+			// The connection was stopped, so there's nothing to renew with
+			m.cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// renew subscribes again before the lifetime runs out, and when a device restarts. The subscriptions are
+// renewed at the same time, and each request is limited to the renewal interval, so a device that doesn't
+// answer doesn't hold up the others, or the next renewal.
+func (m *COVManager) renew(ctx context.Context) {
+	defer m.wg.Done()
+	interval := time.Duration(float64(m.lifetime) * covRenewFraction)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.resubscribeAll(ctx, m.subscriptions, interval)
+		case instance := <-m.restarted:
+			var restarted []COVSubscription
+			for _, sub := range m.subscriptions {
+				if sub.DeviceInstance == instance {
+					restarted = append(restarted, sub)
+				}
+			}
+			m.resubscribeAll(ctx, restarted, interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleMessage forwards our notifications, and has the subscriptions to a device that sent an IAm renewed.
+// The IAm may only be an answer to someone's WhoIs, but renewing early doesn't hurt.
+func (m *COVManager) handleMessage(msg *apdu.UnconfirmedMessage) {
+	switch msg.ServiceID {
+	case apdu.ServiceUnconfirmedCOVNotification:
+		notification, err := apdu.NewCOVNotificationFromMessage(msg)
+		if err != nil || notification.SubscriberProcessID != m.processID {
+			return
+		}
+		select {
+		case m.notifications <- notification:
+		default:
+		}
+	case apdu.ServiceUnconfirmedIAm:
+		iAm, err := apdu.NewIAmFromMessage(msg)
+		if err != nil || iAm.DeviceID.Type != bacnet.ObjectTypeDevice {
+			return
+		}
+		select {
+		case m.restarted <- iAm.DeviceID.Instance:
+		default:
+		}
+	}
+}
+
+// resubscribeAll resubscribes to each of the subscriptions in its own goroutine, and waits for all of them.
+func (m *COVManager) resubscribeAll(ctx context.Context, subs []COVSubscription, timeout time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(len(subs))
+	for _, sub := range subs {
+		go func(sub COVSubscription) {
+			defer wg.Done()
+			m.resubscribe(ctx, sub, timeout)
+		}(sub)
+	}
+	wg.Wait()
+}
+
+// resubscribe subscribes again, and reports the error instead of returning it.
+func (m *COVManager) resubscribe(ctx context.Context, sub COVSubscription, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := m.subscribe(ctx, sub); err != nil {
+		select {
+		case m.errs <- err:
+		default:
+		}
+	}
+}
+
+func (m *COVManager) subscribe(ctx context.Context, sub COVSubscription) error {
+	issueConfirmed := false
+	lifetime := uint((m.lifetime + time.Second - 1) / time.Second)
+	msg, err := apdu.NewSubscribeCOVPropertyMessage(0, &apdu.SubscribeCOVPropertyRequest{
+		SubscriberProcessID: m.processID,
+		MonitoredObject:     sub.Object,
+		IssueConfirmed:      &issueConfirmed,
+		Lifetime:            &lifetime,
+		MonitoredProperty:   apdu.PropertyReference{Property: sub.Property},
+		COVIncrement:        sub.Increment,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := m.conn.SendAndReceive(ctx, sub.Device, msg); err != nil {
+		return fmt.Errorf("subscribing to property %d of %v at %s: %w", sub.Property, sub.Object, sub.Device,
+			err)
+	}
+	return nil
+}
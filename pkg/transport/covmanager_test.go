@@ -0,0 +1,360 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// subscribingDevice is a fake device that acks the SubscribeCOVProperty requests, and reports them with
+	// the time that they arrived.
+	subscribingDevice struct {
+		conn       *connection
+		subscribes chan receivedSubscribe
+	}
+
+	receivedSubscribe struct {
+		at  time.Time
+		req *apdu.SubscribeCOVPropertyRequest
+	}
+)
+
+var _ MessageRouter = (*subscribingDevice)(nil)
+
+func (d *subscribingDevice) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.ConfirmedMessage)
+	if !ok || msg.ServiceID != apdu.ServiceConfirmedSubscribeCOVProperty {
+		return nil
+	}
+	req, err := apdu.NewSubscribeCOVPropertyRequestFromBytes(msg.ServiceData)
+	if err != nil {
+		return err
+	}
+	d.subscribes <- receivedSubscribe{at: time.Now(), req: req}
+	return d.conn.SendResponse(d.conn.LocalIP(),
+		apdu.NewSimpleAck(msg.InvokeID, apdu.ServiceConfirmedSubscribeCOVProperty))
+}
+
+func startCOVManager(t *testing.T, lifetime time.Duration) (*connection, *subscribingDevice, *COVManager) {
+	conn, _ := newMemoryConnection(t)
+	device := &subscribingDevice{conn: conn, subscribes: make(chan receivedSubscribe, 8)}
+	conn.SetMessageRouter(device)
+	conn.Start()
+
+	manager, err := NewCOVManager(conn, 18, lifetime, COVSubscription{
+		Device:         net.IPv4(127, 0, 0, 1),
+		DeviceInstance: 1234,
+		Object:         bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+		Property:       bacnet.PropertyIdentifierPresentValue,
+	})
+	assert.NoError(t, err, "Unexpected error creating manager")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, manager.Start(ctx), "Unexpected error starting manager")
+	return conn, device, manager
+}
+
+func stopCOVManager(t *testing.T, conn *connection, manager *COVManager) {
+	manager.Stop()
+	conn.Stop()
+	assert.NoError(t, conn.Close(), "Error closing connection")
+}
+
+func nextSubscribe(t *testing.T, device *subscribingDevice, timeout time.Duration) *receivedSubscribe {
+	select {
+	case sub := <-device.subscribes:
+		return &sub
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestCOVManagerRenewal(t *testing.T) {
+	const lifetime = time.Second
+	conn, device, manager := startCOVManager(t, lifetime)
+	defer stopCOVManager(t, conn, manager)
+
+	first := nextSubscribe(t, device, time.Second)
+	if !assert.NotNil(t, first, "No subscription") {
+		return
+	}
+	issueConfirmed := false
+	wireLifetime := uint(1)
+	assert.Equal(t, &apdu.SubscribeCOVPropertyRequest{
+		SubscriberProcessID: 18,
+		MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+		IssueConfirmed:      &issueConfirmed,
+		Lifetime:            &wireLifetime,
+		MonitoredProperty:   apdu.PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+	}, first.req, "Unexpected subscription")
+
+	// The renewals come before the lifetime is over
+	previous := first
+	for i := 0; i < 2; i++ {
+		renewal := nextSubscribe(t, device, time.Second)
+		if !assert.NotNil(t, renewal, "Subscription %d was not renewed", i) {
+			return
+		}
+		assert.Less(t, int64(renewal.at.Sub(previous.at)), int64(lifetime), "Renewal %d was too late", i)
+		assert.Equal(t, first.req, renewal.req, "Unexpected renewal %d", i)
+		previous = renewal
+	}
+}
+
+func TestCOVManagerMessages(t *testing.T) {
+	// Long enough that nothing is renewed during the test
+	conn, device, manager := startCOVManager(t, time.Hour)
+	defer stopCOVManager(t, conn, manager)
+	assert.NotNil(t, nextSubscribe(t, device, time.Second), "No subscription")
+
+	t.Run("notifications", func(t *testing.T) {
+		value, err := apdu.NewApplicationReal(21.5)
+		assert.NoError(t, err, "Unexpected error creating value")
+		notification := func(processID uint) *apdu.COVNotification {
+			return &apdu.COVNotification{
+				SubscriberProcessID: processID,
+				InitiatingDevice:    bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+				MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+				TimeRemaining:       3599,
+				Values: []apdu.PropertyValue{{
+					PropertyReference: apdu.PropertyReference{Property: bacnet.PropertyIdentifierPresentValue},
+					Values:            []apdu.TagType{value},
+				}},
+			}
+		}
+		// The one for another subscriber is ignored
+		for _, processID := range []uint{19, 18} {
+			msg, err := apdu.NewCOVNotificationMessage(notification(processID))
+			assert.NoError(t, err, "Unexpected error creating notification")
+			assert.NoError(t, conn.sendUnconfirmed(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, nil,
+				npdu.NormalMessage, 0, msg), "Unable to send notification")
+		}
+		select {
+		case received := <-manager.Notifications():
+			assert.Equal(t, notification(18), received, "Unexpected notification")
+		case <-time.After(time.Second):
+			assert.Fail(t, "Notification was not delivered")
+		}
+	})
+
+	t.Run("resubscribe", func(t *testing.T) {
+		// Another device restarting doesn't matter, but ours does
+		for _, instance := range []uint32{99, 1234} {
+			iAm, err := apdu.NewIAmMessage(uint32(bacnet.ObjectTypeDevice), instance, 1476, apdu.SegmentationNone,
+				260)
+			assert.NoError(t, err, "Unexpected error creating IAm")
+			assert.NoError(t, conn.sendUnconfirmed(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, nil,
+				npdu.NormalMessage, npdu.NetworkLayerIAmMessage, iAm), "Unable to send IAm")
+		}
+		assert.NotNil(t, nextSubscribe(t, device, time.Second), "Not resubscribed after the IAm")
+		assert.Nil(t, nextSubscribe(t, device, 200*time.Millisecond), "Resubscribed more than once")
+	})
+
+	select {
+	case err := <-manager.Errors():
+		assert.NoError(t, err, "Unexpected error")
+	default:
+	}
+}
+
+func TestNewCOVManagerErrors(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	defer func() {
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+	testCases := []struct {
+		name     string
+		lifetime time.Duration
+	}{
+		{"zero", 0},
+		{"negative", -time.Second},
+		// The renewal interval would round down to nothing
+		{"nanosecond", time.Nanosecond},
+		{"under a second", 999 * time.Millisecond},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			_, err := NewCOVManager(conn, 18, tCase.lifetime)
+			assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected an invalid lifetime")
+		})
+	}
+	_, err := NewCOVManager(conn, 18, time.Second)
+	assert.NoError(t, err, "Unexpected error for a lifetime of a second")
+}
+
+type (
+	// blockingCOVConnection acks the first subscription, and holds the renewals until release is closed. It
+	// reports whether each renewal was released, or gave up. The watched messages are sent on msgs.
+	blockingCOVConnection struct {
+		msgs     chan apdu.Message
+		done     chan struct{}
+		release  chan struct{}
+		renewing chan struct{}
+		renewed  chan error
+		calls    int32
+	}
+)
+
+var _ COVConnection = (*blockingCOVConnection)(nil)
+
+func (c *blockingCOVConnection) SendAndReceive(ctx context.Context, dest net.IP, msg *apdu.ConfirmedMessage) (
+	apdu.Message, error) {
+	ack := apdu.NewSimpleAck(msg.InvokeID, apdu.ServiceConfirmedSubscribeCOVProperty)
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		return ack, nil
+	}
+	select {
+	case c.renewing <- struct{}{}:
+	default:
+	}
+	var err error
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	select {
+	case c.renewed <- err:
+	default:
+	}
+	return ack, err
+}
+
+func (c *blockingCOVConnection) Watch(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int) (
+	<-chan apdu.Message, <-chan struct{}, func()) {
+	return c.msgs, c.done, func() {}
+}
+
+func TestCOVManagerSlowRenewal(t *testing.T) {
+	conn := &blockingCOVConnection{
+		msgs:     make(chan apdu.Message),
+		done:     make(chan struct{}),
+		release:  make(chan struct{}),
+		renewing: make(chan struct{}, 1),
+		renewed:  make(chan error, 1),
+	}
+	// The renewal can be held for up to half of the lifetime
+	manager, err := NewCOVManager(conn, 18, 2*time.Second, COVSubscription{
+		Device:         net.IPv4(127, 0, 0, 1),
+		DeviceInstance: 1234,
+		Object:         bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+		Property:       bacnet.PropertyIdentifierPresentValue,
+	})
+	assert.NoError(t, err, "Unexpected error creating manager")
+	assert.NoError(t, manager.Start(context.Background()), "Unexpected error starting manager")
+	defer manager.Stop()
+
+	select {
+	case <-conn.renewing:
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "Subscription was not renewed")
+	}
+
+	// More notifications than the buffers hold are delivered while the renewal is waiting
+	msg, err := apdu.NewCOVNotificationMessage(&apdu.COVNotification{
+		SubscriberProcessID: 18,
+		InitiatingDevice:    bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234},
+		MonitoredObject:     bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+	})
+	assert.NoError(t, err, "Unexpected error creating notification")
+	for i := 0; i < 2*covManagerBuffer; i++ {
+		conn.msgs <- msg
+		<-manager.Notifications()
+	}
+	close(conn.release)
+	select {
+	case err := <-conn.renewed:
+		assert.NoError(t, err, "The renewal gave up before the notifications were delivered")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Renewal did not finish")
+	}
+}
+
+type (
+	// deadDeviceCOVConnection acks the first subscription to each device. After that, the device at dead
+	// doesn't answer, and gaveUp is closed when its renewal times out. The renewals of the others are sent on
+	// renewed.
+	deadDeviceCOVConnection struct {
+		dead       net.IP
+		subscribed sync.Map
+		renewed    chan net.IP
+		gaveUp     chan struct{}
+		gaveUpOnce sync.Once
+	}
+)
+
+var _ COVConnection = (*deadDeviceCOVConnection)(nil)
+
+func (c *deadDeviceCOVConnection) SendAndReceive(ctx context.Context, dest net.IP, msg *apdu.ConfirmedMessage) (
+	apdu.Message, error) {
+	ack := apdu.NewSimpleAck(msg.InvokeID, apdu.ServiceConfirmedSubscribeCOVProperty)
+	if _, renewal := c.subscribed.LoadOrStore(dest.String(), true); !renewal {
+		return ack, nil
+	}
+	if dest.Equal(c.dead) {
+		<-ctx.Done()
+		c.gaveUpOnce.Do(func() { close(c.gaveUp) })
+		return nil, ctx.Err()
+	}
+	select {
+	case c.renewed <- dest:
+	default:
+	}
+	return ack, nil
+}
+
+func (c *deadDeviceCOVConnection) Watch(matches func(sender *net.UDPAddr, msg apdu.Message) bool, size int) (
+	<-chan apdu.Message, <-chan struct{}, func()) {
+	return make(chan apdu.Message), make(chan struct{}), func() {}
+}
+
+func TestCOVManagerDeadDevice(t *testing.T) {
+	dead, live := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	conn := &deadDeviceCOVConnection{dead: dead, renewed: make(chan net.IP, 1), gaveUp: make(chan struct{})}
+	subscription := func(device net.IP, instance uint32) COVSubscription {
+		return COVSubscription{
+			Device:         device,
+			DeviceInstance: instance,
+			Object:         bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1},
+			Property:       bacnet.PropertyIdentifierPresentValue,
+		}
+	}
+	// The dead device is first, so renewing one at a time would wait for it
+	manager, err := NewCOVManager(conn, 18, time.Second, subscription(dead, 1), subscription(live, 2))
+	assert.NoError(t, err, "Unexpected error creating manager")
+	assert.NoError(t, manager.Start(context.Background()), "Unexpected error starting manager")
+	defer manager.Stop()
+
+	select {
+	case renewed := <-conn.renewed:
+		assert.True(t, live.Equal(renewed), "Unexpected device renewed")
+		select {
+		case <-conn.gaveUp:
+			assert.Fail(t, "The live device was renewed after the dead one timed out")
+		default:
+		}
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "The live device was not renewed")
+	}
+
+	select {
+	case err := <-manager.Errors():
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "Expected the dead device to time out")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "No error for the dead device")
+	}
+}
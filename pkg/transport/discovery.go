@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// whoIsResponseBuffer is how many IAms can arrive at once before they are dropped. We read them as they come,
+// so this is only for bursts.
+const whoIsResponseBuffer = 64
+
+// WhoIs broadcasts a WhoIs for the devices with instances from low to high, and returns the IAms that are
+// received until the context is done. For all devices, use 0 and apdu.MaxInstanceNumber. IAms for devices
+// outside of the range are ignored, like apdu.WhoIs.Matches says, as are repeats from the same device. If the
+// connection is stopped first, it returns the IAms so far with ErrConnectionClosed.
+func (c *connection) WhoIs(ctx context.Context, low, high uint) ([]apdu.IAm, error) {
+	if low > high {
+		return nil, fmt.Errorf("WhoIs range %d to %d: %w", low, high, bacnet.ErrInvalidData)
+	}
+	whoIsRange := apdu.WhoIs{Low: low, High: high}
+	whoIs, err := apdu.NewWhoisMessage(low, high)
+	if err != nil {
+		return nil, err
+	}
+	waiter := c.addBufferedWaiter(func(sender *net.UDPAddr, msg apdu.Message) bool {
+		iAm, ok := msg.(*apdu.UnconfirmedMessage)
+		return ok && iAm.ServiceID == apdu.ServiceUnconfirmedIAm
+	}, whoIsResponseBuffer)
+	defer c.removeWaiter(waiter)
+
+	if err := c.SendUnconfirmedMessage(nil, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage,
+		whoIs); err != nil {
+		return nil, err
+	}
+
+	var iAms []apdu.IAm
+	seen := make(map[uint32]bool)
+	for {
+		select {
+		case msg := <-waiter.ch:
+			iAm, err := apdu.NewIAmFromMessage(msg.(*apdu.UnconfirmedMessage))
+			if err != nil || iAm.DeviceID.Type != bacnet.ObjectTypeDevice {
+				continue
+			}
+			instance := iAm.DeviceID.Instance
+			if !whoIsRange.Matches(instance) || seen[instance] {
+				continue
+			}
+			seen[instance] = true
+			iAms = append(iAms, *iAm)
+		case <-waiter.closed:
+			return iAms, ErrConnectionClosed
+		case <-ctx.Done():
+			return iAms, nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// devicesResponder answers a WhoIs with an IAm for each of its instances, whether they are in the range
+	// or not, like a misbehaving device would.
+	devicesResponder struct {
+		conn      *connection
+		instances []uint32
+	}
+)
+
+var _ MessageRouter = (*devicesResponder)(nil)
+
+func (r *devicesResponder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	whoIs, ok := npduMsg.APDU.(*apdu.UnconfirmedMessage)
+	if !ok || whoIs.ServiceID != apdu.ServiceUnconfirmedWhoIs {
+		return nil
+	}
+	for _, instance := range r.instances {
+		iAm, err := apdu.NewDefaultIAmMessage(instance, apdu.SegmentationNone, 999)
+		if err != nil {
+			return err
+		}
+		if err := r.conn.sendUnconfirmed(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, nil,
+			npdu.NormalMessage, npdu.NetworkLayerIAmMessage, iAm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWhoIs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		low         uint
+		high        uint
+		expected    []uint32
+		expectedErr error
+	}{
+		{"range", 0, 100, []uint32{10, 20}, nil},
+		// The unconfigured device isn't a real instance, so it isn't in the full range
+		{"global", 0, apdu.MaxInstanceNumber, []uint32{10, 500, 20}, nil},
+		{"none", 1000, 2000, nil, nil},
+		{"inverted", 100, 0, nil, bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t)
+			// 10 answers twice, which should only be counted once
+			conn.SetMessageRouter(&devicesResponder{conn: conn,
+				instances: []uint32{10, 500, 20, 10, apdu.MaxInstanceNumber}})
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			iAms, err := conn.WhoIs(ctx, tCase.low, tCase.high)
+			assert.Empty(t, conn.waiters, "Waiter was not removed")
+			if tCase.expectedErr != nil {
+				assert.ErrorIs(t, err, tCase.expectedErr, "Expected an error")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error")
+			var instances []uint32
+			for _, iAm := range iAms {
+				assert.Equal(t, bacnet.ObjectType(bacnet.ObjectTypeDevice), iAm.DeviceID.Type, "Not a device")
+				instances = append(instances, iAm.DeviceID.Instance)
+			}
+			assert.Equal(t, tCase.expected, instances, "Unexpected devices")
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"net"
+	"time"
+)
+
+type (
+	// duplicateFilter remembers the NPDUs that were received recently, with the B/IP address of the device that
+	// sent them, so the same NPDU from the same device can be dropped if it arrives again. It is only used by
+	// the receive loop, so it isn't locked.
+	duplicateFilter struct {
+		window time.Duration
+		seen   map[[sha256.Size]byte]time.Time
+		// swept is when the expired entries were last removed
+		swept time.Time
+	}
+)
+
+// WithDuplicateWindow drops a broadcast NPDU that is exactly the same as one that was received from the same
+// device within the window. On networks with BBMDs, the same broadcast can arrive more than once, directly and
+// forwarded by the BBMD. Unicasts are never dropped, since a retransmitted confirmed request has the same
+// bytes. It's off by default, since a device may legitimately send the same message twice, like an
+// unconfirmed COV notification.
+func WithDuplicateWindow(window time.Duration) ConnectionOption {
+	return func(c *connection) {
+		if window > 0 {
+			c.duplicates = newDuplicateFilter(window)
+		}
+	}
+}
+
+func newDuplicateFilter(window time.Duration) *duplicateFilter {
+	return &duplicateFilter{
+		window: window,
+		seen:   make(map[[sha256.Size]byte]time.Time),
+		swept:  time.Now(),
+	}
+}
+
+// isDuplicate checks if the NPDU in the message was seen from the same device within the window, and
+// remembers it if it wasn't. The window starts from the first time it was seen, so repeats don't extend it.
+// Only broadcasts are checked. The expired NPDUs are removed at most once per window, so they don't pile up.
+func (f *duplicateFilter) isDuplicate(sender *net.UDPAddr, msg *BVLCMessage) bool {
+	key, ok := duplicateKey(sender, msg)
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(f.swept) > f.window {
+		for seenKey, seenAt := range f.seen {
+			if now.Sub(seenAt) > f.window {
+				delete(f.seen, seenKey)
+			}
+		}
+		f.swept = now
+	}
+	if seenAt, ok := f.seen[key]; ok && now.Sub(seenAt) <= f.window {
+		return true
+	}
+	f.seen[key] = now
+	return false
+}
+
+// duplicateKey hashes the B/IP address of the device that sent the NPDU with the NPDU. A Forwarded-NPDU has
+// the address of the device in front of the NPDU, so a broadcast forwarded by a BBMD has the same key as the
+// Original-Broadcast-NPDU that we received directly from the device. Only the broadcasts have a key.
+func duplicateKey(sender *net.UDPAddr, msg *BVLCMessage) ([sha256.Size]byte, bool) {
+	var origin, npduBytes []byte
+	switch msg.Function {
+	case BVLCFunctioncForwardedNPDU:
+		if len(msg.Data) < bipAddressLength {
+			return [sha256.Size]byte{}, false
+		}
+		origin, npduBytes = msg.Data[:bipAddressLength], msg.Data[bipAddressLength:]
+	case BVLCFunctioncBroadcast:
+		if sender == nil {
+			return [sha256.Size]byte{}, false
+		}
+		var err error
+		if origin, err = encodeBIPAddress(sender); err != nil {
+			return [sha256.Size]byte{}, false
+		}
+		npduBytes = msg.Data
+	default:
+		return [sha256.Size]byte{}, false
+	}
+	hash := sha256.New()
+	hash.Write(origin)
+	hash.Write(npduBytes)
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key, true
+}
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+)
+
+type (
+	// whoIsRecorder sends the low limit of each WhoIs that is routed to it.
+	whoIsRecorder struct {
+		lows chan uint
+	}
+)
+
+var _ MessageRouter = (*whoIsRecorder)(nil)
+
+func (r *whoIsRecorder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.UnconfirmedMessage)
+	if !ok || msg.ServiceID != apdu.ServiceUnconfirmedWhoIs {
+		return nil
+	}
+	whoIs, err := apdu.NewWhoIsFromMessage(msg)
+	if err != nil {
+		return err
+	}
+	r.lows <- whoIs.Low
+	return nil
+}
+
+func TestDuplicateWindow(t *testing.T) {
+	const (
+		repeatedLow = 1
+		markerLow   = 2
+	)
+	testCases := []struct {
+		name     string
+		opts     []ConnectionOption
+		expected int
+	}{
+		{"default", nil, 2},
+		{"window", []ConnectionOption{WithDuplicateWindow(time.Minute)}, 1},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t, tCase.opts...)
+			recorder := &whoIsRecorder{lows: make(chan uint, 4)}
+			conn.SetMessageRouter(recorder)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			for _, low := range []uint{repeatedLow, repeatedLow, markerLow} {
+				whoIs, err := apdu.NewWhoisMessage(low, 100)
+				assert.NoError(t, err, "Unable to create WhoIs")
+				assert.NoError(t, conn.SendUnconfirmedMessage(nil, npdu.NormalMessage,
+					npdu.NetworkLayerWhoIsMessage, whoIs), "Unable to send WhoIs")
+			}
+
+			// The datagrams are received in order, so once we have the marker, we have all of the repeats.
+			repeats := 0
+			timeout := time.After(5 * time.Second)
+			for done := false; !done; {
+				select {
+				case low := <-recorder.lows:
+					if low == markerLow {
+						done = true
+					} else {
+						repeats++
+					}
+				case <-timeout:
+					assert.Fail(t, "Timed out waiting for the WhoIs")
+					return
+				}
+			}
+			assert.Equal(t, tCase.expected, repeats, "Unexpected number of WhoIs handled")
+		})
+	}
+}
+
+func TestDuplicateOrigin(t *testing.T) {
+	device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: DefaultPort}
+	other := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 21), Port: DefaultPort}
+	bbmd := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: DefaultPort}
+	whoIs, err := apdu.NewWhoisMessage(1, 100)
+	assert.NoError(t, err, "Unable to create WhoIs")
+	npduBytes, err := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount,
+		npdu.NetworkLayerWhoIsMessage, nil, whoIs).Encode()
+	assert.NoError(t, err, "Unable to encode NPDU")
+	deviceAddr, err := encodeBIPAddress(device)
+	assert.NoError(t, err, "Unable to encode B/IP address")
+	forwarded := NewBVLCMessage(BVLCFunctioncForwardedNPDU, append(deviceAddr, npduBytes...))
+	broadcast := NewBVLCMessage(BVLCFunctioncBroadcast, npduBytes)
+	unicast := NewBVLCMessage(BVLCFunctioncUnicast, npduBytes)
+
+	type received struct {
+		sender *net.UDPAddr
+		msg    *BVLCMessage
+	}
+	testCases := []struct {
+		name     string
+		received []received
+		expected []bool
+	}{
+		{"direct and forwarded by a BBMD", []received{{device, broadcast}, {bbmd, forwarded}}, []bool{false, true}},
+		{"forwarded first", []received{{bbmd, forwarded}, {device, broadcast}}, []bool{false, true}},
+		{"same bytes from two devices", []received{{device, broadcast}, {other, broadcast}}, []bool{false, false}},
+		{"repeated", []received{{device, broadcast}, {device, broadcast}}, []bool{false, true}},
+		// A client retransmitting a confirmed request sends the same bytes
+		{"repeated unicast", []received{{device, unicast}, {device, unicast}}, []bool{false, false}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			filter := newDuplicateFilter(time.Minute)
+			for i, r := range tCase.received {
+				assert.Equal(t, tCase.expected[i], filter.isDuplicate(r.sender, r.msg),
+					"Unexpected duplicate check for message %d", i)
+			}
+		})
+	}
+}
+
+func TestSelfFilter(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []ConnectionOption
+		// sender is the source address of what we receive, since the memory connection reports its own address
+		sender   net.IP
+		expected bool
+	}{
+		{"loopback by default", nil, net.IPv4(127, 0, 0, 1), true},
+		{"own address dropped", []ConnectionOption{WithSelfFilter()}, net.IPv4(127, 0, 0, 1), false},
+		{"other address kept", []ConnectionOption{WithSelfFilter()}, net.IPv4(127, 0, 0, 2), true},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			packetConn := NewMemoryPacketConn(&net.UDPAddr{IP: tCase.sender, Port: DefaultPort})
+			c, err := NewConnection([]byte{127, 0, 0, 1}, 8, append(tCase.opts, WithPacketConn(packetConn))...)
+			assert.NoError(t, err, "Unexpected error creating connection")
+			conn := c.(*connection)
+			recorder := &whoIsRecorder{lows: make(chan uint, 1)}
+			conn.SetMessageRouter(recorder)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			whoIs, err := apdu.NewWhoisMessage(1, 100)
+			assert.NoError(t, err, "Unable to create WhoIs")
+			assert.NoError(t, conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, npdu.NetworkLayerWhoIsMessage,
+				whoIs), "Unable to send WhoIs")
+
+			// A dropped datagram never shows up, so we can only wait a while for it.
+			select {
+			case <-recorder.lows:
+				assert.True(t, tCase.expected, "WhoIs from our own address was handled")
+			case <-time.After(200 * time.Millisecond):
+				assert.False(t, tCase.expected, "WhoIs was dropped")
+			}
+		})
+	}
+}
+
+func TestDuplicateExpiry(t *testing.T) {
+	const window = 10 * time.Millisecond
+	device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: DefaultPort}
+	broadcast := func(low uint) *BVLCMessage {
+		whoIs, err := apdu.NewWhoisMessage(low, 100)
+		assert.NoError(t, err, "Unable to create WhoIs")
+		npduBytes, err := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount,
+			npdu.NetworkLayerWhoIsMessage, nil, whoIs).Encode()
+		assert.NoError(t, err, "Unable to encode NPDU")
+		return NewBVLCMessage(BVLCFunctioncBroadcast, npduBytes)
+	}
+
+	filter := newDuplicateFilter(window)
+	assert.False(t, filter.isDuplicate(device, broadcast(1)), "New broadcast was a duplicate")
+	assert.False(t, filter.isDuplicate(device, broadcast(2)), "New broadcast was a duplicate")
+	time.Sleep(2 * window)
+
+	// Seeing one again after the window is not a duplicate, and the expired ones are removed
+	assert.False(t, filter.isDuplicate(device, broadcast(1)), "Expired broadcast was a duplicate")
+	assert.Len(t, filter.seen, 1, "Expired broadcasts were not removed")
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// A BBMD keeps a foreign device table (FDT) of the devices that registered with it from other networks, so
+// that it can forward broadcasts to them (J.5.2 in the spec). Read-Foreign-Device-Table has no data, and the
+// ack is the list of entries. Delete-Foreign-Device-Table-Entry has the B/IP address of the entry to delete.
+// Each entry is:
+// - B/IP address (6 bytes: the IP and port)
+// - Time-to-Live that the device registered with, in seconds (2 bytes)
+// - Remaining time until the BBMD deletes the entry, in seconds (2 bytes), which includes a grace period of
+//   30 seconds
+
+const (
+	// bipAddressLength is the length of a B/IP address: the 4 byte IP and the 2 byte port.
+	bipAddressLength = 6
+	// fdtEntryLength is the length of an entry in the Read-Foreign-Device-Table-Ack.
+	fdtEntryLength = bipAddressLength + 4
+)
+
+// ForeignDeviceTableEntry is a device that is registered with a BBMD.
+type ForeignDeviceTableEntry struct {
+	Address *net.UDPAddr
+	// TTL is the time to live that the device registered with, in seconds.
+	TTL uint16
+	// Remaining is the time until the entry is deleted if the device doesn't register again, in seconds.
+	Remaining uint16
+}
+
+// NewReadForeignDeviceTableMessage creates the request for the foreign device table of a BBMD.
+func NewReadForeignDeviceTableMessage() *BVLCMessage {
+	return NewBVLCMessage(BVLCFunctioncReadForeignDeviceTable, nil)
+}
+
+// NewReadForeignDeviceTableAckMessage creates the ack with the entries of the foreign device table.
+func NewReadForeignDeviceTableAckMessage(entries []ForeignDeviceTableEntry) (*BVLCMessage, error) {
+	data := make([]byte, 0, len(entries)*fdtEntryLength)
+	for _, entry := range entries {
+		addr, err := encodeBIPAddress(entry.Address)
+		if err != nil {
+			return nil, err
+		}
+		times := make([]byte, 4)
+		binary.BigEndian.PutUint16(times, entry.TTL)
+		binary.BigEndian.PutUint16(times[2:], entry.Remaining)
+		data = append(append(data, addr...), times...)
+	}
+	return NewBVLCMessage(BVLCFunctioncReadForeignDeviceTableAck, data), nil
+}
+
+// DecodeForeignDeviceTableAck gets the entries of the foreign device table from the ack.
+func DecodeForeignDeviceTableAck(msg *BVLCMessage) ([]ForeignDeviceTableEntry, error) {
+	if msg.Function != BVLCFunctioncReadForeignDeviceTableAck {
+		return nil, bacnet.ErrInvalidData
+	}
+	if len(msg.Data)%fdtEntryLength != 0 {
+		return nil, fmt.Errorf("foreign device table of %d bytes isn't whole entries: %w", len(msg.Data),
+			bacnet.ErrInvalidData)
+	}
+	entries := make([]ForeignDeviceTableEntry, 0, len(msg.Data)/fdtEntryLength)
+	for data := msg.Data; len(data) > 0; data = data[fdtEntryLength:] {
+		entries = append(entries, ForeignDeviceTableEntry{
+			Address:   decodeBIPAddress(data),
+			TTL:       binary.BigEndian.Uint16(data[bipAddressLength:]),
+			Remaining: binary.BigEndian.Uint16(data[bipAddressLength+2:]),
+		})
+	}
+	return entries, nil
+}
+
+// NewDeleteForeignDeviceTableEntryMessage creates the request to delete the entry of the device at the
+// address from the foreign device table of a BBMD.
+func NewDeleteForeignDeviceTableEntryMessage(addr *net.UDPAddr) (*BVLCMessage, error) {
+	data, err := encodeBIPAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewBVLCMessage(BVLCFunctioncDeleteForeignDeviceTableEntry, data), nil
+}
+
+// DecodeDeleteForeignDeviceTableEntry gets the address of the entry to delete from the request.
+func DecodeDeleteForeignDeviceTableEntry(msg *BVLCMessage) (*net.UDPAddr, error) {
+	if msg.Function != BVLCFunctioncDeleteForeignDeviceTableEntry || len(msg.Data) != bipAddressLength {
+		return nil, bacnet.ErrInvalidData
+	}
+	return decodeBIPAddress(msg.Data), nil
+}
+
+// encodeBIPAddress encodes the IPv4 address and port as a B/IP address.
+func encodeBIPAddress(addr *net.UDPAddr) ([]byte, error) {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("B/IP address %v isn't IPv4: %w", addr.IP, bacnet.ErrInvalidData)
+	}
+	data := make([]byte, bipAddressLength)
+	copy(data, ip)
+	binary.BigEndian.PutUint16(data[net.IPv4len:], uint16(addr.Port))
+	return data, nil
+}
+
+// decodeBIPAddress decodes the B/IP address at the start of the data, which must be long enough.
+func decodeBIPAddress(data []byte) *net.UDPAddr {
+	return &net.UDPAddr{
+		IP:   net.IP(append([]byte{}, data[:net.IPv4len]...)),
+		Port: int(binary.BigEndian.Uint16(data[net.IPv4len:])),
+	}
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestDeleteForeignDeviceTableEntry(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IP{192, 168, 3, 16}, Port: DefaultPort}
+	msg, err := NewDeleteForeignDeviceTableEntryMessage(addr)
+	assert.NoError(t, err, "Unexpected error creating the request")
+	encoded, err := msg.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, []byte{0x81, 0x08, 0x00, 0x0A, 192, 168, 3, 16, 0xBA, 0xC0}, encoded, "Unexpected encoding")
+
+	decoded, err := NewBVLCMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding")
+	deleted, err := DecodeDeleteForeignDeviceTableEntry(decoded)
+	assert.NoError(t, err, "Unexpected error decoding the request")
+	assert.Equal(t, addr, deleted, "Unexpected address")
+
+	_, err = NewDeleteForeignDeviceTableEntryMessage(&net.UDPAddr{IP: net.IPv6loopback, Port: DefaultPort})
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for an IPv6 address")
+}
+
+func TestForeignDeviceTableAck(t *testing.T) {
+	encoded := []byte{
+		0x81, 0x07, 0x00, 0x18,
+		192, 168, 3, 16, 0xBA, 0xC0, 0x00, 0x3C, 0x00, 0x5A,
+		10, 0, 0, 7, 0xBA, 0xC1, 0x01, 0x2C, 0x00, 0x1E,
+	}
+	expected := []ForeignDeviceTableEntry{
+		{Address: &net.UDPAddr{IP: net.IP{192, 168, 3, 16}, Port: 0xBAC0}, TTL: 60, Remaining: 90},
+		{Address: &net.UDPAddr{IP: net.IP{10, 0, 0, 7}, Port: 0xBAC1}, TTL: 300, Remaining: 30},
+	}
+
+	msg, err := NewBVLCMessageFromBytes(encoded)
+	assert.NoError(t, err, "Unexpected error decoding")
+	entries, err := DecodeForeignDeviceTableAck(msg)
+	assert.NoError(t, err, "Unexpected error decoding the table")
+	assert.Equal(t, expected, entries, "Unexpected entries")
+
+	ack, err := NewReadForeignDeviceTableAckMessage(expected)
+	assert.NoError(t, err, "Unexpected error creating the ack")
+	reencoded, err := ack.Encode()
+	assert.NoError(t, err, "Unexpected error encoding")
+	assert.Equal(t, encoded, reencoded, "Unexpected encoding")
+
+	read, err := NewReadForeignDeviceTableMessage().Encode()
+	assert.NoError(t, err, "Unexpected error encoding the read")
+	assert.Equal(t, []byte{0x81, 0x06, 0x00, 0x04}, read, "Unexpected encoding of the read")
+
+	_, err = DecodeForeignDeviceTableAck(NewBVLCMessage(BVLCFunctioncReadForeignDeviceTableAck, encoded[4:13]))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected error for a partial entry")
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// FileWriter is an io.Writer to a file object on a device. Each Write is sent as one or more
+	// AtomicWriteFile requests, split so that each request fits in the APDU. Writes are not buffered, so
+	// wrap it in a bufio.Writer to avoid many small requests.
+	FileWriter struct {
+		ctx       context.Context
+		conn      Connection
+		dest      net.IP
+		file      bacnet.ObjectID
+		position  int
+		chunkSize int
+	}
+)
+
+// NewFileWriter creates a writer to the file on the device at dest, starting at the start position. maxLength
+// is the APDU length negotiated with the device, which is the smaller of ours and what it accepts. A reserved
+// length, which has no room for file data, is ErrInvalidData.
+func NewFileWriter(ctx context.Context, conn Connection, dest net.IP, file bacnet.ObjectID, start int,
+	maxLength apdu.MaxAPDULength) (*FileWriter, error) {
+	chunkSize := apdu.MaxAtomicWriteFileData(maxLength)
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("max APDU length %d has no room for file data: %w", maxLength, bacnet.ErrInvalidData)
+	}
+	return &FileWriter{
+		ctx:       ctx,
+		conn:      conn,
+		dest:      dest,
+		file:      file,
+		position:  start,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+// Write sends p to the file. The position for the next write is the start position from the device's ack,
+// plus what was written, since the device may not write where we asked (e.g. appending).
+func (w *FileWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > w.chunkSize {
+			chunk = chunk[:w.chunkSize]
+		}
+		msg, err := apdu.NewAtomicWriteFileMessage(0, w.file, w.position, chunk)
+		if err != nil {
+			return written, err
+		}
+		resp, err := w.conn.SendAndReceive(w.ctx, w.dest, msg)
+		if err != nil {
+			return written, err
+		}
+		ack, ok := resp.(*apdu.ComplexAckMessage)
+		if !ok || ack.ServiceID != apdu.ServiceConfirmedAtomicWriteFile {
+			return written, fmt.Errorf("unexpected response to AtomicWriteFile: %w", bacnet.ErrInvalidData)
+		}
+		start, err := apdu.NewAtomicWriteFileAckFromBytes(ack.ServiceData)
+		if err != nil {
+			return written, err
+		}
+		w.position = start + len(chunk)
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Position returns where the next write will start.
+func (w *FileWriter) Position() int {
+	return w.position
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// fileResponder acts as a device with a file object, writing the data from AtomicWriteFile requests that
+	// loop back and acking them.
+	fileResponder struct {
+		conn     *connection
+		contents []byte
+		// requestSizes are the encoded sizes of the requests
+		requestSizes []int
+	}
+)
+
+var _ MessageRouter = (*fileResponder)(nil)
+
+func (r *fileResponder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.ConfirmedMessage)
+	if !ok || msg.ServiceID != apdu.ServiceConfirmedAtomicWriteFile {
+		return nil
+	}
+	encoded, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	r.requestSizes = append(r.requestSizes, len(encoded))
+
+	req, err := apdu.NewAtomicWriteFileRequestFromBytes(msg.ServiceData)
+	if err != nil {
+		return err
+	}
+	start := req.Start
+	if start == -1 {
+		start = len(r.contents)
+	}
+	if end := start + len(req.Data); end > len(r.contents) {
+		r.contents = append(r.contents, make([]byte, end-len(r.contents))...)
+	}
+	copy(r.contents[start:], req.Data)
+
+	ack, err := apdu.NewAtomicWriteFileAckMessage(msg.InvokeID, start)
+	if err != nil {
+		return err
+	}
+	ackMsg := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount, 0, nil, ack)
+	return r.conn.send(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, ackMsg)
+}
+
+func TestFileWriter(t *testing.T) {
+	const writeSize = 512
+	stream := make([]byte, 10*1024)
+	for i := range stream {
+		stream[i] = byte(i)
+	}
+	file := bacnet.ObjectID{Type: bacnet.ObjectTypeFile, Instance: 1}
+
+	testCases := []struct {
+		name      string
+		maxLength apdu.MaxAPDULength
+		// requests is the number of requests for each write
+		requests int
+	}{
+		{"whole writes", apdu.MaxAPDULength1476, 1},
+		{"split writes", apdu.MaxAPDULength480, 2},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t)
+			responder := &fileResponder{conn: conn}
+			conn.SetMessageRouter(responder)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			writer, err := NewFileWriter(ctx, conn, net.IPv4(127, 0, 0, 1), file, 0, tCase.maxLength)
+			assert.NoError(t, err, "Unexpected error creating writer")
+			reader := bytes.NewReader(stream)
+			buf := make([]byte, writeSize)
+			for {
+				n, _ := reader.Read(buf)
+				if n == 0 {
+					break
+				}
+				written, err := writer.Write(buf[:n])
+				assert.NoError(t, err, "Unexpected error writing")
+				assert.Equal(t, n, written, "Not everything was written")
+			}
+
+			assert.Equal(t, len(stream), writer.Position(), "Unexpected final position")
+			assert.Equal(t, stream, responder.contents, "File contents do not match")
+			assert.Equal(t, len(stream)/writeSize*tCase.requests, len(responder.requestSizes),
+				"Unexpected number of requests")
+			for _, size := range responder.requestSizes {
+				assert.LessOrEqual(t, uint(size), tCase.maxLength.Bytes(), "Request larger than the APDU")
+			}
+		})
+	}
+}
+
+func TestNewFileWriterInvalidLength(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	defer func() {
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+	file := bacnet.ObjectID{Type: bacnet.ObjectTypeFile, Instance: 1}
+	writer, err := NewFileWriter(context.Background(), conn, net.IPv4(127, 0, 0, 1), file, 0, apdu.MaxAPDULength(7))
+	assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected an error for a reserved length")
+	assert.Nil(t, writer, "Unexpected writer")
+}
@@ -1,6 +1,8 @@
 package transport
 
 import (
+	"context"
+
 	"github.com/shigmas/modore/internal/apdu"
 	"github.com/shigmas/modore/internal/npdu"
 )
@@ -66,11 +68,24 @@ type (
 		*NPDUMessageHandlerBase
 		npduChannel NPDUMessageChannel
 	}
+
+	// EphemeralHandler collects APDU messages for a limited time, such as the IAm responses to a WhoIs.
+	// Register it for the message types to collect, and unregister it with UnregisterAPDUHandler when it's
+	// done, since the nexus blocks on a handler whose buffer is full. Messages that arrive when nobody is
+	// collecting are buffered, up to the limit.
+	EphemeralHandler struct {
+		ch  APDUMessageChannel
+		max int
+	}
 )
 
+// defaultEphemeralBuffer is the buffer size of an EphemeralHandler with no limit
+const defaultEphemeralBuffer = 16
+
 var (
 	_ BVLCMessageHandler = (*NPDUMessageHandlerBase)(nil)
 	_ BVLCMessageHandler = (*APDUMessageHandlerBase)(nil)
+	_ APDUMessageHandler = (*EphemeralHandler)(nil)
 )
 
 func NewNPDUMessageHandlerBase(c Connection) *NPDUMessageHandlerBase {
@@ -98,3 +113,42 @@ func (h *APDUMessageHandlerBase) NewAPDUMessageHandlerBase(c Connection) *APDUMe
 func (h *APDUMessageHandlerBase) GetNPDUChannel() NPDUMessageChannel {
 	return h.npduChannel
 }
+
+// NewEphemeralHandler creates an EphemeralHandler that stops collecting after max messages. If max is 0,
+// it collects until the context is done.
+func NewEphemeralHandler(max int) *EphemeralHandler {
+	size := max
+	if size <= 0 {
+		size = defaultEphemeralBuffer
+	}
+	return &EphemeralHandler{
+		ch:  make(APDUMessageChannel, size),
+		max: max,
+	}
+}
+
+func (h *EphemeralHandler) GetAPDUChannel() APDUMessageChannel {
+	return h.ch
+}
+
+func (h *EphemeralHandler) Equals(other Equatable) bool {
+	if o, ok := other.(*EphemeralHandler); ok {
+		return h == o
+	}
+	return false
+}
+
+// CollectUntil returns the messages received until the context is done, or the handler's maximum number of
+// messages is reached. Whatever arrived is returned, which may be nothing.
+func (h *EphemeralHandler) CollectUntil(ctx context.Context) []*apdu.Message {
+	var msgs []*apdu.Message
+	for h.max <= 0 || len(msgs) < h.max {
+		select {
+		case msg := <-h.ch:
+			msgs = append(msgs, msg)
+		case <-ctx.Done():
+			return msgs
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+)
+
+func newTestIAm(t *testing.T, instance uint32) *apdu.Message {
+	iAm, err := apdu.NewIAmMessage(8, instance, 1476, apdu.SegmentationNone, 999)
+	assert.NoError(t, err, "Unexpected error creating IAm")
+	var msg apdu.Message = iAm
+	return &msg
+}
+
+func TestEphemeralHandler(t *testing.T) {
+	t.Run("CollectUntil deadline", func(t *testing.T) {
+		handler := NewEphemeralHandler(0)
+		first := newTestIAm(t, 1)
+		second := newTestIAm(t, 2)
+		go func() {
+			handler.GetAPDUChannel() <- first
+			time.Sleep(10 * time.Millisecond)
+			handler.GetAPDUChannel() <- second
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		msgs := handler.CollectUntil(ctx)
+		assert.Equal(t, []*apdu.Message{first, second}, msgs, "Unexpected messages collected")
+
+		// After the deadline, messages are not collected
+		handler.GetAPDUChannel() <- newTestIAm(t, 3)
+		assert.Equal(t, 2, len(msgs), "Message collected after the deadline")
+	})
+
+	t.Run("CollectUntil max", func(t *testing.T) {
+		handler := NewEphemeralHandler(2)
+		for i := uint32(1); i <= 2; i++ {
+			handler.GetAPDUChannel() <- newTestIAm(t, i)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		start := time.Now()
+		msgs := handler.CollectUntil(ctx)
+		assert.Equal(t, 2, len(msgs), "Unexpected number of messages collected")
+		assert.Less(t, time.Since(start), time.Second, "Should not wait for the deadline")
+	})
+	t.Run("registered with the nexus", func(t *testing.T) {
+		nexus := NewMessageNexus()
+		handler := NewEphemeralHandler(0)
+		// Gets every message, so we know when the one after the deadline was routed
+		other := &testAPDUMessageHandler{ch: make(APDUMessageChannel, 4)}
+		nexus.RegisterAPDUHandler(apdu.ServiceUnconfirmedWhoIs, handler)
+		nexus.RegisterAPDUHandler(apdu.ServiceUnconfirmedWhoIs, other)
+		nexus.Start()
+		defer nexus.Stop()
+
+		routeWhoIs := func(instance uint) {
+			whoIs, err := apdu.NewWhoisMessage(instance, instance)
+			assert.NoError(t, err, "Unexpected error creating WhoIs")
+			npduBytes, err := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount,
+				0, nil, whoIs).Encode()
+			assert.NoError(t, err, "Unexpected error encoding NPDU")
+			assert.NoError(t, nexus.RouteMessage(NewBVLCMessage(BVLCFunctioncBroadcast, npduBytes)),
+				"Unable to route")
+		}
+		routeWhoIs(1)
+		routeWhoIs(2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		msgs := handler.CollectUntil(ctx)
+		assert.Equal(t, 2, len(msgs), "Unexpected number of messages collected")
+		nexus.UnregisterAPDUHandler(handler)
+		assert.Equal(t, []APDUMessageHandler{other}, nexus.OrderedAPDUHandlers(apdu.ServiceUnconfirmedWhoIs),
+			"Handler was not unregistered")
+
+		// After the deadline, the handler doesn't get any messages
+		routeWhoIs(3)
+		for i := 0; i < 3; i++ {
+			select {
+			case <-other.ch:
+			case <-time.After(time.Second):
+				assert.Fail(t, "Timeout waiting for message")
+			}
+		}
+		assert.Empty(t, handler.GetAPDUChannel(), "Message delivered after the handler was unregistered")
+	})
+}
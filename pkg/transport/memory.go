@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+type (
+	// MemoryPacketConn is an in-memory PacketConn that loops every datagram that is written back to be
+	// read, as if it came from its own address. This is for testing without real sockets. If it was created
+	// by a MemoryNetwork, the datagrams go to the other connections on the network instead.
+	MemoryPacketConn struct {
+		addr      *net.UDPAddr
+		network   *MemoryNetwork
+		datagrams chan memoryDatagram
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+
+	// MemoryNetwork connects MemoryPacketConns, so a client and a device can each have their own connection
+	// and address, like on a real network. A datagram goes to the connection with the address it's written
+	// to, or if there isn't one, like for a broadcast address, to every connection on the network, including
+	// the one that wrote it.
+	MemoryNetwork struct {
+		mux   sync.RWMutex
+		conns []*MemoryPacketConn
+	}
+
+	// memoryDatagram is a datagram and the address that it was written from
+	memoryDatagram struct {
+		data []byte
+		from *net.UDPAddr
+	}
+)
+
+var _ PacketConn = (*MemoryPacketConn)(nil)
+
+// memoryPacketConnBuffer is the number of datagrams that can be written before they are read.
+const memoryPacketConnBuffer = 16
+
+// NewMemoryPacketConn creates an in-memory PacketConn. addr is the sender address of the datagrams that are
+// read.
+func NewMemoryPacketConn(addr *net.UDPAddr) *MemoryPacketConn {
+	return &MemoryPacketConn{
+		addr:      addr,
+		datagrams: make(chan memoryDatagram, memoryPacketConnBuffer),
+		closed:    make(chan struct{}),
+	}
+}
+
+// NewMemoryNetwork creates a network without any connections.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{}
+}
+
+// NewPacketConn creates an in-memory PacketConn at addr on the network.
+func (n *MemoryNetwork) NewPacketConn(addr *net.UDPAddr) *MemoryPacketConn {
+	conn := NewMemoryPacketConn(addr)
+	conn.network = n
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.conns = append(n.conns, conn)
+	return conn
+}
+
+// deliver gives the datagram to the connection at addr, or to all of them if none are at addr. Connections
+// that are closed are skipped.
+func (n *MemoryNetwork) deliver(datagram memoryDatagram, addr net.Addr) {
+	n.mux.RLock()
+	var targets []*MemoryPacketConn
+	for _, conn := range n.conns {
+		if conn.addr.String() == addr.String() {
+			targets = append(targets, conn)
+		}
+	}
+	if len(targets) == 0 {
+		targets = n.conns
+	}
+	n.mux.RUnlock()
+
+	for _, conn := range targets {
+		select {
+		case conn.datagrams <- datagram:
+		case <-conn.closed:
+		}
+	}
+}
+
+// ReadFrom blocks until a datagram is written, or the connection is closed.
+func (m *MemoryPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case datagram := <-m.datagrams:
+		return copy(p, datagram.data), datagram.from, nil
+	case <-m.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteTo loops the datagram back, regardless of the address, unless the connection is on a network.
+func (m *MemoryPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	datagram := memoryDatagram{data: make([]byte, len(p)), from: m.addr}
+	copy(datagram.data, p)
+	if m.network != nil {
+		select {
+		case <-m.closed:
+			return 0, net.ErrClosed
+		default:
+		}
+		m.network.deliver(datagram, addr)
+		return len(p), nil
+	}
+	select {
+	case m.datagrams <- datagram:
+		return len(p), nil
+	case <-m.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// LocalAddr returns the address of the connection, which is where the datagrams that it writes are from.
+func (m *MemoryPacketConn) LocalAddr() net.Addr {
+	return m.addr
+}
+
+func (m *MemoryPacketConn) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+const (
+	// objectListAckOverhead is the most that the ReadProperty ack of the object list needs besides the
+	// values: the APDU header, the object ID and property, and the opening and closing tags.
+	objectListAckOverhead = 16
+	// objectIDTagLength is the length of each object ID in the object list
+	objectIDTagLength = 5
+	// maxObjectListLength is the longest object list we believe. It's the number of instances of an object type,
+	// so a device that claims more is broken or lying, and we don't read that many elements.
+	maxObjectListLength = apdu.MaxInstanceNumber
+)
+
+// ReadObjectList reads the object list of the device at dest. It reads the length of the list first, then the
+// whole list if it fits in the APDU length negotiated with the device, or each element if it doesn't. A whole
+// list that doesn't have that length is an error.
+func (c *connection) ReadObjectList(ctx context.Context, dest net.IP, deviceInstance uint) ([]bacnet.ObjectID,
+	error) {
+	device := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: uint32(deviceInstance)}
+	lengthIndex := uint(0)
+	ack, err := c.readProperty(ctx, dest, device, bacnet.PropertyIdentifierObjectList, &lengthIndex)
+	if err != nil {
+		return nil, err
+	}
+	if len(ack.Values) != 1 {
+		return nil, fmt.Errorf("object list length has %d values: %w", len(ack.Values), bacnet.ErrInvalidData)
+	}
+	lengthTag, ok := ack.Values[0].(*apdu.ApplicationUnsignedIntType)
+	if !ok {
+		return nil, fmt.Errorf("object list length is not unsigned: %w", bacnet.ErrInvalidData)
+	}
+	length := lengthTag.Value()
+	if length > maxObjectListLength {
+		return nil, fmt.Errorf("object list length %d: %w", length, bacnet.ErrInvalidData)
+	}
+
+	if objectListAckOverhead+length*objectIDTagLength <= c.negotiatedAPDULength(dest) {
+		ack, err := c.readProperty(ctx, dest, device, bacnet.PropertyIdentifierObjectList, nil)
+		if err == nil {
+			ids, err := objectIDsFromTags(ack.Values)
+			if err != nil {
+				return nil, err
+			}
+			// The list changed between the reads, or the device doesn't agree with itself
+			if uint(len(ids)) != length {
+				return nil, fmt.Errorf("object list has %d objects, but its length is %d: %w", len(ids), length,
+					bacnet.ErrInvalidData)
+			}
+			return ids, nil
+		}
+		// The device may not be able to send it all without segmentation, so fall back to each element.
+	}
+
+	// Not preallocated, since the length is only what the device claims
+	var objects []bacnet.ObjectID
+	for i := uint(1); i <= length; i++ {
+		index := i
+		ack, err := c.readProperty(ctx, dest, device, bacnet.PropertyIdentifierObjectList, &index)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := objectIDsFromTags(ack.Values)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) != 1 {
+			return nil, fmt.Errorf("object list element %d has %d values: %w", i, len(ids), bacnet.ErrInvalidData)
+		}
+		objects = append(objects, ids[0])
+	}
+	return objects, nil
+}
+
+// readProperty reads the property with ReadProperty, and decodes the ack.
+func (c *connection) readProperty(ctx context.Context, dest net.IP, objectID bacnet.ObjectID,
+	property bacnet.PropertyIdentifier, arrayIndex *uint) (*apdu.ReadPropertyAck, error) {
+	msg, err := apdu.NewReadPropertyMessage(0, objectID, property, arrayIndex)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendAndReceive(ctx, dest, msg)
+	if err != nil {
+		return nil, err
+	}
+	ack, ok := resp.(*apdu.ComplexAckMessage)
+	if !ok || ack.ServiceID != apdu.ServiceConfirmedReadProperty {
+		return nil, fmt.Errorf("unexpected response to ReadProperty: %w", bacnet.ErrInvalidData)
+	}
+	return apdu.NewReadPropertyAckFromBytes(ack.ServiceData)
+}
+
+// objectIDsFromTags gets the object IDs from the values of the object list.
+func objectIDsFromTags(tags []apdu.TagType) ([]bacnet.ObjectID, error) {
+	ids := make([]bacnet.ObjectID, 0, len(tags))
+	for _, tag := range tags {
+		idTag, ok := tag.(*apdu.ApplicationObjectIDType)
+		if !ok {
+			return nil, fmt.Errorf("object list has a value that is not an object ID: %w", bacnet.ErrInvalidData)
+		}
+		ids = append(ids, idTag.ObjectID())
+	}
+	return ids, nil
+}
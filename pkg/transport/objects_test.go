@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// objectListResponder acts as a device with the objects, answering ReadProperty requests for its object
+	// list that loop back.
+	objectListResponder struct {
+		conn    *connection
+		device  bacnet.ObjectID
+		objects []bacnet.ObjectID
+		// indexes are the array indexes of the requests, with -1 for the whole array
+		indexes []int
+		// length is the length of the list that is claimed, if it isn't the number of objects
+		length uint
+	}
+)
+
+var _ MessageRouter = (*objectListResponder)(nil)
+
+func (r *objectListResponder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.ConfirmedMessage)
+	if !ok || msg.ServiceID != apdu.ServiceConfirmedReadProperty {
+		return nil
+	}
+	params, err := msg.Parameters()
+	if err != nil {
+		return err
+	}
+	objectID, err := params[0].(*apdu.ContextSpecificRawType).ObjectID()
+	if err != nil {
+		return err
+	}
+	property := bacnet.PropertyIdentifier(params[1].(*apdu.ContextSpecificRawType).Unsigned())
+	if objectID != r.device || property != bacnet.PropertyIdentifierObjectList {
+		return nil
+	}
+
+	ack := apdu.ReadPropertyAck{ObjectID: objectID, Property: property}
+	if len(params) > 2 {
+		index := params[2].(*apdu.ContextSpecificRawType).Unsigned()
+		ack.ArrayIndex = &index
+		r.indexes = append(r.indexes, int(index))
+		if index == 0 {
+			claimed := uint(len(r.objects))
+			if r.length > 0 {
+				claimed = r.length
+			}
+			length, _ := apdu.NewApplicationUnsignedInt(claimed)
+			ack.Values = []apdu.TagType{length}
+		} else {
+			ack.Values = []apdu.TagType{r.objectTag(r.objects[index-1])}
+		}
+	} else {
+		r.indexes = append(r.indexes, -1)
+		for _, obj := range r.objects {
+			ack.Values = append(ack.Values, r.objectTag(obj))
+		}
+	}
+
+	ackMsg, err := apdu.NewReadPropertyAckMessage(msg.InvokeID, &ack)
+	if err != nil {
+		return err
+	}
+	resp := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount, 0, nil, ackMsg)
+	return r.conn.send(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, resp)
+}
+
+func (r *objectListResponder) objectTag(obj bacnet.ObjectID) apdu.TagType {
+	tag, _ := apdu.NewApplicationObjectID(uint32(obj.Type), obj.Instance)
+	return tag
+}
+
+func TestReadObjectList(t *testing.T) {
+	defer apdu.SetDefaultMaxAPDULength(apdu.DefaultMaxAPDULength())
+
+	device := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	objects := []bacnet.ObjectID{
+		device,
+		{Type: bacnet.ObjectTypeAnalogInput, Instance: 1},
+		{Type: bacnet.ObjectTypeAnalogValue, Instance: 2},
+	}
+	// Too many to fit in the smallest APDU
+	manyObjects := append([]bacnet.ObjectID{}, objects...)
+	for i := uint32(1); i <= 5; i++ {
+		manyObjects = append(manyObjects, bacnet.ObjectID{Type: bacnet.ObjectTypeFile, Instance: i})
+	}
+
+	allIndexes := []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
+
+	testCases := []struct {
+		name            string
+		maxLength       apdu.MaxAPDULength
+		peerMaxLengths  map[string]apdu.MaxAPDULength
+		objects         []bacnet.ObjectID
+		claimedLength   uint
+		expectedIndexes []int
+		expectedErr     error
+	}{
+		{"whole list", apdu.MaxAPDULength1476, nil, objects, 0, []int{0, -1}, nil},
+		{"small list", apdu.MaxAPDULength50, nil, objects, 0, []int{0, -1}, nil},
+		{"each element", apdu.MaxAPDULength50, nil, manyObjects, 0, allIndexes, nil},
+		{"small peer", apdu.MaxAPDULength1476, map[string]apdu.MaxAPDULength{"127.0.0.1": apdu.MaxAPDULength50},
+			manyObjects, 0, allIndexes, nil},
+		{"huge length", apdu.MaxAPDULength1476, nil, objects, maxObjectListLength + 1, []int{0},
+			bacnet.ErrInvalidData},
+		{"inconsistent length", apdu.MaxAPDULength1476, nil, objects, 2, []int{0, -1}, bacnet.ErrInvalidData},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			apdu.SetDefaultMaxAPDULength(tCase.maxLength)
+			conn, _ := newMemoryConnection(t)
+			conn.peerMaxAPDU = tCase.peerMaxLengths
+			responder := &objectListResponder{conn: conn, device: device, objects: tCase.objects,
+				length: tCase.claimedLength}
+			conn.SetMessageRouter(responder)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			list, err := conn.ReadObjectList(ctx, net.IPv4(127, 0, 0, 1), uint(device.Instance))
+			assert.Equal(t, tCase.expectedIndexes, responder.indexes, "Unexpected requests")
+			if tCase.expectedErr != nil {
+				assert.ErrorIs(t, err, tCase.expectedErr, "Expected an error reading the object list")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error reading the object list")
+			assert.Equal(t, tCase.objects, list, "Unexpected objects")
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// ReadProperties reads the properties with ReadPropertyMultiple. If the device doesn't support it, which is
+// a reject for an unrecognized service, each property is read with ReadProperty instead, and we remember
+// that the device doesn't support it for the next time. Properties that couldn't be read have the
+// AccessError set, like in the ReadPropertyMultiple ack.
+func (c *connection) ReadProperties(ctx context.Context, dest net.IP, specs []apdu.ReadAccessSpec) (
+	[]apdu.ReadAccessResult, error) {
+	if c.supportsReadPropertyMultiple(dest) {
+		results, err := c.readPropertyMultiple(ctx, dest, specs)
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			return results, err
+		}
+		reject, ok := respErr.Response.(*apdu.RejectMessage)
+		if !ok || reject.Reason != apdu.RejectReasonUnrecognizedService {
+			return nil, err
+		}
+		c.setNoReadPropertyMultiple(dest)
+	}
+
+	results := make([]apdu.ReadAccessResult, 0, len(specs))
+	for _, spec := range specs {
+		result := apdu.ReadAccessResult{ObjectID: spec.ObjectID}
+		for _, ref := range spec.Properties {
+			res := apdu.ReadResult{PropertyReference: ref}
+			ack, err := c.readProperty(ctx, dest, spec.ObjectID, ref.Property, ref.ArrayIndex)
+			var respErr *ResponseError
+			if errors.As(err, &respErr) {
+				errMsg, ok := respErr.Response.(*apdu.ErrorMessage)
+				if !ok {
+					return nil, err
+				}
+				res.AccessError = &apdu.PropertyAccessError{Class: errMsg.Class, Code: errMsg.Code}
+			} else if err != nil {
+				return nil, err
+			} else {
+				res.Values = ack.Values
+			}
+			result.Results = append(result.Results, res)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// readPropertyMultiple reads the properties with ReadPropertyMultiple, and decodes the ack.
+func (c *connection) readPropertyMultiple(ctx context.Context, dest net.IP, specs []apdu.ReadAccessSpec) (
+	[]apdu.ReadAccessResult, error) {
+	msg, err := apdu.NewReadPropertyMultipleMessage(0, specs)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendAndReceive(ctx, dest, msg)
+	if err != nil {
+		return nil, err
+	}
+	ack, ok := resp.(*apdu.ComplexAckMessage)
+	if !ok || ack.ServiceID != apdu.ServiceConfirmedReadPropertyMultiple {
+		return nil, fmt.Errorf("unexpected response to ReadPropertyMultiple: %w", bacnet.ErrInvalidData)
+	}
+	return apdu.NewReadPropertyMultipleAckFromBytes(ack.ServiceData)
+}
+
+// supportsReadPropertyMultiple checks if the device hasn't rejected ReadPropertyMultiple before.
+func (c *connection) supportsReadPropertyMultiple(dest net.IP) bool {
+	c.noRPMMux.Lock()
+	defer c.noRPMMux.Unlock()
+	return !c.noRPM[dest.String()]
+}
+
+func (c *connection) setNoReadPropertyMultiple(dest net.IP) {
+	c.noRPMMux.Lock()
+	defer c.noRPMMux.Unlock()
+	if c.noRPM == nil {
+		c.noRPM = make(map[string]bool)
+	}
+	c.noRPM[dest.String()] = true
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// noRPMResponder acts as an old device that rejects ReadPropertyMultiple, but answers ReadProperty for the
+	// present value of its objects.
+	noRPMResponder struct {
+		conn *connection
+		// values are the present values of the objects
+		values map[bacnet.ObjectID]uint
+		// rpmRequests and rpRequests are the number of each request
+		rpmRequests int
+		rpRequests  int
+	}
+)
+
+var _ MessageRouter = (*noRPMResponder)(nil)
+
+func (r *noRPMResponder) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	msg, ok := npduMsg.APDU.(*apdu.ConfirmedMessage)
+	if !ok {
+		return nil
+	}
+
+	var resp apdu.Message
+	switch msg.ServiceID {
+	case apdu.ServiceConfirmedReadPropertyMultiple:
+		r.rpmRequests++
+		resp = apdu.NewReject(msg.InvokeID, apdu.RejectReasonUnrecognizedService)
+	case apdu.ServiceConfirmedReadProperty:
+		r.rpRequests++
+		params, err := msg.Parameters()
+		if err != nil {
+			return err
+		}
+		objectID, err := params[0].(*apdu.ContextSpecificRawType).ObjectID()
+		if err != nil {
+			return err
+		}
+		property := bacnet.PropertyIdentifier(params[1].(*apdu.ContextSpecificRawType).Unsigned())
+		value, ok := r.values[objectID]
+		if !ok || property != bacnet.PropertyIdentifierPresentValue {
+			resp = apdu.NewErrorResponse(msg.InvokeID, apdu.ServiceConfirmedReadProperty, apdu.ErrorClassProperty,
+				apdu.ErrorCodeUnknownProperty)
+			break
+		}
+		valueTag, _ := apdu.NewApplicationUnsignedInt(value)
+		resp, err = apdu.NewReadPropertyAckMessage(msg.InvokeID, &apdu.ReadPropertyAck{
+			ObjectID: objectID,
+			Property: property,
+			Values:   []apdu.TagType{valueTag},
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	respMsg := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount, 0, nil, resp)
+	return r.conn.send(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, respMsg)
+}
+
+func TestReadPropertiesFallback(t *testing.T) {
+	first := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 1}
+	second := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogValue, Instance: 2}
+	conn, _ := newMemoryConnection(t)
+	responder := &noRPMResponder{conn: conn, values: map[bacnet.ObjectID]uint{first: 10, second: 20}}
+	conn.SetMessageRouter(responder)
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	specs := []apdu.ReadAccessSpec{
+		{ObjectID: first, Properties: []apdu.PropertyReference{
+			{Property: bacnet.PropertyIdentifierPresentValue},
+			{Property: bacnet.PropertyIdentifierUnits},
+		}},
+		{ObjectID: second, Properties: []apdu.PropertyReference{{Property: bacnet.PropertyIdentifierPresentValue}}},
+	}
+	firstValue, _ := apdu.NewApplicationUnsignedInt(10)
+	secondValue, _ := apdu.NewApplicationUnsignedInt(20)
+	expected := []apdu.ReadAccessResult{
+		{ObjectID: first, Results: []apdu.ReadResult{
+			{PropertyReference: specs[0].Properties[0], Values: []apdu.TagType{firstValue}},
+			{PropertyReference: specs[0].Properties[1], AccessError: &apdu.PropertyAccessError{
+				Class: apdu.ErrorClassProperty,
+				Code:  apdu.ErrorCodeUnknownProperty,
+			}},
+		}},
+		{ObjectID: second, Results: []apdu.ReadResult{
+			{PropertyReference: specs[1].Properties[0], Values: []apdu.TagType{secondValue}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		results, err := conn.ReadProperties(ctx, net.IPv4(127, 0, 0, 1), specs)
+		assert.NoError(t, err, "Unexpected error reading properties")
+		assert.Equal(t, expected, results, "Unexpected results")
+	}
+	// ReadPropertyMultiple is only tried the first time
+	assert.Equal(t, 1, responder.rpmRequests, "Unexpected ReadPropertyMultiple requests")
+	assert.Equal(t, 6, responder.rpRequests, "Unexpected ReadProperty requests")
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+var (
+	// ErrNoInvokeID is returned when all of the invoke IDs are used by outstanding requests.
+	ErrNoInvokeID = errors.New("no invoke ID available")
+	// ErrConnectionClosed is returned to the requests that are waiting for a response when the connection is
+	// stopped or closed.
+	ErrConnectionClosed = errors.New("connection closed")
+	// ErrNoResponse is returned when the device didn't respond to any of the attempts of the RetryStrategy.
+	ErrNoResponse = errors.New("no response")
+)
+
+type (
+	// ResponseError is returned by SendAndReceive when the device responds with an Error, Reject, or Abort.
+	// The response is kept so the caller can check the details.
+	ResponseError struct {
+		Response apdu.Message
+	}
+)
+
+func (e *ResponseError) Error() string {
+	switch r := e.Response.(type) {
+	case *apdu.ErrorMessage:
+		return fmt.Sprintf("error response: class %d, code %d", r.Class, r.Code)
+	case *apdu.RejectMessage:
+		return fmt.Sprintf("request rejected: reason %d", r.Reason)
+	case *apdu.AbortMessage:
+		return fmt.Sprintf("request aborted: reason %d", r.Reason)
+	default:
+		return fmt.Sprintf("unexpected response: %T", r)
+	}
+}
+
+// WithMaxPendingRequests limits the number of confirmed requests that can be waiting for a response. When
+// the limit is reached, SendAndReceive waits for one of them to complete.
+func WithMaxPendingRequests(limit int) ConnectionOption {
+	return func(c *connection) {
+		if limit > 0 {
+			c.pendingSlots = make(chan struct{}, limit)
+		}
+	}
+}
+
+// SendAndReceive sends the confirmed request to the device at dest and waits for the response. The invoke
+// ID of the request is assigned here, so any value from the builder is replaced. Error, Reject, and Abort
+// responses are returned as a *ResponseError. Without a response, the request is sent again when the
+// RetryStrategy says, and ErrNoResponse is returned after the last attempt. A segmented ComplexAck is
+// reassembled, so the response is never segmented.
+func (c *connection) SendAndReceive(ctx context.Context, dest net.IP, msg *apdu.ConfirmedMessage) (
+	apdu.Message, error) {
+	if c.pendingSlots != nil {
+		select {
+		case c.pendingSlots <- struct{}{}:
+			defer func() { <-c.pendingSlots }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting to send request: %w", ctx.Err())
+		}
+	}
+
+	invokeID, err := c.acquireInvokeID()
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseInvokeID(invokeID)
+	msg.InvokeID = invokeID
+	if err := c.checkRequestLength(dest, msg); err != nil {
+		return nil, err
+	}
+
+	// The response can be segmented, so there's room for a window of segments
+	waiter := c.addKeptWaiter(func(sender *net.UDPAddr, resp apdu.Message) bool {
+		carrier, ok := resp.(apdu.InvokeIDCarrier)
+		return ok && carrier.InvokeID() == invokeID && sender != nil && sender.IP.Equal(dest)
+	}, segmentBuffer)
+	defer c.removeWaiter(waiter)
+
+	for attempt := 0; ; attempt++ {
+		timeout, ok := c.retry.Timeout(attempt)
+		if !ok {
+			return nil, fmt.Errorf("%w from %s after %d attempts", ErrNoResponse, dest, attempt)
+		}
+		if err := c.SendConfirmedMessage(dest, npdu.NormalMessage, 0, msg); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case resp := <-waiter.ch:
+			timer.Stop()
+			switch r := resp.(type) {
+			case *apdu.ErrorMessage, *apdu.RejectMessage, *apdu.AbortMessage:
+				return resp, &ResponseError{Response: resp}
+			case *apdu.ComplexAckMessage:
+				if r.IsSegmented {
+					return c.receiveSegments(ctx, dest, waiter, r)
+				}
+			}
+			return resp, nil
+		case <-waiter.closed:
+			timer.Stop()
+			return nil, ErrConnectionClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("no response from %s: %w", dest, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// checkRequestLength makes sure the request fits in the APDU size negotiated with the device. We don't
+// segment requests, so a request that is too large would only be rejected or dropped by the device.
+func (c *connection) checkRequestLength(dest net.IP, msg *apdu.ConfirmedMessage) error {
+	c.prepareConfirmedMessage(msg)
+	data, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	limit := c.negotiatedAPDULength(dest)
+	if uint(len(data)) > limit {
+		return fmt.Errorf("request of %d bytes is larger than the %d bytes accepted by %s: %w", len(data), limit,
+			dest, bacnet.ErrValueTooLarge)
+	}
+	return nil
+}
+
+// negotiatedAPDULength is the largest APDU we can send to the device. If we haven't seen an IAm from it, we
+// only have our own limit.
+func (c *connection) negotiatedAPDULength(dest net.IP) uint {
+	local := apdu.DefaultMaxAPDULength()
+	c.peerMaxAPDUMux.Lock()
+	remote, ok := c.peerMaxAPDU[dest.String()]
+	c.peerMaxAPDUMux.Unlock()
+	if !ok {
+		return local.Bytes()
+	}
+	return apdu.NegotiatedAPDULength(remote, local)
+}
+
+// rememberPeer keeps the max APDU length from an IAm, so requests to the device respect its limit. A partial
+// IAm, or one without a length, doesn't tell us the limit, so the one we have is kept.
+func (c *connection) rememberPeer(sender *net.UDPAddr, msg apdu.Message) {
+	unconfirmed, ok := msg.(*apdu.UnconfirmedMessage)
+	if !ok || sender == nil || unconfirmed.ServiceID != apdu.ServiceUnconfirmedIAm {
+		return
+	}
+	iAm, err := apdu.NewIAmFromMessage(unconfirmed)
+	if err != nil || iAm.Partial || iAm.MaxAPDULengthAccepted == 0 {
+		return
+	}
+	c.peerMaxAPDUMux.Lock()
+	defer c.peerMaxAPDUMux.Unlock()
+	if c.peerMaxAPDU == nil {
+		c.peerMaxAPDU = make(map[string]apdu.MaxAPDULength)
+	}
+	c.peerMaxAPDU[sender.IP.String()] = apdu.MaxAPDULengthFromBytes(iAm.MaxAPDULengthAccepted)
+}
+
+// acquireInvokeID finds the next invoke ID that isn't used by an outstanding request.
+func (c *connection) acquireInvokeID() (uint8, error) {
+	c.invokeIDMux.Lock()
+	defer c.invokeIDMux.Unlock()
+	if c.invokeIDs == nil {
+		c.invokeIDs = make(map[uint8]bool)
+	}
+	for i := 0; i < 256; i++ {
+		id := c.nextInvokeID
+		c.nextInvokeID++
+		if !c.invokeIDs[id] {
+			c.invokeIDs[id] = true
+			return id, nil
+		}
+	}
+	return 0, ErrNoInvokeID
+}
+
+func (c *connection) releaseInvokeID(id uint8) {
+	c.invokeIDMux.Lock()
+	defer c.invokeIDMux.Unlock()
+	delete(c.invokeIDs, id)
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// heldRequestRouter reports the confirmed requests that loop back, but doesn't respond, so the test can
+	// decide when each request completes.
+	heldRequestRouter struct {
+		requests chan uint8
+	}
+)
+
+var _ MessageRouter = (*heldRequestRouter)(nil)
+
+func (r *heldRequestRouter) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	if req, ok := npduMsg.APDU.(*apdu.ConfirmedMessage); ok {
+		r.requests <- req.InvokeID
+	}
+	return nil
+}
+
+// newMemoryConnection creates a connection on the loopback address over a memory connection. If the
+// connection isn't started, what it sends can be read from the memory connection.
+func newMemoryConnection(t *testing.T, opts ...ConnectionOption) (*connection, *MemoryPacketConn) {
+	return newMemoryConnectionAt(t, net.IPv4(127, 0, 0, 1).To4(), 8, opts...)
+}
+
+// newMemoryConnectionAt is newMemoryConnection for a connection on another address.
+func newMemoryConnectionAt(t *testing.T, ip net.IP, mask uint16, opts ...ConnectionOption) (*connection,
+	*MemoryPacketConn) {
+	packetConn := NewMemoryPacketConn(&net.UDPAddr{IP: ip, Port: DefaultPort})
+	opts = append(opts, WithPacketConn(packetConn))
+	conn, err := NewConnection(ip, mask, opts...)
+	assert.NoError(t, err, "Unexpected error creating connection")
+	return conn.(*connection), packetConn
+}
+
+func sendSimpleAck(t *testing.T, c *connection, invokeID uint8) {
+	ack := apdu.NewSimpleAck(invokeID, apdu.ServiceConfirmedReadProperty)
+	npduMsg := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount, 0, nil, ack)
+	assert.NoError(t, c.send(net.IPv4(127, 0, 0, 1), BVLCFunctioncUnicast, npduMsg), "Unable to send ack")
+}
+
+func TestMaxPendingRequests(t *testing.T) {
+	const maxPending = 2
+	conn, _ := newMemoryConnection(t, WithMaxPendingRequests(maxPending))
+	router := &heldRequestRouter{requests: make(chan uint8, maxPending+1)}
+	conn.SetMessageRouter(router)
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	results := make(chan error, maxPending+1)
+	send := func() {
+		msg, err := apdu.NewReadPropertyMessage(0, objectID, bacnet.PropertyIdentifierObjectName, nil)
+		assert.NoError(t, err, "Unexpected error creating ReadProperty")
+		_, err = conn.SendAndReceive(ctx, net.IPv4(127, 0, 0, 1), msg)
+		results <- err
+	}
+
+	var invokeIDs []uint8
+	for i := 0; i < maxPending; i++ {
+		go send()
+		invokeIDs = append(invokeIDs, <-router.requests)
+	}
+	assert.NotEqual(t, invokeIDs[0], invokeIDs[1], "Outstanding requests share an invoke ID")
+
+	// The next request has to wait for a slot
+	go send()
+	select {
+	case id := <-router.requests:
+		assert.Fail(t, "Request sent over the limit", "invoke ID %d", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sendSimpleAck(t, conn, invokeIDs[0])
+	assert.NoError(t, <-results, "Unexpected error for the first request")
+	select {
+	case id := <-router.requests:
+		invokeIDs = append(invokeIDs, id)
+	case <-ctx.Done():
+		assert.FailNow(t, "Waiting request was not sent after a slot was freed")
+	}
+
+	for _, id := range invokeIDs[1:] {
+		sendSimpleAck(t, conn, id)
+		assert.NoError(t, <-results, "Unexpected error for request")
+	}
+}
+
+func TestCloseWithPendingRequest(t *testing.T) {
+	testCases := []struct {
+		name  string
+		close func(c *connection)
+	}{
+		{"stop", func(c *connection) { c.Stop() }},
+		{"close", func(c *connection) { assert.NoError(t, c.Close(), "Error closing connection") }},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t)
+			router := &heldRequestRouter{requests: make(chan uint8, 1)}
+			conn.SetMessageRouter(router)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				conn.Close()
+			}()
+
+			// The deadline is long, so returning before it means that the close unblocked the request.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+			msg, err := apdu.NewReadPropertyMessage(0, objectID, bacnet.PropertyIdentifierObjectName, nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			result := make(chan error, 1)
+			go func() {
+				_, err := conn.SendAndReceive(ctx, net.IPv4(127, 0, 0, 1), msg)
+				result <- err
+			}()
+			<-router.requests
+
+			tCase.close(conn)
+			select {
+			case err := <-result:
+				assert.ErrorIs(t, err, ErrConnectionClosed, "Expected the connection closed error")
+			case <-time.After(time.Second):
+				assert.Fail(t, "Request did not return when the connection was closed")
+			}
+		})
+	}
+}
+
+func TestRememberPeer(t *testing.T) {
+	devID, err := apdu.NewApplicationObjectID(uint32(bacnet.ObjectTypeDevice), 1234)
+	assert.NoError(t, err, "Unexpected error creating device ID")
+	iAm := func(maxAPDU uint) apdu.Message {
+		msg, err := apdu.NewIAmMessage(uint32(bacnet.ObjectTypeDevice), 1234, maxAPDU, apdu.SegmentationNone, 260)
+		assert.NoError(t, err, "Unexpected error creating IAm")
+		return msg
+	}
+	local := apdu.DefaultMaxAPDULength().Bytes()
+	testCases := []struct {
+		name     string
+		msgs     []apdu.Message
+		expected uint
+	}{
+		{"full", []apdu.Message{iAm(50)}, 50},
+		{"partial", []apdu.Message{&apdu.UnconfirmedMessage{
+			MessageBase: apdu.MessageBase{ServiceType: apdu.PDUTypeUnconfirmedServiceRequest},
+			ServiceID:   apdu.ServiceUnconfirmedIAm,
+			ServiceData: []apdu.TagType{devID},
+		}}, local},
+		{"zero length", []apdu.Message{iAm(0)}, local},
+		{"zero length keeps the last", []apdu.Message{iAm(206), iAm(0)}, 206},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t)
+			defer conn.Close()
+			peer := net.IPv4(127, 0, 0, 2)
+			for _, msg := range tCase.msgs {
+				conn.rememberPeer(&net.UDPAddr{IP: peer, Port: DefaultPort}, msg)
+			}
+			assert.Equal(t, tCase.expected, conn.negotiatedAPDULength(peer), "Unexpected APDU length")
+		})
+	}
+}
+
+func TestRememberPeerWithoutWaiters(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	// No WhoIs or Ping is waiting for the IAm, but it's still remembered
+	iAm, err := apdu.NewIAmMessage(uint32(bacnet.ObjectTypeDevice), 1234, 50, apdu.SegmentationNone, 260)
+	assert.NoError(t, err, "Unexpected error creating IAm")
+	dest := net.IPv4(127, 0, 0, 1)
+	assert.NoError(t, conn.sendUnconfirmed(dest, BVLCFunctioncUnicast, nil, npdu.NormalMessage,
+		npdu.NetworkLayerIAmMessage, iAm), "Unable to send IAm")
+	assert.Eventually(t, func() bool {
+		return conn.negotiatedAPDULength(dest) == 50
+	}, time.Second, 10*time.Millisecond, "IAm was not remembered")
+}
+
+func TestSendAndReceivePeerMaxAPDU(t *testing.T) {
+	conn, _ := newMemoryConnection(t)
+	defer conn.Close()
+
+	// The peer says it accepts only 50 bytes. We don't start the connection, so remember it directly.
+	peer := net.IPv4(127, 0, 0, 2)
+	iAm, err := apdu.NewIAmMessage(uint32(bacnet.ObjectTypeDevice), 1234, 50, apdu.SegmentationNone, 260)
+	assert.NoError(t, err, "Unexpected error creating IAm")
+	conn.rememberPeer(&net.UDPAddr{IP: peer, Port: DefaultPort}, iAm)
+
+	var specs []apdu.ReadAccessSpec
+	for i := uint32(0); i < 10; i++ {
+		objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: i}
+		specs = append(specs, apdu.NewReadAccessSpec(objectID, bacnet.PropertyIdentifierPresentValue))
+	}
+	msg, err := apdu.NewReadPropertyMultipleMessage(0, specs)
+	assert.NoError(t, err, "Unexpected error creating ReadPropertyMultiple")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = conn.SendAndReceive(ctx, peer, msg)
+	assert.ErrorIs(t, err, bacnet.ErrValueTooLarge, "Expected the request to be too large for the peer")
+
+	// Without an IAm, our own limit applies, so the request is sent.
+	_, err = conn.SendAndReceive(ctx, net.IPv4(127, 0, 0, 3), msg)
+	assert.Error(t, err, "Expected no response")
+	assert.NotErrorIs(t, err, bacnet.ErrValueTooLarge, "Request was limited without an IAm")
+}
@@ -0,0 +1,210 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+	"github.com/shigmas/modore/pkg/device"
+)
+
+type (
+	// IAmResponder answers the WhoIs requests for our device with a broadcast IAm. A gateway can host
+	// several devices, and answers with an IAm for each one in the range. Register it as an APDU handler for
+	// WhoIs, and call Run to answer the requests it gets.
+	IAmResponder struct {
+		conn            Connection
+		deviceInstances []uint32
+		segmentation    apdu.Segmentation
+		vendorID        uint16
+		ch              APDUMessageChannel
+
+		// RespondWhenUnconfigured answers a WhoIs for the full range even though our device instance is
+		// apdu.MaxInstanceNumber, which means that the device hasn't been configured. By default, an
+		// unconfigured device doesn't answer.
+		RespondWhenUnconfigured bool
+	}
+
+	// IHaveResponder answers the WhoHas requests for the objects of our device with a broadcast IHave. The
+	// object is found by its ID, or by its name, which must match exactly, including the case. Register it as
+	// an APDU handler for WhoHas, and call Run to answer the requests it gets.
+	IHaveResponder struct {
+		conn           Connection
+		deviceInstance uint32
+		database       *device.Database
+		ch             APDUMessageChannel
+	}
+)
+
+const (
+	// iAmResponderBuffer is how many WhoIs requests can be waiting to be answered
+	iAmResponderBuffer = 16
+	// iHaveResponderBuffer is how many WhoHas requests can be waiting to be answered
+	iHaveResponderBuffer = 16
+)
+
+var (
+	_ APDUMessageHandler = (*IAmResponder)(nil)
+	_ APDUMessageHandler = (*IHaveResponder)(nil)
+)
+
+// NewIAmResponder creates a responder for the device.
+func NewIAmResponder(conn Connection, deviceInstance uint32, segmentation apdu.Segmentation,
+	vendorID uint16) *IAmResponder {
+	return NewGatewayIAmResponder(conn, []uint32{deviceInstance}, segmentation, vendorID)
+}
+
+// NewGatewayIAmResponder creates a responder for the devices that a gateway hosts. They all have the same
+// segmentation and vendor.
+func NewGatewayIAmResponder(conn Connection, deviceInstances []uint32, segmentation apdu.Segmentation,
+	vendorID uint16) *IAmResponder {
+	return &IAmResponder{
+		conn:            conn,
+		deviceInstances: append([]uint32{}, deviceInstances...),
+		segmentation:    segmentation,
+		vendorID:        vendorID,
+		ch:              make(APDUMessageChannel, iAmResponderBuffer),
+	}
+}
+
+func (r *IAmResponder) GetAPDUChannel() APDUMessageChannel {
+	return r.ch
+}
+
+func (r *IAmResponder) Equals(other Equatable) bool {
+	if o, ok := other.(*IAmResponder); ok {
+		return r == o
+	}
+	return false
+}
+
+// Run answers the WhoIs requests until the context is done. Errors sending the IAm are dropped, since the
+// requester will try again if it doesn't hear from us.
+func (r *IAmResponder) Run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-r.ch:
+			if msg == nil {
+				continue
+			}
+			if whoIs, ok := (*msg).(*apdu.UnconfirmedMessage); ok {
+				_, _ = r.Respond(whoIs)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Respond sends an IAm for each of our devices that the WhoIs is for, and returns whether any were sent. It
+// stops at the first error.
+func (r *IAmResponder) Respond(msg *apdu.UnconfirmedMessage) (bool, error) {
+	whoIs, err := apdu.NewWhoIsFromMessage(msg)
+	if err != nil {
+		return false, err
+	}
+	responded := false
+	for _, instance := range r.deviceInstances {
+		if !r.matches(whoIs, instance) {
+			continue
+		}
+		iAm, err := apdu.NewDefaultIAmMessage(instance, r.segmentation, r.vendorID)
+		if err != nil {
+			return responded, err
+		}
+		err = r.conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, 0, iAm)
+		if err != nil {
+			return responded, err
+		}
+		responded = true
+	}
+	return responded, nil
+}
+
+// matches checks if the WhoIs is for the device. Since an unconfigured device doesn't have a real instance,
+// it can only match the full range, and only if that's enabled.
+func (r *IAmResponder) matches(whoIs *apdu.WhoIs, instance uint32) bool {
+	if instance >= apdu.MaxInstanceNumber {
+		return r.RespondWhenUnconfigured && whoIs.Low == 0 && whoIs.High >= apdu.MaxInstanceNumber
+	}
+	return whoIs.Matches(instance)
+}
+
+// NewIHaveResponder creates a responder for the objects in the database of the device.
+func NewIHaveResponder(conn Connection, deviceInstance uint32, database *device.Database) *IHaveResponder {
+	return &IHaveResponder{
+		conn:           conn,
+		deviceInstance: deviceInstance,
+		database:       database,
+		ch:             make(APDUMessageChannel, iHaveResponderBuffer),
+	}
+}
+
+func (r *IHaveResponder) GetAPDUChannel() APDUMessageChannel {
+	return r.ch
+}
+
+func (r *IHaveResponder) Equals(other Equatable) bool {
+	if o, ok := other.(*IHaveResponder); ok {
+		return r == o
+	}
+	return false
+}
+
+// Run answers the WhoHas requests until the context is done. Like IAmResponder, errors sending the IHave are
+// dropped.
+func (r *IHaveResponder) Run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-r.ch:
+			if msg == nil {
+				continue
+			}
+			if whoHas, ok := (*msg).(*apdu.UnconfirmedMessage); ok {
+				_, _ = r.Respond(whoHas)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Respond sends the IHave if the WhoHas is for our device, and we have the object, and returns whether it was
+// sent.
+func (r *IHaveResponder) Respond(msg *apdu.UnconfirmedMessage) (bool, error) {
+	whoHas, err := apdu.NewWhoHasFromMessage(msg)
+	if err != nil {
+		return false, err
+	}
+	if !whoHas.Matches(r.deviceInstance) {
+		return false, nil
+	}
+	obj, ok := r.find(whoHas)
+	if !ok {
+		return false, nil
+	}
+	iHave, err := apdu.NewIHaveMessage(&apdu.IHave{
+		DeviceID:   bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: r.deviceInstance},
+		ObjectID:   obj.ID,
+		ObjectName: obj.Name,
+	})
+	if err != nil {
+		return false, err
+	}
+	err = r.conn.SendUnconfirmedMessage(nil, npdu.NormalMessage, 0, iHave)
+	return err == nil, err
+}
+
+// find gets the object that the WhoHas is for. Names are compared exactly, since the spec doesn't allow
+// partial or case insensitive matches.
+func (r *IHaveResponder) find(whoHas *apdu.WhoHas) (*device.Object, bool) {
+	if whoHas.ObjectID != nil {
+		return r.database.Get(*whoHas.ObjectID)
+	}
+	id, ok := r.database.FindByName(whoHas.ObjectName)
+	if !ok {
+		return nil, false
+	}
+	return r.database.Get(id)
+}
@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+	"github.com/shigmas/modore/pkg/device"
+)
+
+func TestIAmResponder(t *testing.T) {
+	testCases := []struct {
+		name                    string
+		instance                uint32
+		respondWhenUnconfigured bool
+		low                     uint
+		high                    uint
+		expected                bool
+	}{
+		{"full range", 1234, false, 0, apdu.MaxInstanceNumber, true},
+		{"in range", 1234, false, 1000, 2000, true},
+		{"out of range", 1234, false, 0, 1000, false},
+		{"unconfigured", apdu.MaxInstanceNumber, false, 0, apdu.MaxInstanceNumber, false},
+		{"unconfigured enabled", apdu.MaxInstanceNumber, true, 0, apdu.MaxInstanceNumber, true},
+		{"unconfigured enabled limited", apdu.MaxInstanceNumber, true, 0, 1000, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, packetConn := newMemoryConnection(t)
+			defer func() {
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			responder := NewIAmResponder(conn, tCase.instance, apdu.SegmentationNone, 999)
+			responder.RespondWhenUnconfigured = tCase.respondWhenUnconfigured
+			whoIs, err := apdu.NewWhoisMessage(tCase.low, tCase.high)
+			assert.NoError(t, err, "Unable to create WhoIs")
+			responded, err := responder.Respond(whoIs)
+			assert.NoError(t, err, "Unexpected error responding")
+			assert.Equal(t, tCase.expected, responded, "Unexpected response")
+			if !tCase.expected {
+				return
+			}
+
+			buf := make([]byte, 1500)
+			n, _, err := packetConn.ReadFrom(buf)
+			assert.NoError(t, err, "Unable to read what was sent")
+			bvlcMsg, err := NewBVLCMessageFromBytes(buf[:n])
+			assert.NoError(t, err, "Unable to decode BVLC")
+			assert.Equal(t, BVLCFunction(BVLCFunctioncBroadcast), bvlcMsg.Function, "IAm should be broadcast")
+			npduMsg, err := npdu.NewMessageFromBytes(bvlcMsg.Data)
+			assert.NoError(t, err, "Unable to decode NPDU")
+			iAm, err := apdu.NewIAmFromMessage(npduMsg.APDU.(*apdu.UnconfirmedMessage))
+			assert.NoError(t, err, "Unable to decode IAm")
+			assert.Equal(t, bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: tCase.instance}, iAm.DeviceID,
+				"Unexpected device")
+		})
+	}
+}
+
+func TestGatewayIAmResponder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		low      uint
+		high     uint
+		expected []uint32
+	}{
+		{"one in range", 0, 15, []uint32{10}},
+		{"all in range", 0, apdu.MaxInstanceNumber, []uint32{10, 20}},
+		{"none in range", 11, 19, nil},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, packetConn := newMemoryConnection(t)
+			defer func() {
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			responder := NewGatewayIAmResponder(conn, []uint32{10, 20}, apdu.SegmentationNone, 999)
+			whoIs, err := apdu.NewWhoisMessage(tCase.low, tCase.high)
+			assert.NoError(t, err, "Unable to create WhoIs")
+			responded, err := responder.Respond(whoIs)
+			assert.NoError(t, err, "Unexpected error responding")
+			assert.Equal(t, len(tCase.expected) > 0, responded, "Unexpected response")
+
+			// Everything that was sent is already waiting to be read
+			var instances []uint32
+			buf := make([]byte, 1500)
+			for len(packetConn.datagrams) > 0 {
+				n, _, err := packetConn.ReadFrom(buf)
+				assert.NoError(t, err, "Unable to read what was sent")
+				bvlcMsg, err := NewBVLCMessageFromBytes(buf[:n])
+				assert.NoError(t, err, "Unable to decode BVLC")
+				npduMsg, err := npdu.NewMessageFromBytes(bvlcMsg.Data)
+				assert.NoError(t, err, "Unable to decode NPDU")
+				iAm, err := apdu.NewIAmFromMessage(npduMsg.APDU.(*apdu.UnconfirmedMessage))
+				assert.NoError(t, err, "Unable to decode IAm")
+				instances = append(instances, iAm.DeviceID.Instance)
+			}
+			assert.Equal(t, tCase.expected, instances, "Unexpected devices in the IAms")
+		})
+	}
+}
+
+func TestIHaveResponder(t *testing.T) {
+	const deviceInstance = 1234
+	inputID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}
+	otherID := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 2}
+	database := device.NewDatabase()
+	assert.NoError(t, database.Add(&device.Object{ID: inputID, Name: "Zone Temp"}), "Unable to add object")
+
+	testCases := []struct {
+		name     string
+		whoHas   apdu.WhoHas
+		expected bool
+	}{
+		{"exact name", apdu.WhoHas{WhoIs: apdu.WhoIs{High: apdu.MaxInstanceNumber}, ObjectName: "Zone Temp"},
+			true},
+		{"case mismatch", apdu.WhoHas{WhoIs: apdu.WhoIs{High: apdu.MaxInstanceNumber}, ObjectName: "zone temp"},
+			false},
+		{"partial name", apdu.WhoHas{WhoIs: apdu.WhoIs{High: apdu.MaxInstanceNumber}, ObjectName: "Zone"},
+			false},
+		{"id", apdu.WhoHas{WhoIs: apdu.WhoIs{High: apdu.MaxInstanceNumber}, ObjectID: &inputID}, true},
+		{"unknown id", apdu.WhoHas{WhoIs: apdu.WhoIs{High: apdu.MaxInstanceNumber}, ObjectID: &otherID},
+			false},
+		{"out of range", apdu.WhoHas{WhoIs: apdu.WhoIs{Low: 0, High: 1000}, ObjectID: &inputID}, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, packetConn := newMemoryConnection(t)
+			defer func() {
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			responder := NewIHaveResponder(conn, deviceInstance, database)
+			whoHas, err := apdu.NewWhoHasMessage(&tCase.whoHas)
+			assert.NoError(t, err, "Unable to create WhoHas")
+			responded, err := responder.Respond(whoHas)
+			assert.NoError(t, err, "Unexpected error responding")
+			assert.Equal(t, tCase.expected, responded, "Unexpected response")
+			if !tCase.expected {
+				return
+			}
+
+			buf := make([]byte, 1500)
+			n, _, err := packetConn.ReadFrom(buf)
+			assert.NoError(t, err, "Unable to read what was sent")
+			bvlcMsg, err := NewBVLCMessageFromBytes(buf[:n])
+			assert.NoError(t, err, "Unable to decode BVLC")
+			npduMsg, err := npdu.NewMessageFromBytes(bvlcMsg.Data)
+			assert.NoError(t, err, "Unable to decode NPDU")
+			iHave, err := apdu.NewIHaveFromMessage(npduMsg.APDU.(*apdu.UnconfirmedMessage))
+			assert.NoError(t, err, "Unable to decode IHave")
+			assert.Equal(t, &apdu.IHave{
+				DeviceID:   bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: deviceInstance},
+				ObjectID:   inputID,
+				ObjectName: "Zone Temp",
+			}, iHave, "Unexpected IHave")
+		})
+	}
+}
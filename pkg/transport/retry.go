@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+const (
+	// DefaultAPDUTimeout and DefaultAPDURetries are the defaults of the APDU_Timeout and
+	// Number_Of_APDU_Retries properties of the device object (12.11 in the spec).
+	DefaultAPDUTimeout = 3 * time.Second
+	DefaultAPDURetries = 3
+)
+
+type (
+	// RetryStrategy decides when SendAndReceive sends a confirmed request again because it didn't get a
+	// response (5.4.4.1 in the spec). Each retry has the same invoke ID, so a response to any of them
+	// completes the request.
+	RetryStrategy interface {
+		// Timeout returns how long to wait for the response after the attempt, which is 0 for the first time
+		// the request is sent, and whether to make the attempt. The request fails with ErrNoResponse when the
+		// attempt isn't made.
+		Timeout(attempt int) (time.Duration, bool)
+		// Validate checks the settings, so NewConnection rejects a strategy that would send the request again
+		// without waiting.
+		Validate() error
+	}
+
+	// FixedRetry waits the same time for every attempt, like APDU_Timeout and Number_Of_APDU_Retries.
+	FixedRetry struct {
+		Interval time.Duration
+		Retries  int
+	}
+
+	// ExponentialBackoff multiplies the time it waits by Multiplier for each retry, up to Max, if it's set.
+	// Jitter is the fraction of the time that can be added at random, so that devices that lost the same
+	// response don't retry at the same time.
+	ExponentialBackoff struct {
+		Initial    time.Duration
+		Multiplier float64
+		Max        time.Duration
+		Jitter     float64
+		Retries    int
+	}
+)
+
+var (
+	_ RetryStrategy = (*FixedRetry)(nil)
+	_ RetryStrategy = (*ExponentialBackoff)(nil)
+)
+
+// DefaultRetryStrategy is the strategy of a connection without WithRetryStrategy. It uses the spec's defaults,
+// so a request fails with ErrNoResponse after DefaultAPDURetries+1 attempts of DefaultAPDUTimeout each, even
+// if the context of SendAndReceive allows longer.
+func DefaultRetryStrategy() RetryStrategy {
+	return &FixedRetry{Interval: DefaultAPDUTimeout, Retries: DefaultAPDURetries}
+}
+
+// WithRetryStrategy sets when confirmed requests are sent again. A nil strategy keeps the default.
+func WithRetryStrategy(strategy RetryStrategy) ConnectionOption {
+	return func(c *connection) {
+		if strategy != nil {
+			c.retry = strategy
+		}
+	}
+}
+
+// Timeout waits Interval for every attempt, and makes Retries attempts after the first.
+func (f *FixedRetry) Timeout(attempt int) (time.Duration, bool) {
+	return f.Interval, attempt <= f.Retries
+}
+
+// Validate checks that there is an interval to wait.
+func (f *FixedRetry) Validate() error {
+	if f.Interval <= 0 {
+		return fmt.Errorf("retry interval %s: %w", f.Interval, bacnet.ErrInvalidData)
+	}
+	return nil
+}
+
+// Timeout waits Initial for the first attempt, and Multiplier times longer for each retry, capped at Max. The
+// jitter is added after the cap, so a timeout can be up to Max*(1+Jitter).
+func (e *ExponentialBackoff) Timeout(attempt int) (time.Duration, bool) {
+	if attempt > e.Retries {
+		return 0, false
+	}
+	timeout := float64(e.Initial)
+	for i := 0; i < attempt; i++ {
+		timeout *= e.Multiplier
+		if e.Max > 0 && timeout >= float64(e.Max) {
+			timeout = float64(e.Max)
+			break
+		}
+	}
+	if e.Jitter > 0 {
+		timeout += timeout * e.Jitter * rand.Float64()
+	}
+	return time.Duration(timeout), true
+}
+
+// Validate checks that there is an initial time to wait, and that it doesn't shrink, so the retries are never
+// sent without waiting. A Max, if it's set, can't be less than Initial, or it would cap the first attempt.
+func (e *ExponentialBackoff) Validate() error {
+	if e.Initial <= 0 {
+		return fmt.Errorf("initial backoff %s: %w", e.Initial, bacnet.ErrInvalidData)
+	}
+	if e.Multiplier < 1 {
+		return fmt.Errorf("backoff multiplier %g: %w", e.Multiplier, bacnet.ErrInvalidData)
+	}
+	if e.Max != 0 && e.Max < e.Initial {
+		return fmt.Errorf("max backoff %s is less than the initial %s: %w", e.Max, e.Initial, bacnet.ErrInvalidData)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+func TestRetryStrategies(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		strategy := DefaultRetryStrategy()
+		for attempt := 0; attempt <= DefaultAPDURetries; attempt++ {
+			timeout, ok := strategy.Timeout(attempt)
+			assert.True(t, ok, "Attempt %d should be made", attempt)
+			assert.Equal(t, DefaultAPDUTimeout, timeout, "Unexpected timeout for attempt %d", attempt)
+		}
+		_, ok := strategy.Timeout(DefaultAPDURetries + 1)
+		assert.False(t, ok, "Too many attempts")
+	})
+
+	t.Run("exponential", func(t *testing.T) {
+		strategy := &ExponentialBackoff{Initial: time.Second, Multiplier: 2, Max: 5 * time.Second, Retries: 4}
+		expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second,
+			5 * time.Second}
+		for attempt, expectedTimeout := range expected {
+			timeout, ok := strategy.Timeout(attempt)
+			assert.True(t, ok, "Attempt %d should be made", attempt)
+			assert.Equal(t, expectedTimeout, timeout, "Unexpected timeout for attempt %d", attempt)
+		}
+		_, ok := strategy.Timeout(len(expected))
+		assert.False(t, ok, "Too many attempts")
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		strategy := &ExponentialBackoff{Initial: time.Second, Multiplier: 2, Jitter: 0.5, Retries: 3}
+		for attempt := 0; attempt <= 3; attempt++ {
+			base := time.Second << attempt
+			timeout, _ := strategy.Timeout(attempt)
+			assert.GreaterOrEqual(t, timeout, base, "Jitter should only add")
+			assert.LessOrEqual(t, timeout, base+base/2, "Jitter should be at most half")
+		}
+	})
+}
+
+func TestRetryStrategyValidation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy RetryStrategy
+		valid    bool
+	}{
+		{"nil keeps the default", nil, true},
+		{"fixed", &FixedRetry{Interval: time.Second, Retries: 3}, true},
+		{"fixed without interval", &FixedRetry{Retries: 3}, false},
+		{"exponential", &ExponentialBackoff{Initial: time.Second, Multiplier: 2, Retries: 3}, true},
+		{"exponential without initial", &ExponentialBackoff{Multiplier: 2, Retries: 3}, false},
+		{"exponential without multiplier", &ExponentialBackoff{Initial: time.Second, Retries: 3}, false},
+		{"exponential shrinking", &ExponentialBackoff{Initial: time.Second, Multiplier: 0.5, Retries: 3}, false},
+		{"exponential with max", &ExponentialBackoff{Initial: time.Second, Multiplier: 2, Max: time.Second,
+			Retries: 3}, true},
+		{"exponential max below initial", &ExponentialBackoff{Initial: time.Second, Multiplier: 2,
+			Max: time.Millisecond, Retries: 3}, false},
+	}
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, err := NewConnection([]byte{127, 0, 0, 1}, 8, WithPacketConn(NewMemoryPacketConn(loopback)),
+				WithRetryStrategy(tCase.strategy))
+			if !tCase.valid {
+				assert.ErrorIs(t, err, bacnet.ErrInvalidData, "Expected an invalid strategy")
+				return
+			}
+			assert.NoError(t, err, "Unexpected error creating connection")
+			assert.NotNil(t, conn.(*connection).retry, "Expected a strategy")
+			assert.NoError(t, conn.Close(), "Error closing connection")
+		})
+	}
+}
+
+func TestSendAndReceiveRetries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy RetryStrategy
+		// expected are the times between the attempts
+		expected []time.Duration
+	}{
+		{"fixed", &FixedRetry{Interval: 50 * time.Millisecond, Retries: 2},
+			[]time.Duration{50 * time.Millisecond, 50 * time.Millisecond}},
+		{"exponential", &ExponentialBackoff{Initial: 25 * time.Millisecond, Multiplier: 3, Retries: 2},
+			[]time.Duration{25 * time.Millisecond, 75 * time.Millisecond}},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			conn, _ := newMemoryConnection(t, WithRetryStrategy(tCase.strategy))
+			router := &heldRequestRouter{requests: make(chan uint8, len(tCase.expected)+1)}
+			conn.SetMessageRouter(router)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+			msg, err := apdu.NewReadPropertyMessage(0, objectID, bacnet.PropertyIdentifierObjectName, nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			result := make(chan error, 1)
+			go func() {
+				_, err := conn.SendAndReceive(ctx, net.IPv4(127, 0, 0, 1), msg)
+				result <- err
+			}()
+
+			// The times are when the router got each attempt, so they can be off a little either way
+			const slack = 10 * time.Millisecond
+			invokeID := <-router.requests
+			last := time.Now()
+			for i, interval := range tCase.expected {
+				assert.Equal(t, invokeID, <-router.requests, "Retry %d should have the same invoke ID", i+1)
+				elapsed := time.Since(last)
+				last = time.Now()
+				assert.GreaterOrEqual(t, elapsed, interval-slack, "Retry %d was too soon", i+1)
+				assert.Less(t, elapsed, interval+10*slack, "Retry %d was too late", i+1)
+			}
+			assert.ErrorIs(t, <-result, ErrNoResponse, "Expected no response after the last attempt")
+			assert.Len(t, router.requests, 0, "Unexpected attempt")
+		})
+	}
+}
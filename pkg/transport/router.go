@@ -3,6 +3,7 @@ package transport
 import (
 	"context"
 	"errors"
+	"net"
 	"sync"
 
 	"github.com/shigmas/modore/internal/apdu"
@@ -11,19 +12,67 @@ import (
 
 type (
 	// MessageNexus handles message routing and will accept register requests.
+	// The registries are keyed by filter, but messages are delivered from the ordered lists, since the
+	// order of a map is random.
 	MessageNexus struct {
 		bvlcRegistry map[uint8][]BVLCMessageHandler
+		bvlcOrder    []handlerRegistration[BVLCMessageHandler]
 		bvlcMux      sync.RWMutex
 		npduRegistry map[uint8][]NPDUMessageHandler
+		npduOrder    []handlerRegistration[NPDUMessageHandler]
 		npduMux      sync.RWMutex
 		apduRegistry map[uint8][]APDUMessageHandler
+		apduOrder    []handlerRegistration[APDUMessageHandler]
 		apduMux      sync.RWMutex
+		// Confirmed requests are filtered by the service, not a mask of them
+		confirmedRegistry map[uint8][]APDUMessageHandler
+		confirmedOrder    []handlerRegistration[APDUMessageHandler]
+		confirmedMux      sync.RWMutex
 
 		wg             sync.WaitGroup
 		stopFunc       context.CancelFunc
 		defaultHandler *BVLCNPDURouterHandler
 	}
 
+	// MessageNexusOptions configures the handlers that the MessageNexus registers for itself. The zero value
+	// is the default behavior.
+	MessageNexusOptions struct {
+		// OmitDefaultHandlers does not register the default handler, so the registries start empty. The
+		// caller is responsible for getting BVLC messages to NPDU handlers, and NPDU messages to APDU
+		// handlers.
+		OmitDefaultHandlers bool
+		// BVLCHandler, if set, replaces the default handler for broadcast and unicast BVLC messages.
+		BVLCHandler BVLCMessageHandler
+		// NPDUHandler, if set, replaces the default handler for NPDU messages.
+		NPDUHandler NPDUMessageHandler
+	}
+
+	// handlerRegistration is a handler in the ordered list, which is sorted by priority, and then by when it
+	// was registered.
+	handlerRegistration[HandlerType Equatable] struct {
+		filter   uint8
+		priority int
+		handler  HandlerType
+	}
+
+	// BVLCNPDUMessage is the NPDU message that the default handler passes to the NPDU handlers. It keeps the
+	// BVLC function that it arrived in, so a responder can tell if a request was broadcast (reply with
+	// unicast) or unicast (reply in kind), and the sender, so it knows where to reply.
+	BVLCNPDUMessage struct {
+		npdu.Message
+		Function BVLCFunction
+		Sender   *net.UDPAddr
+	}
+
+	// ConfirmedRequest is the confirmed request that the default handler passes to the confirmed APDU
+	// handlers. It keeps the data expecting reply bit of the NPDU, since a request without it shouldn't be
+	// answered, and the sender, which is where the response goes.
+	ConfirmedRequest struct {
+		*apdu.ConfirmedMessage
+		ExpectingReply bool
+		Sender         *net.UDPAddr
+	}
+
 	// BVLCNPDURouterHandler handles registers itself with the MessageNexus to handle BVLCMessages and NPDU
 	// messages. It will use the nexus's registry to check for other handlers as well. (it will find itself
 	// in the registry, although it doesn't really matter.
@@ -31,9 +80,30 @@ type (
 		registrar MessageRegistrar
 		bvlcCh    BVLCMessageChannel
 		npduCh    NPDUMessageChannel
+
+		unhandledMux sync.RWMutex
+		onUnhandled  UnhandledFunc
 	}
+
+	// UnhandledFunc is called with the messages that the default handler couldn't decode, so they are
+	// dropped. layer is which layer couldn't be decoded (UnhandledLayerBVLC, UnhandledLayerNPDU or
+	// UnhandledLayerAPDU), and raw is the bytes of that layer. msgType is the BVLC function, the NPDU
+	// message type, or for the APDU, the service choice of an unconfirmed request, or the PDU type of the
+	// others.
+	UnhandledFunc func(layer string, msgType uint8, raw []byte)
+)
+
+// The layers for UnhandledFunc
+const (
+	UnhandledLayerBVLC = "bvlc"
+	UnhandledLayerNPDU = "npdu"
+	UnhandledLayerAPDU = "apdu"
 )
 
+// DefaultHandlerPriority is the priority of handlers that are registered without one. Handlers with a higher
+// priority get messages first.
+const DefaultHandlerPriority = 0
+
 var (
 	_ MessageRouter      = (*MessageNexus)(nil)
 	_ MessageRegistrar   = (*MessageNexus)(nil)
@@ -62,13 +132,70 @@ func (b *BVLCNPDURouterHandler) Equals(other Equatable) bool {
 	return false
 }
 
+// IsBroadcast is true if the message arrived as an original broadcast.
+func (m *BVLCNPDUMessage) IsBroadcast() bool {
+	return m.Function == BVLCFunctioncBroadcast
+}
+
+// Clone copies the request, keeping the expecting reply bit and the sender.
+func (r *ConfirmedRequest) Clone() apdu.Message {
+	return &ConfirmedRequest{
+		ConfirmedMessage: r.ConfirmedMessage.Clone().(*apdu.ConfirmedMessage),
+		ExpectingReply:   r.ExpectingReply,
+		Sender:           r.Sender,
+	}
+}
+
 func (b *BVLCNPDURouterHandler) getNPDUMessageFromBVLCMessage(msg *BVLCMessage) (npdu.Message, error) {
 	// I think only broadcast and unicast messages can have NPDU? Forward also does, but we
 	// don't forward.
 	if msg.Function != BVLCFunctioncBroadcast && msg.Function != BVLCFunctioncUnicast {
+		b.unhandled(UnhandledLayerBVLC, uint8(msg.Function), msg.Data)
 		return nil, errors.New("Invalid BVLCFunction type for this handler")
 	}
-	return npdu.NewMessageFromBytes(msg.Data)
+	npduMsg, err := npdu.NewMessageFromBytes(msg.Data)
+	if err != nil {
+		var apduErr *npdu.APDUDecodeError
+		if errors.As(err, &apduErr) {
+			b.unhandled(UnhandledLayerAPDU, apduMessageType(apduErr.APDU), apduErr.APDU)
+		} else {
+			var msgType uint8
+			if len(msg.Data) > 1 {
+				msgType = msg.Data[1]
+			}
+			b.unhandled(UnhandledLayerNPDU, msgType, msg.Data)
+		}
+		return nil, err
+	}
+	return &BVLCNPDUMessage{Message: npduMsg, Function: msg.Function, Sender: msg.Sender}, nil
+}
+
+// setOnUnhandled sets the function that is called with the messages that couldn't be decoded.
+func (b *BVLCNPDURouterHandler) setOnUnhandled(f UnhandledFunc) {
+	b.unhandledMux.Lock()
+	defer b.unhandledMux.Unlock()
+	b.onUnhandled = f
+}
+
+func (b *BVLCNPDURouterHandler) unhandled(layer string, msgType uint8, raw []byte) {
+	b.unhandledMux.RLock()
+	f := b.onUnhandled
+	b.unhandledMux.RUnlock()
+	if f != nil {
+		f(layer, msgType, raw)
+	}
+}
+
+// apduMessageType is the msgType of an APDU for UnhandledFunc.
+func apduMessageType(data []byte) uint8 {
+	if len(data) == 0 {
+		return 0
+	}
+	pduType := apdu.PDUType(data[0] & 0xF0)
+	if pduType == apdu.PDUTypeUnconfirmedServiceRequest && len(data) > 1 {
+		return data[1]
+	}
+	return uint8(pduType)
 }
 
 func (b *BVLCNPDURouterHandler) Start(done <-chan struct{}, wg *sync.WaitGroup) {
@@ -82,14 +209,9 @@ func (b *BVLCNPDURouterHandler) Start(done <-chan struct{}, wg *sync.WaitGroup)
 					// implement some error handling for this
 					continue
 				}
-				//for filter, npduHandlers := range b.registrar.GetNPDUHandlers() {
-				for _, npduHandlers := range b.registrar.GetNPDUHandlers() {
-					// Damn it. type can be 0, which can't be &'ed
-					//					if filter&uint8(npduMsg.GetMessageType()) > 0 {
-					for _, h := range npduHandlers {
-						h.GetNPDUChannel() <- npduMsg
-					}
-					//				}
+				// Damn it. type can be 0, which can't be &'ed, so every NPDU handler gets every message
+				for _, h := range b.registrar.OrderedNPDUHandlers() {
+					h.GetNPDUChannel() <- npduMsg
 				}
 			case <-done:
 				wg.Done()
@@ -102,15 +224,22 @@ func (b *BVLCNPDURouterHandler) Start(done <-chan struct{}, wg *sync.WaitGroup)
 			select {
 			case npduMsg := <-b.npduCh:
 				apduMsg := npduMsg.GetAPDUMessage()
-				unconfirmed, ok := apduMsg.(*apdu.UnconfirmedMessage)
-				if !ok {
-					continue
-				}
-				for filter, apduHandlers := range b.registrar.GetAPDUHandlers() {
-					if filter&uint8(unconfirmed.ServiceID) > 0 {
-						for _, h := range apduHandlers {
-							h.GetAPDUChannel() <- &apduMsg
-						}
+				switch msg := apduMsg.(type) {
+				case *apdu.UnconfirmedMessage:
+					for _, h := range b.registrar.OrderedAPDUHandlers(msg.ServiceID) {
+						h.GetAPDUChannel() <- &apduMsg
+					}
+				case *apdu.ConfirmedMessage:
+					request := &ConfirmedRequest{
+						ConfirmedMessage: msg,
+						ExpectingReply:   npduMsg.IsExpectingReply(),
+					}
+					if received, ok := npduMsg.(*BVLCNPDUMessage); ok {
+						request.Sender = received.Sender
+					}
+					var requestMsg apdu.Message = request
+					for _, h := range b.registrar.OrderedConfirmedAPDUHandlers(msg.ServiceID) {
+						h.GetAPDUChannel() <- &requestMsg
 					}
 				}
 
@@ -122,56 +251,154 @@ func (b *BVLCNPDURouterHandler) Start(done <-chan struct{}, wg *sync.WaitGroup)
 	}()
 }
 
+// NewMessageNexus creates a MessageNexus with the default handlers registered for BVLC and NPDU messages.
 func NewMessageNexus() *MessageNexus {
+	return NewMessageNexusWithOptions(MessageNexusOptions{})
+}
+
+// NewMessageNexusWithOptions creates a MessageNexus where the default handlers can be omitted or replaced.
+// Replacement handlers are not started by the nexus.
+func NewMessageNexusWithOptions(opts MessageNexusOptions) *MessageNexus {
 	nexus := MessageNexus{
 		bvlcRegistry: make(map[uint8][]BVLCMessageHandler),
 		npduRegistry: make(map[uint8][]NPDUMessageHandler),
 		apduRegistry: make(map[uint8][]APDUMessageHandler),
+
+		confirmedRegistry: make(map[uint8][]APDUMessageHandler),
+	}
+	if opts.OmitDefaultHandlers {
+		return &nexus
 	}
-	nexus.defaultHandler = newBVLCNPDURouterHandler(&nexus)
-	nexus.RegisterBVLCHandler(BVLCFunctioncBroadcast|BVLCFunctioncUnicast, nexus.defaultHandler)
-	nexus.RegisterNPDUHandler(npdu.NetworkLayerWhoIsMessage|npdu.NetworkLayerIAmMessage, nexus.defaultHandler)
+
+	bvlcHandler := opts.BVLCHandler
+	npduHandler := opts.NPDUHandler
+	if bvlcHandler == nil || npduHandler == nil {
+		nexus.defaultHandler = newBVLCNPDURouterHandler(&nexus)
+		if bvlcHandler == nil {
+			bvlcHandler = nexus.defaultHandler
+		}
+		if npduHandler == nil {
+			npduHandler = nexus.defaultHandler
+		}
+	}
+	nexus.RegisterBVLCHandler(BVLCFunctioncBroadcast|BVLCFunctioncUnicast, bvlcHandler)
+	nexus.RegisterNPDUHandler(npdu.NetworkLayerWhoIsMessage|npdu.NetworkLayerIAmMessage, npduHandler)
 
 	return &nexus
 }
 
+// OnUnhandled sets the function that is called with the messages that the default handler couldn't decode,
+// instead of dropping them silently. It has no effect if the default handlers were omitted or replaced.
+func (n *MessageNexus) OnUnhandled(f UnhandledFunc) {
+	if n.defaultHandler != nil {
+		n.defaultHandler.setOnUnhandled(f)
+	}
+}
+
 func (n *MessageNexus) Start() {
 	ctx, stopFunc := context.WithCancel(context.Background())
-	n.wg.Add(2)
-	n.defaultHandler.Start(ctx.Done(), &n.wg)
+	if n.defaultHandler != nil {
+		n.wg.Add(2)
+		n.defaultHandler.Start(ctx.Done(), &n.wg)
+	}
 	n.stopFunc = stopFunc
 }
 
+// Stop stops the default handlers. It does nothing if the nexus wasn't started, and it can be called again.
 func (n *MessageNexus) Stop() {
-	n.stopFunc()
-	n.wg.Wait()
+	if n.stopFunc != nil {
+		n.stopFunc()
+		n.wg.Wait()
+	}
 }
 
+// RouteMessage passes the message to the BVLC handlers that match it, in priority order.
 func (n *MessageNexus) RouteMessage(message *BVLCMessage) error {
-	n.bvlcMux.RLock()
-	defer n.bvlcMux.RUnlock()
-
-	for filter, handlers := range n.bvlcRegistry {
-		if filter&uint8(message.Function) != 0 {
-			// filter match. Iterate through the handlers and pass the message
-			for _, handler := range handlers {
-				handler.GetBVLCChannel() <- message
-			}
-		}
+	for _, handler := range n.OrderedBVLCHandlers(message.Function) {
+		handler.GetBVLCChannel() <- message
 	}
 	return nil
 }
 
 func (n *MessageNexus) RegisterBVLCHandler(newFilter BVLCFunction, handler BVLCMessageHandler) {
-	registerGeneric(uint8(newFilter), handler, n.bvlcRegistry, &n.bvlcMux)
+	n.RegisterBVLCHandlerWithPriority(newFilter, DefaultHandlerPriority, handler)
 }
 
 func (n *MessageNexus) RegisterNPDUHandler(newFilter npdu.NetworkLayerMessageType, handler NPDUMessageHandler) {
-	registerGeneric(uint8(newFilter), handler, n.npduRegistry, &n.npduMux)
+	n.RegisterNPDUHandlerWithPriority(newFilter, DefaultHandlerPriority, handler)
 }
 
 func (n *MessageNexus) RegisterAPDUHandler(newFilter apdu.ServiceUnconfirmed, handler APDUMessageHandler) {
-	registerGeneric(uint8(newFilter), handler, n.apduRegistry, &n.apduMux)
+	n.RegisterAPDUHandlerWithPriority(newFilter, DefaultHandlerPriority, handler)
+}
+
+// RegisterConfirmedAPDUHandler registers the handler for the confirmed requests for the service. They are
+// delivered as a *ConfirmedRequest.
+func (n *MessageNexus) RegisterConfirmedAPDUHandler(service apdu.ServiceConfirmed, handler APDUMessageHandler) {
+	n.RegisterConfirmedAPDUHandlerWithPriority(service, DefaultHandlerPriority, handler)
+}
+
+// RegisterBVLCHandlerWithPriority registers the handler so it gets messages before handlers with a lower
+// priority. Handlers with the same priority get messages in the order that they were registered.
+func (n *MessageNexus) RegisterBVLCHandlerWithPriority(newFilter BVLCFunction, priority int,
+	handler BVLCMessageHandler) {
+	registerGeneric(uint8(newFilter), priority, handler, n.bvlcRegistry, &n.bvlcOrder, &n.bvlcMux)
+}
+
+// RegisterNPDUHandlerWithPriority is like RegisterBVLCHandlerWithPriority, for NPDU handlers.
+func (n *MessageNexus) RegisterNPDUHandlerWithPriority(newFilter npdu.NetworkLayerMessageType, priority int,
+	handler NPDUMessageHandler) {
+	registerGeneric(uint8(newFilter), priority, handler, n.npduRegistry, &n.npduOrder, &n.npduMux)
+}
+
+// RegisterAPDUHandlerWithPriority is like RegisterBVLCHandlerWithPriority, for APDU handlers.
+func (n *MessageNexus) RegisterAPDUHandlerWithPriority(newFilter apdu.ServiceUnconfirmed, priority int,
+	handler APDUMessageHandler) {
+	registerGeneric(uint8(newFilter), priority, handler, n.apduRegistry, &n.apduOrder, &n.apduMux)
+}
+
+// RegisterConfirmedAPDUHandlerWithPriority is like RegisterBVLCHandlerWithPriority, for confirmed APDU
+// handlers.
+func (n *MessageNexus) RegisterConfirmedAPDUHandlerWithPriority(service apdu.ServiceConfirmed, priority int,
+	handler APDUMessageHandler) {
+	registerGeneric(uint8(service), priority, handler, n.confirmedRegistry, &n.confirmedOrder, &n.confirmedMux)
+}
+
+// UnregisterAPDUHandler removes the handler from every filter that it was registered for, so it doesn't get
+// any more messages. Messages that were already sent to its channel are still there.
+func (n *MessageNexus) UnregisterAPDUHandler(handler APDUMessageHandler) {
+	unregisterGeneric(handler, n.apduRegistry, &n.apduOrder, &n.apduMux)
+}
+
+// OrderedBVLCHandlers returns the handlers whose filter matches the function, in the order that they get
+// messages.
+func (n *MessageNexus) OrderedBVLCHandlers(function BVLCFunction) []BVLCMessageHandler {
+	return orderedGeneric(&n.bvlcOrder, &n.bvlcMux, func(filter uint8) bool {
+		return filter&uint8(function) != 0
+	})
+}
+
+// OrderedNPDUHandlers returns all of the NPDU handlers, in the order that they get messages.
+func (n *MessageNexus) OrderedNPDUHandlers() []NPDUMessageHandler {
+	return orderedGeneric(&n.npduOrder, &n.npduMux, func(uint8) bool {
+		return true
+	})
+}
+
+// OrderedAPDUHandlers returns the handlers whose filter matches the service, in the order that they get
+// messages.
+func (n *MessageNexus) OrderedAPDUHandlers(service apdu.ServiceUnconfirmed) []APDUMessageHandler {
+	return orderedGeneric(&n.apduOrder, &n.apduMux, func(filter uint8) bool {
+		return filter&uint8(service) > 0
+	})
+}
+
+// OrderedConfirmedAPDUHandlers returns the handlers for the confirmed service, in the order that they get
+// messages.
+func (n *MessageNexus) OrderedConfirmedAPDUHandlers(service apdu.ServiceConfirmed) []APDUMessageHandler {
+	return orderedGeneric(&n.confirmedOrder, &n.confirmedMux, func(filter uint8) bool {
+		return filter == uint8(service)
+	})
 }
 
 func (n *MessageNexus) GetBVLCHandlers() map[uint8][]BVLCMessageHandler {
@@ -185,32 +412,81 @@ func (n *MessageNexus) GetAPDUHandlers() map[uint8][]APDUMessageHandler {
 	return n.apduRegistry
 }
 
+func (n *MessageNexus) GetConfirmedAPDUHandlers() map[uint8][]APDUMessageHandler {
+	return n.confirmedRegistry
+}
+
 // This is not a great use of generics. But, since we are inserting into a collection (or, even, a
 // collection of a collection), generics lets us keep the type of the collection while still using only one
 // function instead of 3.
 // Also, hide this so the API hides 1.18'isms.
-func registerGeneric[HandlerType Equatable](newFilter uint8, handler HandlerType,
-	handlerMap map[uint8][]HandlerType, mux *sync.RWMutex) {
-	mux.RLock()
-	var writeHandlers []HandlerType
-	for filter, handlers := range handlerMap {
-		if filter == uint8(newFilter) {
-			writeHandlers = handlers
+func registerGeneric[HandlerType Equatable](newFilter uint8, priority int, handler HandlerType,
+	handlerMap map[uint8][]HandlerType, order *[]handlerRegistration[HandlerType], mux *sync.RWMutex) {
+	// The lookup and the write need to be under the same lock, or two callers can both see no handlers
+	// for the filter, and one will overwrite the other.
+	mux.Lock()
+	defer mux.Unlock()
+	writeHandlers := handlerMap[newFilter]
+	if isRegistered(handler, writeHandlers) {
+		return
+	}
+	handlerMap[newFilter] = append(writeHandlers, handler)
+
+	// Insert after everything with the same or higher priority, so registration order breaks ties
+	index := len(*order)
+	for i, r := range *order {
+		if r.priority < priority {
+			index = i
 			break
 		}
 	}
-	mux.RUnlock()
+	registration := handlerRegistration[HandlerType]{filter: newFilter, priority: priority, handler: handler}
+	*order = append(*order, registration)
+	copy((*order)[index+1:], (*order)[index:])
+	(*order)[index] = registration
+}
 
+// unregisterGeneric removes the handler from the registry and the ordered list, under the same lock as
+// registerGeneric.
+func unregisterGeneric[HandlerType Equatable](handler HandlerType, handlerMap map[uint8][]HandlerType,
+	order *[]handlerRegistration[HandlerType], mux *sync.RWMutex) {
 	mux.Lock()
 	defer mux.Unlock()
-	if writeHandlers != nil {
-		if !isRegistered(handler, writeHandlers) {
-			writeHandlers = append(writeHandlers, handler)
+	for filter, handlers := range handlerMap {
+		var kept []HandlerType
+		for _, h := range handlers {
+			if !h.Equals(handler) {
+				kept = append(kept, h)
+			}
+		}
+		if len(kept) == 0 {
+			delete(handlerMap, filter)
+		} else {
+			handlerMap[filter] = kept
+		}
+	}
+	var kept []handlerRegistration[HandlerType]
+	for _, r := range *order {
+		if !r.handler.Equals(handler) {
+			kept = append(kept, r)
+		}
+	}
+	*order = kept
+}
+
+// orderedGeneric copies the handlers that match out of the ordered list, so they can be used without holding
+// the lock.
+func orderedGeneric[HandlerType Equatable](order *[]handlerRegistration[HandlerType], mux *sync.RWMutex,
+	matches func(filter uint8) bool) []HandlerType {
+	mux.RLock()
+	defer mux.RUnlock()
+	var handlers []HandlerType
+	for _, r := range *order {
+		if matches(r.filter) {
+			handlers = append(handlers, r.handler)
 		}
-	} else {
-		writeHandlers = []HandlerType{handler}
 	}
-	handlerMap[uint8(newFilter)] = writeHandlers
+	return handlers
 }
 
 func isRegistered[HandlerType Equatable](handler HandlerType, existing []HandlerType) bool {
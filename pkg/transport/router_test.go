@@ -2,11 +2,13 @@ package transport
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/shigmas/modore/internal/apdu"
 	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -128,3 +130,270 @@ func TestMessageNexus(t *testing.T) {
 
 	})
 }
+
+func TestMessageNexusConfirmed(t *testing.T) {
+	nexus := NewMessageNexus()
+	readHandler := &testAPDUMessageHandler{ch: make(APDUMessageChannel, 2)}
+	writeHandler := &testAPDUMessageHandler{ch: make(APDUMessageChannel, 2)}
+	nexus.RegisterConfirmedAPDUHandler(apdu.ServiceConfirmedReadProperty, readHandler)
+	nexus.RegisterConfirmedAPDUHandler(apdu.ServiceConfirmedWriteProperty, writeHandler)
+	nexus.Start()
+	defer nexus.Stop()
+
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	for _, expectingReply := range []bool{true, false} {
+		msg, err := apdu.NewReadPropertyMessage(3, objectID, bacnet.PropertyIdentifierObjectName, nil)
+		assert.NoError(t, err, "Unexpected error creating ReadProperty")
+		npduBytes, err := npdu.NewMessage(npdu.NormalMessage, expectingReply, false, nil, nil, DefaultHopCount, 0,
+			nil, msg).Encode()
+		assert.NoError(t, err, "Unexpected error encoding NPDU")
+		assert.NoError(t, nexus.RouteMessage(NewBVLCMessage(BVLCFunctioncUnicast, npduBytes)),
+			"Unexpected error routing")
+
+		select {
+		case received := <-readHandler.ch:
+			request, ok := (*received).(*ConfirmedRequest)
+			if assert.True(t, ok, "Expected a ConfirmedRequest, got %T", *received) {
+				assert.Equal(t, expectingReply, request.ExpectingReply, "Unexpected expecting reply")
+				assert.Equal(t, uint8(3), request.InvokeID, "Unexpected invoke ID")
+				assert.Equal(t, apdu.ServiceConfirmed(apdu.ServiceConfirmedReadProperty), request.ServiceID,
+					"Unexpected service")
+			}
+		case <-time.After(time.Second):
+			assert.Fail(t, "Timed out waiting for the confirmed request")
+		}
+	}
+	assert.Empty(t, writeHandler.ch, "The handler for another service got the request")
+}
+
+func TestMessageNexusWithOptions(t *testing.T) {
+	t.Run("omit defaults", func(t *testing.T) {
+		nexus := NewMessageNexusWithOptions(MessageNexusOptions{OmitDefaultHandlers: true})
+		assert.Equal(t, 0, len(nexus.bvlcRegistry), "Unexpected number of entries in BVLC Registry")
+		assert.Equal(t, 0, len(nexus.npduRegistry), "Unexpected number of entries in NPDU Registry")
+		assert.Equal(t, 0, len(nexus.apduRegistry), "Unexpected number of entries in APDU Registry")
+		assert.Nil(t, nexus.defaultHandler, "Default handler should not be created")
+
+		// Start and Stop should still work without the default handler
+		nexus.Start()
+		nexus.Stop()
+	})
+
+	t.Run("replace defaults", func(t *testing.T) {
+		bHandler := newTestBVLCMessageHandler()
+		nHandler := newTestNPDUMessageHandler()
+		nexus := NewMessageNexusWithOptions(MessageNexusOptions{BVLCHandler: bHandler, NPDUHandler: nHandler})
+		assert.Nil(t, nexus.defaultHandler, "Default handler should not be created")
+		for _, handlers := range nexus.bvlcRegistry {
+			assert.Equal(t, []BVLCMessageHandler{bHandler}, handlers, "BVLC handler not replaced")
+		}
+		for _, handlers := range nexus.npduRegistry {
+			assert.Equal(t, []NPDUMessageHandler{nHandler}, handlers, "NPDU handler not replaced")
+		}
+	})
+}
+
+func TestMessageNexusStop(t *testing.T) {
+	t.Run("not started", func(t *testing.T) {
+		nexus := NewMessageNexus()
+		assert.NotPanics(t, nexus.Stop, "Stop without Start should do nothing")
+	})
+
+	t.Run("twice", func(t *testing.T) {
+		nexus := NewMessageNexus()
+		nexus.Start()
+		assert.NotPanics(t, nexus.Stop, "Unexpected panic stopping")
+		assert.NotPanics(t, nexus.Stop, "Unexpected panic stopping again")
+	})
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	const numHandlers = 50
+	nexus := NewMessageNexusWithOptions(MessageNexusOptions{OmitDefaultHandlers: true})
+
+	handlers := make([]*testAPDUMessageHandler, numHandlers)
+	for i := range handlers {
+		handlers[i] = newTestAPDUMessageHandler()
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, h := range handlers {
+		wg.Add(1)
+		go func(h *testAPDUMessageHandler) {
+			defer wg.Done()
+			<-start
+			nexus.RegisterAPDUHandler(apdu.ServiceUnconfirmedIAm, h)
+		}(h)
+	}
+	close(start)
+	wg.Wait()
+
+	registered := nexus.apduRegistry[uint8(apdu.ServiceUnconfirmedIAm)]
+	assert.Equal(t, numHandlers, len(registered), "Handlers were lost registering concurrently")
+	for _, h := range handlers {
+		assert.True(t, isRegistered[APDUMessageHandler](h, registered), "Handler not registered")
+	}
+}
+
+func TestBVLCFunctionThroughRouter(t *testing.T) {
+	whoIs, err := apdu.NewWhoisMessage(0, apdu.MaxInstanceNumber)
+	assert.NoError(t, err, "Unexpected error creating WhoIs")
+	npduBytes, err := npdu.NewMessage(npdu.NormalMessage, false, false, nil, nil, DefaultHopCount,
+		npdu.NetworkLayerWhoIsMessage, nil, whoIs).Encode()
+	assert.NoError(t, err, "Unexpected error encoding NPDU")
+
+	testCases := []struct {
+		name      string
+		function  BVLCFunction
+		broadcast bool
+	}{
+		{"broadcast", BVLCFunctioncBroadcast, true},
+		{"unicast", BVLCFunctioncUnicast, false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			nexus := NewMessageNexus()
+			nHandler := newTestNPDUMessageHandler()
+			nexus.RegisterNPDUHandler(npdu.NetworkLayerWhoIsMessage, nHandler)
+			nexus.Start()
+			defer nexus.Stop()
+
+			go nexus.RouteMessage(NewBVLCMessage(tCase.function, npduBytes))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			select {
+			case msg := <-nHandler.ch:
+				received, ok := msg.(*BVLCNPDUMessage)
+				assert.True(t, ok, "NPDU message does not have the BVLC function")
+				assert.Equal(t, tCase.function, received.Function, "BVLCFunction mismatch")
+				assert.Equal(t, tCase.broadcast, received.IsBroadcast(), "Broadcast mismatch")
+				assert.Equal(t, whoIs, received.GetAPDUMessage(), "APDU mismatch")
+			case <-ctx.Done():
+				assert.Fail(t, "Timeout waiting for message")
+			}
+		})
+	}
+}
+
+func TestPriorityThroughRouter(t *testing.T) {
+	whoIs, err := apdu.NewWhoisMessage(0, apdu.MaxInstanceNumber)
+	assert.NoError(t, err, "Unexpected error creating WhoIs")
+
+	testCases := []struct {
+		name     string
+		priority npdu.NetworkMessagePriority
+	}{
+		{"normal", npdu.NormalMessage},
+		{"critical", npdu.CriticalEquipmentMessage},
+		{"life safety", npdu.LifeSafetyMessage},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			npduBytes, err := npdu.NewMessage(tCase.priority, false, false, nil, nil, DefaultHopCount,
+				npdu.NetworkLayerWhoIsMessage, nil, whoIs).Encode()
+			assert.NoError(t, err, "Unexpected error encoding NPDU")
+
+			nexus := NewMessageNexus()
+			nHandler := newTestNPDUMessageHandler()
+			nexus.RegisterNPDUHandler(npdu.NetworkLayerWhoIsMessage, nHandler)
+			nexus.Start()
+			defer nexus.Stop()
+
+			go nexus.RouteMessage(NewBVLCMessage(BVLCFunctioncBroadcast, npduBytes))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			select {
+			case msg := <-nHandler.ch:
+				assert.Equal(t, tCase.priority, msg.GetPriority(), "Priority mismatch")
+				assert.Equal(t, whoIs, msg.GetAPDUMessage(), "APDU mismatch")
+			case <-ctx.Done():
+				assert.Fail(t, "Timeout waiting for message")
+			}
+		})
+	}
+}
+
+type (
+	// orderedBVLCMessageHandler records its name when the router gets its channel to deliver a message, so
+	// the test can see the order that the handlers are delivered to.
+	orderedBVLCMessageHandler struct {
+		name      string
+		ch        BVLCMessageChannel
+		delivered *[]string
+	}
+)
+
+func (o *orderedBVLCMessageHandler) GetBVLCChannel() BVLCMessageChannel {
+	*o.delivered = append(*o.delivered, o.name)
+	return o.ch
+}
+
+func (o *orderedBVLCMessageHandler) Equals(other Equatable) bool {
+	if other, ok := other.(*orderedBVLCMessageHandler); ok {
+		return o == other
+	}
+	return false
+}
+
+func TestHandlerPriority(t *testing.T) {
+	nexus := NewMessageNexusWithOptions(MessageNexusOptions{OmitDefaultHandlers: true})
+	var delivered []string
+	newHandler := func(name string) *orderedBVLCMessageHandler {
+		// Buffered, so a wrong delivery fails the test instead of blocking it
+		return &orderedBVLCMessageHandler{name: name, ch: make(BVLCMessageChannel, 8), delivered: &delivered}
+	}
+
+	first := newHandler("first")
+	second := newHandler("second")
+	high := newHandler("high")
+	forwarded := newHandler("forwarded")
+	both := newHandler("both")
+	nexus.RegisterBVLCHandler(BVLCFunctioncUnicast, first)
+	nexus.RegisterBVLCHandler(BVLCFunctioncUnicast, second)
+	nexus.RegisterBVLCHandlerWithPriority(BVLCFunctioncUnicast, 10, high)
+	// The filters are bit masks, so this is the only function that doesn't overlap with unicast
+	nexus.RegisterBVLCHandlerWithPriority(BVLCFunctioncForwardedNPDU, 20, forwarded)
+	nexus.RegisterBVLCHandlerWithPriority(BVLCFunctioncBroadcast|BVLCFunctioncUnicast, 5, both)
+
+	for i := 0; i < 3; i++ {
+		delivered = nil
+		assert.NoError(t, nexus.RouteMessage(&BVLCMessage{Function: BVLCFunctioncUnicast}), "Unable to route")
+		assert.Equal(t, []string{"high", "both", "first", "second"}, delivered, "Unexpected delivery order")
+	}
+
+	delivered = nil
+	assert.NoError(t, nexus.RouteMessage(&BVLCMessage{Function: BVLCFunctioncForwardedNPDU}), "Unable to route")
+	assert.Equal(t, []string{"forwarded"}, delivered, "Unexpected delivery order")
+}
+
+func TestOnUnhandled(t *testing.T) {
+	type unhandled struct {
+		layer   string
+		msgType uint8
+		raw     []byte
+	}
+	// An unconfirmed EventNotification, which we can't decode. The service data doesn't matter.
+	eventNotification := []byte{byte(apdu.PDUTypeUnconfirmedServiceRequest),
+		byte(apdu.ServiceUnconfirmedEventNotification), 0x09, 0x01}
+	npduBytes := append([]byte{npdu.DefaultProtocolVersion, 0x00}, eventNotification...)
+
+	nexus := NewMessageNexus()
+	ch := make(chan unhandled, 1)
+	nexus.OnUnhandled(func(layer string, msgType uint8, raw []byte) {
+		ch <- unhandled{layer, msgType, raw}
+	})
+	nexus.Start()
+	defer nexus.Stop()
+
+	assert.NoError(t, nexus.RouteMessage(NewBVLCMessage(BVLCFunctioncUnicast, npduBytes)), "Unable to route")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	select {
+	case msg := <-ch:
+		assert.Equal(t, UnhandledLayerAPDU, msg.layer, "Layer mismatch")
+		assert.Equal(t, uint8(apdu.ServiceUnconfirmedEventNotification), msg.msgType, "Message type mismatch")
+		assert.Equal(t, eventNotification, msg.raw, "Raw APDU mismatch")
+	case <-ctx.Done():
+		assert.Fail(t, "Timeout waiting for unhandled message")
+	}
+}
@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+// segmentBuffer is how many segments of a response can arrive before SendAndReceive reads them. A window is
+// at most 127 segments (20.1.2.8 in the spec), so a whole window fits.
+const segmentBuffer = 128
+
+type (
+	// segmentReassembler puts the segments of a segmented ComplexAck back together. UDP can reorder them, so
+	// the segments that arrive early, but within the window, are kept until the ones before them arrive.
+	segmentReassembler struct {
+		invokeID  uint8
+		serviceID apdu.ServiceConfirmed
+		window    uint8
+		newAck    func(invokeID, sequenceNumber, window uint8, negative bool) *apdu.SegmentAckMessage
+		// maxSegments is how many segments we accept, or 0 for no limit
+		maxSegments uint
+		// count is how many segments we have in order
+		count uint
+		// acked is set once we sent an ack, which tells the device the window size
+		acked bool
+		// next is the sequence number of the segment after the ones we have in order
+		next uint8
+		// inWindow is how many segments we have added in order since the last ack
+		inWindow uint8
+		// nakSent is set when we sent a negative ack for a gap at next, so we only send it once
+		nakSent  bool
+		pending  map[uint8]*apdu.ComplexAckMessage
+		data     []byte
+		complete bool
+		// overflow is set when there are more segments than we accept
+		overflow bool
+	}
+)
+
+// newSegmentReassembler starts the reassembly for the response that the first segment belongs to. The
+// window is the one that we proposed, unless the device proposed a smaller one. The acks are created with
+// newAck, and a maxSegments of 0 accepts any number of segments.
+func newSegmentReassembler(first *apdu.ComplexAckMessage, window uint8, maxSegments uint,
+	newAck func(invokeID, sequenceNumber, window uint8, negative bool) *apdu.SegmentAckMessage) *segmentReassembler {
+	if first.ProposedWindowSize != nil && *first.ProposedWindowSize > 0 && *first.ProposedWindowSize < window {
+		window = *first.ProposedWindowSize
+	}
+	if window == 0 {
+		window = 1
+	}
+	return &segmentReassembler{
+		invokeID:    first.OriginalInvokeID,
+		serviceID:   first.ServiceID,
+		window:      window,
+		newAck:      newAck,
+		maxSegments: maxSegments,
+		pending:     make(map[uint8]*apdu.ComplexAckMessage),
+	}
+}
+
+// add takes the segment, in any order, and returns the SegmentAck to send for it, or nil if it doesn't need
+// one yet. The ack is for the last segment we have in order. It's negative when a segment arrives before
+// the ones that should be in front of it, so the device sends the missing ones again. The first segment is
+// acked right away, since the device waits for that ack to learn our window size (5.4.4.2 in the spec).
+func (r *segmentReassembler) add(segment *apdu.ComplexAckMessage) *apdu.SegmentAckMessage {
+	if r.complete || r.overflow || segment.SequenceNumber == nil {
+		return nil
+	}
+	seq := *segment.SequenceNumber
+	if behind := r.next - seq; behind > 0 && behind <= r.window {
+		// We already have it. The device may have sent it again because our ack was lost, so repeat it.
+		return r.ack(false)
+	}
+	if seq-r.next >= r.window {
+		// Too far ahead to keep, so the device has to send it again after the ones before it.
+		return r.nak()
+	}
+	if _, ok := r.pending[seq]; ok {
+		return nil
+	}
+	r.pending[seq] = segment
+
+	for {
+		inOrder, ok := r.pending[r.next]
+		if !ok {
+			break
+		}
+		delete(r.pending, r.next)
+		r.count++
+		if r.maxSegments > 0 && r.count > r.maxSegments {
+			r.overflow = true
+			return nil
+		}
+		r.data = append(r.data, inOrder.ServiceData...)
+		r.next++
+		r.inWindow++
+		r.nakSent = false
+		if !inOrder.DoSegmentsFollow {
+			r.complete = true
+			return r.ack(false)
+		}
+	}
+
+	if len(r.pending) > 0 {
+		return r.nak()
+	}
+	if !r.acked || r.inWindow >= r.window {
+		return r.ack(false)
+	}
+	return nil
+}
+
+// nak sends a negative ack for the gap at next, unless we already sent one for it.
+func (r *segmentReassembler) nak() *apdu.SegmentAckMessage {
+	if r.nakSent {
+		return nil
+	}
+	r.nakSent = true
+	return r.ack(true)
+}
+
+func (r *segmentReassembler) ack(negative bool) *apdu.SegmentAckMessage {
+	r.inWindow = 0
+	r.acked = true
+	return r.newAck(r.invokeID, r.next-1, r.window, negative)
+}
+
+// message is the unsegmented ComplexAck with the service data of all of the segments. It's only complete
+// after the last segment is added.
+func (r *segmentReassembler) message() *apdu.ComplexAckMessage {
+	return apdu.NewComplexAck(r.invokeID, r.serviceID, r.data)
+}
+
+// receiveSegments reassembles the segmented response to a request from SendAndReceive, starting with the
+// first segment that it received, and acks the segments to the device as they arrive.
+func (c *connection) receiveSegments(ctx context.Context, dest net.IP, waiter *responseWaiter,
+	first *apdu.ComplexAckMessage) (apdu.Message, error) {
+	reassembler := newSegmentReassembler(first, c.segmentWindow, apdu.MaxSegments(c.maxSegments).Segments(),
+		c.newSegmentAck)
+	timeout, ok := c.retry.Timeout(0)
+	if !ok {
+		timeout = DefaultAPDUTimeout
+	}
+	segment := first
+	for {
+		if ack := reassembler.add(segment); ack != nil {
+			if err := c.SendResponse(dest, ack); err != nil {
+				return nil, err
+			}
+		}
+		if reassembler.complete {
+			return reassembler.message(), nil
+		}
+		if reassembler.overflow {
+			// The device sent more than we said we accept
+			if err := c.SendResponse(dest, apdu.NewAbort(reassembler.invokeID, apdu.AbortReasonBufferOverflow,
+				false)); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("response from %s has more than %d segments: %w", dest,
+				reassembler.maxSegments, bacnet.ErrValueTooLarge)
+		}
+
+		segment = nil
+		timer := time.NewTimer(timeout)
+		for segment == nil {
+			select {
+			case resp := <-waiter.ch:
+				switch r := resp.(type) {
+				case *apdu.ComplexAckMessage:
+					segment = r
+				case *apdu.ErrorMessage, *apdu.RejectMessage, *apdu.AbortMessage:
+					timer.Stop()
+					return resp, &ResponseError{Response: resp}
+				}
+			case <-waiter.closed:
+				timer.Stop()
+				return nil, ErrConnectionClosed
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("waiting for segment %d from %s: %w", reassembler.next, dest, ctx.Err())
+			case <-timer.C:
+				// Tell the device that we gave up, so it stops sending
+				if err := c.SendResponse(dest, apdu.NewAbort(reassembler.invokeID, apdu.AbortReasonTSMTimeout,
+					false)); err != nil {
+					return nil, err
+				}
+				return nil, fmt.Errorf("%w: segment %d from %s", ErrNoResponse, reassembler.next, dest)
+			}
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,238 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/internal/npdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+)
+
+type (
+	// segmentingRouter reports the confirmed requests and the segment acks that loop back, so the test can
+	// send the segments of the response and check how they were acked.
+	segmentingRouter struct {
+		requests chan uint8
+		acks     chan *apdu.SegmentAckMessage
+		aborts   chan *apdu.AbortMessage
+	}
+)
+
+var _ MessageRouter = (*segmentingRouter)(nil)
+
+func (r *segmentingRouter) RouteMessage(message *BVLCMessage) error {
+	npduMsg, err := npdu.NewMessageFromBytes(message.Data)
+	if err != nil {
+		return err
+	}
+	switch msg := npduMsg.APDU.(type) {
+	case *apdu.ConfirmedMessage:
+		r.requests <- msg.InvokeID
+	case *apdu.SegmentAckMessage:
+		r.acks <- msg
+	case *apdu.AbortMessage:
+		r.aborts <- msg
+	}
+	return nil
+}
+
+func newTestSegment(invokeID, sequenceNumber, window uint8, more bool, data []byte) *apdu.ComplexAckMessage {
+	segment := apdu.NewComplexAck(invokeID, apdu.ServiceConfirmedReadProperty, data)
+	segment.IsSegmented = true
+	segment.DoSegmentsFollow = more
+	segment.SequenceNumber = &sequenceNumber
+	segment.ProposedWindowSize = &window
+	return segment
+}
+
+func TestSegmentReassembler(t *testing.T) {
+	type expectedAck struct {
+		sequenceNumber uint8
+		negative       bool
+	}
+	testCases := []struct {
+		name        string
+		window      uint8
+		maxSegments uint
+		order       []uint8
+		acks        []*expectedAck
+		complete    bool
+		overflow    bool
+	}{
+		{"in order", 2, 0, []uint8{0, 1, 2}, []*expectedAck{{0, false}, nil, {2, false}}, true, false},
+		{"full window", 1, 0, []uint8{0, 1, 2}, []*expectedAck{{0, false}, {1, false}, {2, false}}, true, false},
+		{"out of order", 2, 0, []uint8{0, 2, 1}, []*expectedAck{{0, false}, {0, true}, {2, false}}, true, false},
+		{"duplicate", 3, 0, []uint8{0, 0, 1, 2}, []*expectedAck{{0, false}, {0, false}, nil, {2, false}}, true,
+			false},
+		{"duplicate ahead", 3, 0, []uint8{0, 2, 2, 1}, []*expectedAck{{0, false}, {0, true}, nil, {2, false}},
+			true, false},
+		{"beyond the window", 1, 0, []uint8{0, 2}, []*expectedAck{{0, false}, {0, true}}, false, false},
+		{"missing", 3, 0, []uint8{0, 1}, []*expectedAck{{0, false}, nil}, false, false},
+		{"max segments", 3, 3, []uint8{0, 1, 2}, []*expectedAck{{0, false}, nil, {2, false}}, true, false},
+		{"too many segments", 3, 2, []uint8{0, 1, 2}, []*expectedAck{{0, false}, nil, nil}, false, true},
+	}
+	// The response has 3 segments, and the last one has no more following.
+	data := [][]byte{{0x01, 0x02}, {0x03}, {0x04, 0x05}}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			segments := make([]*apdu.ComplexAckMessage, len(data))
+			for i := range data {
+				segments[i] = newTestSegment(7, uint8(i), tCase.window, i < len(data)-1, data[i])
+			}
+			// Our window is larger, so the device's proposal is used
+			reassembler := newSegmentReassembler(segments[tCase.order[0]], 16, tCase.maxSegments,
+				(&connection{segmentWindow: 16}).newSegmentAck)
+			for i, seq := range tCase.order {
+				ack := reassembler.add(segments[seq])
+				expected := tCase.acks[i]
+				if expected == nil {
+					assert.Nil(t, ack, "Unexpected ack for segment %d", seq)
+					continue
+				}
+				if assert.NotNil(t, ack, "Expected an ack for segment %d", seq) {
+					assert.Equal(t, apdu.NewSegmentAck(7, expected.sequenceNumber, tCase.window, expected.negative,
+						false), ack, "Unexpected ack for segment %d", seq)
+				}
+			}
+			assert.Equal(t, tCase.complete, reassembler.complete, "Unexpected completion")
+			assert.Equal(t, tCase.overflow, reassembler.overflow, "Unexpected overflow")
+			if tCase.complete {
+				assert.Equal(t, apdu.NewComplexAck(7, apdu.ServiceConfirmedReadProperty,
+					[]byte{0x01, 0x02, 0x03, 0x04, 0x05}), reassembler.message(), "Unexpected reassembled message")
+			}
+		})
+	}
+}
+
+func TestSendAndReceiveSegmented(t *testing.T) {
+	conn, _ := newMemoryConnection(t, WithSegmentWindow(2))
+	router := &segmentingRouter{
+		requests: make(chan uint8, 1),
+		acks:     make(chan *apdu.SegmentAckMessage, 4),
+		aborts:   make(chan *apdu.AbortMessage, 1),
+	}
+	conn.SetMessageRouter(router)
+	conn.Start()
+	defer func() {
+		conn.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+	msg, err := apdu.NewReadPropertyMessage(0, objectID, bacnet.PropertyIdentifierObjectList, nil)
+	assert.NoError(t, err, "Unexpected error creating ReadProperty")
+	type result struct {
+		resp apdu.Message
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := conn.SendAndReceive(ctx, net.IPv4(127, 0, 0, 1), msg)
+		results <- result{resp, err}
+	}()
+	invokeID := <-router.requests
+
+	// The second segment arrives after the third
+	dest := net.IPv4(127, 0, 0, 1)
+	for _, segment := range []*apdu.ComplexAckMessage{
+		newTestSegment(invokeID, 0, 2, true, []byte{0x01, 0x02}),
+		newTestSegment(invokeID, 2, 2, false, []byte{0x04}),
+		newTestSegment(invokeID, 1, 2, true, []byte{0x03}),
+	} {
+		assert.NoError(t, conn.SendResponse(dest, segment), "Unable to send segment")
+	}
+
+	select {
+	case r := <-results:
+		assert.NoError(t, r.err, "Unexpected error")
+		assert.Equal(t, apdu.NewComplexAck(invokeID, apdu.ServiceConfirmedReadProperty,
+			[]byte{0x01, 0x02, 0x03, 0x04}), r.resp, "Unexpected reassembled response")
+	case <-ctx.Done():
+		assert.FailNow(t, "Segmented response was not reassembled")
+	}
+
+	// The ack for the first segment, a negative ack when the third segment was early, then the ack for the last
+	// one
+	for _, expected := range []*apdu.SegmentAckMessage{
+		apdu.NewSegmentAck(invokeID, 0, 2, false, false),
+		apdu.NewSegmentAck(invokeID, 0, 2, true, false),
+		apdu.NewSegmentAck(invokeID, 2, 2, false, false),
+	} {
+		select {
+		case ack := <-router.acks:
+			assert.Equal(t, expected, ack, "Unexpected segment ack")
+		case <-time.After(time.Second):
+			assert.Fail(t, "Segment ack was not sent")
+		}
+	}
+}
+
+func TestSendAndReceiveSegmentAbort(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []ConnectionOption
+		segments int
+		reason   apdu.AbortReason
+		err      error
+	}{
+		{"timeout", nil, 1, apdu.AbortReasonTSMTimeout, ErrNoResponse},
+		{"too many segments", []ConnectionOption{WithMaxSegments(uint8(apdu.MaxSegments2))}, 3,
+			apdu.AbortReasonBufferOverflow, bacnet.ErrValueTooLarge},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			opts := append([]ConnectionOption{
+				WithRetryStrategy(&FixedRetry{Interval: 100 * time.Millisecond}),
+			}, tCase.opts...)
+			conn, _ := newMemoryConnection(t, opts...)
+			router := &segmentingRouter{
+				requests: make(chan uint8, 1),
+				acks:     make(chan *apdu.SegmentAckMessage, 4),
+				aborts:   make(chan *apdu.AbortMessage, 1),
+			}
+			conn.SetMessageRouter(router)
+			conn.Start()
+			defer func() {
+				conn.Stop()
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			objectID := bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: 1234}
+			msg, err := apdu.NewReadPropertyMessage(0, objectID, bacnet.PropertyIdentifierObjectList, nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			errs := make(chan error, 1)
+			dest := net.IPv4(127, 0, 0, 1)
+			go func() {
+				_, err := conn.SendAndReceive(ctx, dest, msg)
+				errs <- err
+			}()
+			invokeID := <-router.requests
+
+			for i := 0; i < tCase.segments; i++ {
+				assert.NoError(t, conn.SendResponse(dest, newTestSegment(invokeID, uint8(i), 4, true, []byte{0x01})),
+					"Unable to send segment")
+			}
+			select {
+			case err := <-errs:
+				assert.ErrorIs(t, err, tCase.err, "Unexpected error")
+			case <-ctx.Done():
+				assert.FailNow(t, "Request did not fail")
+			}
+			select {
+			case abort := <-router.aborts:
+				assert.Equal(t, apdu.NewAbort(invokeID, tCase.reason, false), abort, "Unexpected abort")
+			case <-time.After(time.Second):
+				assert.Fail(t, "Abort was not sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package transport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+	"github.com/shigmas/modore/pkg/device"
+)
+
+type (
+	// Device is a minimal BACnet device for tests and examples. It has a device object with the required
+	// properties, except for the bit strings, which we can't encode yet. It answers WhoIs with an IAm, and
+	// ReadProperty from the properties of its objects, and rejects the other confirmed services.
+	// Attach it to a connection from a MemoryNetwork, and a client with another connection on the network can
+	// talk to it without sockets.
+	Device struct {
+		instance uint32
+		database *device.Database
+		conn     Connection
+		iAm      *IAmResponder
+		ch       APDUMessageChannel
+
+		mux        sync.RWMutex
+		properties map[bacnet.ObjectID]map[bacnet.PropertyIdentifier][]apdu.TagType
+		// objectList is the objects in the order that they were added, starting with the device. The object
+		// list property is made from it when it's read.
+		objectList []bacnet.ObjectID
+	}
+)
+
+const (
+	// TestDeviceVendorID is the vendor identifier of the test device
+	TestDeviceVendorID uint16 = 260
+	// testDeviceAPDUTimeout and testDeviceAPDURetries are the defaults from the spec
+	testDeviceAPDUTimeout = 3000
+	testDeviceAPDURetries = 3
+	// testDeviceProtocolRevision is the revision that the device claims to implement
+	testDeviceProtocolRevision = 14
+	// testDeviceBuffer is how many confirmed requests can be waiting to be answered
+	testDeviceBuffer = 16
+)
+
+var _ APDUMessageHandler = (*Device)(nil)
+
+// NewTestDevice creates a device with the instance and name, and no other objects. It panics if the instance
+// isn't a valid object instance, since that's a mistake in the test.
+func NewTestDevice(instance uint, name string) *Device {
+	d := Device{
+		instance:   uint32(instance),
+		database:   device.NewDatabase(),
+		ch:         make(APDUMessageChannel, testDeviceBuffer),
+		properties: make(map[bacnet.ObjectID]map[bacnet.PropertyIdentifier][]apdu.TagType),
+	}
+	id := d.ID()
+	if err := d.AddObject(id, name, nil); err != nil {
+		panic(err)
+	}
+
+	required := []struct {
+		property bacnet.PropertyIdentifier
+		value    func() (apdu.TagType, error)
+	}{
+		{bacnet.PropertyIdentifierSystemStatus, func() (apdu.TagType, error) {
+			// operational
+			return apdu.NewApplicationEnumerated(0)
+		}},
+		{bacnet.PropertyIdentifierVendorName, func() (apdu.TagType, error) {
+			return apdu.NewApplicationCharacterString("modore")
+		}},
+		{bacnet.PropertyIdentifierVendorIdentifier, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(uint(TestDeviceVendorID))
+		}},
+		{bacnet.PropertyIdentifierModelName, func() (apdu.TagType, error) {
+			return apdu.NewApplicationCharacterString("test device")
+		}},
+		{bacnet.PropertyIdentifierFirmwareRevision, func() (apdu.TagType, error) {
+			return apdu.NewApplicationCharacterString("1.0")
+		}},
+		{bacnet.PropertyIdentifierApplicationSoftwareVersion, func() (apdu.TagType, error) {
+			return apdu.NewApplicationCharacterString("1.0")
+		}},
+		{bacnet.PropertyIdentifierProtocolVersion, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(1)
+		}},
+		{bacnet.PropertyIdentifierProtocolRevision, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(testDeviceProtocolRevision)
+		}},
+		{bacnet.PropertyIdentifierMaxAPDULengthAccepted, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(apdu.DefaultMaxAPDULength().Bytes())
+		}},
+		{bacnet.PropertyIdentifierSegmentationSupported, func() (apdu.TagType, error) {
+			return apdu.NewApplicationEnumerated(uint(apdu.SegmentationNone))
+		}},
+		{bacnet.PropertyIdentifierAPDUTimeout, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(testDeviceAPDUTimeout)
+		}},
+		{bacnet.PropertyIdentifierNumberOfAPDURetries, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(testDeviceAPDURetries)
+		}},
+		{bacnet.PropertyIdentifierDatabaseRevision, func() (apdu.TagType, error) {
+			return apdu.NewApplicationUnsignedInt(0)
+		}},
+	}
+	for _, prop := range required {
+		value, err := prop.value()
+		if err != nil {
+			panic(err)
+		}
+		d.properties[id][prop.property] = []apdu.TagType{value}
+	}
+	// We don't have any bindings, so the list is empty
+	d.properties[id][bacnet.PropertyIdentifierDeviceAddressBinding] = []apdu.TagType{}
+	return &d
+}
+
+// ID returns the object ID of the device object
+func (d *Device) ID() bacnet.ObjectID {
+	return bacnet.ObjectID{Type: bacnet.ObjectTypeDevice, Instance: d.instance}
+}
+
+// Attach registers the handlers of the device with the registrar, which is the router of the connection, so
+// the other handlers are kept. The device gets every confirmed service, so it can reject the ones it doesn't
+// support. Call Run to answer the requests.
+func (d *Device) Attach(conn Connection, registrar MessageRegistrar) {
+	d.conn = conn
+	d.iAm = NewIAmResponder(conn, d.instance, apdu.SegmentationNone, TestDeviceVendorID)
+	registrar.RegisterAPDUHandler(apdu.ServiceUnconfirmedWhoIs, d.iAm)
+	for service := apdu.ServiceConfirmed(0); service < apdu.ServiceConfirmedMax; service++ {
+		registrar.RegisterConfirmedAPDUHandler(service, d)
+	}
+}
+
+func (d *Device) GetAPDUChannel() APDUMessageChannel {
+	return d.ch
+}
+
+func (d *Device) Equals(other Equatable) bool {
+	if o, ok := other.(*Device); ok {
+		return d == o
+	}
+	return false
+}
+
+// Run answers the WhoIs and confirmed requests until the context is done. The device must be attached.
+func (d *Device) Run(ctx context.Context) {
+	go d.iAm.Run(ctx)
+	for {
+		select {
+		case msg := <-d.ch:
+			if msg == nil {
+				continue
+			}
+			if req, ok := (*msg).(*ConfirmedRequest); ok {
+				_, _ = d.Respond(req)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// AddObject adds an object with the identifier, name and type properties, and the present value, if it's not
+// nil. Like in a real device, the IDs and names must be unique.
+func (d *Device) AddObject(id bacnet.ObjectID, name string, presentValue apdu.TagType) error {
+	idTag, err := apdu.NewApplicationObjectID(uint32(id.Type), id.Instance)
+	if err != nil {
+		return err
+	}
+	nameTag, err := apdu.NewApplicationCharacterString(name)
+	if err != nil {
+		return err
+	}
+	typeTag, err := apdu.NewApplicationEnumerated(uint(id.Type))
+	if err != nil {
+		return err
+	}
+	if err := d.database.Add(&device.Object{ID: id, Name: name}); err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.properties[id] = map[bacnet.PropertyIdentifier][]apdu.TagType{
+		bacnet.PropertyIdentifierObjectIdentifier: {idTag},
+		bacnet.PropertyIdentifierObjectName:       {nameTag},
+		bacnet.PropertyIdentifierObjectType:       {typeTag},
+		bacnet.PropertyIdentifierPresentValue:     {presentValue},
+	}
+	if presentValue == nil {
+		delete(d.properties[id], bacnet.PropertyIdentifierPresentValue)
+	}
+	d.objectList = append(d.objectList, id)
+	return nil
+}
+
+// SetProperty sets the values of the property of the object, which must have been added. The object list is
+// kept by the device, so it can't be set.
+func (d *Device) SetProperty(id bacnet.ObjectID, property bacnet.PropertyIdentifier,
+	values ...apdu.TagType) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	props, ok := d.properties[id]
+	if !ok {
+		return device.ErrObjectNotFound
+	}
+	if id == d.ID() && property == bacnet.PropertyIdentifierObjectList {
+		return bacnet.ErrInvalidData
+	}
+	props[property] = append([]apdu.TagType{}, values...)
+	return nil
+}
+
+// Respond answers the confirmed request, and returns whether the response was sent. It's sent to the sender
+// of the request, and only if the NPDU says that the sender expects a reply.
+func (d *Device) Respond(req *ConfirmedRequest) (bool, error) {
+	if !req.ExpectingReply || req.Sender == nil {
+		return false, nil
+	}
+	resp, err := d.respond(req.ConfirmedMessage)
+	if err != nil {
+		return false, err
+	}
+	err = d.conn.SendResponse(req.Sender.IP, resp)
+	return err == nil, err
+}
+
+// respond creates the response to the confirmed request.
+func (d *Device) respond(msg *apdu.ConfirmedMessage) (apdu.Message, error) {
+	if msg.ServiceID != apdu.ServiceConfirmedReadProperty {
+		return apdu.NewReject(msg.InvokeID, apdu.RejectReasonUnrecognizedService), nil
+	}
+	req, err := apdu.NewReadPropertyRequestFromBytes(msg.ServiceData)
+	if err != nil {
+		return apdu.NewReject(msg.InvokeID, apdu.RejectReasonInvalidParameterDataType), nil
+	}
+	values, class, code := d.readProperty(req)
+	if values == nil {
+		return apdu.NewErrorResponse(msg.InvokeID, apdu.ServiceConfirmedReadProperty, class, code), nil
+	}
+	return apdu.NewReadPropertyAckMessage(msg.InvokeID, &apdu.ReadPropertyAck{
+		ObjectID:   req.ObjectID,
+		Property:   req.Property,
+		ArrayIndex: req.ArrayIndex,
+		Values:     values,
+	})
+}
+
+// readProperty gets the values of the property. If it can't be read, the values are nil, and the error class
+// and code are why.
+func (d *Device) readProperty(req *apdu.ReadPropertyRequest) ([]apdu.TagType, apdu.ErrorClass, apdu.ErrorCode) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	props, ok := d.properties[req.ObjectID]
+	if !ok {
+		return nil, apdu.ErrorClassObject, apdu.ErrorCodeUnknownObject
+	}
+	if req.ObjectID == d.ID() && req.Property == bacnet.PropertyIdentifierObjectList {
+		return d.objectListValues(req.ArrayIndex)
+	}
+	values, ok := props[req.Property]
+	if !ok {
+		return nil, apdu.ErrorClassProperty, apdu.ErrorCodeUnknownProperty
+	}
+	if req.ArrayIndex != nil {
+		return nil, apdu.ErrorClassProperty, apdu.ErrorCodePropertyIsNotAnArray
+	}
+	return values, 0, 0
+}
+
+// objectListValues gets the object list, or the element or length of it for the array index. The lock must
+// be held.
+func (d *Device) objectListValues(arrayIndex *uint) ([]apdu.TagType, apdu.ErrorClass, apdu.ErrorCode) {
+	if arrayIndex != nil && *arrayIndex == 0 {
+		length, err := apdu.NewApplicationUnsignedInt(uint(len(d.objectList)))
+		if err != nil {
+			return nil, apdu.ErrorClassDevice, apdu.ErrorCodeOther
+		}
+		return []apdu.TagType{length}, 0, 0
+	}
+	objects := d.objectList
+	if arrayIndex != nil {
+		if *arrayIndex > uint(len(d.objectList)) {
+			return nil, apdu.ErrorClassProperty, apdu.ErrorCodeInvalidArrayIndex
+		}
+		objects = d.objectList[*arrayIndex-1 : *arrayIndex]
+	}
+	values := make([]apdu.TagType, 0, len(objects))
+	for _, id := range objects {
+		tag, err := apdu.NewApplicationObjectID(uint32(id.Type), id.Instance)
+		if err != nil {
+			return nil, apdu.ErrorClassDevice, apdu.ErrorCodeOther
+		}
+		values = append(values, tag)
+	}
+	return values, 0, 0
+}
@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shigmas/modore/internal/apdu"
+	"github.com/shigmas/modore/pkg/bacnet"
+	"github.com/shigmas/modore/pkg/device"
+)
+
+// newNetworkConnection creates a connection at the IP on the memory network.
+func newNetworkConnection(t *testing.T, network *MemoryNetwork, ip net.IP) *connection {
+	packetConn := network.NewPacketConn(&net.UDPAddr{IP: ip, Port: DefaultPort})
+	conn, err := NewConnection(ip, 8, WithPacketConn(packetConn))
+	assert.NoError(t, err, "Unexpected error creating connection")
+	return conn.(*connection)
+}
+
+func TestTestDevice(t *testing.T) {
+	const instance = 1234
+	testDevice := NewTestDevice(instance, "Test Device")
+	sensor := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 1}
+	presentValue, _ := apdu.NewApplicationUnsignedInt(72)
+	assert.NoError(t, testDevice.AddObject(sensor, "Zone Temp", presentValue), "Unable to add object")
+	assert.ErrorIs(t, testDevice.AddObject(bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 2},
+		"Zone Temp", presentValue), device.ErrDuplicateName, "Expected error for a duplicate name")
+
+	// The client and the device each have their own connection and nexus on the network
+	network := NewMemoryNetwork()
+	clientIP := net.IPv4(127, 0, 0, 1).To4()
+	deviceIP := net.IPv4(127, 0, 0, 2).To4()
+	conn := newNetworkConnection(t, network, clientIP)
+	deviceConn := newNetworkConnection(t, network, deviceIP)
+
+	clientNexus := NewMessageNexus()
+	// Buffered, so the client's nexus doesn't wait for the test to read it
+	clientHandler := &testAPDUMessageHandler{ch: make(APDUMessageChannel, 4)}
+	clientNexus.RegisterAPDUHandler(apdu.ServiceUnconfirmedWhoIs, clientHandler)
+	conn.SetMessageRouter(clientNexus)
+	deviceNexus := NewMessageNexus()
+	deviceConn.SetMessageRouter(deviceNexus)
+	testDevice.Attach(deviceConn, deviceNexus)
+
+	runCtx, runCancel := context.WithCancel(context.Background())
+	go testDevice.Run(runCtx)
+	clientNexus.Start()
+	deviceNexus.Start()
+	conn.Start()
+	deviceConn.Start()
+	defer func() {
+		conn.Stop()
+		deviceConn.Stop()
+		runCancel()
+		clientNexus.Stop()
+		deviceNexus.Stop()
+		assert.NoError(t, conn.Close(), "Error closing connection")
+		assert.NoError(t, deviceConn.Close(), "Error closing device connection")
+	}()
+
+	whoIsCtx, whoIsCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer whoIsCancel()
+	iAms, err := conn.WhoIs(whoIsCtx, 0, apdu.MaxInstanceNumber)
+	assert.NoError(t, err, "Unexpected error from WhoIs")
+	assert.Equal(t, 1, len(iAms), "Unexpected number of IAms")
+	if len(iAms) == 1 {
+		assert.Equal(t, testDevice.ID(), iAms[0].DeviceID, "Unexpected device in IAm")
+	}
+	// The handlers of the client still get messages, like its own WhoIs broadcast
+	select {
+	case msg := <-clientHandler.GetAPDUChannel():
+		assert.Equal(t, apdu.ServiceUnconfirmed(apdu.ServiceUnconfirmedWhoIs),
+			(*msg).(*apdu.UnconfirmedMessage).ServiceID, "Unexpected message for the client handler")
+	case <-time.After(time.Second):
+		assert.Fail(t, "The client handler didn't get the WhoIs")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dest := deviceIP
+	objects, err := conn.ReadObjectList(ctx, dest, instance)
+	assert.NoError(t, err, "Unexpected error reading the object list")
+	assert.Equal(t, []bacnet.ObjectID{testDevice.ID(), sensor}, objects, "Unexpected object list")
+
+	unknown := bacnet.ObjectID{Type: bacnet.ObjectTypeAnalogInput, Instance: 99}
+	specs := []apdu.ReadAccessSpec{
+		{ObjectID: sensor, Properties: []apdu.PropertyReference{{Property: bacnet.PropertyIdentifierPresentValue}}},
+		{ObjectID: unknown, Properties: []apdu.PropertyReference{{Property: bacnet.PropertyIdentifierPresentValue}}},
+	}
+	results, err := conn.ReadProperties(ctx, dest, specs)
+	assert.NoError(t, err, "Unexpected error reading the present value")
+	assert.Equal(t, []apdu.ReadAccessResult{
+		{ObjectID: sensor, Results: []apdu.ReadResult{
+			{PropertyReference: specs[0].Properties[0], Values: []apdu.TagType{presentValue}},
+		}},
+		{ObjectID: unknown, Results: []apdu.ReadResult{
+			{PropertyReference: specs[1].Properties[0], AccessError: &apdu.PropertyAccessError{
+				Class: apdu.ErrorClassObject,
+				Code:  apdu.ErrorCodeUnknownObject,
+			}},
+		}},
+	}, results, "Unexpected results")
+}
+
+func TestTestDeviceExpectingReply(t *testing.T) {
+	testCases := []struct {
+		name           string
+		expectingReply bool
+	}{
+		{"expecting reply", true},
+		{"not expecting reply", false},
+	}
+	for _, tCase := range testCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			testDevice := NewTestDevice(1234, "Test Device")
+			conn, packetConn := newMemoryConnection(t)
+			defer func() {
+				assert.NoError(t, conn.Close(), "Error closing connection")
+			}()
+			testDevice.Attach(conn, NewMessageNexus())
+
+			readProperty, err := apdu.NewReadPropertyMessage(1, testDevice.ID(),
+				bacnet.PropertyIdentifierObjectName, nil)
+			assert.NoError(t, err, "Unexpected error creating ReadProperty")
+			responded, err := testDevice.Respond(&ConfirmedRequest{
+				ConfirmedMessage: readProperty,
+				ExpectingReply:   tCase.expectingReply,
+				Sender:           &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: DefaultPort},
+			})
+			assert.NoError(t, err, "Unexpected error responding to the request")
+			assert.Equal(t, tCase.expectingReply, responded, "Unexpected response")
+			assert.Equal(t, tCase.expectingReply, len(packetConn.datagrams) == 1, "Unexpected response")
+		})
+	}
+}